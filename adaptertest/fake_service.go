@@ -0,0 +1,455 @@
+// Package adaptertest provides a scriptable adapters.Service implementation for
+// testing code that consumes service adapters (e.g. reconnect supervisors)
+// without running the wrapped service.
+package adaptertest
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+)
+
+// The service name reported to the metrics sink.
+const serviceName = "fake"
+
+// FakeService implements adapters.Service. Each call to Dial or DialContext
+// performs a single dial attempt whose outcome can be scripted via FailDials;
+// the configured dial policy is recorded but not used for retries. Connection
+// resets can be simulated via Drop and DropAfter.
+type FakeService struct {
+
+	// A mutex protecting the service state.
+	sync.Mutex
+
+	// Connection status.
+	connected bool
+
+	// The error returned by the most recent failed dial attempt.
+	lastErr error
+
+	// Errors returned by the next dial attempts.
+	dialErrs []error
+
+	// The time each dial attempt takes.
+	dialDelay time.Duration
+
+	// If non-zero, the connection is reset this long after each successful dial.
+	dropAfter time.Duration
+
+	// A counter incremented on each successful dial. It allows scheduled
+	// drops to detect that the connection they target was replaced.
+	generation uint64
+
+	// The error returned by the next Config calls.
+	configErr error
+
+	// The error returned by the next Close calls.
+	closeErr error
+
+	// The merged settings applied via Config.
+	settings map[string]string
+
+	// The setting values reported by ConfigDefaults.
+	defaults map[string]string
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
+	// The params of the Config calls that have not been consumed via NextConfig.
+	configCalls []map[string]string
+
+	// Closed and replaced whenever a Config call is recorded.
+	configCalled chan struct{}
+
+	// Call counters.
+	dialCalls  int
+	closeCalls int
+
+	// The registered options.
+	logger     *log.Logger
+	dialPolicy dial.Policy
+	metrics    adapters.Metrics
+	tracer     adapters.Tracer
+
+	// A notifier for close events.
+	closeNotifier *adapters.Notifier
+}
+
+// Create a new fake service whose dial attempts succeed.
+func New() *FakeService {
+	return &FakeService{
+		settings:      make(map[string]string),
+		configCalled:  make(chan struct{}),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+}
+
+// Make the next n dial attempts fail with err. Subsequent attempts succeed.
+func (s *FakeService) FailDials(n int, err error) *FakeService {
+	s.Lock()
+	defer s.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.dialErrs = append(s.dialErrs, err)
+	}
+	return s
+}
+
+// Make each dial attempt take d to complete. DialContext returns ctx.Err() if
+// ctx is cancelled in the meantime.
+func (s *FakeService) DialDelay(d time.Duration) *FakeService {
+	s.Lock()
+	defer s.Unlock()
+
+	s.dialDelay = d
+	return s
+}
+
+// Reset the connection d after each successful dial. Passing 0 disables
+// scheduled resets.
+func (s *FakeService) DropAfter(d time.Duration) *FakeService {
+	s.Lock()
+	defer s.Unlock()
+
+	s.dropAfter = d
+	return s
+}
+
+// Make the next Config calls fail with err. Passing nil restores the default behavior.
+func (s *FakeService) FailConfig(err error) *FakeService {
+	s.Lock()
+	defer s.Unlock()
+
+	s.configErr = err
+	return s
+}
+
+// Make the next Close calls fail with err. Passing nil restores the default behavior.
+func (s *FakeService) FailClose(err error) *FakeService {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closeErr = err
+	return s
+}
+
+// Report defaults as the default setting values via ConfigDefaults.
+func (s *FakeService) Defaults(defaults map[string]string) *FakeService {
+	s.Lock()
+	defer s.Unlock()
+
+	s.defaults = make(map[string]string, len(defaults))
+	for k, v := range defaults {
+		s.defaults[k] = v
+	}
+	return s
+}
+
+// Simulate a connection reset. Registered listeners are closed without an error.
+func (s *FakeService) Drop() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.drop()
+}
+
+// Reset the connection. This method is not thread-safe so it should be invoked
+// while holding the service lock.
+func (s *FakeService) drop() {
+	if !s.connected {
+		return
+	}
+
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+	s.closeNotifier.NotifyAll(nil)
+}
+
+// Report whether the service is connected.
+func (s *FakeService) Connected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Report whether the service is connected. Implements adapters.HealthReporter.
+func (s *FakeService) IsConnected() bool {
+	return s.Connected()
+}
+
+// Get the error returned by the most recent scripted dial failure; nil if the most
+// recent dial attempt succeeded. Implements adapters.HealthReporter.
+func (s *FakeService) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Get the number of Dial and DialContext calls.
+func (s *FakeService) DialCalls() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.dialCalls
+}
+
+// Get the number of Close and CloseContext calls.
+func (s *FakeService) CloseCalls() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.closeCalls
+}
+
+// Get a copy of the settings applied via Config.
+func (s *FakeService) Settings() map[string]string {
+	s.Lock()
+	defer s.Unlock()
+
+	settings := make(map[string]string, len(s.settings))
+	for k, v := range s.settings {
+		settings[k] = v
+	}
+	return settings
+}
+
+// Wait for the next Config call that has not been consumed yet and get a copy of
+// its params. Config calls are consumed in the order they were made, including the
+// ones that failed. Returns ctx.Err() if ctx is done before a Config call is made.
+func (s *FakeService) NextConfig(ctx context.Context) (map[string]string, error) {
+	for {
+		s.Lock()
+		if len(s.configCalls) > 0 {
+			params := s.configCalls[0]
+			s.configCalls = s.configCalls[1:]
+			s.Unlock()
+			return params, nil
+		}
+		called := s.configCalled
+		s.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-called:
+		}
+	}
+}
+
+// Connect to the service.
+func (s *FakeService) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context.
+func (s *FakeService) DialContext(ctx context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.dialCalls++
+	if s.connected {
+		return adapters.ErrAlreadyConnected
+	}
+
+	if s.dialDelay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.dialDelay):
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.metrics.IncDialAttempt(serviceName)
+	if len(s.dialErrs) > 0 {
+		err := s.dialErrs[0]
+		s.dialErrs = s.dialErrs[1:]
+		s.metrics.IncDialFailure(serviceName, err)
+		s.lastErr = err
+		return err
+	}
+
+	s.connected = true
+	s.lastErr = nil
+	s.generation++
+	s.metrics.IncDialSuccess(serviceName)
+	s.metrics.SetConnected(serviceName, true)
+
+	if s.dropAfter > 0 {
+		generation := s.generation
+		time.AfterFunc(s.dropAfter, func() {
+			s.Lock()
+			defer s.Unlock()
+
+			if s.generation == generation {
+				s.drop()
+			}
+		})
+	}
+
+	return nil
+}
+
+// Disconnect. If a close error has been scripted via FailClose, the service is
+// still disconnected but the error is returned.
+func (s *FakeService) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closeCalls++
+	if !s.connected {
+		return nil
+	}
+
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	return s.closeErr
+}
+
+// Disconnect. The fake service has no borrowed connections so it never blocks.
+func (s *FakeService) CloseContext(ctx context.Context) error {
+	return s.Close()
+}
+
+// Register a listener for receiving close notifications.
+func (s *FakeService) NotifyClose(c adapters.CloseListener) {
+	s.closeNotifier.Add(c)
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *FakeService) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *FakeService) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Apply a list of options to the service.
+func (s *FakeService) SetOptions(opts ...adapters.ServiceOption) error {
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register a logger instance for service events.
+func (s *FakeService) SetLogger(logger *log.Logger) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.logger = logger
+}
+
+// Get the logger registered via SetLogger.
+func (s *FakeService) Logger() *log.Logger {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.logger
+}
+
+// Set a dial policy for this service.
+func (s *FakeService) SetDialPolicy(policy dial.Policy) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *FakeService) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.metrics = m
+}
+
+// Register a tracer. The fake service does not create any spans.
+func (s *FakeService) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.tracer = t
+}
+
+// Merge params into the service settings. Modifying any setting of a connected
+// service triggers a connection reset. The params of each call are recorded for
+// NextConfig.
+func (s *FakeService) Config(params map[string]string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	call := make(map[string]string, len(params))
+	for k, v := range params {
+		call[k] = v
+	}
+	s.configCalls = append(s.configCalls, call)
+	close(s.configCalled)
+	s.configCalled = make(chan struct{})
+
+	s.configChanged = false
+	if s.configErr != nil {
+		return s.configErr
+	}
+
+	needsReset := false
+	for k, v := range params {
+		if cur, exists := s.settings[k]; !exists || cur != v {
+			s.settings[k] = v
+			needsReset = true
+		}
+	}
+
+	s.configChanged = needsReset
+	if needsReset {
+		s.drop()
+	}
+
+	return nil
+}
+
+// Get a copy of the default setting values registered via Defaults. Implements
+// adapters.ConfigDefaulter.
+func (s *FakeService) ConfigDefaults() map[string]string {
+	s.Lock()
+	defer s.Unlock()
+
+	defaults := make(map[string]string, len(s.defaults))
+	for k, v := range s.defaults {
+		defaults[k] = v
+	}
+	return defaults
+}
+
+// Report whether the most recent Config call modified any setting.
+func (s *FakeService) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
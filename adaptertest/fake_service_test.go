@@ -0,0 +1,279 @@
+package adaptertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+)
+
+// Ensure that FakeService can be used wherever an adapters.Service is expected.
+var _ adapters.Service = (*FakeService)(nil)
+var _ adapters.CloseNotificationSuppressor = (*FakeService)(nil)
+var _ adapters.HealthReporter = (*FakeService)(nil)
+
+func TestScriptedDialFailures(t *testing.T) {
+	dialErr := errors.New("connection refused")
+	srv := New().FailDials(2, dialErr)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := srv.Dial(); err != dialErr {
+			t.Fatalf("[attempt %d] Expected Dial to fail with the scripted error; got %v", attempt, err)
+		}
+	}
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected the third Dial to succeed; got %v", err)
+	}
+	if !srv.Connected() {
+		t.Fatalf("Expected service to be connected")
+	}
+	if err := srv.Dial(); err != adapters.ErrAlreadyConnected {
+		t.Fatalf("Expected Dial to return ErrAlreadyConnected; got %v", err)
+	}
+	if calls := srv.DialCalls(); calls != 4 {
+		t.Fatalf("Expected 4 dial calls; got %d", calls)
+	}
+}
+
+func TestDropAfter(t *testing.T) {
+	srv := New().DropAfter(20 * time.Millisecond)
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	select {
+	case err, ok := <-listener:
+		if ok {
+			t.Fatalf("Expected listener to be closed without an error; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for a connection reset")
+	}
+
+	if srv.Connected() {
+		t.Fatalf("Expected service to be disconnected after a reset")
+	}
+
+	// A supervisor re-dials after a reset
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected re-dial to succeed; got %v", err)
+	}
+	srv.Close()
+}
+
+func TestDialDelay(t *testing.T) {
+	srv := New().DialDelay(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := srv.DialContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected DialContext to fail with context.DeadlineExceeded; got %v", err)
+	}
+
+	srv.DialDelay(0)
+	if err := adapters.DialTimeout(srv, time.Second); err != nil {
+		t.Fatalf("Expected DialTimeout to succeed; got %v", err)
+	}
+}
+
+func TestCloseNotifiesShutdown(t *testing.T) {
+	srv := New()
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	shutdown := adapters.NotifyShutdown(srv)
+
+	// A reset should not be reported as a shutdown
+	srv.Drop()
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected re-dial to succeed; got %v", err)
+	}
+	select {
+	case <-shutdown:
+		t.Fatalf("Expected a connection reset not to close the shutdown channel")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	srv.Close()
+	select {
+	case <-shutdown:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the shutdown channel to close")
+	}
+	if calls := srv.CloseCalls(); calls != 1 {
+		t.Fatalf("Expected 1 close call; got %d", calls)
+	}
+}
+
+func TestSuppressCloseNotifications(t *testing.T) {
+	srv := New()
+	if err := srv.SetOptions(adapters.SuppressCloseNotifications(true)); err != nil {
+		t.Fatalf("Expected SetOptions to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	srv.Close()
+	select {
+	case err := <-listener:
+		t.Fatalf("Expected listener not to be notified while suppressed; got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The listener remains registered and is notified once suppression is lifted
+	if err := srv.SetOptions(adapters.SuppressCloseNotifications(false)); err != nil {
+		t.Fatalf("Expected SetOptions to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	srv.Close()
+	select {
+	case err := <-listener:
+		if err != adapters.ErrConnectionClosed {
+			t.Fatalf("Expected listener to receive %v; got %v", adapters.ErrConnectionClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for close notification")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	srv := New()
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	if err := srv.Config(map[string]string{"endpoint": "10.0.0.1:6379"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if !srv.ConfigChanged() {
+		t.Fatalf("Expected ConfigChanged() to report a change")
+	}
+	if _, ok := <-listener; ok || srv.Connected() {
+		t.Fatalf("Expected a config change to reset the connection")
+	}
+
+	if err := srv.Config(map[string]string{"endpoint": "10.0.0.1:6379"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if srv.ConfigChanged() {
+		t.Fatalf("Expected ConfigChanged() to report no change for identical settings")
+	}
+
+	configErr := errors.New("invalid settings")
+	srv.FailConfig(configErr)
+	if err := srv.Config(map[string]string{"db": "1"}); err != configErr {
+		t.Fatalf("Expected Config to fail with the scripted error; got %v", err)
+	}
+	if settings := srv.Settings(); len(settings) != 1 || settings["endpoint"] != "10.0.0.1:6379" {
+		t.Fatalf("Expected settings to be {endpoint: 10.0.0.1:6379}; got %v", settings)
+	}
+}
+
+func TestNextConfig(t *testing.T) {
+	srv := New().FailConfig(errors.New("invalid settings"))
+	srv.Config(map[string]string{"db": "1"})
+	srv.FailConfig(nil)
+	srv.Config(map[string]string{"db": "2"})
+
+	// Config calls are consumed in order, including the failed ones
+	for _, exp := range []string{"1", "2"} {
+		params, err := srv.NextConfig(context.Background())
+		if err != nil || params["db"] != exp {
+			t.Fatalf("Expected Config call with db=%s; got %v, %v", exp, params, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if params, err := srv.NextConfig(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected NextConfig to fail with context.DeadlineExceeded; got %v, %v", params, err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		srv.Config(map[string]string{"db": "3"})
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if params, err := srv.NextConfig(ctx); err != nil || params["db"] != "3" {
+		t.Fatalf("Expected NextConfig to wait for a Config call with db=3; got %v, %v", params, err)
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	defaults := map[string]string{"db": "0"}
+	srv := New().Defaults(defaults)
+	defaults["db"] = "1"
+
+	if got := srv.ConfigDefaults(); len(got) != 1 || got["db"] != "0" {
+		t.Fatalf("Expected ConfigDefaults to return {db: 0}; got %v", got)
+	}
+
+	var _ adapters.ConfigDefaulter = srv
+}
+
+func TestFailClose(t *testing.T) {
+	srv := New()
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	closeErr := errors.New("broken pipe")
+	srv.FailClose(closeErr)
+	if err := srv.Close(); err != closeErr {
+		t.Fatalf("Expected Close to fail with the scripted error; got %v", err)
+	}
+	if srv.Connected() {
+		t.Fatalf("Expected the service to be disconnected despite the close error")
+	}
+	if err := <-listener; err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected to receive ErrConnectionClosed; got %v", err)
+	}
+}
+
+func TestResetCloseListeners(t *testing.T) {
+	srv := New()
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	srv.ResetCloseListeners()
+
+	if _, ok := <-listener; ok {
+		t.Fatalf("Expected ResetCloseListeners to close the listener channel")
+	}
+	if !srv.Connected() {
+		t.Fatalf("Expected the service to remain connected")
+	}
+
+	// Listeners registered after the reset are notified as usual
+	listener = make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	srv.Close()
+	if err := <-listener; err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected to receive ErrConnectionClosed; got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen = errors.New("Circuit open")
+)
+
+// The state of a circuit breaker.
+type BreakerState int
+
+// Supported circuit breaker states.
+const (
+	// Calls are allowed through; failures are counted.
+	BreakerClosed BreakerState = iota
+
+	// Calls fail fast with ErrCircuitOpen until the cooldown period expires.
+	BreakerOpen
+
+	// A single probe call is allowed through. Its outcome closes or re-opens the circuit.
+	BreakerHalfOpen
+)
+
+func (st BreakerState) String() string {
+	switch st {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Options for configuring a circuit breaker.
+type BreakerOptions struct {
+
+	// The number of consecutive failures that open the circuit. Defaults to 5.
+	Threshold int
+
+	// The time to wait before allowing a probe call through an open circuit. Defaults to 30 seconds.
+	Cooldown time.Duration
+
+	// An optional callback invoked whenever the breaker changes state (e.g. for
+	// reporting the state to a metrics sink). It is invoked while holding the
+	// breaker lock so it must not call back into the breaker.
+	OnStateChange func(from, to BreakerState)
+}
+
+// Breaker wraps a Service with a circuit breaker. Consecutive failures of Dial,
+// DialContext and calls made via Call open the circuit; while open, these calls
+// fail fast with ErrCircuitOpen. Once the cooldown period expires, a single probe
+// call is allowed through; if it succeeds the circuit is closed, otherwise it is
+// re-opened for another cooldown period.
+type Breaker struct {
+	Service
+
+	// A mutex protecting the breaker state.
+	sync.Mutex
+
+	threshold     int
+	cooldown      time.Duration
+	onStateChange func(from, to BreakerState)
+
+	state    BreakerState
+	failures int
+	openedAt time.Time
+
+	// A time source that can be overridden by tests.
+	now func() time.Time
+}
+
+// Wrap service s with a circuit breaker.
+func CircuitBreaker(s Service, opts BreakerOptions) *Breaker {
+	if opts.Threshold <= 0 {
+		opts.Threshold = 5
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 30 * time.Second
+	}
+
+	return &Breaker{
+		Service:       s,
+		threshold:     opts.Threshold,
+		cooldown:      opts.Cooldown,
+		onStateChange: opts.OnStateChange,
+		state:         BreakerClosed,
+		now:           time.Now,
+	}
+}
+
+// Get the current breaker state.
+func (b *Breaker) State() BreakerState {
+	b.Lock()
+	defer b.Unlock()
+
+	// Report an open circuit whose cooldown has expired as half-open
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Connect to the wrapped service unless the circuit is open.
+func (b *Breaker) Dial() error {
+	return b.DialContext(context.Background())
+}
+
+// Connect to the wrapped service using the supplied context unless the circuit is open.
+func (b *Breaker) DialContext(ctx context.Context) error {
+	return b.Call(func() error {
+		err := b.Service.DialContext(ctx)
+		if err == ErrAlreadyConnected {
+			return nil
+		}
+		return err
+	})
+}
+
+// Invoke fn unless the circuit is open and record its outcome. This allows calls
+// such as fetching a connection from an adapter to be guarded by the breaker.
+func (b *Breaker) Call(fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := fn()
+	b.record(err)
+	return err
+}
+
+// Check whether a call may proceed, transitioning to the half-open state if the
+// cooldown period has expired.
+func (b *Breaker) allow() error {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.setState(BreakerHalfOpen)
+		return nil
+	case BreakerHalfOpen:
+		// A probe is already in flight
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Record the outcome of a call.
+func (b *Breaker) record(err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		b.setState(BreakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// Update the breaker state. This method is not thread-safe so it should be
+// invoked while holding the breaker lock.
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+
+	from := b.state
+	b.state = state
+	if b.onStateChange != nil {
+		b.onStateChange(from, state)
+	}
+}
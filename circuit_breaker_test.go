@@ -0,0 +1,125 @@
+package adapters
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	released := make(chan struct{})
+	close(released)
+	dialErr := errors.New("connection refused")
+	srv := &hangingService{release: released, dialErr: dialErr}
+
+	now := time.Now()
+	var transitions []string
+	breaker := CircuitBreaker(srv, BreakerOptions{
+		Threshold: 2,
+		Cooldown:  time.Minute,
+		OnStateChange: func(from, to BreakerState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+	breaker.now = func() time.Time { return now }
+
+	// closed -> open after 2 consecutive failures
+	for attempt := 0; attempt < 2; attempt++ {
+		if state := breaker.State(); state != BreakerClosed {
+			t.Fatalf("[attempt %d] Expected breaker to be closed; got %s", attempt, state)
+		}
+		if err := breaker.Dial(); err != dialErr {
+			t.Fatalf("[attempt %d] Expected Dial to fail with the dial error; got %v", attempt, err)
+		}
+	}
+	if state := breaker.State(); state != BreakerOpen {
+		t.Fatalf("Expected breaker to be open; got %s", state)
+	}
+
+	// Calls fail fast while open
+	invoked := false
+	err := breaker.Call(func() error {
+		invoked = true
+		return nil
+	})
+	if err != ErrCircuitOpen || invoked {
+		t.Fatalf("Expected Call to fail fast with ErrCircuitOpen; got %v (invoked: %t)", err, invoked)
+	}
+
+	// open -> half-open after the cooldown; a failed probe re-opens the circuit
+	now = now.Add(time.Minute)
+	if state := breaker.State(); state != BreakerHalfOpen {
+		t.Fatalf("Expected breaker to be half-open after the cooldown; got %s", state)
+	}
+	if err := breaker.Dial(); err != dialErr {
+		t.Fatalf("Expected probe Dial to fail with the dial error; got %v", err)
+	}
+	if state := breaker.State(); state != BreakerOpen {
+		t.Fatalf("Expected a failed probe to re-open the breaker; got %s", state)
+	}
+
+	// half-open -> closed after a successful probe
+	now = now.Add(time.Minute)
+	srv.dialErr = nil
+	if err := breaker.Dial(); err != nil {
+		t.Fatalf("Expected probe Dial to succeed; got %v", err)
+	}
+	if state := breaker.State(); state != BreakerClosed {
+		t.Fatalf("Expected a successful probe to close the breaker; got %s", state)
+	}
+
+	expected := []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}
+	if len(transitions) != len(expected) {
+		t.Fatalf("Expected transitions %v; got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Fatalf("Expected transitions %v; got %v", expected, transitions)
+		}
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	now := time.Now()
+	breaker := CircuitBreaker(&hangingService{}, BreakerOptions{Threshold: 1, Cooldown: time.Second})
+	breaker.now = func() time.Time { return now }
+
+	callErr := errors.New("connection refused")
+	if err := breaker.Call(func() error { return callErr }); err != callErr {
+		t.Fatalf("Expected Call to return the call error; got %v", err)
+	}
+
+	// While the probe is in flight, other calls should fail fast
+	now = now.Add(time.Second)
+	err := breaker.Call(func() error {
+		if err := breaker.Call(func() error { return nil }); err != ErrCircuitOpen {
+			t.Fatalf("Expected concurrent Call to fail with ErrCircuitOpen while probing; got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected probe Call to succeed; got %v", err)
+	}
+	if state := breaker.State(); state != BreakerClosed {
+		t.Fatalf("Expected breaker to be closed; got %s", state)
+	}
+}
+
+func TestCircuitBreakerResetsFailuresOnSuccess(t *testing.T) {
+	breaker := CircuitBreaker(&hangingService{}, BreakerOptions{Threshold: 2})
+
+	callErr := errors.New("connection refused")
+	breaker.Call(func() error { return callErr })
+	breaker.Call(func() error { return nil })
+	breaker.Call(func() error { return callErr })
+
+	if state := breaker.State(); state != BreakerClosed {
+		t.Fatalf("Expected non-consecutive failures not to open the breaker; got %s", state)
+	}
+}
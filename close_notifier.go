@@ -1,6 +1,11 @@
 package adapters
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
 
 type Notifier struct {
 
@@ -9,6 +14,16 @@ type Notifier struct {
 
 	// A list of listeners to be notified.
 	listeners []chan error
+
+	// If set, NotifyAll is a no-op.
+	suppressed bool
+
+	// The number of listeners above which warn is invoked; 0 disables the check.
+	maxListeners int
+	warn         func(count int)
+
+	// Set once warn has been invoked; cleared when the listeners are released.
+	warned bool
 }
 
 // Create new notifier.
@@ -20,10 +35,38 @@ func NewNotifier() *Notifier {
 
 // Register a listener.
 func (n *Notifier) Add(listener chan error) {
+	n.Lock()
+	n.listeners = append(n.listeners, listener)
+	count, warn := len(n.listeners), n.warn
+	exceeded := n.maxListeners > 0 && count > n.maxListeners && !n.warned
+	if exceeded {
+		n.warned = true
+	}
+	n.Unlock()
+
+	if exceeded && warn != nil {
+		warn(count)
+	}
+}
+
+// Invoke warn when more than max listeners are registered, which usually indicates
+// a listener leak (e.g. a caller registering a new listener in a loop). The warning
+// fires once and is re-armed after the listeners are released by NotifyAll or Reset.
+// Passing 0 disables the check; it is disabled by default.
+func (n *Notifier) SetMaxListeners(max int, warn func(count int)) {
 	n.Lock()
 	defer n.Unlock()
 
-	n.listeners = append(n.listeners, listener)
+	n.maxListeners, n.warn, n.warned = max, warn, false
+}
+
+// Enable or disable close notifications. While suppressed, NotifyAll is a no-op and
+// registered listeners remain registered.
+func (n *Notifier) Suppress(suppress bool) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.suppressed = suppress
 }
 
 // Notify all listeners, close their channels and remove them from the notification list. If err is not nil, it
@@ -32,6 +75,10 @@ func (n *Notifier) NotifyAll(err error) {
 	n.Lock()
 	defer n.Unlock()
 
+	if n.suppressed {
+		return
+	}
+
 	for _, listener := range n.listeners {
 		if err != nil {
 			listener <- err
@@ -41,4 +88,150 @@ func (n *Notifier) NotifyAll(err error) {
 
 	// empty list
 	n.listeners = make([]chan error, 0)
+	n.warned = false
+}
+
+// Close the channels of all listeners and remove them from the notification list
+// without emitting any error. Unlike NotifyAll, Reset is not affected by Suppress.
+func (n *Notifier) Reset() {
+	n.Lock()
+	defer n.Unlock()
+
+	for _, listener := range n.listeners {
+		close(listener)
+	}
+
+	// empty list
+	n.listeners = make([]chan error, 0)
+	n.warned = false
+}
+
+// Get a channel that is closed when service s is cleanly shut down. Connection resets
+// (e.g. due to a configuration change) do not close the channel. This is useful for
+// terminating background workers (e.g. configuration monitors) tied to a service.
+func NotifyShutdown(s Service) <-chan struct{} {
+	shutdownChan := make(chan struct{})
+
+	// Use a buffered listener so we never block the service notifier. The first
+	// listener is registered before returning so that an immediate shutdown is not missed.
+	listener := make(CloseListener, 1)
+	s.NotifyClose(listener)
+
+	go func() {
+		defer close(shutdownChan)
+
+		for {
+			err, ok := <-listener
+			if ok && err == ErrConnectionClosed {
+				return
+			}
+
+			// Connection reset; wait for the channel to be closed and register a new listener
+			for range listener {
+			}
+			listener = make(CloseListener, 1)
+			s.NotifyClose(listener)
+		}
+	}()
+
+	return shutdownChan
+}
+
+// The interval for polling the in-use count in WaitForRelease.
+var releasePollInterval = 10 * time.Millisecond
+
+// Block until inUse reports that no resources (e.g. borrowed connections or open
+// channels) are in use or ctx is done. Service adapters use this for waiting for
+// their resources to be released in CloseContext. Returns ctx.Err() if ctx is done
+// before inUse drops to zero.
+func WaitForRelease(ctx context.Context, inUse func() int) error {
+	ticker := time.NewTicker(releasePollInterval)
+	defer ticker.Stop()
+
+	for inUse() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// Services that report a name for identifying them in close events.
+type NamedService interface {
+
+	// Get the service name.
+	Name() string
+}
+
+// Get the name of service s. Services that do not implement NamedService are
+// identified by their type (e.g. "*redis.Redis").
+func ServiceName(s Service) string {
+	if named, ok := s.(NamedService); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", s)
+}
+
+// A close event received from one of the services passed to MergeCloseListeners.
+type ServiceClose struct {
+
+	// The name of the service that fired the event; see ServiceName.
+	Name string
+
+	// The error emitted by the service; nil if its connection was reset.
+	Err error
+}
+
+// Merge the close notifications of multiple services into a single channel. Each
+// close event (including connection resets) is forwarded as a ServiceClose value
+// identifying the service that fired it. A service stops being monitored once it is
+// cleanly shut down and the returned channel is closed after all services have
+// been shut down.
+func MergeCloseListeners(services ...Service) <-chan ServiceClose {
+	merged := make(chan ServiceClose)
+
+	var wg sync.WaitGroup
+	wg.Add(len(services))
+	for _, s := range services {
+		// Use a buffered listener so we never block the service notifier. The first
+		// listener is registered before returning so that an immediate close is not missed.
+		listener := make(CloseListener, 1)
+		s.NotifyClose(listener)
+
+		go func(s Service, listener CloseListener) {
+			defer wg.Done()
+
+			name := ServiceName(s)
+			for {
+				err, ok := <-listener
+				if ok {
+					// Wait for the channel to be closed
+					for range listener {
+					}
+				}
+
+				// Keep monitoring the service after a connection reset
+				shutdown := err == ErrConnectionClosed
+				if !shutdown {
+					listener = make(CloseListener, 1)
+					s.NotifyClose(listener)
+				}
+
+				merged <- ServiceClose{Name: name, Err: err}
+				if shutdown {
+					return
+				}
+			}
+		}(s, listener)
+	}
+
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	return merged
 }
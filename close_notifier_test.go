@@ -0,0 +1,168 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNotifierReset(t *testing.T) {
+	n := NewNotifier()
+	listeners := []chan error{make(chan error, 1), make(chan error, 1)}
+	for _, listener := range listeners {
+		n.Add(listener)
+	}
+
+	// Reset should close the listener channels even while notifications are suppressed
+	n.Suppress(true)
+	n.Reset()
+	for index, listener := range listeners {
+		if err, ok := <-listener; ok {
+			t.Fatalf("[listener %d] Expected channel to be closed without an error; got %v", index, err)
+		}
+	}
+
+	// Reset listeners should not be notified again
+	n.Suppress(false)
+	n.NotifyAll(ErrConnectionClosed)
+}
+
+func TestNotifierMaxListeners(t *testing.T) {
+	n := NewNotifier()
+	var warnings []int
+	n.SetMaxListeners(2, func(count int) { warnings = append(warnings, count) })
+
+	for i := 0; i < 4; i++ {
+		n.Add(make(chan error, 1))
+	}
+	if len(warnings) != 1 || warnings[0] != 3 {
+		t.Fatalf("Expected a single warning once 3 listeners were registered; got %v", warnings)
+	}
+
+	// Releasing the listeners re-arms the warning
+	n.NotifyAll(nil)
+	for i := 0; i < 3; i++ {
+		n.Add(make(chan error, 1))
+	}
+	if len(warnings) != 2 || warnings[1] != 3 {
+		t.Fatalf("Expected the warning to fire again after NotifyAll; got %v", warnings)
+	}
+
+	// A zero limit disables the check
+	n.Reset()
+	n.SetMaxListeners(0, func(count int) { warnings = append(warnings, count) })
+	for i := 0; i < 5; i++ {
+		n.Add(make(chan error, 1))
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("Expected no warnings while the check is disabled; got %v", warnings)
+	}
+}
+
+// A named service that fires close events via its notifier.
+type notifyingService struct {
+	Service
+	name     string
+	notifier *Notifier
+}
+
+func newNotifyingService(name string) *notifyingService {
+	return &notifyingService{name: name, notifier: NewNotifier()}
+}
+
+func (s *notifyingService) Name() string                { return s.name }
+func (s *notifyingService) NotifyClose(c CloseListener) { s.notifier.Add(c) }
+
+func expectServiceClose(t *testing.T, merged <-chan ServiceClose, exp ServiceClose) {
+	select {
+	case evt, ok := <-merged:
+		if !ok {
+			t.Fatalf("Expected to receive %+v; merged channel was closed", exp)
+		}
+		if evt != exp {
+			t.Fatalf("Expected to receive %+v; got %+v", exp, evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for %+v", exp)
+	}
+}
+
+func TestMergeCloseListeners(t *testing.T) {
+	redis := newNotifyingService("redis")
+	amqp := newNotifyingService("amqp")
+	merged := MergeCloseListeners(redis, amqp)
+
+	// Wait for the re-registered listener so that the next event is not missed
+	waitForListeners := func(s *notifyingService) {
+		deadline := time.Now().Add(time.Second)
+		for {
+			s.notifier.Lock()
+			count := len(s.notifier.listeners)
+			s.notifier.Unlock()
+			if count > 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s listener", s.name)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	connLost := errors.New("connection lost")
+	redis.notifier.NotifyAll(nil)
+	expectServiceClose(t, merged, ServiceClose{Name: "redis"})
+	amqp.notifier.NotifyAll(connLost)
+	expectServiceClose(t, merged, ServiceClose{Name: "amqp", Err: connLost})
+
+	waitForListeners(redis)
+	redis.notifier.NotifyAll(ErrConnectionClosed)
+	expectServiceClose(t, merged, ServiceClose{Name: "redis", Err: ErrConnectionClosed})
+
+	// The merged channel stays open until all services are shut down
+	waitForListeners(amqp)
+	amqp.notifier.NotifyAll(ErrConnectionClosed)
+	expectServiceClose(t, merged, ServiceClose{Name: "amqp", Err: ErrConnectionClosed})
+	select {
+	case _, ok := <-merged:
+		if ok {
+			t.Fatalf("Expected the merged channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the merged channel to be closed")
+	}
+
+	// An empty service list yields a closed channel
+	if _, ok := <-MergeCloseListeners(); ok {
+		t.Fatalf("Expected the merged channel of an empty service list to be closed")
+	}
+	if name := ServiceName(&hangingService{}); name != "*adapters.hangingService" {
+		t.Fatalf("Expected unnamed services to be identified by their type; got %q", name)
+	}
+}
+
+func TestWaitForRelease(t *testing.T) {
+	var inUse int32 = 2
+	go func() {
+		for atomic.LoadInt32(&inUse) > 0 {
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&inUse, -1)
+		}
+	}()
+
+	countInUse := func() int { return int(atomic.LoadInt32(&inUse)) }
+	if err := WaitForRelease(context.Background(), countInUse); err != nil {
+		t.Fatalf("Expected WaitForRelease to succeed; got %v", err)
+	}
+	if n := countInUse(); n != 0 {
+		t.Fatalf("Expected WaitForRelease to return once nothing is in use; got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := WaitForRelease(ctx, func() int { return 1 }); err != context.DeadlineExceeded {
+		t.Fatalf("Expected WaitForRelease to fail with context.DeadlineExceeded; got %v", err)
+	}
+}
@@ -0,0 +1,12 @@
+package adapters
+
+// Services that report the default value of their configuration settings.
+// Configuration middleware uses these values to revert settings that are no
+// longer defined by the configuration source. The redis adapter implements
+// this interface.
+type ConfigDefaulter interface {
+
+	// Get the default value of each setting recognized by Config, formatted as
+	// it would be passed to Config.
+	ConfigDefaults() map[string]string
+}
@@ -0,0 +1,67 @@
+package adapters
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ConfigSchema provides typed access to the settings passed to a service's Config
+// method. Each getter returns the supplied default if the setting is not present
+// and a consistently formatted error if its value cannot be parsed.
+type ConfigSchema map[string]string
+
+// Get a string setting.
+func (c ConfigSchema) String(key string, def string) string {
+	val, exists := c[key]
+	if !exists {
+		return def
+	}
+	return val
+}
+
+// Get an integer setting.
+func (c ConfigSchema) Int(key string, def int) (int, error) {
+	val, exists := c[key]
+	if !exists {
+		return def, nil
+	}
+
+	intVal, err := strconv.Atoi(val)
+	if err != nil {
+		return def, invalidValueError(key, val)
+	}
+	return intVal, nil
+}
+
+// Get a boolean setting. Any value accepted by strconv.ParseBool is valid.
+func (c ConfigSchema) Bool(key string, def bool) (bool, error) {
+	val, exists := c[key]
+	if !exists {
+		return def, nil
+	}
+
+	boolVal, err := strconv.ParseBool(val)
+	if err != nil {
+		return def, invalidValueError(key, val)
+	}
+	return boolVal, nil
+}
+
+// Get a duration setting expressed as an integer number of units (e.g. seconds).
+func (c ConfigSchema) Duration(key string, unit time.Duration, def time.Duration) (time.Duration, error) {
+	val, exists := c[key]
+	if !exists {
+		return def, nil
+	}
+
+	intVal, err := strconv.Atoi(val)
+	if err != nil {
+		return def, invalidValueError(key, val)
+	}
+	return time.Duration(intVal) * unit, nil
+}
+
+func invalidValueError(key, val string) error {
+	return fmt.Errorf("invalid value for '%s': %s", key, val)
+}
@@ -0,0 +1,80 @@
+package adapters
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigSchemaDefaults(t *testing.T) {
+	schema := ConfigSchema{}
+
+	if val := schema.String("endpoint", "localhost"); val != "localhost" {
+		t.Fatalf("Expected String to return the default value; got %s", val)
+	}
+
+	intVal, err := schema.Int("db", 3)
+	if err != nil || intVal != 3 {
+		t.Fatalf("Expected Int to return the default value; got %d, %v", intVal, err)
+	}
+
+	boolVal, err := schema.Bool("tls", true)
+	if err != nil || !boolVal {
+		t.Fatalf("Expected Bool to return the default value; got %t, %v", boolVal, err)
+	}
+
+	duration, err := schema.Duration("connTimeout", time.Second, 5*time.Second)
+	if err != nil || duration != 5*time.Second {
+		t.Fatalf("Expected Duration to return the default value; got %v, %v", duration, err)
+	}
+}
+
+func TestConfigSchemaValues(t *testing.T) {
+	schema := ConfigSchema{
+		"endpoint":    "10.0.0.1:6379",
+		"db":          "2",
+		"tls":         "false",
+		"connTimeout": "250",
+	}
+
+	if val := schema.String("endpoint", "localhost"); val != "10.0.0.1:6379" {
+		t.Fatalf("Expected String to return 10.0.0.1:6379; got %s", val)
+	}
+
+	intVal, err := schema.Int("db", 0)
+	if err != nil || intVal != 2 {
+		t.Fatalf("Expected Int to return 2; got %d, %v", intVal, err)
+	}
+
+	boolVal, err := schema.Bool("tls", true)
+	if err != nil || boolVal {
+		t.Fatalf("Expected Bool to return false; got %t, %v", boolVal, err)
+	}
+
+	duration, err := schema.Duration("connTimeout", time.Millisecond, time.Second)
+	if err != nil || duration != 250*time.Millisecond {
+		t.Fatalf("Expected Duration to return 250ms; got %v, %v", duration, err)
+	}
+}
+
+func TestConfigSchemaParseErrors(t *testing.T) {
+	schema := ConfigSchema{
+		"db":          "one",
+		"tls":         "maybe",
+		"connTimeout": "soon",
+	}
+
+	_, err := schema.Int("db", 0)
+	if err == nil || err.Error() != "invalid value for 'db': one" {
+		t.Fatalf("Expected Int to fail with a parse error; got %v", err)
+	}
+
+	_, err = schema.Bool("tls", false)
+	if err == nil || err.Error() != "invalid value for 'tls': maybe" {
+		t.Fatalf("Expected Bool to fail with a parse error; got %v", err)
+	}
+
+	_, err = schema.Duration("connTimeout", time.Second, 0)
+	if err == nil || err.Error() != "invalid value for 'connTimeout': soon" {
+		t.Fatalf("Expected Duration to fail with a parse error; got %v", err)
+	}
+}
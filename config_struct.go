@@ -0,0 +1,109 @@
+package adapters
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Build a configuration settings map from a struct (or a pointer to a struct) whose
+// fields are tagged with the name of the setting they map to, e.g.:
+//
+//	type RedisConfig struct {
+//		Endpoint    string        `adapter:"endpoint"`
+//		Db          int           `adapter:"db,omitempty"`
+//		ConnTimeout time.Duration `adapter:"connTimeout,omitempty"`
+//	}
+//
+// String, bool, integer and float fields are formatted using their default string
+// representation. time.Duration fields are formatted as a whole number of seconds,
+// matching the timeout settings of the service adapters; durations that are not
+// whole seconds are rejected. Fields tagged with omitempty are skipped if they
+// contain their zero value so the adapter applies its default for that setting.
+// Untagged and unexported fields and fields tagged with "-" are ignored.
+func ConfigFromStruct(v interface{}) (map[string]string, error) {
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil, errors.New("config: nil struct pointer")
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: expected a struct; got %T", v)
+	}
+
+	params := make(map[string]string)
+	seen := make(map[string]bool)
+	valType := val.Type()
+	for index := 0; index < valType.NumField(); index++ {
+		field := valType.Field(index)
+		tag, ok := field.Tag.Lookup("adapter")
+		if !ok || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if sep := strings.IndexByte(tag, ','); sep != -1 {
+			name, opts = tag[:sep], tag[sep+1:]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("config: missing setting name in tag of field %q", field.Name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("config: duplicate setting %q in tag of field %q", name, field.Name)
+		}
+		seen[name] = true
+		if opts != "" && opts != "omitempty" {
+			return nil, fmt.Errorf("config: unsupported tag option %q for field %q", opts, field.Name)
+		}
+
+		fieldVal := val.Field(index)
+		if opts == "omitempty" && isZeroValue(fieldVal) {
+			continue
+		}
+
+		formatted, err := formatFieldValue(fieldVal)
+		if err != nil {
+			return nil, fmt.Errorf("config: field %q: %v", field.Name, err)
+		}
+		params[name] = formatted
+	}
+
+	return params, nil
+}
+
+// Format a struct field value as a configuration setting.
+func formatFieldValue(val reflect.Value) (string, error) {
+	if val.Type() == durationType {
+		d := time.Duration(val.Int())
+		if d%time.Second != 0 {
+			return "", fmt.Errorf("duration %v is not a whole number of seconds", d)
+		}
+		return strconv.FormatInt(int64(d/time.Second), 10), nil
+	}
+
+	switch val.Kind() {
+	case reflect.String:
+		return val.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(val.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(val.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(val.Float(), 'f', -1, val.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", val.Type())
+}
+
+// Check whether val contains the zero value of its type.
+func isZeroValue(val reflect.Value) bool {
+	return reflect.DeepEqual(val.Interface(), reflect.Zero(val.Type()).Interface())
+}
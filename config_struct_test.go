@@ -0,0 +1,102 @@
+package adapters
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testRedisConfig struct {
+	Endpoint    string        `adapter:"endpoint"`
+	Db          int           `adapter:"db"`
+	ConnTimeout time.Duration `adapter:"connTimeout"`
+	Password    string        `adapter:"password,omitempty"`
+	MaxActive   uint          `adapter:"maxActive,omitempty"`
+	Cluster     bool          `adapter:"cluster,omitempty"`
+	Ratio       float64       `adapter:"ratio,omitempty"`
+	Internal    string        `adapter:"-"`
+	Untagged    string
+	unexported  string `adapter:"unexported"`
+}
+
+func TestConfigFromStruct(t *testing.T) {
+	specs := []struct {
+		cfg      interface{}
+		expected map[string]string
+	}{
+		{
+			cfg: testRedisConfig{
+				Endpoint:    "127.0.0.1:6379",
+				Db:          2,
+				ConnTimeout: 5 * time.Second,
+				Password:    "secret",
+				MaxActive:   10,
+				Cluster:     true,
+				Ratio:       0.25,
+				Internal:    "ignored",
+				Untagged:    "ignored",
+				unexported:  "ignored",
+			},
+			expected: map[string]string{
+				"endpoint":    "127.0.0.1:6379",
+				"db":          "2",
+				"connTimeout": "5",
+				"password":    "secret",
+				"maxActive":   "10",
+				"cluster":     "true",
+				"ratio":       "0.25",
+			},
+		},
+		// Zero values are only omitted for fields tagged with omitempty
+		{
+			cfg:      &testRedisConfig{Endpoint: "redis:6379"},
+			expected: map[string]string{"endpoint": "redis:6379", "db": "0", "connTimeout": "0"},
+		},
+	}
+
+	for index, spec := range specs {
+		params, err := ConfigFromStruct(spec.cfg)
+		if err != nil {
+			t.Fatalf("[spec %d] Expected ConfigFromStruct to succeed; got %v", index, err)
+		}
+		if !reflect.DeepEqual(params, spec.expected) {
+			t.Fatalf("[spec %d] Expected params to be %v; got %v", index, spec.expected, params)
+		}
+	}
+}
+
+func TestConfigFromStructErrors(t *testing.T) {
+	var nilCfg *testRedisConfig
+
+	specs := []struct {
+		cfg    interface{}
+		expErr string
+	}{
+		{nilCfg, "nil struct pointer"},
+		{map[string]string{"endpoint": "redis:6379"}, "expected a struct"},
+		{struct {
+			Timeout time.Duration `adapter:"connTimeout"`
+		}{1500 * time.Millisecond}, "not a whole number of seconds"},
+		{struct {
+			Hosts []string `adapter:"hosts"`
+		}{}, "unsupported type []string"},
+		{struct {
+			Endpoint string `adapter:",omitempty"`
+		}{}, "missing setting name"},
+		{struct {
+			Endpoint string `adapter:"endpoint,required"`
+		}{}, "unsupported tag option"},
+		{struct {
+			Endpoint string `adapter:"endpoint"`
+			Address  string `adapter:"endpoint"`
+		}{}, "duplicate setting"},
+	}
+
+	for index, spec := range specs {
+		_, err := ConfigFromStruct(spec.cfg)
+		if err == nil || !strings.Contains(err.Error(), spec.expErr) {
+			t.Fatalf("[spec %d] Expected error containing %q; got %v", index, spec.expErr, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package adapters
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	configValRe = regexp.MustCompile("(\\S+)=(\\S+)")
+)
+
+// Parse a configuration value retrieved from a key-value store (e.g. etcd) into a map.
+// Values starting with '{' are decoded as a JSON object with string values; any other
+// value is tokenized as a list of k=v pairs delimited by whitespace.
+func ParseConfigValue(value string) (map[string]string, error) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, "{") {
+		return tokenizeConfigValue(value), nil
+	}
+
+	params := make(map[string]string)
+	if err := json.Unmarshal([]byte(trimmed), &params); err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// Tokenize a value with format k1=v1 k2=v2 into a map.
+func tokenizeConfigValue(value string) map[string]string {
+	params := make(map[string]string)
+	matches := configValRe.FindAllStringSubmatch(value, -1)
+
+	// index 0 is the full capture
+	// index 1 is the key
+	// index 2 is the value
+	for _, match := range matches {
+		params[match[1]] = match[2]
+	}
+
+	return params
+}
+
+// Format params as a configuration value that ParseConfigValue decodes back into
+// the same map. Settings are written as k1=v1 k2=v2 pairs sorted by key; if any key
+// or value cannot be expressed in that format (e.g. it is empty or contains
+// whitespace or '='), the settings are encoded as a JSON object instead.
+func FormatConfigValue(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !isPlainToken(key) || !isPlainToken(params[key]) || strings.HasPrefix(key, "{") {
+			data, _ := json.Marshal(params)
+			return string(data)
+		}
+		pairs = append(pairs, key+"="+params[key])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Check whether token can be written as the key or value of a k=v pair.
+func isPlainToken(token string) bool {
+	return token != "" && !strings.ContainsAny(token, "= \t\r\n\v\f")
+}
@@ -0,0 +1,72 @@
+package adapters
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigValue(t *testing.T) {
+	specs := []struct {
+		value    string
+		expected map[string]string
+		err      bool
+	}{
+		{
+			value:    "endpoint=127.0.0.1:6379   db=1\n\tconnTimeout=2",
+			expected: map[string]string{"endpoint": "127.0.0.1:6379", "db": "1", "connTimeout": "2"},
+		},
+		{
+			value:    ` {"endpoint":"host:6379","password":"a b c"}`,
+			expected: map[string]string{"endpoint": "host:6379", "password": "a b c"},
+		},
+		{
+			value: `{"endpoint":`,
+			err:   true,
+		},
+	}
+
+	for index, spec := range specs {
+		params, err := ParseConfigValue(spec.value)
+		if spec.err {
+			if err == nil {
+				t.Fatalf("[spec %d] Expected to get a parse error", index)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("[spec %d] Unexpected parse error: %v", index, err)
+		}
+		if !reflect.DeepEqual(params, spec.expected) {
+			t.Fatalf("[spec %d] Expected parsed value to be %v; got %v", index, spec.expected, params)
+		}
+	}
+}
+
+func TestFormatConfigValue(t *testing.T) {
+	specs := []struct {
+		params   map[string]string
+		expected string
+	}{
+		{map[string]string{"endpoint": "127.0.0.1:6379", "db": "1"}, "db=1 endpoint=127.0.0.1:6379"},
+		{map[string]string{}, ""},
+		{map[string]string{"password": "a b c"}, `{"password":"a b c"}`},
+		{map[string]string{"password": ""}, `{"password":""}`},
+		{map[string]string{"dsn": "user=app"}, `{"dsn":"user=app"}`},
+	}
+
+	for index, spec := range specs {
+		value := FormatConfigValue(spec.params)
+		if value != spec.expected {
+			t.Fatalf("[spec %d] Expected %q; got %q", index, spec.expected, value)
+		}
+
+		params, err := ParseConfigValue(value)
+		if err != nil {
+			t.Fatalf("[spec %d] Unexpected parse error: %v", index, err)
+		}
+		if !reflect.DeepEqual(params, spec.params) {
+			t.Fatalf("[spec %d] Expected %q to parse back to %v; got %v", index, value, spec.params, params)
+		}
+	}
+}
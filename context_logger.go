@@ -0,0 +1,67 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// ContextLogger derives loggers whose output is tagged with fields carried by a
+// context such as a request correlation ID.
+type ContextLogger interface {
+
+	// Get a logger for ctx. Implementations may return nil if ctx carries no
+	// fields; the service logger is then used instead.
+	WithContext(ctx context.Context) *log.Logger
+}
+
+// Services that support context-scoped logging. All service adapters in this
+// package implement this interface.
+type ContextLoggerSetter interface {
+
+	// Attach a context logger to the service. Passing nil restores plain logging.
+	SetContextLogger(logger ContextLogger)
+}
+
+// Services that expose the logger registered via SetLogger. All service adapters in
+// this package implement this interface.
+type LoggerGetter interface {
+
+	// Get the logger registered via SetLogger.
+	Logger() *log.Logger
+}
+
+// Get the logger of service s or the standard logger if s does not expose one.
+func serviceLogger(s Service) *log.Logger {
+	if getter, ok := s.(LoggerGetter); ok {
+		if logger := getter.Logger(); logger != nil {
+			return logger
+		}
+	}
+	return log.Default()
+}
+
+// Attach a context logger to a service. Log lines emitted by DialContext and
+// ConfigContext are then written to the logger obtained for the supplied context.
+func WithContextLogger(logger ContextLogger) ServiceOption {
+	return func(s Service) error {
+		setter, ok := s.(ContextLoggerSetter)
+		if !ok {
+			return errors.New("service does not support context loggers")
+		}
+		setter.SetContextLogger(logger)
+		return nil
+	}
+}
+
+// Get the logger to use for ctx. If contextLogger is nil or does not provide a
+// logger for ctx, fallback is returned.
+func LoggerForContext(ctx context.Context, contextLogger ContextLogger, fallback *log.Logger) *log.Logger {
+	if contextLogger == nil {
+		return fallback
+	}
+	if logger := contextLogger.WithContext(ctx); logger != nil {
+		return logger
+	}
+	return fallback
+}
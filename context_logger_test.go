@@ -0,0 +1,46 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"testing"
+)
+
+type requestIDKey struct{}
+
+// A context logger that only provides loggers for contexts carrying a request ID.
+type requestLogger struct {
+	out *bytes.Buffer
+}
+
+func (l requestLogger) WithContext(ctx context.Context) *log.Logger {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	if !ok {
+		return nil
+	}
+	return log.New(l.out, "[req="+id+"] ", 0)
+}
+
+func TestLoggerForContext(t *testing.T) {
+	var plainOut, ctxOut bytes.Buffer
+	plain := log.New(&plainOut, "", 0)
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "abc123")
+
+	LoggerForContext(ctx, nil, plain).Print("no context logger")
+	LoggerForContext(context.Background(), requestLogger{&ctxOut}, plain).Print("no request ID")
+	LoggerForContext(ctx, requestLogger{&ctxOut}, plain).Print("dialing")
+
+	if exp := "no context logger\nno request ID\n"; plainOut.String() != exp {
+		t.Fatalf("Expected plain logger output %q; got %q", exp, plainOut.String())
+	}
+	if exp := "[req=abc123] dialing\n"; ctxOut.String() != exp {
+		t.Fatalf("Expected context logger output %q; got %q", exp, ctxOut.String())
+	}
+}
+
+func TestWithContextLoggerUnsupportedService(t *testing.T) {
+	if err := WithContextLogger(requestLogger{})(&hangingService{}); err == nil {
+		t.Fatalf("Expected WithContextLogger to fail for services without context logging support")
+	}
+}
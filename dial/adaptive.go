@@ -0,0 +1,120 @@
+package dial
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The number of recent dial outcomes tracked by adaptive dial policies.
+const adaptiveWindow = 10
+
+// A dial policy whose retry interval tracks the success rate of recent dial attempts.
+type adaptivePolicy struct {
+	// A mutex for guarding changes to the struct fields.
+	sync.Mutex
+
+	min, max time.Duration
+
+	curAttempt uint32
+
+	// A ring buffer with the outcomes of the most recent dial attempts.
+	outcomes [adaptiveWindow]bool
+	next     int
+	count    int
+}
+
+// Implements a dial policy that adapts its retry interval to the outcomes of the
+// last 10 dial attempts reported via RecordOutcome. The interval moves linearly
+// from min (no recent failures) to max (all recent attempts failed), so it shortens
+// after successes and lengthens after failures. Outcomes are retained across
+// ResetAttempts. This policy never gives up; use DialContext with a deadline to
+// bound the time spent dialing. If min is negative it is treated as 0 and if max
+// is less than min it is treated as min.
+func Adaptive(min, max time.Duration) *adaptivePolicy {
+	if min < 0 {
+		min = 0
+	}
+	if max < min {
+		max = min
+	}
+
+	return &adaptivePolicy{
+		min: min,
+		max: max,
+	}
+}
+
+// Record the outcome of a dial attempt. Only the most recent outcomes are tracked;
+// older outcomes are evicted from the window.
+func (d *adaptivePolicy) RecordOutcome(success bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.outcomes[d.next] = success
+	d.next = (d.next + 1) % adaptiveWindow
+	if d.count < adaptiveWindow {
+		d.count++
+	}
+}
+
+// Reset the attempt counter. Recorded outcomes are retained. Implements the DialPolicy interface.
+func (d *adaptivePolicy) ResetAttempts() {
+	d.Lock()
+	defer d.Unlock()
+
+	d.curAttempt = 0
+}
+
+// Get the attempt counter. Implements the DialPolicy interface.
+func (d *adaptivePolicy) CurAttempt() uint32 {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.curAttempt
+}
+
+// Get the next retry interval. Implements the DialPolicy interface.
+func (d *adaptivePolicy) NextRetry() (time.Duration, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.curAttempt++
+	return d.interval(), nil
+}
+
+// Get the retry interval for the failure rate of the tracked outcomes.
+//
+// This method is not thread-safe so it should be invoked while holding the policy lock.
+func (d *adaptivePolicy) interval() time.Duration {
+	failures := 0
+	for index := 0; index < d.count; index++ {
+		if !d.outcomes[index] {
+			failures++
+		}
+	}
+	if failures == 0 {
+		return d.min
+	}
+
+	return d.min + time.Duration(int64(d.max-d.min)*int64(failures)/int64(d.count))
+}
+
+// Get a copy of the dial policy with its attempt counter reset and no recorded
+// outcomes. Invoked by Clone.
+func (d *adaptivePolicy) Clone() Policy {
+	return Adaptive(d.min, d.max)
+}
+
+// Describe the policy settings. Implements the Describer interface.
+func (d *adaptivePolicy) Describe() string {
+	return fmt.Sprintf("adaptive(min=%v, max=%v)", d.min, d.max)
+}
+
+// Report the outcome of a dial attempt to p. Services invoke this after each dial
+// attempt. Policies that do not track outcomes (see Adaptive) are left unmodified.
+func RecordOutcome(p Policy, success bool) {
+	if r, ok := p.(interface{ RecordOutcome(bool) }); ok {
+		r.RecordOutcome(success)
+	}
+}
@@ -0,0 +1,100 @@
+package dial
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptive(t *testing.T) {
+	min, max := 10*time.Millisecond, 110*time.Millisecond
+	policy := Adaptive(min, max)
+
+	if next, err := policy.NextRetry(); err != nil || next != min {
+		t.Fatalf("Expected the initial interval to be %v; got %v (%v)", min, next, err)
+	}
+
+	// Each failure should lengthen the interval until it reaches max
+	prev := min
+	for index := 0; index < adaptiveWindow; index++ {
+		policy.RecordOutcome(false)
+		next, _ := policy.NextRetry()
+		if next < prev || next > max {
+			t.Fatalf("[failure %d] Expected interval to be within [%v, %v]; got %v", index, prev, max, next)
+		}
+		prev = next
+	}
+	if prev != max {
+		t.Fatalf("Expected interval to be %v after %d failures; got %v", max, adaptiveWindow, prev)
+	}
+
+	// Each success should shorten the interval until it reaches min
+	for index := 0; index < adaptiveWindow; index++ {
+		policy.RecordOutcome(true)
+		next, _ := policy.NextRetry()
+		if next > prev || next < min {
+			t.Fatalf("[success %d] Expected interval to be within [%v, %v]; got %v", index, min, prev, next)
+		}
+		prev = next
+	}
+	if prev != min {
+		t.Fatalf("Expected interval to be %v after %d successes; got %v", min, adaptiveWindow, prev)
+	}
+}
+
+func TestAdaptiveSlidingWindow(t *testing.T) {
+	policy := Adaptive(0, 100*time.Millisecond)
+
+	specs := []struct {
+		outcomes []bool
+		expNext  time.Duration
+	}{
+		{[]bool{false, true, true, true}, 25 * time.Millisecond},
+		{[]bool{true, true, true, true, true, true}, 10 * time.Millisecond},
+		// The initial failure is evicted from the window
+		{[]bool{true}, 0},
+		{[]bool{false, false}, 20 * time.Millisecond},
+	}
+
+	for index, spec := range specs {
+		for _, success := range spec.outcomes {
+			RecordOutcome(policy, success)
+		}
+		if next, _ := policy.NextRetry(); next != spec.expNext {
+			t.Fatalf("[spec %d] Expected interval to be %v; got %v", index, spec.expNext, next)
+		}
+	}
+
+	// Outcomes should survive attempt resets but not clones
+	policy.ResetAttempts()
+	if policy.CurAttempt() != 0 {
+		t.Fatalf("Expected attempt counter to be reset")
+	}
+	if next, _ := policy.NextRetry(); next != 20*time.Millisecond {
+		t.Fatalf("Expected outcomes to be retained after ResetAttempts; got interval %v", next)
+	}
+	if next, _ := Clone(policy).NextRetry(); next != 0 {
+		t.Fatalf("Expected cloned policy to start without outcomes; got interval %v", next)
+	}
+}
+
+func TestAdaptiveLimits(t *testing.T) {
+	policy := Adaptive(-time.Second, -2*time.Second)
+	policy.RecordOutcome(false)
+	if next, _ := policy.NextRetry(); next != 0 {
+		t.Fatalf("Expected negative limits to be capped to 0; got interval %v", next)
+	}
+}
+
+func TestRecordOutcomeForwarding(t *testing.T) {
+	adaptive := Adaptive(0, 100*time.Millisecond)
+	policy := SharedBudget(1).Wrap(ServerDirected(adaptive))
+
+	RecordOutcome(policy, false)
+	if next, _ := policy.NextRetry(); next != 100*time.Millisecond {
+		t.Fatalf("Expected outcome to be forwarded to the adaptive policy; got interval %v", next)
+	}
+	Release(policy)
+
+	// Policies that do not track outcomes should be left unmodified
+	RecordOutcome(Periodic(1, time.Millisecond), false)
+}
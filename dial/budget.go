@@ -0,0 +1,140 @@
+package dial
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A retry budget caps the number of concurrent dial attempts across all policies
+// wrapped by it. This prevents multiple services that reconnect at the same time
+// from stampeding a recovering backend.
+type Budget struct {
+	tokens chan struct{}
+}
+
+// Create a retry budget that allows up to maxConcurrent dial attempts to be in
+// flight at the same time. Values less than 1 are treated as 1.
+func SharedBudget(maxConcurrent int) *Budget {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+
+	return &Budget{
+		tokens: make(chan struct{}, maxConcurrent),
+	}
+}
+
+// Wrap policy p so that its retries are subject to the budget. The same budget
+// can wrap any number of policies.
+func (b *Budget) Wrap(p Policy) Policy {
+	return &budgetPolicy{
+		budget: b,
+		policy: p,
+	}
+}
+
+// Get the number of tokens currently held by wrapped policies.
+func (b *Budget) InUse() int {
+	return len(b.tokens)
+}
+
+// A dial policy that acquires a token from a shared budget before each attempt.
+type budgetPolicy struct {
+	// A mutex for guarding changes to the struct fields.
+	sync.Mutex
+
+	budget *Budget
+	policy Policy
+
+	// Set while the policy holds a budget token.
+	holding bool
+}
+
+// Reset the attempt counter and release any held token. Implements the DialPolicy interface.
+func (d *budgetPolicy) ResetAttempts() {
+	d.Release()
+	d.policy.ResetAttempts()
+}
+
+// Get the attempt counter. Implements the DialPolicy interface.
+func (d *budgetPolicy) CurAttempt() uint32 {
+	return d.policy.CurAttempt()
+}
+
+// Get the next retry interval. Implements the DialPolicy interface. The token held
+// for the previous attempt, if any, is released and a new token is acquired before
+// returning; this call blocks until a token becomes available. The token is held
+// until the next call to NextRetry, ResetAttempts or Release. No token is acquired
+// if the wrapped policy gives up. Use NextRetryContext for bounding the wait.
+func (d *budgetPolicy) NextRetry() (time.Duration, error) {
+	return d.NextRetryContext(context.Background())
+}
+
+// Get the next retry interval like NextRetry. If ctx is done before a token becomes
+// available, ctx.Err() is returned without acquiring a token. Invoked by NextRetryContext.
+func (d *budgetPolicy) NextRetryContext(ctx context.Context) (time.Duration, error) {
+	d.Release()
+
+	next, err := d.policy.NextRetry()
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case d.budget.tokens <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	d.Lock()
+	d.holding = true
+	d.Unlock()
+
+	return next, nil
+}
+
+// Release the token held for the current attempt, if any.
+func (d *budgetPolicy) Release() {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.holding {
+		<-d.budget.tokens
+		d.holding = false
+	}
+}
+
+// Forward a suggested retry interval to the wrapped policy if it accepts suggestions
+// (see ServerDirected).
+func (d *budgetPolicy) SuggestNext(next time.Duration) {
+	if s, ok := d.policy.(interface{ SuggestNext(time.Duration) }); ok {
+		s.SuggestNext(next)
+	}
+}
+
+// Forward the outcome of a dial attempt to the wrapped policy (see RecordOutcome).
+func (d *budgetPolicy) RecordOutcome(success bool) {
+	RecordOutcome(d.policy, success)
+}
+
+// Get a copy of the dial policy with its attempt counter reset. The copy shares
+// the budget of the original policy. Invoked by Clone.
+func (d *budgetPolicy) Clone() Policy {
+	return d.budget.Wrap(Clone(d.policy))
+}
+
+// Describe the policy settings. Implements the Describer interface.
+func (d *budgetPolicy) Describe() string {
+	return fmt.Sprintf("budget(maxConcurrent=%d, %s)", cap(d.budget.tokens), Describe(d.policy))
+}
+
+// Release the budget token held by p for its current attempt. Services invoke
+// this once a dial completes so that cancelled or aborted dials do not hold on
+// to their token. Policies without a budget are left unmodified.
+func Release(p Policy) {
+	if r, ok := p.(interface{ Release() }); ok {
+		r.Release()
+	}
+}
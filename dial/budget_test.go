@@ -0,0 +1,135 @@
+package dial
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSharedBudgetSerializesRetries(t *testing.T) {
+	budget := SharedBudget(1)
+
+	var inFlight, maxInFlight, attempts int32
+	var wg sync.WaitGroup
+	for index := 0; index < 3; index++ {
+		policy := budget.Wrap(Periodic(3, 0))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := policy.NextRetry(); err != nil {
+					return
+				}
+
+				// Simulate a dial attempt
+				cur := atomic.AddInt32(&inFlight, 1)
+				for {
+					max := atomic.LoadInt32(&maxInFlight)
+					if cur <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, cur) {
+						break
+					}
+				}
+				atomic.AddInt32(&attempts, 1)
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&inFlight, -1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if attempts != 9 {
+		t.Fatalf("Expected 9 attempts; got %d", attempts)
+	}
+	if maxInFlight != 1 {
+		t.Fatalf("Expected at most 1 concurrent attempt; got %d", maxInFlight)
+	}
+
+	// Exhausted policies should not hold on to any tokens
+	if inUse := budget.InUse(); inUse != 0 {
+		t.Fatalf("Expected all tokens to be released; %d still in use", inUse)
+	}
+}
+
+func TestSharedBudgetRelease(t *testing.T) {
+	budget := SharedBudget(1)
+	policy1 := budget.Wrap(Periodic(10, time.Millisecond))
+	policy2 := Clone(policy1)
+
+	if _, err := policy1.NextRetry(); err != nil {
+		t.Fatalf("Expected to get the next attempt duration; got error %v", err)
+	}
+
+	// The clone shares the budget so it should block until policy1 releases its token
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		policy2.NextRetry()
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("Expected NextRetry to block while the budget is exhausted")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	Release(policy1)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for NextRetry to acquire a token")
+	}
+
+	// ResetAttempts releases the token; further releases are no-ops
+	policy2.ResetAttempts()
+	Release(policy2)
+	if inUse := budget.InUse(); inUse != 0 {
+		t.Fatalf("Expected all tokens to be released; %d still in use", inUse)
+	}
+
+	// Policies without a budget are left unmodified
+	Release(Periodic(1, time.Millisecond))
+}
+
+func TestSharedBudgetForwardsSuggestions(t *testing.T) {
+	policy := SharedBudget(1).Wrap(ServerDirected(Periodic(2, time.Millisecond)))
+
+	SuggestFromError(policy, hintError(time.Second))
+	if next, _ := policy.NextRetry(); next != time.Second {
+		t.Fatalf("Expected to get the hinted duration %d; got %d", time.Second, next)
+	}
+}
+
+func TestSharedBudgetNextRetryContext(t *testing.T) {
+	budget := SharedBudget(1)
+	holder := budget.Wrap(Periodic(10, time.Millisecond))
+	if _, err := holder.NextRetry(); err != nil {
+		t.Fatalf("Expected to get the next attempt duration; got error %v", err)
+	}
+	defer Release(holder)
+
+	// Waiting for a token held by another policy is aborted once ctx expires
+	for _, policy := range []Policy{budget.Wrap(Periodic(10, time.Millisecond)), ServerDirected(budget.Wrap(Periodic(10, time.Millisecond)))} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		_, err := NextRetryContext(ctx, policy)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("[%s] Expected NextRetryContext to fail with context.DeadlineExceeded; got %v", Describe(policy), err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("[%s] Expected NextRetryContext to return once the context expired; took %v", Describe(policy), elapsed)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := Sleep(ctx, budget.Wrap(Periodic(10, time.Millisecond))); err != context.DeadlineExceeded {
+		t.Fatalf("Expected Sleep to fail with context.DeadlineExceeded; got %v", err)
+	}
+
+	// Aborted waits must not acquire a token
+	if inUse := budget.InUse(); inUse != 1 {
+		t.Fatalf("Expected only the holder token to be in use; got %d", inUse)
+	}
+}
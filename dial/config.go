@@ -0,0 +1,98 @@
+package dial
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The settings used by FromConfig when not specified.
+const (
+	defaultPolicy      = "periodic"
+	defaultMaxAttempts = 5
+	defaultUnit        = time.Second
+)
+
+// The environment variable suffixes recognized by FromEnv and the FromConfig
+// settings they map to.
+var envSettings = map[string]string{
+	"DIAL_POLICY":       "policy",
+	"DIAL_MAX_ATTEMPTS": "maxAttempts",
+	"DIAL_UNIT":         "unit",
+	"DIAL_JITTER":       "jitter",
+}
+
+// Create a dial policy from a map of settings. The following settings are supported:
+//   - policy: one of periodic, periodicJitter or expBackoff (default: periodic).
+//   - maxAttempts: the max number of dial attempts (default: 5).
+//   - unit: the retry interval (periodic policies) or retry unit (expBackoff) as a
+//     duration string such as "200ms" (default: 1s).
+//   - jitter: the jitter factor of the periodicJitter policy (default: 0).
+func FromConfig(params map[string]string) (Policy, error) {
+	maxAttempts := uint32(defaultMaxAttempts)
+	if val, ok := params["maxAttempts"]; ok {
+		n, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return nil, invalidValueError("maxAttempts", val)
+		}
+		maxAttempts = uint32(n)
+	}
+
+	unit := defaultUnit
+	if val, ok := params["unit"]; ok {
+		d, err := time.ParseDuration(val)
+		if err != nil || d < 0 {
+			return nil, invalidValueError("unit", val)
+		}
+		unit = d
+	}
+
+	jitter := 0.0
+	if val, ok := params["jitter"]; ok {
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil || f < 0 {
+			return nil, invalidValueError("jitter", val)
+		}
+		jitter = f
+	}
+
+	policy := defaultPolicy
+	if val, ok := params["policy"]; ok {
+		policy = val
+	}
+	switch policy {
+	case "periodic":
+		return Periodic(maxAttempts, unit), nil
+	case "periodicJitter":
+		return PeriodicJitter(maxAttempts, unit, jitter), nil
+	case "expBackoff":
+		return ExpBackoff(maxAttempts, unit), nil
+	}
+
+	return nil, invalidValueError("policy", policy)
+}
+
+// Create a dial policy from environment variables. The variables <PREFIX>_DIAL_POLICY,
+// <PREFIX>_DIAL_MAX_ATTEMPTS, <PREFIX>_DIAL_UNIT and <PREFIX>_DIAL_JITTER map to the
+// FromConfig settings; unset variables fall back to the FromConfig defaults. An empty
+// prefix reads the variables without a prefix (e.g. DIAL_POLICY).
+func FromEnv(prefix string) (Policy, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+
+	params := make(map[string]string)
+	for suffix, key := range envSettings {
+		if val, ok := os.LookupEnv(prefix + suffix); ok {
+			params[key] = val
+		}
+	}
+
+	return FromConfig(params)
+}
+
+func invalidValueError(key, val string) error {
+	return fmt.Errorf("invalid value for '%s': %s", key, val)
+}
@@ -0,0 +1,130 @@
+package dial
+
+import (
+	"testing"
+	"time"
+)
+
+// Drain p and return the retry intervals it generated before giving up.
+func drainPolicy(t *testing.T, p Policy) []time.Duration {
+	var retries []time.Duration
+	for {
+		next, err := p.NextRetry()
+		if err != nil {
+			return retries
+		}
+		retries = append(retries, next)
+		if len(retries) > 100 {
+			t.Fatalf("Expected policy to give up")
+		}
+	}
+}
+
+func TestFromEnvDefaults(t *testing.T) {
+	policy, err := FromEnv("TEST")
+	if err != nil {
+		t.Fatalf("Expected FromEnv to succeed; got %v", err)
+	}
+
+	retries := drainPolicy(t, policy)
+	if len(retries) != defaultMaxAttempts {
+		t.Fatalf("Expected %d attempts; got %d", defaultMaxAttempts, len(retries))
+	}
+	for index, retry := range retries {
+		if retry != time.Second {
+			t.Fatalf("[attempt %d] Expected retry interval to be 1s; got %v", index, retry)
+		}
+	}
+}
+
+func TestFromEnvPeriodic(t *testing.T) {
+	t.Setenv("TEST_DIAL_POLICY", "periodic")
+	t.Setenv("TEST_DIAL_MAX_ATTEMPTS", "3")
+	t.Setenv("TEST_DIAL_UNIT", "200ms")
+
+	policy, err := FromEnv("TEST")
+	if err != nil {
+		t.Fatalf("Expected FromEnv to succeed; got %v", err)
+	}
+
+	retries := drainPolicy(t, policy)
+	if len(retries) != 3 {
+		t.Fatalf("Expected 3 attempts; got %d", len(retries))
+	}
+	for index, retry := range retries {
+		if retry != 200*time.Millisecond {
+			t.Fatalf("[attempt %d] Expected retry interval to be 200ms; got %v", index, retry)
+		}
+	}
+}
+
+func TestFromEnvPeriodicJitter(t *testing.T) {
+	t.Setenv("TEST_DIAL_POLICY", "periodicJitter")
+	t.Setenv("TEST_DIAL_MAX_ATTEMPTS", "20")
+	t.Setenv("TEST_DIAL_UNIT", "100ms")
+	t.Setenv("TEST_DIAL_JITTER", "0.5")
+
+	policy, err := FromEnv("TEST_")
+	if err != nil {
+		t.Fatalf("Expected FromEnv to succeed; got %v", err)
+	}
+
+	retries := drainPolicy(t, policy)
+	if len(retries) != 20 {
+		t.Fatalf("Expected 20 attempts; got %d", len(retries))
+	}
+	for index, retry := range retries {
+		if retry < 50*time.Millisecond || retry > 150*time.Millisecond {
+			t.Fatalf("[attempt %d] Expected retry interval to be within [50ms, 150ms]; got %v", index, retry)
+		}
+	}
+}
+
+func TestFromEnvExpBackoff(t *testing.T) {
+	t.Setenv("TEST_DIAL_POLICY", "expBackoff")
+	t.Setenv("TEST_DIAL_MAX_ATTEMPTS", "4")
+	t.Setenv("TEST_DIAL_UNIT", "1ms")
+
+	policy, err := FromEnv("TEST")
+	if err != nil {
+		t.Fatalf("Expected FromEnv to succeed; got %v", err)
+	}
+
+	retries := drainPolicy(t, policy)
+	if len(retries) != 4 {
+		t.Fatalf("Expected 4 attempts; got %d", len(retries))
+	}
+	for index, retry := range retries {
+		if max := time.Duration(1<<uint(index+1)-1) * time.Millisecond; retry > max {
+			t.Fatalf("[attempt %d] Expected retry interval to be at most %v; got %v", index, max, retry)
+		}
+	}
+}
+
+func TestFromEnvInvalidValues(t *testing.T) {
+	specs := map[string]string{
+		"TEST_DIAL_POLICY":       "linear",
+		"TEST_DIAL_MAX_ATTEMPTS": "-1",
+		"TEST_DIAL_UNIT":         "10",
+		"TEST_DIAL_JITTER":       "-0.5",
+	}
+
+	for key, val := range specs {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, val)
+			if _, err := FromEnv("TEST"); err == nil {
+				t.Fatalf("Expected FromEnv to reject %s=%s", key, val)
+			}
+		})
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	policy, err := FromConfig(map[string]string{"policy": "periodic", "maxAttempts": "2", "unit": "5ms"})
+	if err != nil {
+		t.Fatalf("Expected FromConfig to succeed; got %v", err)
+	}
+	if retries := drainPolicy(t, policy); len(retries) != 2 || retries[0] != 5*time.Millisecond {
+		t.Fatalf("Expected 2 attempts with a 5ms interval; got %v", retries)
+	}
+}
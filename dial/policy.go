@@ -1,6 +1,8 @@
 package dial
 
 import (
+	"context"
+	"fmt"
 	"math/rand"
 	"sync"
 	"time"
@@ -36,6 +38,9 @@ type dialPolicyImpl struct {
 	curAttempt uint32
 
 	retryGenerator func(curAttempt uint32) (time.Duration, error)
+
+	// A description of the policy settings returned by Describe.
+	descr string
 }
 
 // Reset the attempt counter. Implements the DialPolicy interface.
@@ -63,6 +68,36 @@ func (d *dialPolicyImpl) NextRetry() (time.Duration, error) {
 	return d.retryGenerator(d.curAttempt)
 }
 
+// Get a copy of the dial policy with its attempt counter reset. Invoked by Clone.
+func (d *dialPolicyImpl) Clone() Policy {
+	d.Lock()
+	defer d.Unlock()
+
+	return &dialPolicyImpl{
+		curAttempt:     0,
+		retryGenerator: d.retryGenerator,
+		descr:          d.descr,
+	}
+}
+
+// Describe the policy settings. Implements the Describer interface.
+func (d *dialPolicyImpl) Describe() string {
+	return d.descr
+}
+
+// Get an independent copy of p with its attempt counter reset. Since policies
+// track the current attempt, a single policy instance must not be used by more
+// than one service at the same time; services clone the policy passed to
+// SetDialPolicy so that it can be safely shared. Policies that implement a
+// Clone() Policy method are copied via that method; any other policy is
+// returned as-is.
+func Clone(p Policy) Policy {
+	if c, ok := p.(interface{ Clone() Policy }); ok {
+		return c.Clone()
+	}
+	return p
+}
+
 // Implements an periodic dial policy that returns
 // the same time.Duration value between all attempts.
 func Periodic(maxAttempts uint32, retry time.Duration) *dialPolicyImpl {
@@ -79,6 +114,36 @@ func Periodic(maxAttempts uint32, retry time.Duration) *dialPolicyImpl {
 
 			return retry, nil
 		},
+		descr: fmt.Sprintf("periodic(max=%d, retry=%v)", maxAttempts, retry),
+	}
+}
+
+// Implements a periodic dial policy that randomizes each interval by up to
+// ±jitter*retry so that clients reconnecting at the same time spread out their
+// attempts. A jitter of 0.1 yields values in [0.9*retry, 1.1*retry]. Negative
+// jitter values are treated as 0 and the returned intervals are never negative.
+func PeriodicJitter(maxAttempts uint32, retry time.Duration, jitter float64) *dialPolicyImpl {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	if jitter < 0 {
+		jitter = 0
+	}
+
+	return &dialPolicyImpl{
+		curAttempt: 0,
+		retryGenerator: func(curAttempt uint32) (time.Duration, error) {
+			if curAttempt > maxAttempts {
+				return 0, ErrTimeout
+			}
+
+			next := retry + time.Duration(float64(retry)*jitter*(2*rand.Float64()-1))
+			if next < 0 {
+				next = 0
+			}
+			return next, nil
+		},
+		descr: fmt.Sprintf("periodicJitter(max=%d, retry=%v, jitter=%v)", maxAttempts, retry, jitter),
 	}
 }
 
@@ -103,5 +168,131 @@ func ExpBackoff(maxAttempts uint32, retryUnit time.Duration) *dialPolicyImpl {
 
 			return retryUnit * time.Duration(rand.Int63n(1<<curAttempt)), nil
 		},
+		descr: fmt.Sprintf("expBackoff(max=%d, unit=%v)", maxAttempts, retryUnit),
+	}
+}
+
+// A dial policy that uses retry intervals suggested by the server (e.g. via a
+// Retry-After header) and falls back to another policy for attempts without a
+// suggestion.
+type serverDirectedPolicy struct {
+	// A mutex for guarding changes to the struct fields.
+	sync.Mutex
+
+	fallback Policy
+
+	suggested     time.Duration
+	haveSuggested bool
+}
+
+// Implements a dial policy that honors retry intervals supplied via SuggestNext.
+// A suggested interval is only used for the next retry; other retries use the
+// intervals generated by fallback. Attempts are always counted by fallback so
+// its max number of attempts still applies.
+func ServerDirected(fallback Policy) *serverDirectedPolicy {
+	return &serverDirectedPolicy{
+		fallback: fallback,
+	}
+}
+
+// Use d as the interval for the next retry.
+func (d *serverDirectedPolicy) SuggestNext(next time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.suggested, d.haveSuggested = next, true
+}
+
+// Reset the attempt counter and discard any suggested interval. Implements the DialPolicy interface.
+func (d *serverDirectedPolicy) ResetAttempts() {
+	d.Lock()
+	defer d.Unlock()
+
+	d.haveSuggested = false
+	d.fallback.ResetAttempts()
+}
+
+// Get the attempt counter. Implements the DialPolicy interface.
+func (d *serverDirectedPolicy) CurAttempt() uint32 {
+	return d.fallback.CurAttempt()
+}
+
+// Get the next retry interval. Implements the DialPolicy interface.
+func (d *serverDirectedPolicy) NextRetry() (time.Duration, error) {
+	return d.NextRetryContext(context.Background())
+}
+
+// Get the next retry interval, forwarding ctx to the fallback policy (see
+// NextRetryContext).
+func (d *serverDirectedPolicy) NextRetryContext(ctx context.Context) (time.Duration, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	next, err := NextRetryContext(ctx, d.fallback)
+	if err != nil {
+		return 0, err
+	}
+
+	if d.haveSuggested {
+		next = d.suggested
+		d.haveSuggested = false
 	}
+	return next, nil
+}
+
+// Forward the outcome of a dial attempt to the fallback policy (see RecordOutcome).
+func (d *serverDirectedPolicy) RecordOutcome(success bool) {
+	RecordOutcome(d.fallback, success)
+}
+
+// Get a copy of the dial policy with its attempt counter reset. Invoked by Clone.
+func (d *serverDirectedPolicy) Clone() Policy {
+	return ServerDirected(Clone(d.fallback))
+}
+
+// Describe the policy settings. Implements the Describer interface.
+func (d *serverDirectedPolicy) Describe() string {
+	return "serverDirected(" + Describe(d.fallback) + ")"
+}
+
+// Policies that can describe their settings, e.g. for logging the retry
+// configuration of a service. All built-in policies implement this interface.
+type Describer interface {
+
+	// Get a description of the policy settings such as "expBackoff(max=10, unit=1s)".
+	Describe() string
+}
+
+// Describe the settings of p. Policies that do not implement Describer are
+// described by their type (e.g. "*mypkg.customPolicy").
+func Describe(p Policy) string {
+	if d, ok := p.(Describer); ok {
+		return d.Describe()
+	}
+	return fmt.Sprintf("%T", p)
+}
+
+// Errors implementing RetryHint carry a server-supplied hint for how long to
+// wait before the next dial attempt.
+type RetryHint interface {
+	RetryAfter() time.Duration
+}
+
+// If err (or any error it wraps) carries a positive retry hint, suggest it to p.
+// Policies that do not accept suggestions (see ServerDirected) are left unmodified.
+func SuggestFromError(p Policy, err error) {
+	var hint RetryHint
+	if !errors.As(err, &hint) || hint.RetryAfter() <= 0 {
+		return
+	}
+
+	if s, ok := p.(interface{ SuggestNext(time.Duration) }); ok {
+		s.SuggestNext(hint.RetryAfter())
+	}
+}
+
+// Wrap the error returned by the last dial attempt once a dial policy gives up.
+// The returned error matches both ErrTimeout and lastErr when checked with errors.Is.
+func Exhausted(attempts int, lastErr error) error {
+	return fmt.Errorf("dial exhausted after %d attempt(s): %w: %w", attempts, ErrTimeout, lastErr)
 }
@@ -1,6 +1,8 @@
 package dial
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -58,6 +60,68 @@ func TestPeriodicPolicyLimits(t *testing.T) {
 	}
 }
 
+func TestPeriodicJitterPolicy(t *testing.T) {
+	var maxAttempts uint32 = 1000
+	var attempt uint32
+	period := time.Second
+	jitter := 0.2
+	policy := PeriodicJitter(maxAttempts, period, jitter)
+
+	minRetry := time.Duration(float64(period) * (1 - jitter))
+	maxRetry := time.Duration(float64(period) * (1 + jitter))
+	distinct := make(map[time.Duration]struct{})
+	for attempt = 0; attempt < maxAttempts; attempt++ {
+		next, err := policy.NextRetry()
+		if err != nil {
+			t.Fatalf("Expected to get the next attempt duration; got error %v", err)
+		}
+
+		if next < minRetry || next > maxRetry {
+			t.Fatalf("Expected to get a next attempt duration in the range [%d, %d]; got %d", minRetry, maxRetry, next)
+		}
+		distinct[next] = struct{}{}
+	}
+	if len(distinct) < 2 {
+		t.Fatalf("Expected jittered attempt durations to vary")
+	}
+
+	// The next attempt should fail
+	_, err := policy.NextRetry()
+	if err == nil {
+		t.Fatalf("Expected to fail after exceeding maxAttempts=%d", maxAttempts)
+	}
+}
+
+func TestPeriodicJitterPolicyLimits(t *testing.T) {
+	// Intervals should never be negative even if jitter exceeds 1
+	policy := PeriodicJitter(100, time.Second, 5)
+	for attempt := 0; attempt < 100; attempt++ {
+		next, err := policy.NextRetry()
+		if err != nil {
+			t.Fatalf("Expected to get the next attempt duration; got error %v", err)
+		}
+		if next < 0 {
+			t.Fatalf("Expected a non-negative attempt duration; got %d", next)
+		}
+	}
+
+	// Negative jitter values should disable jitter
+	policy = PeriodicJitter(0, time.Second, -1)
+	next, err := policy.NextRetry()
+	if err != nil {
+		t.Fatalf("Expected to get the next attempt duration; got error %v", err)
+	}
+	if next != time.Second {
+		t.Fatalf("Expected to get a next attempt duration equal to %d; got %d", time.Second, next)
+	}
+
+	// The next attempt should fail
+	_, err = policy.NextRetry()
+	if err == nil {
+		t.Fatalf("Expected to fail after exceeding maxAttempts=%d", 1)
+	}
+}
+
 func TestExpBackoffPolicy(t *testing.T) {
 	var maxAttempts uint32 = 10
 	var attempt uint32
@@ -117,3 +181,158 @@ func TestExpBackoffPolicyLimits(t *testing.T) {
 		t.Fatalf("Expected to fail after exceeding maxAttempts=%d", 32)
 	}
 }
+
+func TestExhausted(t *testing.T) {
+	dialErr := errors.New("connection refused")
+
+	err := Exhausted(3, dialErr)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected error to match ErrTimeout; got %v", err)
+	}
+	if !errors.Is(err, dialErr) {
+		t.Fatalf("Expected error to match the last dial error; got %v", err)
+	}
+	if expected := "dial exhausted after 3 attempt(s): Connection timeout: connection refused"; err.Error() != expected {
+		t.Fatalf("Expected error message to be %q; got %q", expected, err.Error())
+	}
+}
+
+func TestClone(t *testing.T) {
+	policy := Periodic(2, time.Millisecond)
+	clone := Clone(policy)
+
+	for attempt := 0; attempt < 3; attempt++ {
+		policy.NextRetry()
+	}
+	if attempt := policy.CurAttempt(); attempt != 3 {
+		t.Fatalf("Expected CurAttempt() to return 3; got %d", attempt)
+	}
+
+	// The clone should keep its own attempt counter
+	if attempt := clone.CurAttempt(); attempt != 0 {
+		t.Fatalf("Expected clone CurAttempt() to return 0; got %d", attempt)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := clone.NextRetry(); err != nil {
+			t.Fatalf("Expected to get the next attempt duration; got error %v", err)
+		}
+	}
+	if _, err := clone.NextRetry(); err == nil {
+		t.Fatalf("Expected clone to fail after exceeding maxAttempts=%d", 2)
+	}
+
+	// Cloning a policy with used attempts should reset the counter
+	if attempt := Clone(policy).CurAttempt(); attempt != 0 {
+		t.Fatalf("Expected the clone attempt counter to be reset; got %d", attempt)
+	}
+}
+
+// A dial policy that does not support cloning.
+type staticPolicy struct{}
+
+func (staticPolicy) ResetAttempts()                    {}
+func (staticPolicy) CurAttempt() uint32                { return 0 }
+func (staticPolicy) NextRetry() (time.Duration, error) { return 0, nil }
+
+func TestCloneUnsupportedPolicy(t *testing.T) {
+	policy := &staticPolicy{}
+	if clone := Clone(policy); clone != Policy(policy) {
+		t.Fatalf("Expected policies without a Clone method to be returned as-is")
+	}
+}
+
+// An error carrying a retry hint.
+type hintError time.Duration
+
+func (e hintError) Error() string             { return "busy" }
+func (e hintError) RetryAfter() time.Duration { return time.Duration(e) }
+
+func TestServerDirectedPolicy(t *testing.T) {
+	period := 10 * time.Millisecond
+	policy := ServerDirected(Periodic(3, period))
+
+	policy.SuggestNext(time.Second)
+	expRetries := []time.Duration{time.Second, period, period}
+	for index, expRetry := range expRetries {
+		next, err := policy.NextRetry()
+		if err != nil {
+			t.Fatalf("[attempt %d] Expected to get the next attempt duration; got error %v", index, err)
+		}
+		if next != expRetry {
+			t.Fatalf("[attempt %d] Expected to get a next attempt duration equal to %d; got %d", index, expRetry, next)
+		}
+	}
+
+	// Suggestions should not bypass the fallback attempt limit
+	policy.SuggestNext(time.Second)
+	if _, err := policy.NextRetry(); err != ErrTimeout {
+		t.Fatalf("Expected to fail after exceeding maxAttempts=%d; got %v", 3, err)
+	}
+	if attempt := policy.CurAttempt(); attempt != 4 {
+		t.Fatalf("Expected CurAttempt() to return 4; got %d", attempt)
+	}
+
+	// Resetting should discard pending suggestions
+	policy.SuggestNext(time.Second)
+	policy.ResetAttempts()
+	if next, _ := policy.NextRetry(); next != period {
+		t.Fatalf("Expected ResetAttempts to discard the suggested duration; got %d", next)
+	}
+}
+
+func TestSuggestFromError(t *testing.T) {
+	period := 10 * time.Millisecond
+	policy := ServerDirected(Periodic(5, period))
+
+	SuggestFromError(policy, errors.New("no hint"))
+	SuggestFromError(policy, hintError(0))
+	if next, _ := policy.NextRetry(); next != period {
+		t.Fatalf("Expected errors without a positive hint to be ignored; got %d", next)
+	}
+
+	SuggestFromError(policy, fmt.Errorf("dial failed: %w", hintError(time.Second)))
+	if next, _ := policy.NextRetry(); next != time.Second {
+		t.Fatalf("Expected to get the hinted duration %d; got %d", time.Second, next)
+	}
+
+	// Policies that do not accept suggestions should be left unmodified
+	periodic := Periodic(5, period)
+	SuggestFromError(periodic, hintError(time.Second))
+	if next, _ := periodic.NextRetry(); next != period {
+		t.Fatalf("Expected to get a next attempt duration equal to %d; got %d", period, next)
+	}
+
+	// Clones should not inherit pending suggestions
+	policy.SuggestNext(time.Second)
+	if next, _ := Clone(policy).NextRetry(); next != period {
+		t.Fatalf("Expected clone to ignore the suggested duration; got %d", next)
+	}
+}
+
+// A policy that does not implement Describer.
+type undescribedPolicy struct {
+	Policy
+}
+
+func TestDescribe(t *testing.T) {
+	specs := []struct {
+		policy Policy
+		exp    string
+	}{
+		{Periodic(5, time.Second), "periodic(max=5, retry=1s)"},
+		{PeriodicJitter(20, 100*time.Millisecond, 0.5), "periodicJitter(max=20, retry=100ms, jitter=0.5)"},
+		{ExpBackoff(10, time.Second), "expBackoff(max=10, unit=1s)"},
+		{ExpBackoff(64, time.Millisecond), "expBackoff(max=32, unit=1ms)"},
+		{Adaptive(10*time.Millisecond, time.Second), "adaptive(min=10ms, max=1s)"},
+		{ServerDirected(Periodic(3, time.Second)), "serverDirected(periodic(max=3, retry=1s))"},
+		{SharedBudget(2).Wrap(ExpBackoff(4, time.Second)), "budget(maxConcurrent=2, expBackoff(max=4, unit=1s))"},
+		{Clone(Periodic(5, time.Second)), "periodic(max=5, retry=1s)"},
+		{undescribedPolicy{}, "dial.undescribedPolicy"},
+	}
+
+	for index, spec := range specs {
+		if descr := Describe(spec.policy); descr != spec.exp {
+			t.Errorf("[spec %d] Expected description %q; got %q", index, spec.exp, descr)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package dial
+
+import (
+	"context"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
+)
+
+// Perform one policy-driven sleep between dial attempts. The next retry interval is
+// obtained from p and the call blocks until it elapses. Returns ErrTimeout if p gives
+// up or ctx.Err() if ctx is done before the interval elapses or, for policies subject
+// to a Budget, before a budget token becomes available.
+func Sleep(ctx context.Context, p Policy) error {
+	return SleepNotify(ctx, p, nil)
+}
+
+// Perform one policy-driven sleep like Sleep. If scheduled is not nil, it is invoked
+// with the retry interval before sleeping (e.g. for logging the scheduled retry); it
+// is not invoked if p gives up.
+func SleepNotify(ctx context.Context, p Policy, scheduled func(wait time.Duration)) error {
+	wait, err := NextRetryContext(ctx, p)
+	switch {
+	case err != nil && err == ctx.Err():
+		return err
+	case err != nil:
+		return ErrTimeout
+	}
+
+	if scheduled != nil {
+		scheduled(wait)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clock.Default.After(wait):
+		return nil
+	}
+}
+
+// Get the next retry interval of p. Policies subject to a Budget block until a budget
+// token becomes available; if ctx is done first, ctx.Err() is returned. Other policies
+// are equivalent to calling p.NextRetry.
+func NextRetryContext(ctx context.Context, p Policy) (time.Duration, error) {
+	if c, ok := p.(interface {
+		NextRetryContext(context.Context) (time.Duration, error)
+	}); ok {
+		return c.NextRetryContext(ctx)
+	}
+	return p.NextRetry()
+}
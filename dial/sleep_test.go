@@ -0,0 +1,54 @@
+package dial
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
+)
+
+func TestSleep(t *testing.T) {
+	sleeps := clock.UseFake(t)
+	policy := Periodic(2, time.Hour)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := Sleep(context.Background(), policy); err != nil {
+			t.Fatalf("[attempt %d] Expected Sleep to succeed; got %v", attempt, err)
+		}
+	}
+	if got := sleeps.Sleeps(); !reflect.DeepEqual(got, []time.Duration{time.Hour, time.Hour}) {
+		t.Fatalf("Expected two 1h sleeps; got %v", got)
+	}
+}
+
+func TestSleepExhausted(t *testing.T) {
+	sleeps := clock.UseFake(t)
+	policy := Periodic(1, time.Hour)
+	policy.NextRetry()
+
+	scheduled := false
+	err := SleepNotify(context.Background(), policy, func(time.Duration) { scheduled = true })
+	if err != ErrTimeout {
+		t.Fatalf("Expected Sleep to fail with ErrTimeout; got %v", err)
+	}
+	if scheduled || len(sleeps.Sleeps()) != 0 {
+		t.Fatalf("Expected no retry to be scheduled once the policy gives up")
+	}
+}
+
+func TestSleepCancelled(t *testing.T) {
+	policy := Periodic(1, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wait time.Duration
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := SleepNotify(ctx, policy, func(d time.Duration) { wait = d })
+	if err != context.Canceled {
+		t.Fatalf("Expected Sleep to fail with context.Canceled; got %v", err)
+	}
+	if wait != time.Hour {
+		t.Fatalf("Expected the scheduled retry interval to be 1h; got %v", wait)
+	}
+}
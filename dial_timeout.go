@@ -0,0 +1,37 @@
+package adapters
+
+import (
+	"context"
+	"time"
+)
+
+// Dial a service and wait up to timeout for the connection to be established
+// regardless of the service's dial policy. If the timeout expires first, the
+// context passed to DialContext is cancelled to signal the adapter to abort
+// and ErrDialTimeout is returned. Should the adapter connect after the timeout
+// has expired, the service is closed.
+func DialTimeout(s Service, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- s.DialContext(ctx)
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil && err == ctx.Err() {
+			return ErrDialTimeout
+		}
+		return err
+	case <-ctx.Done():
+		// Ensure that a late dial does not leave the service connected
+		go func() {
+			if err := <-result; err == nil {
+				s.Close()
+			}
+		}()
+		return ErrDialTimeout
+	}
+}
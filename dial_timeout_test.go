@@ -0,0 +1,106 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters/dial"
+)
+
+// A fake service whose DialContext blocks until release is closed. If
+// ignoreContext is false, DialContext also returns when its context is done
+// unless release has already been closed.
+type hangingService struct {
+	release       chan struct{}
+	ignoreContext bool
+	dialErr       error
+	closed        int32
+}
+
+func (s *hangingService) Dial() error { return s.DialContext(context.Background()) }
+func (s *hangingService) DialContext(ctx context.Context) error {
+	if s.ignoreContext {
+		<-s.release
+		return s.dialErr
+	}
+
+	// Services that have already been released always complete their dial
+	select {
+	case <-s.release:
+		return s.dialErr
+	default:
+	}
+
+	select {
+	case <-s.release:
+		return s.dialErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+func (s *hangingService) Close() error {
+	atomic.StoreInt32(&s.closed, 1)
+	return nil
+}
+func (s *hangingService) CloseContext(ctx context.Context) error { return s.Close() }
+func (s *hangingService) NotifyClose(c CloseListener)            {}
+func (s *hangingService) SetOptions(opts ...ServiceOption) error { return nil }
+func (s *hangingService) SetLogger(logger *log.Logger)           {}
+func (s *hangingService) SetDialPolicy(policy dial.Policy)       {}
+func (s *hangingService) SetMetrics(m Metrics)                   {}
+func (s *hangingService) SetTracer(t Tracer)                     {}
+func (s *hangingService) Config(params map[string]string) error  { return nil }
+func (s *hangingService) ConfigChanged() bool                    { return false }
+
+func TestDialTimeoutExpires(t *testing.T) {
+	srv := &hangingService{release: make(chan struct{})}
+
+	start := time.Now()
+	err := DialTimeout(srv, 20*time.Millisecond)
+	if err != ErrDialTimeout {
+		t.Fatalf("Expected DialTimeout to fail with ErrDialTimeout; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected DialTimeout to return promptly; took %v", elapsed)
+	}
+}
+
+func TestDialTimeoutClosesLateConnection(t *testing.T) {
+	srv := &hangingService{release: make(chan struct{}), ignoreContext: true}
+
+	err := DialTimeout(srv, 20*time.Millisecond)
+	if err != ErrDialTimeout {
+		t.Fatalf("Expected DialTimeout to fail with ErrDialTimeout; got %v", err)
+	}
+
+	close(srv.release)
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&srv.closed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the service to be closed after a late successful dial")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDialTimeoutReturnsDialResult(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+
+	srv := &hangingService{release: release}
+	if err := DialTimeout(srv, time.Second); err != nil {
+		t.Fatalf("Expected DialTimeout to succeed; got %v", err)
+	}
+
+	srv = &hangingService{release: release, dialErr: errors.New("auth failed")}
+	if err := DialTimeout(srv, time.Second); err != srv.dialErr {
+		t.Fatalf("Expected DialTimeout to return the dial error; got %v", err)
+	}
+	if atomic.LoadInt32(&srv.closed) != 0 {
+		t.Fatalf("Expected DialTimeout not to close the service")
+	}
+}
@@ -0,0 +1,182 @@
+package adapters
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The number of events buffered by an EventStream before new events are dropped.
+const eventStreamBuffer = 64
+
+// The type of a connection event.
+type EventType int
+
+// Supported connection event types.
+const (
+	// The service connected successfully.
+	EventConnect EventType = iota
+
+	// The service was disconnected. Err is ErrConnectionClosed if the service was
+	// closed by the user or the reason for the connection reset otherwise.
+	EventDisconnect
+
+	// Config modified the service settings.
+	EventConfigChanged
+
+	// A dial attempt failed and a new one has been scheduled. Attempt is the number of
+	// the failed attempt, Wait the delay before the next one and Err the dial error.
+	// The event is emitted before the dial loop starts waiting, including after the
+	// first failed attempt.
+	EventRetryScheduled
+
+	// The service gave up dialing. Err contains the error returned by the dial.
+	EventDialFailed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventConnect:
+		return "connect"
+	case EventDisconnect:
+		return "disconnect"
+	case EventConfigChanged:
+		return "configChanged"
+	case EventRetryScheduled:
+		return "retryScheduled"
+	case EventDialFailed:
+		return "dialFailed"
+	}
+	return "unknown"
+}
+
+// A connection event emitted by a service adapter.
+type Event struct {
+	Type EventType
+
+	// The time the event occurred.
+	Time time.Time
+
+	// The dial attempt the event refers to or 0 if not applicable.
+	Attempt int
+
+	// The delay before the next dial attempt (EventRetryScheduled) or 0 if not applicable.
+	Wait time.Duration
+
+	// An optional error associated with the event.
+	Err error
+}
+
+// EventStream is a buffered stream of connection events. Emit never blocks;
+// if the buffer is full because nobody is consuming the stream, new events are
+// dropped. The stream also tracks the connection state implied by the emitted
+// events so that callers can wait for a connection without consuming them.
+// The zero value is ready to use.
+type EventStream struct {
+	once   sync.Once
+	events chan Event
+
+	// A mutex protecting the connection state.
+	mu sync.Mutex
+
+	// Set by connect events and cleared by disconnect events.
+	connected bool
+
+	// The error of the most recent failed dial while disconnected.
+	dialErr error
+
+	// Closed and reset whenever the connection state changes.
+	changed chan struct{}
+}
+
+func (s *EventStream) init() {
+	s.once.Do(func() {
+		s.events = make(chan Event, eventStreamBuffer)
+	})
+}
+
+// Get the channel that receives emitted events.
+func (s *EventStream) C() <-chan Event {
+	s.init()
+	return s.events
+}
+
+// Emit an event. If the event time is not set, it defaults to the current time.
+func (s *EventStream) Emit(evt Event) {
+	s.init()
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	s.updateState(evt)
+
+	select {
+	case s.events <- evt:
+	default:
+	}
+}
+
+// Update the connection state using evt and wake up any waiters.
+func (s *EventStream) updateState(evt Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch evt.Type {
+	case EventConnect:
+		s.connected, s.dialErr = true, nil
+	case EventDisconnect:
+		s.connected, s.dialErr = false, nil
+	case EventDialFailed:
+		// Failed dials of connected services (e.g. pool dials) do not affect the state
+		if s.connected {
+			return
+		}
+		s.dialErr = evt.Err
+	default:
+		return
+	}
+
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// Record that a new dial has started. This clears the error of the previous
+// failed dial so that WaitForConnection blocks until the new dial connects or
+// fails instead of returning the stale error. Adapters call DialStarted at the
+// beginning of DialContext; no event is emitted.
+func (s *EventStream) DialStarted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dialErr = nil
+}
+
+// Block until a connect event is emitted and return nil. If the most recent dial
+// fails, its error is returned instead; if ctx expires first, ctx.Err() is returned.
+// WaitForConnection returns immediately if the service is already connected or
+// if the most recent dial has already failed and no new dial has been started.
+func (s *EventStream) WaitForConnection(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.connected {
+			s.mu.Unlock()
+			return nil
+		}
+		if err := s.dialErr; err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if s.changed == nil {
+			s.changed = make(chan struct{})
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventStream(t *testing.T) {
+	var stream EventStream
+
+	dialErr := errors.New("connection refused")
+	stream.Emit(Event{Type: EventRetryScheduled, Attempt: 1, Err: dialErr})
+	stream.Emit(Event{Type: EventConnect, Attempt: 2})
+
+	evt := <-stream.C()
+	if evt.Type != EventRetryScheduled || evt.Attempt != 1 || evt.Err != dialErr {
+		t.Fatalf("Expected a retryScheduled event for attempt 1; got %+v", evt)
+	}
+	if evt.Time.IsZero() {
+		t.Fatalf("Expected event time to be set")
+	}
+
+	evt = <-stream.C()
+	if evt.Type != EventConnect || evt.Attempt != 2 {
+		t.Fatalf("Expected a connect event for attempt 2; got %+v", evt)
+	}
+}
+
+func TestEventStreamDropsWhenFull(t *testing.T) {
+	var stream EventStream
+
+	// Emit should never block even if nobody consumes the stream
+	for i := 0; i < eventStreamBuffer*2; i++ {
+		stream.Emit(Event{Type: EventConfigChanged, Attempt: i})
+	}
+
+	if buffered := len(stream.C()); buffered != eventStreamBuffer {
+		t.Fatalf("Expected %d buffered events; got %d", eventStreamBuffer, buffered)
+	}
+	if evt := <-stream.C(); evt.Attempt != 0 {
+		t.Fatalf("Expected the oldest event to be retained; got %+v", evt)
+	}
+}
+
+func TestEventStreamWaitForConnection(t *testing.T) {
+	var stream EventStream
+
+	// Connect after a delay
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		stream.Emit(Event{Type: EventRetryScheduled, Attempt: 1})
+		time.Sleep(20 * time.Millisecond)
+		stream.Emit(Event{Type: EventConnect, Attempt: 2})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := stream.WaitForConnection(ctx); err != nil {
+		t.Fatalf("Expected WaitForConnection to succeed; got %v", err)
+	}
+
+	// Return immediately while connected
+	if err := stream.WaitForConnection(ctx); err != nil {
+		t.Fatalf("Expected WaitForConnection to succeed while connected; got %v", err)
+	}
+
+	// Failed dials while connected should be ignored
+	stream.Emit(Event{Type: EventDialFailed, Err: errors.New("pool dial failed")})
+	if err := stream.WaitForConnection(ctx); err != nil {
+		t.Fatalf("Expected WaitForConnection to ignore dial failures while connected; got %v", err)
+	}
+
+	// The events should still be delivered to the stream consumer
+	if buffered := len(stream.C()); buffered != 3 {
+		t.Fatalf("Expected 3 buffered events; got %d", buffered)
+	}
+}
+
+func TestEventStreamWaitForConnectionDialFailure(t *testing.T) {
+	var stream EventStream
+	stream.Emit(Event{Type: EventConnect})
+	stream.Emit(Event{Type: EventDisconnect})
+
+	dialErr := errors.New("connection refused")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		stream.Emit(Event{Type: EventDialFailed, Err: dialErr})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := stream.WaitForConnection(ctx); err != dialErr {
+		t.Fatalf("Expected WaitForConnection to fail with %v; got %v", dialErr, err)
+	}
+
+	// A subsequent connection clears the failure
+	stream.Emit(Event{Type: EventConnect})
+	if err := stream.WaitForConnection(ctx); err != nil {
+		t.Fatalf("Expected WaitForConnection to succeed; got %v", err)
+	}
+}
+
+func TestEventStreamWaitForConnectionRedial(t *testing.T) {
+	var stream EventStream
+	dialErr := errors.New("connection refused")
+	stream.Emit(Event{Type: EventDialFailed, Err: dialErr})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := stream.WaitForConnection(ctx); err != dialErr {
+		t.Fatalf("Expected WaitForConnection to fail with %v; got %v", dialErr, err)
+	}
+
+	// A new dial clears the stale error; waiters block until it completes
+	stream.DialStarted()
+	result := make(chan error, 1)
+	go func() { result <- stream.WaitForConnection(ctx) }()
+
+	select {
+	case err := <-result:
+		t.Fatalf("Expected WaitForConnection to block while the new dial is in progress; got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	stream.Emit(Event{Type: EventConnect})
+	if err := <-result; err != nil {
+		t.Fatalf("Expected WaitForConnection to succeed once the new dial connects; got %v", err)
+	}
+}
+
+func TestEventStreamWaitForConnectionCancelled(t *testing.T) {
+	var stream EventStream
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := stream.WaitForConnection(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected WaitForConnection to fail with DeadlineExceeded; got %v", err)
+	}
+}
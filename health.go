@@ -0,0 +1,99 @@
+package adapters
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Services that can report their connection health. All service adapters in this
+// package implement this interface.
+type HealthReporter interface {
+
+	// Report whether the service is connected.
+	IsConnected() bool
+
+	// Get the error that caused the most recent dial to fail; nil if the most
+	// recent dial succeeded or no dial has been attempted yet.
+	LastError() error
+}
+
+// Services that can report a snapshot of their connection state. The redis, amqp and
+// etcd adapters implement this interface.
+type StatusReporter interface {
+
+	// Get a snapshot of the connection state of the service.
+	Status() Status
+}
+
+// A JSON-serializable snapshot of the connection state of a service, e.g. for
+// serving from a status endpoint. Adapters that dial pooled connections lazily
+// (redis) report the dial fields of the most recent pooled connection dial.
+type Status struct {
+	// Whether the service is connected.
+	Connected bool `json:"connected"`
+
+	// The configured service endpoint.
+	Endpoint string `json:"endpoint"`
+
+	// The error that caused the most recent dial to fail; empty if it succeeded.
+	LastError string `json:"lastError"`
+
+	// The number of attempts made by the most recent dial.
+	DialAttempts uint32 `json:"dialAttempts"`
+
+	// The time of the most recent successful dial; zero if no dial succeeded yet.
+	LastConnectedAt time.Time `json:"lastConnectedAt"`
+
+	// The connection mode used by the service (e.g. "cluster" or "standalone" for
+	// redis); empty for services that only support a single mode.
+	Mode string `json:"mode,omitempty"`
+}
+
+// The health of a single service as reported by HealthHandler.
+type ServiceHealth struct {
+	Connected bool   `json:"connected"`
+	LastError string `json:"lastError"`
+}
+
+// Create an http.Handler (e.g. for readiness probes) that reports the health of
+// services keyed by name. The handler responds with 200 if all services are
+// connected and 503 otherwise. The response body is a JSON object mapping each
+// service name to its ServiceHealth. Services that do not implement HealthReporter
+// are reported as not connected.
+func HealthHandler(services map[string]Service) http.Handler {
+	named := make(map[string]Service, len(services))
+	for name, s := range services {
+		named[name] = s
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		report := make(map[string]ServiceHealth, len(named))
+		for name, s := range named {
+			health := checkHealth(s)
+			if !health.Connected {
+				status = http.StatusServiceUnavailable
+			}
+			report[name] = health
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// Get the health of service s.
+func checkHealth(s Service) ServiceHealth {
+	reporter, ok := s.(HealthReporter)
+	if !ok {
+		return ServiceHealth{LastError: "service does not report its health"}
+	}
+
+	health := ServiceHealth{Connected: reporter.IsConnected()}
+	if err := reporter.LastError(); err != nil {
+		health.LastError = err.Error()
+	}
+	return health
+}
@@ -0,0 +1,100 @@
+package adapters
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A service that reports a fixed health status.
+type healthyService struct {
+	Service
+	connected bool
+	lastErr   error
+}
+
+func (s *healthyService) IsConnected() bool { return s.connected }
+func (s *healthyService) LastError() error  { return s.lastErr }
+
+func getHealth(t *testing.T, handler http.Handler) (int, map[string]ServiceHealth) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Expected Content-Type to be application/json; got %q", contentType)
+	}
+
+	var report map[string]ServiceHealth
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Expected a JSON body; got %q (%v)", rec.Body.String(), err)
+	}
+	return rec.Code, report
+}
+
+func TestHealthHandler(t *testing.T) {
+	redis := &healthyService{connected: true}
+	amqp := &healthyService{connected: true}
+	handler := HealthHandler(map[string]Service{"redis": redis, "amqp": amqp})
+
+	code, report := getHealth(t, handler)
+	if code != http.StatusOK {
+		t.Fatalf("Expected status %d; got %d", http.StatusOK, code)
+	}
+	expReport := map[string]ServiceHealth{
+		"redis": {Connected: true},
+		"amqp":  {Connected: true},
+	}
+	if !reflect.DeepEqual(report, expReport) {
+		t.Fatalf("Expected report %+v; got %+v", expReport, report)
+	}
+
+	// A single disconnected service makes the whole report unhealthy
+	amqp.connected, amqp.lastErr = false, errors.New("connection refused")
+	code, report = getHealth(t, handler)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d; got %d", http.StatusServiceUnavailable, code)
+	}
+	expReport["amqp"] = ServiceHealth{Connected: false, LastError: "connection refused"}
+	if !reflect.DeepEqual(report, expReport) {
+		t.Fatalf("Expected report %+v; got %+v", expReport, report)
+	}
+}
+
+func TestHealthHandlerUnsupportedService(t *testing.T) {
+	handler := HealthHandler(map[string]Service{"custom": &hangingService{}})
+
+	code, report := getHealth(t, handler)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d; got %d", http.StatusServiceUnavailable, code)
+	}
+	if health := report["custom"]; health.Connected || health.LastError == "" {
+		t.Fatalf("Expected services without health reporting to be reported as unhealthy; got %+v", health)
+	}
+
+	// An empty service set is always healthy
+	if code, _ = getHealth(t, HealthHandler(nil)); code != http.StatusOK {
+		t.Fatalf("Expected status %d; got %d", http.StatusOK, code)
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	status := Status{
+		Connected:       true,
+		Endpoint:        "localhost:6379",
+		DialAttempts:    2,
+		LastConnectedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Expected Status to be serializable; got %v", err)
+	}
+
+	exp := `{"connected":true,"endpoint":"localhost:6379","lastError":"","dialAttempts":2,"lastConnectedAt":"2024-01-02T03:04:05Z"}`
+	if string(data) != exp {
+		t.Fatalf("Expected %s; got %s", exp, data)
+	}
+}
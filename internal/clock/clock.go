@@ -0,0 +1,90 @@
+// Package clock abstracts the passage of time so that the dial loops of the
+// service adapters can be tested without real delays.
+package clock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// A Clock provides the current time and timers.
+type Clock interface {
+
+	// Get a channel that receives the current time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+
+	// Get the current time.
+	Now() time.Time
+}
+
+// Default is the clock used by the dial loops. Tests may replace it with a Fake
+// and must restore it once they complete.
+var Default Clock = Real{}
+
+// Get the time elapsed since t according to the Default clock.
+func Since(t time.Time) time.Duration {
+	return Default.Now().Sub(t)
+}
+
+// Real is a Clock backed by the time package.
+type Real struct{}
+
+// Get a channel that receives the current time once d has elapsed.
+func (Real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Get the current time.
+func (Real) Now() time.Time { return time.Now() }
+
+// Fake is a Clock that never blocks. Each call to After advances the fake time by
+// d and returns a channel that fires immediately, so loops that sleep between
+// attempts run through all their attempts instantly.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+// Create a fake clock starting at start.
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Replace the Default clock with a fake clock starting at the current time and
+// restore it once t completes.
+func UseFake(t testing.TB) *Fake {
+	fake := NewFake(time.Now())
+	orig := Default
+	Default = fake
+	t.Cleanup(func() { Default = orig })
+	return fake
+}
+
+// Advance the fake time by d and get a channel that receives the new time.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sleeps = append(f.sleeps, d)
+	f.now = f.now.Add(d)
+
+	c := make(chan time.Time, 1)
+	c <- f.now
+	return c
+}
+
+// Get the fake time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// Get the durations passed to After.
+func (f *Fake) Sleeps() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]time.Duration(nil), f.sleeps...)
+}
@@ -0,0 +1,33 @@
+package clock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+
+	if now := <-f.After(time.Hour); !now.Equal(start.Add(time.Hour)) {
+		t.Fatalf("Expected After to fire at %v; got %v", start.Add(time.Hour), now)
+	}
+	<-f.After(time.Minute)
+
+	if now := f.Now(); !now.Equal(start.Add(time.Hour + time.Minute)) {
+		t.Fatalf("Expected fake time to be %v; got %v", start.Add(time.Hour+time.Minute), now)
+	}
+	if sleeps := f.Sleeps(); !reflect.DeepEqual(sleeps, []time.Duration{time.Hour, time.Minute}) {
+		t.Fatalf("Expected sleeps [1h 1m]; got %v", sleeps)
+	}
+
+	fake := UseFake(t)
+	if Default != Clock(fake) {
+		t.Fatalf("Expected UseFake to replace the Default clock")
+	}
+	<-fake.After(time.Hour)
+	if elapsed := Since(fake.Now().Add(-time.Minute)); elapsed != time.Minute {
+		t.Fatalf("Expected Since to use the Default clock; got %v", elapsed)
+	}
+}
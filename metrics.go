@@ -0,0 +1,43 @@
+package adapters
+
+import "time"
+
+// Metrics receives dial and connection events from service adapters. Each method
+// receives the name of the service (e.g. "redis") that emitted the event.
+type Metrics interface {
+
+	// Invoked before each connection attempt.
+	IncDialAttempt(service string)
+
+	// Invoked after each failed connection attempt with the error that caused the attempt to fail.
+	IncDialFailure(service string, err error)
+
+	// Invoked after a connection is established.
+	IncDialSuccess(service string)
+
+	// Invoked when a dial completes, fails or is aborted with the total time spent dialing.
+	ObserveDialDuration(service string, d time.Duration)
+
+	// Invoked when the service connects or disconnects.
+	SetConnected(service string, connected bool)
+}
+
+// Metrics sinks that also track pool saturation. Services that pool connections or
+// channels report the pool stats to their sink if it implements this interface.
+type PoolMetrics interface {
+
+	// Invoked when the pool of a service changes with the number of borrowed and idle
+	// pool entries and the max number of entries the service can allocate.
+	SetPoolStats(service string, inUse, idle, max int)
+}
+
+// NopMetrics is a Metrics implementation that discards all events. Services use it by default.
+var NopMetrics Metrics = nopMetrics{}
+
+type nopMetrics struct{}
+
+func (nopMetrics) IncDialAttempt(service string)                       {}
+func (nopMetrics) IncDialFailure(service string, err error)            {}
+func (nopMetrics) IncDialSuccess(service string)                       {}
+func (nopMetrics) ObserveDialDuration(service string, d time.Duration) {}
+func (nopMetrics) SetConnected(service string, connected bool)         {}
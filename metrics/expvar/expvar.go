@@ -0,0 +1,95 @@
+// Package expvar provides an adapters.Metrics implementation that publishes
+// service dial and connection stats as expvar variables.
+package expvar
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+)
+
+// Metrics publishes the dial and connection stats of a single service as an
+// expvar map with the following keys:
+//   - connected: 1 if the service is connected; 0 otherwise
+//   - dialAttempts: the total number of connection attempts
+//   - dialFailures: the total number of failed connection attempts
+//   - lastError: the error that caused the last failed connection attempt
+//   - poolInUse, poolIdle, poolMax: the pool stats of services that pool connections
+//     or channels
+type Metrics struct {
+	vars         *expvar.Map
+	connected    *expvar.Int
+	dialAttempts *expvar.Int
+	dialFailures *expvar.Int
+	lastError    *expvar.String
+	poolInUse    *expvar.Int
+	poolIdle     *expvar.Int
+	poolMax      *expvar.Int
+}
+
+// Publish the stats of srv as an expvar map with the specified name and attach
+// the returned metrics sink to the service. As with expvar.Publish, the call
+// panics if a variable with the same name has already been published.
+func Publish(name string, srv adapters.Service) *Metrics {
+	m := &Metrics{
+		vars:         expvar.NewMap(name),
+		connected:    new(expvar.Int),
+		dialAttempts: new(expvar.Int),
+		dialFailures: new(expvar.Int),
+		lastError:    new(expvar.String),
+		poolInUse:    new(expvar.Int),
+		poolIdle:     new(expvar.Int),
+		poolMax:      new(expvar.Int),
+	}
+	m.vars.Set("connected", m.connected)
+	m.vars.Set("dialAttempts", m.dialAttempts)
+	m.vars.Set("dialFailures", m.dialFailures)
+	m.vars.Set("lastError", m.lastError)
+	m.vars.Set("poolInUse", m.poolInUse)
+	m.vars.Set("poolIdle", m.poolIdle)
+	m.vars.Set("poolMax", m.poolMax)
+
+	srv.SetMetrics(m)
+	return m
+}
+
+// Invoked before each connection attempt.
+func (m *Metrics) IncDialAttempt(service string) {
+	m.dialAttempts.Add(1)
+}
+
+// Invoked after each failed connection attempt.
+func (m *Metrics) IncDialFailure(service string, err error) {
+	m.dialFailures.Add(1)
+	if err != nil {
+		m.lastError.Set(err.Error())
+	}
+}
+
+// Invoked after a connection is established.
+func (m *Metrics) IncDialSuccess(service string) {}
+
+// Invoked when a dial completes, fails or is aborted with the total time spent dialing.
+func (m *Metrics) ObserveDialDuration(service string, d time.Duration) {}
+
+// Invoked when the service connects or disconnects.
+func (m *Metrics) SetConnected(service string, connected bool) {
+	if connected {
+		m.connected.Set(1)
+	} else {
+		m.connected.Set(0)
+	}
+}
+
+// Invoked when the pool of the service changes.
+func (m *Metrics) SetPoolStats(service string, inUse, idle, max int) {
+	m.poolInUse.Set(int64(inUse))
+	m.poolIdle.Set(int64(idle))
+	m.poolMax.Set(int64(max))
+}
+
+var (
+	_ adapters.Metrics     = (*Metrics)(nil)
+	_ adapters.PoolMetrics = (*Metrics)(nil)
+)
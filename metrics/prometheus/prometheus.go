@@ -0,0 +1,137 @@
+// Package prometheus provides an adapters.Metrics implementation that exports
+// service dial and connection metrics to prometheus.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics collects service adapter events as prometheus metrics. All metrics
+// are labeled by service name. Metrics implements prometheus.Collector so it
+// can be registered with any prometheus registry.
+type Metrics struct {
+	dialAttempts  *prom.CounterVec
+	dialFailures  *prom.CounterVec
+	dialSuccesses *prom.CounterVec
+	dialDuration  *prom.HistogramVec
+	connected     *prom.GaugeVec
+	poolInUse     *prom.GaugeVec
+	poolIdle      *prom.GaugeVec
+	poolMax       *prom.GaugeVec
+}
+
+// Create a new prometheus metrics sink. All metric names are prefixed by namespace, if not empty.
+func New(namespace string) *Metrics {
+	labels := []string{"service"}
+	return &Metrics{
+		dialAttempts: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "service_dial_attempts_total",
+			Help:      "The number of service connection attempts.",
+		}, labels),
+		dialFailures: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "service_dial_failures_total",
+			Help:      "The number of failed service connection attempts.",
+		}, labels),
+		dialSuccesses: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "service_dial_successes_total",
+			Help:      "The number of established service connections.",
+		}, labels),
+		dialDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "service_dial_duration_seconds",
+			Help:      "The time spent dialing a service, including any retries.",
+			Buckets:   prom.DefBuckets,
+		}, labels),
+		connected: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: namespace,
+			Name:      "service_connected",
+			Help:      "Whether the service is currently connected (1) or not (0).",
+		}, labels),
+		poolInUse: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: namespace,
+			Name:      "service_pool_in_use",
+			Help:      "The number of pool entries currently borrowed from the service pool.",
+		}, labels),
+		poolIdle: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: namespace,
+			Name:      "service_pool_idle",
+			Help:      "The number of idle entries in the service pool.",
+		}, labels),
+		poolMax: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: namespace,
+			Name:      "service_pool_max",
+			Help:      "The max number of entries the service can allocate.",
+		}, labels),
+	}
+}
+
+// Invoked before each connection attempt.
+func (m *Metrics) IncDialAttempt(service string) {
+	m.dialAttempts.WithLabelValues(service).Inc()
+}
+
+// Invoked after each failed connection attempt.
+func (m *Metrics) IncDialFailure(service string, err error) {
+	m.dialFailures.WithLabelValues(service).Inc()
+}
+
+// Invoked after a connection is established.
+func (m *Metrics) IncDialSuccess(service string) {
+	m.dialSuccesses.WithLabelValues(service).Inc()
+}
+
+// Invoked when a dial completes, fails or is aborted with the total time spent dialing.
+func (m *Metrics) ObserveDialDuration(service string, d time.Duration) {
+	m.dialDuration.WithLabelValues(service).Observe(d.Seconds())
+}
+
+// Invoked when the service connects or disconnects.
+func (m *Metrics) SetConnected(service string, connected bool) {
+	val := 0.0
+	if connected {
+		val = 1.0
+	}
+	m.connected.WithLabelValues(service).Set(val)
+}
+
+// Invoked when the pool of a service changes.
+func (m *Metrics) SetPoolStats(service string, inUse, idle, max int) {
+	m.poolInUse.WithLabelValues(service).Set(float64(inUse))
+	m.poolIdle.WithLabelValues(service).Set(float64(idle))
+	m.poolMax.WithLabelValues(service).Set(float64(max))
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prom.Desc) {
+	m.dialAttempts.Describe(ch)
+	m.dialFailures.Describe(ch)
+	m.dialSuccesses.Describe(ch)
+	m.dialDuration.Describe(ch)
+	m.connected.Describe(ch)
+	m.poolInUse.Describe(ch)
+	m.poolIdle.Describe(ch)
+	m.poolMax.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prom.Metric) {
+	m.dialAttempts.Collect(ch)
+	m.dialFailures.Collect(ch)
+	m.dialSuccesses.Collect(ch)
+	m.dialDuration.Collect(ch)
+	m.connected.Collect(ch)
+	m.poolInUse.Collect(ch)
+	m.poolIdle.Collect(ch)
+	m.poolMax.Collect(ch)
+}
+
+var (
+	_ adapters.Metrics     = (*Metrics)(nil)
+	_ adapters.PoolMetrics = (*Metrics)(nil)
+)
@@ -0,0 +1,56 @@
+package prometheus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New("usrv")
+
+	registry := prom.NewRegistry()
+	if err := registry.Register(m); err != nil {
+		t.Fatalf("Expected Register to succeed; got %v", err)
+	}
+
+	m.IncDialAttempt("redis")
+	m.IncDialFailure("redis", errors.New("connection refused"))
+	m.IncDialAttempt("redis")
+	m.IncDialSuccess("redis")
+	m.ObserveDialDuration("redis", 10*time.Millisecond)
+	m.SetConnected("redis", true)
+	m.IncDialAttempt("amqp")
+	m.SetPoolStats("amqp", 3, 1, 2047)
+
+	specs := []struct {
+		collector prom.Collector
+		expected  float64
+	}{
+		{m.dialAttempts.WithLabelValues("redis"), 2},
+		{m.dialFailures.WithLabelValues("redis"), 1},
+		{m.dialSuccesses.WithLabelValues("redis"), 1},
+		{m.connected.WithLabelValues("redis"), 1},
+		{m.dialAttempts.WithLabelValues("amqp"), 1},
+		{m.poolInUse.WithLabelValues("amqp"), 3},
+		{m.poolIdle.WithLabelValues("amqp"), 1},
+		{m.poolMax.WithLabelValues("amqp"), 2047},
+	}
+	for index, spec := range specs {
+		if got := testutil.ToFloat64(spec.collector); got != spec.expected {
+			t.Fatalf("[spec %d] Expected metric value to be %v; got %v", index, spec.expected, got)
+		}
+	}
+
+	m.SetConnected("redis", false)
+	if got := testutil.ToFloat64(m.connected.WithLabelValues("redis")); got != 0 {
+		t.Fatalf("Expected connected gauge to be 0 after disconnecting; got %v", got)
+	}
+
+	if count := testutil.CollectAndCount(m, "usrv_service_dial_duration_seconds"); count != 1 {
+		t.Fatalf("Expected 1 dial duration series; got %d", count)
+	}
+}
@@ -0,0 +1,89 @@
+package adapters
+
+import (
+	"fmt"
+	"time"
+)
+
+// Configuration middleware for services whose settings are kept outside etcd (e.g. in
+// a file or behind an HTTP endpoint). It returns a ServiceOption that invokes fetch
+// once when applied and then every interval, and calls s.Config whenever the fetched
+// settings differ from the previously applied ones. Fetch and Config errors are logged
+// using the service logger (see LoggerGetter) and retried at the next interval. The
+// poller is stopped when the service is shut down.
+func PollConfig(fetch func() (map[string]string, error), interval time.Duration) ServiceOption {
+	return func(s Service) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid value for 'interval': %v", interval)
+		}
+
+		// Register for shutdown notifications before fetching the initial settings so
+		// that a shutdown while fetching is not missed.
+		shutdownChan := NotifyShutdown(s)
+
+		poller := &configPoller{service: s, fetch: fetch}
+		poller.poll()
+
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-shutdownChan:
+					return
+				case <-ticker.C:
+					poller.poll()
+				}
+			}
+		}()
+
+		return nil
+	}
+}
+
+// Tracks the settings most recently applied by PollConfig.
+type configPoller struct {
+	service Service
+	fetch   func() (map[string]string, error)
+	last    map[string]string
+	fetched bool
+}
+
+// Fetch the settings and reconfigure the service if they changed.
+func (p *configPoller) poll() {
+	params, err := p.fetch()
+	if err != nil {
+		serviceLogger(p.service).Printf("[POLLCONFIG] Could not fetch settings for service %s: %v\n", ServiceName(p.service), err)
+		return
+	}
+
+	if p.fetched && sameSettings(params, p.last) {
+		return
+	}
+
+	if err = p.service.Config(params); err != nil {
+		serviceLogger(p.service).Printf("[POLLCONFIG] Could not apply settings to service %s: %v\n", ServiceName(p.service), err)
+		return
+	}
+
+	// Keep a copy in case fetch reuses its map between calls
+	p.last = make(map[string]string, len(params))
+	for key, val := range params {
+		p.last[key] = val
+	}
+	p.fetched = true
+}
+
+// Check whether a and b contain the same settings. Nil and empty maps are equal.
+func sameSettings(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, val := range a {
+		if other, ok := b[key]; !ok || other != val {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,191 @@
+package adapters
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A service that records the settings passed to Config.
+type configRecordingService struct {
+	Service
+	notifier *Notifier
+
+	mu      sync.Mutex
+	configs []map[string]string
+
+	// Errors returned by successive Config calls; later calls succeed.
+	configErrs []error
+	logger     *log.Logger
+}
+
+func (s *configRecordingService) Logger() *log.Logger { return s.logger }
+
+func (s *configRecordingService) NotifyClose(c CloseListener) { s.notifier.Add(c) }
+
+func (s *configRecordingService) Config(params map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.configs = append(s.configs, params)
+	if len(s.configErrs) != 0 {
+		err := s.configErrs[0]
+		s.configErrs = s.configErrs[1:]
+		return err
+	}
+	return nil
+}
+
+func (s *configRecordingService) recorded() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]map[string]string(nil), s.configs...)
+}
+
+// A fetcher that returns a sequence of results and then keeps returning the last one.
+type fakeFetcher struct {
+	mu      sync.Mutex
+	results []fetchResult
+	calls   int
+}
+
+type fetchResult struct {
+	params map[string]string
+	err    error
+}
+
+func (f *fakeFetcher) fetch() (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := f.results[f.calls]
+	if f.calls < len(f.results)-1 {
+		f.calls++
+	}
+	return res.params, res.err
+}
+
+func (f *fakeFetcher) exhausted() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls == len(f.results)-1
+}
+
+func TestPollConfig(t *testing.T) {
+	v1 := map[string]string{"endpoint": "10.0.0.1:6379"}
+	v2 := map[string]string{"endpoint": "10.0.0.2:6379", "db": "1"}
+	fetcher := &fakeFetcher{results: []fetchResult{
+		{params: v1},
+		{params: map[string]string{"endpoint": "10.0.0.1:6379"}},
+		{err: errors.New("connection refused")},
+		{params: v1},
+		{params: v2},
+		{params: v2},
+	}}
+
+	srv := &configRecordingService{notifier: NewNotifier()}
+	if err := PollConfig(fetcher.fetch, time.Millisecond)(srv); err != nil {
+		t.Fatalf("Expected PollConfig to succeed; got %v", err)
+	}
+
+	// The initial settings are applied before PollConfig returns
+	if configs := srv.recorded(); len(configs) != 1 {
+		t.Fatalf("Expected the initial settings to be applied; got %v", configs)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !fetcher.exhausted() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the poller to fetch all results")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Give the poller a few more intervals to make sure unchanged settings are skipped
+	time.Sleep(10 * time.Millisecond)
+	if exp, configs := []map[string]string{v1, v2}, srv.recorded(); !reflect.DeepEqual(configs, exp) {
+		t.Fatalf("Expected Config to be called with %v; got %v", exp, configs)
+	}
+}
+
+func TestPollConfigRetriesFailedConfig(t *testing.T) {
+	v1 := map[string]string{"endpoint": "10.0.0.1:6379"}
+	fetch := func() (map[string]string, error) { return v1, nil }
+
+	var buf bytes.Buffer
+	srv := &configRecordingService{
+		notifier:   NewNotifier(),
+		configErrs: []error{errors.New("connection refused")},
+		logger:     log.New(&buf, "", 0),
+	}
+	defer srv.notifier.NotifyAll(ErrConnectionClosed)
+	if err := PollConfig(fetch, time.Millisecond)(srv); err != nil {
+		t.Fatalf("Expected PollConfig to succeed; got %v", err)
+	}
+
+	// The rejected settings should be applied again at the next interval even
+	// though they did not change
+	deadline := time.Now().Add(time.Second)
+	for len(srv.recorded()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the rejected settings to be re-applied")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if exp, configs := []map[string]string{v1, v1}, srv.recorded(); !reflect.DeepEqual(configs, exp) {
+		t.Fatalf("Expected Config to be called with %v; got %v", exp, configs)
+	}
+
+	if out := buf.String(); !strings.Contains(out, "[POLLCONFIG] Could not apply settings") {
+		t.Fatalf("Expected the Config error to be logged via the service logger; got %q", out)
+	}
+}
+
+func TestPollConfigStopsOnShutdown(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	fetch := func() (map[string]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		return nil, nil
+	}
+	fetchCalls := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return calls
+	}
+
+	srv := &configRecordingService{notifier: NewNotifier()}
+	if err := PollConfig(fetch, time.Millisecond)(srv); err != nil {
+		t.Fatalf("Expected PollConfig to succeed; got %v", err)
+	}
+
+	srv.notifier.NotifyAll(ErrConnectionClosed)
+
+	// Allow the poller to observe the shutdown before sampling the call count
+	time.Sleep(10 * time.Millisecond)
+	before := fetchCalls()
+	time.Sleep(20 * time.Millisecond)
+	if after := fetchCalls(); after != before {
+		t.Fatalf("Expected the poller to stop after shutdown; fetch calls went from %d to %d", before, after)
+	}
+}
+
+func TestPollConfigInvalidInterval(t *testing.T) {
+	srv := &configRecordingService{notifier: NewNotifier()}
+	fetch := func() (map[string]string, error) { return nil, nil }
+	if err := PollConfig(fetch, 0)(srv); err == nil {
+		t.Fatalf("Expected PollConfig to reject a zero interval")
+	}
+}
@@ -0,0 +1,80 @@
+package adapters
+
+import "context"
+
+// Services that accept a context when applying configuration settings. All service
+// adapters in this package implement this interface.
+type ContextConfigurer interface {
+
+	// Apply configuration settings using the supplied context; see Service.Config.
+	ConfigContext(ctx context.Context, params map[string]string) error
+}
+
+// Services that apply new settings to a connected service in place (e.g. by
+// rebuilding their connection pool) instead of disconnecting it. As such services
+// remain connected, Reconfigure probes them rather than dialing them again. The
+// redis and etcd adapters implement this interface.
+type Prober interface {
+
+	// Verify that the endpoint can be reached using the current settings, retrying
+	// according to the dial policy until it gives up or ctx is done.
+	Probe(ctx context.Context) error
+}
+
+// Apply params to a service and, if the settings reset a connected service, dial it
+// again. Both steps are bounded by ctx: if the service cannot be re-established
+// before ctx expires (e.g. because the new endpoint is unreachable), ctx.Err() is
+// returned and the service is left disconnected. Should the adapter connect after
+// ctx has expired, the service is closed. Services that keep their connection when
+// reset (see Prober) are probed instead and closed if the probe fails; if ctx
+// expires first, they are closed once the pending probe returns. Services that do
+// not implement HealthReporter are never redialed.
+func Reconfigure(ctx context.Context, s Service, params map[string]string) error {
+	wasConnected := false
+	if reporter, ok := s.(HealthReporter); ok {
+		wasConnected = reporter.IsConnected()
+	}
+
+	var err error
+	if configurer, ok := s.(ContextConfigurer); ok {
+		err = configurer.ConfigContext(ctx, params)
+	} else {
+		err = s.Config(params)
+	}
+	if err != nil || !wasConnected || !s.ConfigChanged() {
+		return err
+	}
+
+	// Services that were reset in place are still connected so dialing them
+	// would return ErrAlreadyConnected without ever using the new settings
+	redial := s.DialContext
+	prober, inPlace := s.(Prober)
+	if inPlace = inPlace && s.(HealthReporter).IsConnected(); inPlace {
+		redial = prober.Probe
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- redial(ctx)
+	}()
+
+	select {
+	case err = <-result:
+		switch {
+		// Another goroutine (e.g. a reconnect supervisor) may have redialed first
+		case err == ErrAlreadyConnected:
+			return nil
+		case err != nil && inPlace:
+			s.Close()
+		}
+		return err
+	case <-ctx.Done():
+		// Ensure that a late dial or probe does not leave the service connected
+		go func() {
+			if err := <-result; err == nil || inPlace {
+				s.Close()
+			}
+		}()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,148 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A fake service that is reset when its endpoint changes and whose DialContext
+// blocks until its context is done while the endpoint is unreachable.
+type reconfigurableService struct {
+	hangingService
+
+	mu            sync.Mutex
+	endpoint      string
+	connected     bool
+	configChanged bool
+	dials         int32
+}
+
+func (s *reconfigurableService) DialContext(ctx context.Context) error {
+	atomic.AddInt32(&s.dials, 1)
+
+	s.mu.Lock()
+	endpoint := s.endpoint
+	s.mu.Unlock()
+
+	if endpoint == "unreachable:6379" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = true
+	return nil
+}
+
+func (s *reconfigurableService) Config(params map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.configChanged = params["endpoint"] != s.endpoint
+	if s.configChanged {
+		s.endpoint = params["endpoint"]
+		s.connected = false
+	}
+	return nil
+}
+
+func (s *reconfigurableService) ConfigChanged() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.configChanged
+}
+
+func (s *reconfigurableService) IsConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connected
+}
+
+func (s *reconfigurableService) LastError() error { return nil }
+
+func TestReconfigure(t *testing.T) {
+	srv := &reconfigurableService{endpoint: "10.0.0.1:6379", connected: true}
+
+	// Unchanged settings do not trigger a redial
+	if err := Reconfigure(context.Background(), srv, map[string]string{"endpoint": "10.0.0.1:6379"}); err != nil {
+		t.Fatalf("Expected Reconfigure to succeed; got %v", err)
+	}
+	if dials := atomic.LoadInt32(&srv.dials); dials != 0 {
+		t.Fatalf("Expected no redials for unchanged settings; got %d", dials)
+	}
+
+	if err := Reconfigure(context.Background(), srv, map[string]string{"endpoint": "10.0.0.2:6379"}); err != nil {
+		t.Fatalf("Expected Reconfigure to succeed; got %v", err)
+	}
+	if dials := atomic.LoadInt32(&srv.dials); dials != 1 || !srv.IsConnected() {
+		t.Fatalf("Expected the service to be redialed once and connected; got %d dial(s), connected=%t", dials, srv.IsConnected())
+	}
+}
+
+func TestReconfigureUnreachableEndpoint(t *testing.T) {
+	srv := &reconfigurableService{endpoint: "10.0.0.1:6379", connected: true}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := Reconfigure(ctx, srv, map[string]string{"endpoint": "unreachable:6379"})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected Reconfigure to fail with context.DeadlineExceeded; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected Reconfigure to return once the context expired; took %v", elapsed)
+	}
+	if srv.IsConnected() {
+		t.Fatalf("Expected the service to be left disconnected")
+	}
+}
+
+func TestReconfigureClosesLateConnection(t *testing.T) {
+	srv := &hangingService{release: make(chan struct{}), ignoreContext: true}
+	reporter := &connectedHangingService{hangingService: srv}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := Reconfigure(ctx, reporter, map[string]string{"endpoint": "10.0.0.2:6379"}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected Reconfigure to fail with context.DeadlineExceeded; got %v", err)
+	}
+
+	close(srv.release)
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&srv.closed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the service to be closed after a late successful dial")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReconfigureConfigError(t *testing.T) {
+	configErr := errors.New("invalid value for 'endpoint': ")
+	srv := &connectedHangingService{hangingService: &hangingService{}, configErr: configErr}
+
+	if err := Reconfigure(context.Background(), srv, map[string]string{"endpoint": ""}); err != configErr {
+		t.Fatalf("Expected Reconfigure to return the config error; got %v", err)
+	}
+}
+
+// A hanging service that reports being connected and that every Config call
+// changes its settings.
+type connectedHangingService struct {
+	*hangingService
+	configErr error
+}
+
+func (s *connectedHangingService) Config(params map[string]string) error { return s.configErr }
+func (s *connectedHangingService) ConfigChanged() bool                   { return true }
+func (s *connectedHangingService) IsConnected() bool                     { return true }
+func (s *connectedHangingService) LastError() error                      { return nil }
@@ -1,6 +1,7 @@
 package adapters
 
 import (
+	"context"
 	"errors"
 	"log"
 
@@ -11,6 +12,8 @@ import (
 
 var (
 	ErrConnectionClosed = errors.New("Connection closed")
+	ErrAlreadyConnected = errors.New("Already connected")
+	ErrDialTimeout      = errors.New("Dial timeout")
 )
 
 // A close listener is a channel that receives errors.
@@ -22,10 +25,21 @@ type Service interface {
 	// Connect to the service. If a dial policy has been specified,
 	// the service will keep trying to reconnect until a connection
 	// is established or the dial policy aborts the reconnection attempt.
+	// ErrAlreadyConnected is returned if the service is already connected.
 	Dial() error
 
-	// Disconnect.
-	Close()
+	// Connect to the service using the supplied context. If ctx is cancelled while
+	// waiting for the next dial attempt, the dial is aborted and ctx.Err() is returned.
+	DialContext(ctx context.Context) error
+
+	// Disconnect. Any error reported by the underlying driver while closing the
+	// connection is returned; closing a service that is not connected is a no-op.
+	Close() error
+
+	// Disconnect after waiting for any borrowed connections or channels to be released.
+	// New requests are rejected while waiting. If ctx expires first, the service is
+	// closed anyway and an error is returned.
+	CloseContext(ctx context.Context) error
 
 	// Register a listener for receiving close notifications. The service adapter will emit an error and
 	// close the channel if the service is cleanly shut down or close the channel if the connection is reset.
@@ -38,11 +52,22 @@ type Service interface {
 	// Register a logger instance for service events.
 	SetLogger(logger *log.Logger)
 
-	// Set a dial policy for this service.
+	// Set a dial policy for this service. The service stores an independent copy
+	// of policy (see dial.Clone) so the same policy may be passed to multiple services.
 	SetDialPolicy(policy dial.Policy)
 
+	// Register a metrics sink for dial and connection events. Passing nil discards all events.
+	SetMetrics(m Metrics)
+
+	// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+	SetTracer(t Tracer)
+
 	// Set the service configuration. Changing the configuration settings for an already connected
 	// service will trigger a service shutdown. The service consumer is responsible for handing
 	// service close events and triggering a re-dial.
 	Config(params map[string]string) error
+
+	// Report whether the most recent Config call modified any setting and triggered a
+	// service reset. Settings identical to the current ones do not trigger a reset.
+	ConfigChanged() bool
 }
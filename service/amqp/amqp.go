@@ -1,8 +1,14 @@
 package amqp
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"time"
 
@@ -10,14 +16,29 @@ import (
 
 	"github.com/achilleasa/usrv-service-adapters"
 	"github.com/achilleasa/usrv-service-adapters/dial"
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
 	amqpDriver "github.com/streadway/amqp"
 )
 
+// The service name reported to the metrics sink.
+const serviceName = "amqp"
+
+// The heartbeat interval and handshake timeout used by the driver's default dialer.
+const (
+	defaultHeartbeat = 10 * time.Second
+	handshakeTimeout = 30 * time.Second
+)
+
+// The prefix of config settings that define custom client connection properties.
+const propertyPrefix = "property."
+
 // Adapter is a singleton instance of a amqp service
 var Adapter *Amqp = &Amqp{
 	endpoint:      "localhost:55672",
 	logger:        log.New(ioutil.Discard, "", log.LstdFlags),
 	dialPolicy:    dial.ExpBackoff(10, time.Millisecond),
+	metrics:       adapters.NopMetrics,
+	tracer:        adapters.NopTracer,
 	closeNotifier: adapters.NewNotifier(),
 }
 
@@ -27,81 +48,361 @@ type Amqp struct {
 	// by a configuration service (e.g. etcd)
 	endpoint string
 
+	// The period of OS-level TCP keepalive probes; 0 uses the driver default.
+	tcpKeepAlive time.Duration
+
+	// The dialer used for establishing network connections; nil uses the driver default.
+	dialer adapters.Dialer
+
+	// The max random delay before the first attempt of each dial; 0 disables it.
+	spreadFirstAttempt time.Duration
+
+	// The connection name advertised to the broker; empty advertises no name.
+	connectionName string
+
+	// Custom client properties advertised to the broker.
+	properties map[string]string
+
+	// The SASL mechanism used for authenticating with the broker (plain, amqplain or
+	// external); empty uses the driver default (plain).
+	authMechanism string
+
+	// The PEM-encoded client certificate and key presented to amqps endpoints.
+	tlsCertFile string
+	tlsKeyFile  string
+
+	// A PEM-encoded CA bundle for verifying amqps endpoints; empty uses the system roots.
+	tlsCAFile string
+
+	// A digest of the TLS files loaded by the most recent dial; compared by Config to
+	// detect certificates rotated in place.
+	tlsDigest string
+
+	// The time after which idle channels in the channel pool are closed; 0 keeps them indefinitely.
+	channelIdleTimeout time.Duration
+
+	// Set when the watchdog is disabled via the watchdog setting. The consumer is then
+	// responsible for detecting connections dropped by the broker.
+	disableWatchdog bool
+
+	// An optional name for telling instances apart in log lines and metrics.
+	name string
+
 	// A logger for service events.
 	logger *log.Logger
 
+	// An optional logger for tagging log lines with the fields of a context.
+	contextLogger adapters.ContextLogger
+
 	// A mutex protecting dial attempts.
 	sync.Mutex
 
 	// The dial policy to use.
 	dialPolicy dial.Policy
 
+	// A sink for dial and connection metrics.
+	metrics adapters.Metrics
+
+	// A tracer for creating spans around Dial and Config.
+	tracer adapters.Tracer
+
+	// An optional callback invoked when a dial gives up; see OnDialFailure.
+	onDialFailure func(attempts uint32, lastErr error)
+
 	// Connection status.
 	connected bool
 
+	// The error that caused the most recent dial to fail; nil if it succeeded.
+	lastErr error
+
+	// The number of attempts made by the most recent dial.
+	dialAttempts uint32
+
+	// The time of the most recent successful dial.
+	lastConnectedAt time.Time
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
+	// Set while CloseContext waits for allocated channels to be closed.
+	draining bool
+
 	// AMQP connection handle.
 	conn *amqpDriver.Connection
 
+	// The number of channels allocated by NewChannel that are still open. A new
+	// counter is allocated for each connection.
+	openChannels *int32
+
+	// The pool of channels handed out by BorrowChannel. A new pool is allocated for
+	// each connection.
+	channelPool *channelPool
+
+	// The topologies declared via DeclareTopology; re-declared after each dial.
+	topologies []Topology
+
 	// A notifier for close events.
 	closeNotifier *adapters.Notifier
+
+	// A stream of connection events.
+	events adapters.EventStream
 }
 
 // Connect to the service. If a dial policy has been specified,
 // the service will keep trying to reconnect until a connection
 // is established or the dial policy aborts the reconnection attempt.
 func (s *Amqp) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context. If ctx is cancelled while
+// waiting for the next dial attempt, the dial is aborted and ctx.Err() is returned.
+func (s *Amqp) DialContext(ctx context.Context) (err error) {
 	s.Lock()
 	defer s.Unlock()
 
 	// We are already connected
 	if s.connected {
-		return nil
+		return adapters.ErrAlreadyConnected
+	}
+	s.events.DialStarted()
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Dial")
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+	span.SetAttribute("endpoint", redactEndpoint(s.endpoint))
+	attempts := 0
+	defer func() {
+		span.SetAttribute("attempts", attempts)
+		span.End(err)
+		s.lastErr = err
+		s.dialAttempts = uint32(attempts)
+		if err != nil {
+			s.events.Emit(adapters.Event{Type: adapters.EventDialFailed, Attempt: attempts, Err: err})
+		}
+	}()
+
+	config, err := s.driverConfig()
+	if err != nil {
+		logger.Printf("%s Could not setup connection to endpoint %s: %v\n", s.logPrefix(), redactEndpoint(s.endpoint), err)
+		return err
 	}
 
-	var err error
-	var wait time.Duration
+	defer dial.Release(s.dialPolicy)
 	s.dialPolicy.ResetAttempts()
-	wait, err = s.dialPolicy.NextRetry()
-	s.logger.Printf("[AMQP] Connecting to endpoint %s\n", s.endpoint)
+	if _, err = dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		logger.Printf("%s Dial cancelled: %v\n", s.logPrefix(), err)
+		return err
+	}
+	if err = s.spreadFirstAttemptDelay(ctx, logger); err != nil {
+		logger.Printf("%s Dial cancelled: %v\n", s.logPrefix(), err)
+		return err
+	}
+	logger.Printf("%s Connecting to endpoint %s\n", s.logPrefix(), redactEndpoint(s.endpoint))
+	start := clock.Default.Now()
 	for {
-		s.conn, err = amqpDriver.Dial(s.endpoint)
+		s.metrics.IncDialAttempt(s.Name())
+		attempts++
+		s.conn, err = dialAmqp(s.endpoint, s.dialer, s.tcpKeepAlive, config)
+		dial.RecordOutcome(s.dialPolicy, err == nil)
 		if err == nil {
 			break
 		}
 
-		wait, err = s.dialPolicy.NextRetry()
-		if err != nil {
-			s.logger.Printf("[AMQP] Could not connect to endpoint %s after %d attempt(s)\n", s.endpoint, s.dialPolicy.CurAttempt())
-			return dial.ErrTimeout
+		s.metrics.IncDialFailure(s.Name(), err)
+		dialErr := err
+		err = dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			logger.Printf("%s Could not connect to endpoint %s; retrying in %v\n", s.logPrefix(), redactEndpoint(s.endpoint), wait)
+			s.events.Emit(adapters.Event{Type: adapters.EventRetryScheduled, Attempt: attempts, Wait: wait, Err: dialErr})
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			logger.Printf("%s Could not connect to endpoint %s after %d attempt(s)\n", s.logPrefix(), redactEndpoint(s.endpoint), s.dialPolicy.CurAttempt())
+			s.metrics.ObserveDialDuration(s.Name(), clock.Since(start))
+			if s.onDialFailure != nil {
+				go s.onDialFailure(uint32(attempts), dialErr)
+			}
+			return dial.Exhausted(attempts, dialErr)
+		case err != nil:
+			logger.Printf("%s Dial cancelled: %v\n", s.logPrefix(), err)
+			s.metrics.ObserveDialDuration(s.Name(), clock.Since(start))
+			return err
+		}
+	}
+
+	// Re-declare any previously declared topologies
+	for _, t := range s.topologies {
+		if err = s.declare(t); err != nil {
+			logger.Printf("%s Could not re-declare topology: %v\n", s.logPrefix(), err)
+			s.conn.Close()
+			s.conn = nil
+			s.metrics.ObserveDialDuration(s.Name(), clock.Since(start))
+			return err
 		}
-		s.logger.Printf("[AMQP] Could not connect to endpoint %s; retrying in %v\n", s.endpoint, wait)
-		<-time.After(wait)
 	}
 
 	s.connected = true
+	s.lastConnectedAt = clock.Default.Now()
+	s.openChannels = new(int32)
+	s.channelPool = newChannelPool(s.channelIdleTimeout)
+	s.reportChannelPoolStats()
+	s.events.Emit(adapters.Event{Type: adapters.EventConnect, Attempt: attempts})
+	s.metrics.IncDialSuccess(s.Name())
+	s.metrics.ObserveDialDuration(s.Name(), clock.Since(start))
+	s.metrics.SetConnected(s.Name(), true)
 	s.dialPolicy.ResetAttempts()
-	s.logger.Printf("[AMQP] Connected to endpoint %s\n", s.endpoint)
+	logger.Printf("%s Connected to endpoint %s\n", s.logPrefix(), redactEndpoint(s.endpoint))
 
 	// Start watchdog
-	go s.watchdog()
+	if !s.disableWatchdog {
+		amqpClose := s.conn.NotifyClose(make(chan *amqpDriver.Error, 1))
+		go s.watchdog(s.conn, amqpClose)
+	}
 
 	return nil
 }
 
+// Build the driver configuration used for dialing the broker. This method is not
+// thread-safe so it should be invoked while holding the service lock.
+func (s *Amqp) driverConfig() (amqpDriver.Config, error) {
+	config := amqpDriver.Config{
+		Heartbeat:  defaultHeartbeat,
+		Locale:     "en_US",
+		Properties: s.clientProperties(),
+		Dial:       amqpDriver.DefaultDial(handshakeTimeout),
+	}
+
+	var err error
+	if config.SASL, err = saslMechanisms(s.authMechanism, s.endpoint); err != nil {
+		return config, err
+	}
+	s.tlsDigest = tlsFilesDigest(s.tlsCertFile, s.tlsKeyFile, s.tlsCAFile)
+	if config.TLSClientConfig, err = loadTLSConfig(s.tlsCertFile, s.tlsKeyFile, s.tlsCAFile); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// Dial an amqp connection using the supplied driver configuration. Network
+// connections are established via dialer or the driver default if dialer is nil.
+// If tcpKeepAlive is positive, TCP keepalive probes with that period are enabled
+// on the underlying connection.
+func dialAmqp(endpoint string, dialer adapters.Dialer, tcpKeepAlive time.Duration, config amqpDriver.Config) (*amqpDriver.Connection, error) {
+	if dialer == nil && tcpKeepAlive <= 0 {
+		return amqpDriver.DialConfig(endpoint, config)
+	}
+	if dialer == nil {
+		dialer = net.DialTimeout
+	}
+
+	netDial := func(network, addr string) (net.Conn, error) {
+		return dialer(network, addr, handshakeTimeout)
+	}
+	if tcpKeepAlive > 0 {
+		netDial = adapters.KeepAliveDialer(dialer, handshakeTimeout, tcpKeepAlive)
+	}
+	config.Dial = func(network, addr string) (net.Conn, error) {
+		conn, err := netDial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// Like the driver's default dialer, bound the handshake; the driver
+		// clears the deadline once the connection is established.
+		if err = conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return amqpDriver.DialConfig(endpoint, config)
+}
+
+// Build the client properties advertised to the broker when dialing. The driver
+// only fills in its product and version defaults when no properties are
+// specified so they are included here. This method is not thread-safe so it
+// should be invoked while holding the service lock.
+func (s *Amqp) clientProperties() amqpDriver.Table {
+	props := amqpDriver.Table{
+		"product": "https://github.com/streadway/amqp",
+		"version": "β",
+	}
+	for key, val := range s.properties {
+		props[key] = val
+	}
+	if s.connectionName != "" {
+		props["connection_name"] = s.connectionName
+	}
+	return props
+}
+
 // Disconnect.
-func (s *Amqp) Close() {
+func (s *Amqp) Close() error {
 	s.Lock()
 	defer s.Unlock()
 
 	if !s.connected {
-		return
+		return nil
 	}
 
-	// Close connection and notify any registered listeners
-	s.conn.Close()
+	return s.disconnect()
+}
+
+// Disconnect after waiting for any channels allocated by NewChannel to be closed.
+// New channel requests are rejected while waiting. If ctx expires before all
+// channels are closed, the service is closed anyway and ctx.Err() is returned.
+func (s *Amqp) CloseContext(ctx context.Context) error {
+	s.Lock()
+	if !s.connected {
+		s.Unlock()
+		return nil
+	}
+	s.draining = true
+	conn, openChannels, pool := s.conn, s.openChannels, s.channelPool
+	s.Unlock()
+
+	// Idle pooled channels count as open so close them before waiting
+	pool.closeIdle()
+
+	err := adapters.WaitForRelease(ctx, func() int {
+		return int(atomic.LoadInt32(openChannels))
+	})
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Unless the connection was reset in the meantime
+	s.draining = false
+	if s.connected && s.conn == conn {
+		if closeErr := s.disconnect(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// Close the connection and notify any registered listeners. This method is not
+// thread-safe so it should be invoked while holding the service lock.
+func (s *Amqp) disconnect() error {
+	err := s.conn.Close()
+	s.closeChannelPool()
 	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
 	s.conn = nil
 	s.connected = false
+	s.metrics.SetConnected(s.Name(), false)
+	s.events.Emit(adapters.Event{Type: adapters.EventDisconnect, Err: adapters.ErrConnectionClosed})
+	return err
+}
+
+// Close the channel pool of the current connection. This method is not thread-safe
+// so it should be invoked while holding the service lock.
+func (s *Amqp) closeChannelPool() {
+	if s.channelPool != nil {
+		s.channelPool.close()
+		s.channelPool = nil
+		s.reportChannelPoolStats()
+	}
 }
 
 // Register a listener for receiving close notifications. The service adapter will emit an error and
@@ -110,6 +411,84 @@ func (s *Amqp) NotifyClose(c adapters.CloseListener) {
 	s.closeNotifier.Add(c)
 }
 
+// Register a callback that is invoked when a dial gives up because its dial policy
+// is exhausted. The callback receives the number of attempts and the error of the
+// last attempt. It fires once per failed dial rather than once per attempt and runs
+// on its own goroutine so it may call back into the service. Passing nil removes it.
+func (s *Amqp) OnDialFailure(fn func(attempts uint32, lastErr error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onDialFailure = fn
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *Amqp) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Log a warning when more than max close listeners are registered, which usually
+// indicates a listener leak; 0 disables the check. See adapters.MaxCloseListeners.
+func (s *Amqp) SetMaxCloseListeners(max int) {
+	s.closeNotifier.SetMaxListeners(max, func(count int) {
+		s.logger.Printf("%s Possible close listener leak: %d listeners registered (max %d)\n", s.logPrefix(), count, max)
+	})
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *Amqp) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Report whether the service is connected.
+func (s *Amqp) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Get the error that caused the most recent dial to fail; nil if the most recent
+// dial succeeded or no dial has been attempted yet.
+func (s *Amqp) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Get a snapshot of the connection state of the service.
+func (s *Amqp) Status() adapters.Status {
+	s.Lock()
+	defer s.Unlock()
+
+	status := adapters.Status{
+		Connected:       s.connected,
+		Endpoint:        redactEndpoint(s.endpoint),
+		DialAttempts:    s.dialAttempts,
+		LastConnectedAt: s.lastConnectedAt,
+	}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	return status
+}
+
+// Get a stream of connection events. All calls return the same channel; if the
+// channel is not drained, new events are dropped.
+func (s *Amqp) Events() <-chan adapters.Event {
+	return s.events.C()
+}
+
+// Block until the service is connected. If the most recent dial fails, its error is
+// returned instead; if ctx expires first, ctx.Err() is returned. Unlike Events, this
+// method does not consume any events.
+func (s *Amqp) WaitForConnection(ctx context.Context) error {
+	return s.events.WaitForConnection(ctx)
+}
+
 // Apply a list of options to the service.
 func (s *Amqp) SetOptions(opts ...adapters.ServiceOption) error {
 	for _, opt := range opts {
@@ -125,69 +504,296 @@ func (s *Amqp) SetLogger(logger *log.Logger) {
 	s.logger = logger
 }
 
+// Get the logger registered via SetLogger. Implements the adapters.LoggerGetter interface.
+func (s *Amqp) Logger() *log.Logger {
+	return s.logger
+}
+
+// Set a name for telling this instance apart from other Amqp instances managed by the
+// same process. The name is included in the prefix of log lines (e.g. "[AMQP orders]")
+// and replaces "amqp" as the service label of reported metrics. It should be set
+// before the service is dialed.
+func (s *Amqp) SetName(name string) {
+	s.name = name
+}
+
+// Get the name set via SetName or "amqp" if no name was set.
+func (s *Amqp) Name() string {
+	if s.name == "" {
+		return serviceName
+	}
+	return s.name
+}
+
+// Get the prefix of the log lines emitted by the service.
+func (s *Amqp) logPrefix() string {
+	if s.name == "" {
+		return "[AMQP]"
+	}
+	return "[AMQP " + s.name + "]"
+}
+
+// Attach a logger for tagging DialContext and ConfigContext log lines with the fields
+// of the supplied context. Passing nil restores plain logging.
+func (s *Amqp) SetContextLogger(logger adapters.ContextLogger) {
+	s.contextLogger = logger
+}
+
 // Set a dial policy for this service.
 func (s *Amqp) SetDialPolicy(policy dial.Policy) {
-	s.dialPolicy = policy
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Get the dial policy used by this service, e.g. for logging its settings via
+// dial.Describe or inspecting CurAttempt while a dial is in progress. The policy
+// is the copy stored by SetDialPolicy so it must not be passed to other services.
+func (s *Amqp) DialPolicy() dial.Policy {
+	return s.dialPolicy
+}
+
+// Set the dialer used for establishing network connections to the broker; nil
+// restores the driver default. It should be set before the service is dialed.
+func (s *Amqp) SetDialer(dialer adapters.Dialer) {
+	s.dialer = dialer
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *Amqp) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+	s.metrics = m
+}
+
+// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+func (s *Amqp) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+	s.tracer = t
 }
 
 // Set the service configuration. Changing the configuration settings for an already connected
 // service will trigger a service shutdown. The service consumer is responsible for handing
 // service close events and triggering a re-dial.
 func (s *Amqp) Config(params map[string]string) error {
+	return s.ConfigContext(context.Background(), params)
+}
+
+// Apply configuration settings using the supplied context. If a context logger is
+// attached, log lines are written to the logger obtained for ctx; see Config.
+func (s *Amqp) ConfigContext(ctx context.Context, params map[string]string) (err error) {
 	s.Lock()
 	defer s.Unlock()
 
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Config")
+	defer func() { span.End(err) }()
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+
+	s.configChanged = false
+
 	needsReset := false
 
-	endpoint, exists := params["endpoint"]
-	if exists {
+	schema := adapters.ConfigSchema(params)
+
+	endpoint := schema.String("endpoint", s.endpoint)
+	connectionName := schema.String("connectionName", s.connectionName)
+	authMechanism := schema.String("authMechanism", s.authMechanism)
+	tlsCertFile := schema.String("tlsCertFile", s.tlsCertFile)
+	tlsKeyFile := schema.String("tlsKeyFile", s.tlsKeyFile)
+	tlsCAFile := schema.String("tlsCAFile", s.tlsCAFile)
+	err = validateAuth(authMechanism, endpoint, tlsCertFile, tlsKeyFile)
+	var tcpKeepAlive time.Duration
+	if err == nil {
+		tcpKeepAlive, err = schema.Duration("tcpKeepAlive", time.Second, s.tcpKeepAlive)
+	}
+	if err == nil && tcpKeepAlive < 0 {
+		err = fmt.Errorf("invalid value for 'tcpKeepAlive': %s", params["tcpKeepAlive"])
+	}
+	var channelIdleTimeout time.Duration
+	if err == nil {
+		channelIdleTimeout, err = schema.Duration("channelIdleTimeout", time.Second, s.channelIdleTimeout)
+	}
+	if err == nil && channelIdleTimeout < 0 {
+		err = fmt.Errorf("invalid value for 'channelIdleTimeout': %s", params["channelIdleTimeout"])
+	}
+	watchdog := !s.disableWatchdog
+	if err == nil {
+		watchdog, err = schema.Bool("watchdog", watchdog)
+	}
+	if err != nil {
+		logger.Printf("%s Configuration error: %s\n", s.logPrefix(), err.Error())
+		return err
+	}
+
+	if endpoint != s.endpoint {
 		s.endpoint = endpoint
 		needsReset = true
 	}
+	if tcpKeepAlive != s.tcpKeepAlive {
+		s.tcpKeepAlive = tcpKeepAlive
+		needsReset = true
+	}
+	if connectionName != s.connectionName {
+		s.connectionName = connectionName
+		needsReset = true
+	}
+	if channelIdleTimeout != s.channelIdleTimeout {
+		s.channelIdleTimeout = channelIdleTimeout
+		needsReset = true
+	}
+	if watchdog == s.disableWatchdog {
+		s.disableWatchdog = !watchdog
+		needsReset = true
+	}
+	if authMechanism != s.authMechanism {
+		s.authMechanism = authMechanism
+		needsReset = true
+	}
+	if tlsCertFile != s.tlsCertFile || tlsKeyFile != s.tlsKeyFile || tlsCAFile != s.tlsCAFile {
+		s.tlsCertFile, s.tlsKeyFile, s.tlsCAFile = tlsCertFile, tlsKeyFile, tlsCAFile
+		needsReset = true
+	} else if s.connected && tlsFilesDigest(tlsCertFile, tlsKeyFile, tlsCAFile) != s.tlsDigest {
+		// The files were rotated in place; reconnect to present the new certificate
+		logger.Printf("%s TLS files changed; reloading certificates\n", s.logPrefix())
+		needsReset = true
+	}
 
+	// Custom properties are merged into the current ones; an empty value removes a property
+	for key, val := range params {
+		if !strings.HasPrefix(key, propertyPrefix) || len(key) == len(propertyPrefix) {
+			continue
+		}
+		name := key[len(propertyPrefix):]
+		cur, exists := s.properties[name]
+		switch {
+		case val == "" && exists:
+			delete(s.properties, name)
+		case val != "" && val != cur:
+			if s.properties == nil {
+				s.properties = make(map[string]string)
+			}
+			s.properties[name] = val
+		default:
+			continue
+		}
+		needsReset = true
+	}
+
+	s.configChanged = needsReset
+	span.SetAttribute("reset", needsReset)
 	if needsReset {
-		s.logger.Printf("[AMQP] Configuration changed; new settings: endpoint=%s\n", s.endpoint)
+		logger.Printf("%s Configuration changed; new settings: endpoint=%s, tcpKeepAlive=%v, connectionName=%s, properties=%v, channelIdleTimeout=%v, watchdog=%v, authMechanism=%s, tlsCertFile=%s, tlsCAFile=%s\n", s.logPrefix(), redactEndpoint(s.endpoint), s.tcpKeepAlive, s.connectionName, s.properties, s.channelIdleTimeout, !s.disableWatchdog, s.authMechanism, s.tlsCertFile, s.tlsCAFile)
+		s.events.Emit(adapters.Event{Type: adapters.EventConfigChanged})
 		if s.connected {
 			s.conn.Close()
+			s.closeChannelPool()
 			s.closeNotifier.NotifyAll(nil)
 			s.conn = nil
 			s.connected = false
+			s.metrics.SetConnected(s.Name(), false)
+			s.events.Emit(adapters.Event{Type: adapters.EventDisconnect})
 		}
 	}
 
 	return nil
 }
 
+// Report whether the most recent Config call modified any setting and triggered a service reset.
+func (s *Amqp) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
+
+// Strip the credentials from an amqp URI so it can be safely logged or reported by
+// Status. URIs that cannot be parsed are replaced by a placeholder as they may still
+// contain a password.
+func redactEndpoint(endpoint string) string {
+	uri, err := url.Parse(endpoint)
+	if err != nil {
+		return "(invalid endpoint)"
+	}
+	uri.User = nil
+	return uri.String()
+}
+
+// Get the configured amqp endpoint.
+func (s *Amqp) Endpoint() string {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.endpoint
+}
+
+// Set the amqp endpoint. This is equivalent to calling Config with the "endpoint" setting.
+func (s *Amqp) SetEndpoint(endpoint string) error {
+	return s.Config(map[string]string{"endpoint": endpoint})
+}
+
 // Allocate new amqp channel.
 func (s *Amqp) NewChannel() (*amqpDriver.Channel, error) {
 	s.Lock()
 	defer s.Unlock()
 
-	if !s.connected {
+	if !s.connected || s.draining {
 		return nil, adapters.ErrConnectionClosed
 	}
 
-	return s.conn.Channel()
+	channel, _, err := s.openChannel()
+	return channel, err
 }
 
-// A worker that listens for service-related notifications or configuration changes.
-func (s *Amqp) watchdog() {
-	amqpClose := make(chan *amqpDriver.Error)
-	s.conn.NotifyClose(amqpClose)
-
-	select {
-	case err := <-amqpClose:
-		// Reset connection
-		s.Lock()
-		s.conn = nil
-		s.connected = false
-		s.Unlock()
-		if err == nil {
-			s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
-			s.logger.Printf("[AMQP] Disconnected from endpoint %s\n", s.endpoint)
-		} else {
-			s.closeNotifier.NotifyAll(nil)
-			s.logger.Printf("[AMQP] Lost connection to endpoint %s\n", s.endpoint)
+// Allocate a channel and track it until it is closed by the caller or the connection
+// is closed. The returned signal is closed once the channel is closed. This method is
+// not thread-safe so it should be invoked while holding the service lock.
+func (s *Amqp) openChannel() (*amqpDriver.Channel, <-chan struct{}, error) {
+	channel, err := s.conn.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	openChannels := s.openChannels
+	atomic.AddInt32(openChannels, 1)
+	closed := make(chan struct{})
+	channelClose := channel.NotifyClose(make(chan *amqpDriver.Error, 1))
+	go func() {
+		for range channelClose {
 		}
+		atomic.AddInt32(openChannels, -1)
+		close(closed)
+	}()
+
+	return channel, closed, nil
+}
+
+// A worker that listens for service-related notifications or configuration changes.
+// Close, CloseContext and Config detach conn before closing it and notify listeners
+// themselves; in that case the watchdog exits without emitting a second notification.
+func (s *Amqp) watchdog(conn *amqpDriver.Connection, amqpClose chan *amqpDriver.Error) {
+	err := <-amqpClose
+
+	s.Lock()
+	defer s.Unlock()
+
+	// The connection was closed by the service or replaced by a re-dial
+	if !s.connected || s.conn != conn {
+		return
+	}
+
+	// Reset connection
+	s.closeChannelPool()
+	s.conn = nil
+	s.connected = false
+	s.metrics.SetConnected(s.Name(), false)
+	if err == nil {
+		s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+		s.events.Emit(adapters.Event{Type: adapters.EventDisconnect, Err: adapters.ErrConnectionClosed})
+		s.logger.Printf("%s Disconnected from endpoint %s\n", s.logPrefix(), redactEndpoint(s.endpoint))
+	} else {
+		s.closeNotifier.NotifyAll(nil)
+		s.events.Emit(adapters.Event{Type: adapters.EventDisconnect, Err: err})
+		s.logger.Printf("%s Lost connection to endpoint %s\n", s.logPrefix(), redactEndpoint(s.endpoint))
 	}
 }
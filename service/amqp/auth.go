@@ -0,0 +1,122 @@
+package amqp
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	amqpDriver "github.com/streadway/amqp"
+)
+
+// The SASL mechanisms that can be selected via the authMechanism setting.
+var authMechanisms = map[string]struct{}{
+	"":         {},
+	"plain":    {},
+	"amqplain": {},
+	"external": {},
+}
+
+// SASL EXTERNAL authentication. The broker authenticates the client using the
+// certificate presented during the TLS handshake so no credentials are sent.
+type externalAuth struct{}
+
+// Mechanism returns "EXTERNAL"
+func (externalAuth) Mechanism() string { return "EXTERNAL" }
+
+// Response returns an empty response; the identity is derived from the client certificate.
+func (externalAuth) Response() string { return "" }
+
+// Build the SASL mechanisms offered to the broker for the supplied authMechanism
+// setting. The plain and amqplain mechanisms use the credentials of the endpoint URI.
+// An empty mechanism returns nil so that the driver default (PLAIN) is used.
+func saslMechanisms(mechanism, endpoint string) ([]amqpDriver.Authentication, error) {
+	switch mechanism {
+	case "":
+		return nil, nil
+	case "external":
+		return []amqpDriver.Authentication{externalAuth{}}, nil
+	}
+
+	uri, err := amqpDriver.ParseURI(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mechanism {
+	case "plain":
+		return []amqpDriver.Authentication{uri.PlainAuth()}, nil
+	case "amqplain":
+		return []amqpDriver.Authentication{uri.AMQPlainAuth()}, nil
+	}
+
+	return nil, fmt.Errorf("invalid value for 'authMechanism': %s", mechanism)
+}
+
+// Validate the authentication and TLS settings.
+func validateAuth(mechanism, endpoint, certFile, keyFile string) error {
+	if _, valid := authMechanisms[mechanism]; !valid {
+		return fmt.Errorf("invalid value for 'authMechanism': %s", mechanism)
+	}
+	if mechanism == "external" && !strings.HasPrefix(endpoint, "amqps://") {
+		return fmt.Errorf("invalid value for 'authMechanism': %s; EXTERNAL auth requires an amqps:// endpoint", mechanism)
+	}
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("invalid value for 'tlsCertFile': %s; tlsCertFile and tlsKeyFile must be specified together", certFile)
+	}
+	return nil
+}
+
+// Build the TLS configuration for amqps endpoints from the supplied PEM files. Returns
+// nil if no files are specified so that the driver default is used.
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" && caFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+
+	return config, nil
+}
+
+// Compute a digest of the contents of the supplied PEM files so that Config can detect
+// certificates rotated in place. Returns an empty string if no files are specified.
+// Files that cannot be read contribute their error instead; Dial reports it.
+func tlsFilesDigest(certFile, keyFile, caFile string) string {
+	if certFile == "" && caFile == "" {
+		return ""
+	}
+
+	h := sha256.New()
+	for _, file := range []string{certFile, keyFile, caFile} {
+		if file != "" {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				data = []byte(err.Error())
+			}
+			h.Write(data)
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
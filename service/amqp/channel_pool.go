@@ -0,0 +1,264 @@
+package amqp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	amqpDriver "github.com/streadway/amqp"
+)
+
+// An idle channel stored in the channel pool.
+type pooledChannel struct {
+	channel *amqpDriver.Channel
+
+	// Closed once the channel is closed by the client or the broker.
+	closed <-chan struct{}
+
+	// The time the channel was returned to the pool.
+	idleSince time.Time
+}
+
+// A pool of channels allocated over a single connection. Channels that stay idle
+// for longer than idleTimeout are closed by a sweeper goroutine and are never
+// handed out again.
+type channelPool struct {
+
+	// A mutex protecting the pool state.
+	sync.Mutex
+
+	// The time after which idle channels are closed; 0 keeps them indefinitely.
+	idleTimeout time.Duration
+
+	// Idle channels ordered by the time they were returned to the pool.
+	idle []pooledChannel
+
+	// The close signals of channels that are currently borrowed from the pool.
+	borrowed map[*amqpDriver.Channel]<-chan struct{}
+
+	// Closed to stop the sweeper goroutine.
+	stop chan struct{}
+
+	// Set once the pool is closed.
+	closed bool
+}
+
+func newChannelPool(idleTimeout time.Duration) *channelPool {
+	p := &channelPool{
+		idleTimeout: idleTimeout,
+		borrowed:    make(map[*amqpDriver.Channel]<-chan struct{}),
+		stop:        make(chan struct{}),
+	}
+
+	if idleTimeout > 0 {
+		go p.sweeper()
+	}
+
+	return p
+}
+
+// Get the most recently returned idle channel that is still open. Expired channels
+// are closed. Returns nil if no idle channel is available.
+func (p *channelPool) get() *amqpDriver.Channel {
+	p.Lock()
+	defer p.Unlock()
+
+	p.evictExpired(time.Now())
+	for len(p.idle) > 0 {
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if isClosed(pc.closed) {
+			continue
+		}
+
+		p.borrowed[pc.channel] = pc.closed
+		return pc.channel
+	}
+
+	return nil
+}
+
+// Track a newly allocated channel as borrowed from the pool.
+func (p *channelPool) add(channel *amqpDriver.Channel, closed <-chan struct{}) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.borrowed[channel] = closed
+}
+
+// Return a borrowed channel to the pool. Channels that are closed or were not
+// borrowed from this pool are closed instead. Returns false if the channel was not
+// pooled.
+func (p *channelPool) put(channel *amqpDriver.Channel) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	closed, borrowed := p.borrowed[channel]
+	delete(p.borrowed, channel)
+	if p.closed || !borrowed || isClosed(closed) {
+		return false
+	}
+
+	p.idle = append(p.idle, pooledChannel{channel: channel, closed: closed, idleSince: time.Now()})
+	return true
+}
+
+// Close all idle channels. Borrowed channels are not affected.
+func (p *channelPool) closeIdle() {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, pc := range p.idle {
+		pc.channel.Close()
+	}
+	p.idle = nil
+}
+
+// Close all idle channels and stop the sweeper. Channels returned afterwards are
+// not pooled.
+func (p *channelPool) close() {
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	p.Unlock()
+
+	p.closeIdle()
+}
+
+// Close idle channels that have been idle for longer than idleTimeout. This method
+// is not thread-safe so it should be invoked while holding the pool lock.
+func (p *channelPool) evictExpired(now time.Time) {
+	if p.idleTimeout <= 0 {
+		return
+	}
+
+	// Idle channels are ordered by idleSince so the expired ones are at the front
+	expired := 0
+	for ; expired < len(p.idle) && now.Sub(p.idle[expired].idleSince) >= p.idleTimeout; expired++ {
+		p.idle[expired].channel.Close()
+	}
+	p.idle = p.idle[expired:]
+}
+
+// Periodically close expired idle channels until the pool is closed.
+func (p *channelPool) sweeper() {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.Lock()
+			p.evictExpired(now)
+			p.Unlock()
+		}
+	}
+}
+
+// Get the number of idle channels in the pool.
+func (p *channelPool) idleCount() int {
+	p.Lock()
+	defer p.Unlock()
+
+	return len(p.idle)
+}
+
+// Get the number of borrowed and idle channels in the pool.
+func (p *channelPool) stats() (inUse, idle int) {
+	p.Lock()
+	defer p.Unlock()
+
+	return len(p.borrowed), len(p.idle)
+}
+
+// Check whether closed has been signalled.
+func isClosed(closed <-chan struct{}) bool {
+	select {
+	case <-closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Borrow a channel from the channel pool. If no idle channel is available, a new
+// one is allocated. Borrowed channels must be handed back via ReturnChannel instead
+// of being closed by the caller. Idle channels are closed once they exceed the
+// channelIdleTimeout setting.
+func (s *Amqp) BorrowChannel() (*amqpDriver.Channel, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected || s.draining {
+		return nil, adapters.ErrConnectionClosed
+	}
+
+	defer s.reportChannelPoolStats()
+	if channel := s.channelPool.get(); channel != nil {
+		return channel, nil
+	}
+
+	channel, closed, err := s.openChannel()
+	if err != nil {
+		return nil, err
+	}
+	s.channelPool.add(channel, closed)
+
+	return channel, nil
+}
+
+// Return a channel obtained via BorrowChannel to the channel pool. If the channel was
+// closed or the connection was reset since it was borrowed, the channel is closed
+// instead.
+func (s *Amqp) ReturnChannel(channel *amqpDriver.Channel) {
+	s.Lock()
+	pool, draining := s.channelPool, s.draining
+	s.Unlock()
+
+	if pool == nil || draining || !pool.put(channel) {
+		channel.Close()
+	}
+
+	s.Lock()
+	s.reportChannelPoolStats()
+	s.Unlock()
+}
+
+// Get the channel pool stats of the current connection: the number of borrowed
+// channels, the number of idle channels and the max number of channels negotiated
+// with the broker. The max applies to all channels of the connection, including those
+// allocated via NewChannel and by consumers, so opening channels fails once
+// inUse approaches max. All stats are 0 while the service is not connected.
+func (s *Amqp) ChannelPoolStats() (inUse, idle, max int) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.channelPoolStats()
+}
+
+// Get the channel pool stats. This method is not thread-safe so it should be
+// invoked while holding the service lock.
+func (s *Amqp) channelPoolStats() (inUse, idle, max int) {
+	if s.channelPool == nil || s.conn == nil {
+		return 0, 0, 0
+	}
+
+	inUse, idle = s.channelPool.stats()
+	return inUse, idle, s.conn.Config.ChannelMax
+}
+
+// Report the channel pool stats to the metrics sink if it implements
+// adapters.PoolMetrics. This method is not thread-safe so it should be invoked
+// while holding the service lock.
+func (s *Amqp) reportChannelPoolStats() {
+	if m, ok := s.metrics.(adapters.PoolMetrics); ok {
+		inUse, idle, max := s.channelPoolStats()
+		m.SetPoolStats(s.Name(), inUse, idle, max)
+	}
+}
@@ -0,0 +1,184 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
+	amqpDriver "github.com/streadway/amqp"
+)
+
+// The interval between attempts to re-establish a consumer after its channel is closed.
+var consumeRetryInterval = 100 * time.Millisecond
+
+var errDeliveriesClosed = errors.New("amqp: delivery channel closed")
+
+// The subset of the amqp channel API used by Consume.
+type consumerChannel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqpDriver.Table) (<-chan amqpDriver.Delivery, error)
+	Close() error
+}
+
+// Open a channel for consuming deliveries. Tests may override this to feed deliveries.
+var openConsumerChannel = func(s *Amqp) (consumerChannel, error) {
+	return s.NewChannel()
+}
+
+// Consume deliveries from queue and invoke handler for each one until ctx is
+// cancelled. Deliveries are acked if handler returns nil and nacked (with requeue)
+// otherwise. If the consumer channel is closed (e.g. the connection was lost),
+// the consumer is transparently re-established once the service reconnects.
+// Consume blocks until ctx is cancelled and returns ctx.Err().
+func (s *Amqp) Consume(ctx context.Context, queue string, handler func(amqpDriver.Delivery) error) error {
+	return s.runConsumer(ctx, queue, handler, nil)
+}
+
+// ConsumeAutoTune works like Consume but also manages the prefetch count of the
+// consumer channel according to tuning, raising it while handlers keep up and
+// lowering it when deliveries pile up behind slow handlers. The prefetch count
+// starts at tuning.Min and is retained when the consumer is re-established.
+func (s *Amqp) ConsumeAutoTune(ctx context.Context, queue string, tuning PrefetchTuning, handler func(amqpDriver.Delivery) error) error {
+	if err := tuning.validate(); err != nil {
+		return err
+	}
+	return s.runConsumer(ctx, queue, handler, &prefetchTuner{tuning: tuning, prefetch: tuning.Min})
+}
+
+// Run the consumer loop, re-establishing the consumer after failures until ctx is
+// cancelled. If tuner is nil, the prefetch count of the channel is left unchanged.
+func (s *Amqp) runConsumer(ctx context.Context, queue string, handler func(amqpDriver.Delivery) error, tuner *prefetchTuner) error {
+	for {
+		err := s.consume(ctx, queue, handler, tuner)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.logger.Printf("%s Consumer for queue %s interrupted: %v; retrying in %v\n", s.logPrefix(), queue, err, consumeRetryInterval)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(consumeRetryInterval):
+		}
+	}
+}
+
+// Run the delivery loop on a new channel until ctx is cancelled or the channel fails.
+func (s *Amqp) consume(ctx context.Context, queue string, handler func(amqpDriver.Delivery) error, tuner *prefetchTuner) error {
+	ch, err := openConsumerChannel(s)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if tuner != nil {
+		if err = ch.Qos(tuner.prefetch, 0, false); err != nil {
+			return err
+		}
+	}
+
+	deliveries, err := ch.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d, ok := <-deliveries:
+			if !ok {
+				return errDeliveriesClosed
+			}
+
+			start := clock.Default.Now()
+			if handlerErr := handler(d); handlerErr != nil {
+				err = d.Nack(false, true)
+			} else {
+				err = d.Ack(false)
+			}
+			if err != nil {
+				return err
+			}
+
+			if tuner == nil {
+				continue
+			}
+			if prefetch, changed := tuner.observe(clock.Since(start)); changed {
+				if err = ch.Qos(prefetch, 0, false); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// PrefetchTuning defines the bounds used by ConsumeAutoTune for adjusting the
+// prefetch count of a consumer.
+type PrefetchTuning struct {
+	// The lower and upper bound of the prefetch count.
+	Min int
+	Max int
+
+	// The max time a delivery should wait in the client-side buffer before it is
+	// handled. As deliveries are handled one at a time, the prefetch count is set
+	// to TargetBacklog divided by the average handler latency.
+	TargetBacklog time.Duration
+}
+
+func (t PrefetchTuning) validate() error {
+	switch {
+	case t.Min < 1:
+		return fmt.Errorf("invalid prefetch tuning: min must be at least 1; got %d", t.Min)
+	case t.Max < t.Min:
+		return fmt.Errorf("invalid prefetch tuning: max must be at least %d; got %d", t.Min, t.Max)
+	case t.TargetBacklog <= 0:
+		return fmt.Errorf("invalid prefetch tuning: target backlog must be positive; got %v", t.TargetBacklog)
+	}
+	return nil
+}
+
+// Tracks handler latencies and calculates the prefetch count of a consumer. The
+// prefetch count is re-evaluated after every window of deliveries whose size
+// matches the current prefetch count. Increases are capped to doubling the
+// prefetch count per window while decreases are applied immediately.
+type prefetchTuner struct {
+	tuning PrefetchTuning
+
+	// The current prefetch count.
+	prefetch int
+
+	// The number of deliveries in the current window and the total time spent
+	// handling them.
+	count int
+	busy  time.Duration
+}
+
+// Record the latency of a handled delivery. If a window is complete, the new
+// prefetch count is returned together with a flag indicating whether it changed.
+func (t *prefetchTuner) observe(latency time.Duration) (int, bool) {
+	t.count++
+	t.busy += latency
+	if t.count < t.prefetch {
+		return t.prefetch, false
+	}
+
+	prefetch := t.tuning.Max
+	if avg := t.busy / time.Duration(t.count); avg > 0 && int64(t.tuning.TargetBacklog/avg) < int64(t.tuning.Max) {
+		prefetch = int(t.tuning.TargetBacklog / avg)
+	}
+	if prefetch > 2*t.prefetch {
+		// Grow gradually so that a few fast deliveries do not flood the consumer
+		prefetch = 2 * t.prefetch
+	}
+	if prefetch < t.tuning.Min {
+		prefetch = t.tuning.Min
+	}
+
+	t.count, t.busy = 0, 0
+	changed := prefetch != t.prefetch
+	t.prefetch = prefetch
+	return prefetch, changed
+}
@@ -0,0 +1,274 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
+	amqpDriver "github.com/streadway/amqp"
+)
+
+// Records acks and nacks and serves as a consumer channel feeding deliveries.
+type fakeConsumer struct {
+	sync.Mutex
+
+	// A channel for each consumer session; a session ends when its channel is closed.
+	sessions chan chan amqpDriver.Delivery
+
+	queues []string
+	acks   []string
+	qos    []int
+	closed int
+}
+
+func (c *fakeConsumer) Qos(prefetchCount, prefetchSize int, global bool) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.qos = append(c.qos, prefetchCount)
+	return nil
+}
+
+func (c *fakeConsumer) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqpDriver.Table) (<-chan amqpDriver.Delivery, error) {
+	c.Lock()
+	c.queues = append(c.queues, queue)
+	c.Unlock()
+
+	return <-c.sessions, nil
+}
+
+func (c *fakeConsumer) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.closed++
+	return nil
+}
+
+func (c *fakeConsumer) Ack(tag uint64, multiple bool) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.acks = append(c.acks, fmt.Sprintf("ack %d", tag))
+	return nil
+}
+
+func (c *fakeConsumer) Nack(tag uint64, multiple bool, requeue bool) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.acks = append(c.acks, fmt.Sprintf("nack %d requeue=%t", tag, requeue))
+	return nil
+}
+
+func (c *fakeConsumer) Reject(tag uint64, requeue bool) error {
+	return errors.New("unexpected reject")
+}
+
+func (c *fakeConsumer) waitForAcks(t *testing.T, count int) []string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.Lock()
+		acks := append([]string{}, c.acks...)
+		c.Unlock()
+		if len(acks) >= count {
+			return acks
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d ack(s); got %v", count, acks)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	defer func(interval time.Duration) { consumeRetryInterval = interval }(consumeRetryInterval)
+	consumeRetryInterval = time.Millisecond
+
+	fake := &fakeConsumer{sessions: make(chan chan amqpDriver.Delivery, 2)}
+	orig := openConsumerChannel
+	openConsumerChannel = func(*Amqp) (consumerChannel, error) { return fake, nil }
+	defer func() { openConsumerChannel = orig }()
+
+	srv := newTestAdapter("")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- srv.Consume(ctx, "jobs", func(d amqpDriver.Delivery) error {
+			if string(d.Body) == "fail" {
+				return errors.New("handler failed")
+			}
+			return nil
+		})
+	}()
+
+	session1 := make(chan amqpDriver.Delivery)
+	fake.sessions <- session1
+	session1 <- amqpDriver.Delivery{Acknowledger: fake, DeliveryTag: 1, Body: []byte("ok")}
+	session1 <- amqpDriver.Delivery{Acknowledger: fake, DeliveryTag: 2, Body: []byte("fail")}
+	session1 <- amqpDriver.Delivery{Acknowledger: fake, DeliveryTag: 3, Body: []byte("ok")}
+	fake.waitForAcks(t, 3)
+
+	// Closing the delivery channel (e.g. due to a connection reset) should re-establish the consumer
+	close(session1)
+	session2 := make(chan amqpDriver.Delivery)
+	fake.sessions <- session2
+	session2 <- amqpDriver.Delivery{Acknowledger: fake, DeliveryTag: 1, Body: []byte("ok")}
+	acks := fake.waitForAcks(t, 4)
+
+	expAcks := []string{"ack 1", "nack 2 requeue=true", "ack 3", "ack 1"}
+	if !reflect.DeepEqual(acks, expAcks) {
+		t.Fatalf("Expected acks %v; got %v", expAcks, acks)
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != context.Canceled {
+			t.Fatalf("Expected Consume to return context.Canceled; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for Consume to return")
+	}
+
+	fake.Lock()
+	defer fake.Unlock()
+	if !reflect.DeepEqual(fake.queues, []string{"jobs", "jobs"}) {
+		t.Fatalf("Expected two consumers for queue jobs; got %v", fake.queues)
+	}
+	if fake.closed != 2 {
+		t.Fatalf("Expected both consumer channels to be closed; got %d", fake.closed)
+	}
+}
+
+func TestConsumeWaitsForConnection(t *testing.T) {
+	defer func(interval time.Duration) { consumeRetryInterval = interval }(consumeRetryInterval)
+	consumeRetryInterval = time.Millisecond
+
+	srv := newTestAdapter("")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// The service is not connected so Consume should keep retrying until ctx expires
+	if err := srv.Consume(ctx, "jobs", func(amqpDriver.Delivery) error { return nil }); err != context.DeadlineExceeded {
+		t.Fatalf("Expected Consume to return context.DeadlineExceeded; got %v", err)
+	}
+}
+
+func TestPrefetchTuner(t *testing.T) {
+	tuner := &prefetchTuner{
+		tuning:   PrefetchTuning{Min: 1, Max: 20, TargetBacklog: 100 * time.Millisecond},
+		prefetch: 1,
+	}
+
+	// Fast handlers grow the prefetch count by doubling it after each window up to Max
+	observe := func(count int, latency time.Duration) []int {
+		var changes []int
+		for i := 0; i < count; i++ {
+			if prefetch, changed := tuner.observe(latency); changed {
+				changes = append(changes, prefetch)
+			}
+		}
+		return changes
+	}
+	if exp, changes := []int{2, 4, 8, 16, 20}, observe(1+2+4+8+16, time.Millisecond); !reflect.DeepEqual(changes, exp) {
+		t.Fatalf("Expected fast handlers to raise prefetch to %v; got %v", exp, changes)
+	}
+	if changes := observe(40, time.Millisecond); len(changes) != 0 {
+		t.Fatalf("Expected prefetch to stay at Max; got %v", changes)
+	}
+
+	// Slow handlers lower the prefetch count to TargetBacklog / latency
+	if exp, changes := []int{4}, observe(20, 25*time.Millisecond); !reflect.DeepEqual(changes, exp) {
+		t.Fatalf("Expected slow handlers to lower prefetch to %v; got %v", exp, changes)
+	}
+
+	// Handlers slower than TargetBacklog are clamped to Min
+	if exp, changes := []int{1}, observe(4, time.Second); !reflect.DeepEqual(changes, exp) {
+		t.Fatalf("Expected very slow handlers to lower prefetch to %v; got %v", exp, changes)
+	}
+}
+
+func TestConsumeAutoTune(t *testing.T) {
+	defer func(interval time.Duration) { consumeRetryInterval = interval }(consumeRetryInterval)
+	consumeRetryInterval = time.Millisecond
+	fakeClock := clock.UseFake(t)
+
+	fake := &fakeConsumer{sessions: make(chan chan amqpDriver.Delivery, 2)}
+	orig := openConsumerChannel
+	openConsumerChannel = func(*Amqp) (consumerChannel, error) { return fake, nil }
+	defer func() { openConsumerChannel = orig }()
+
+	srv := newTestAdapter("")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tuning := PrefetchTuning{Min: 2, Max: 8, TargetBacklog: 40 * time.Millisecond}
+	result := make(chan error, 1)
+	go func() {
+		result <- srv.ConsumeAutoTune(ctx, "jobs", tuning, func(d amqpDriver.Delivery) error {
+			if string(d.Body) == "slow" {
+				<-fakeClock.After(20 * time.Millisecond)
+			}
+			return nil
+		})
+	}()
+
+	session1 := make(chan amqpDriver.Delivery)
+	fake.sessions <- session1
+	send := func(session chan amqpDriver.Delivery, count int, body string) {
+		for i := 0; i < count; i++ {
+			session <- amqpDriver.Delivery{Acknowledger: fake, DeliveryTag: uint64(i + 1), Body: []byte(body)}
+		}
+	}
+
+	// Fast deliveries raise prefetch from Min to Max: 2 -> 4 -> 8
+	send(session1, 2+4, "fast")
+	fake.waitForAcks(t, 6)
+
+	// Slow deliveries lower it to TargetBacklog / latency
+	send(session1, 8, "slow")
+	fake.waitForAcks(t, 14)
+
+	// The tuned prefetch count is retained by a re-established consumer
+	close(session1)
+	session2 := make(chan amqpDriver.Delivery)
+	fake.sessions <- session2
+	send(session2, 1, "fast")
+	fake.waitForAcks(t, 15)
+
+	fake.Lock()
+	qos := append([]int(nil), fake.qos...)
+	fake.Unlock()
+	if exp := []int{2, 4, 8, 2, 2}; !reflect.DeepEqual(qos, exp) {
+		t.Fatalf("Expected prefetch counts %v; got %v", exp, qos)
+	}
+
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Fatalf("Expected ConsumeAutoTune to return context.Canceled; got %v", err)
+	}
+}
+
+func TestConsumeAutoTuneInvalidBounds(t *testing.T) {
+	srv := newTestAdapter("")
+	handler := func(amqpDriver.Delivery) error { return nil }
+
+	specs := []PrefetchTuning{
+		{Min: 0, Max: 10, TargetBacklog: time.Second},
+		{Min: 5, Max: 4, TargetBacklog: time.Second},
+		{Min: 1, Max: 10},
+	}
+	for index, tuning := range specs {
+		if err := srv.ConsumeAutoTune(context.Background(), "jobs", tuning, handler); err == nil {
+			t.Fatalf("[spec %d] Expected ConsumeAutoTune to reject %+v", index, tuning)
+		}
+	}
+}
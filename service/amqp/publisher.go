@@ -0,0 +1,90 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	amqpDriver "github.com/streadway/amqp"
+)
+
+// ErrPublishNacked is returned by Publish when the broker negatively acknowledges a message.
+var ErrPublishNacked = errors.New("amqp: message nacked by the broker")
+
+// ErrPublishReturned is returned by Publish when the broker returns an unroutable
+// mandatory message. The returned error wraps it and includes the broker reply.
+var ErrPublishReturned = errors.New("amqp: message returned by the broker")
+
+// The subset of the amqp channel API used by Publish.
+type publisherChannel interface {
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqpDriver.Confirmation) chan amqpDriver.Confirmation
+	NotifyReturn(returns chan amqpDriver.Return) chan amqpDriver.Return
+	Publish(exchange, key string, mandatory, immediate bool, msg amqpDriver.Publishing) error
+	Close() error
+}
+
+// Open a channel for publishing messages. Tests may override this to script confirmations.
+var openPublisherChannel = func(s *Amqp) (publisherChannel, error) {
+	return s.NewChannel()
+}
+
+// Publish msg to exchange with the supplied routing key on a new channel in confirm
+// mode and wait for the broker to confirm it. If mandatory is set and the message
+// cannot be routed to any queue, an error wrapping ErrPublishReturned is returned.
+// ErrPublishNacked is returned if the broker nacks the message and ctx.Err() if ctx
+// expires before the confirmation arrives. The immediate flag is always cleared
+// since RabbitMQ does not support it.
+func (s *Amqp) Publish(ctx context.Context, exchange, key string, mandatory bool, msg amqpDriver.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ch, err := openPublisherChannel(s)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err = ch.Confirm(false); err != nil {
+		return err
+	}
+	confirms := ch.NotifyPublish(make(chan amqpDriver.Confirmation, 1))
+	returns := ch.NotifyReturn(make(chan amqpDriver.Return, 1))
+
+	if err = ch.Publish(exchange, key, mandatory, false, msg); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case ret, ok := <-returns:
+		if !ok {
+			return adapters.ErrConnectionClosed
+		}
+		return returnError(ret)
+	case confirm, ok := <-confirms:
+		if !ok {
+			return adapters.ErrConnectionClosed
+		}
+
+		// The broker sends the return of an unroutable message before its ack
+		select {
+		case ret, ok := <-returns:
+			if ok {
+				return returnError(ret)
+			}
+		default:
+		}
+		if !confirm.Ack {
+			return ErrPublishNacked
+		}
+		return nil
+	}
+}
+
+func returnError(ret amqpDriver.Return) error {
+	return fmt.Errorf("%w: %d %s", ErrPublishReturned, ret.ReplyCode, ret.ReplyText)
+}
@@ -0,0 +1,106 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqpDriver "github.com/streadway/amqp"
+)
+
+// A publisher channel that answers each publish according to a script.
+type fakePublisher struct {
+	// Whether to ack or nack the message; no confirmation is sent if unset.
+	confirm *bool
+
+	// Whether to return the message before confirming it.
+	returnMsg bool
+
+	confirmMode bool
+	published   []string
+	closed      bool
+
+	confirms chan amqpDriver.Confirmation
+	returns  chan amqpDriver.Return
+}
+
+func (p *fakePublisher) Confirm(noWait bool) error {
+	p.confirmMode = true
+	return nil
+}
+
+func (p *fakePublisher) NotifyPublish(confirm chan amqpDriver.Confirmation) chan amqpDriver.Confirmation {
+	p.confirms = confirm
+	return confirm
+}
+
+func (p *fakePublisher) NotifyReturn(returns chan amqpDriver.Return) chan amqpDriver.Return {
+	p.returns = returns
+	return returns
+}
+
+func (p *fakePublisher) Publish(exchange, key string, mandatory, immediate bool, msg amqpDriver.Publishing) error {
+	p.published = append(p.published, exchange+"/"+key+":"+string(msg.Body))
+	if p.returnMsg && mandatory {
+		p.returns <- amqpDriver.Return{ReplyCode: 312, ReplyText: "NO_ROUTE", Exchange: exchange, RoutingKey: key}
+	}
+	if p.confirm != nil {
+		p.confirms <- amqpDriver.Confirmation{DeliveryTag: 1, Ack: *p.confirm}
+	}
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestPublish(t *testing.T) {
+	defer func(open func(*Amqp) (publisherChannel, error)) { openPublisherChannel = open }(openPublisherChannel)
+
+	ack, nack := true, false
+	specs := []struct {
+		descr     string
+		publisher *fakePublisher
+		timeout   time.Duration
+		expErr    error
+	}{
+		{"ack", &fakePublisher{confirm: &ack}, time.Second, nil},
+		{"nack", &fakePublisher{confirm: &nack}, time.Second, ErrPublishNacked},
+		{"return", &fakePublisher{confirm: &ack, returnMsg: true}, time.Second, ErrPublishReturned},
+		{"deadline", &fakePublisher{}, 10 * time.Millisecond, context.DeadlineExceeded},
+	}
+
+	for _, spec := range specs {
+		openPublisherChannel = func(*Amqp) (publisherChannel, error) { return spec.publisher, nil }
+
+		ctx, cancel := context.WithTimeout(context.Background(), spec.timeout)
+		err := Adapter.Publish(ctx, "events", "user.created", true, amqpDriver.Publishing{Body: []byte("hello")})
+		cancel()
+
+		if !errors.Is(err, spec.expErr) {
+			t.Fatalf("[%s] Expected Publish to return %v; got %v", spec.descr, spec.expErr, err)
+		}
+		if !spec.publisher.confirmMode {
+			t.Fatalf("[%s] Expected the channel to be put in confirm mode", spec.descr)
+		}
+		if len(spec.publisher.published) != 1 || spec.publisher.published[0] != "events/user.created:hello" {
+			t.Fatalf("[%s] Expected a single message to be published; got %v", spec.descr, spec.publisher.published)
+		}
+		if !spec.publisher.closed {
+			t.Fatalf("[%s] Expected the publisher channel to be closed", spec.descr)
+		}
+	}
+}
+
+func TestPublishChannelError(t *testing.T) {
+	defer func(open func(*Amqp) (publisherChannel, error)) { openPublisherChannel = open }(openPublisherChannel)
+
+	openErr := errors.New("channel limit reached")
+	openPublisherChannel = func(*Amqp) (publisherChannel, error) { return nil, openErr }
+
+	if err := Adapter.Publish(context.Background(), "events", "key", false, amqpDriver.Publishing{}); err != openErr {
+		t.Fatalf("Expected Publish to return %v; got %v", openErr, err)
+	}
+}
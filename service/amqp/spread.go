@@ -0,0 +1,58 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
+)
+
+// Get a random delay in the range [0, max). Tests may override this to make the delay deterministic.
+var randomDelay = func(max time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// SpreadFirstAttempt returns a ServiceOption that delays the first connection attempt
+// of each Dial by a random amount in the range [0, initialJitter). When a shared
+// broker restarts, this staggers the reconnects of clients that would otherwise
+// redial in lockstep. Passing 0 removes the delay.
+func SpreadFirstAttempt(initialJitter time.Duration) adapters.ServiceOption {
+	return func(s adapters.Service) error {
+		srv, ok := s.(*Amqp)
+		if !ok {
+			return errors.New("service does not support spreading the first dial attempt")
+		}
+		if initialJitter < 0 {
+			return errors.New("SpreadFirstAttempt requires a non-negative jitter")
+		}
+
+		srv.Lock()
+		defer srv.Unlock()
+
+		srv.spreadFirstAttempt = initialJitter
+		return nil
+	}
+}
+
+// Wait for a random delay before the first connection attempt if SpreadFirstAttempt
+// is set. If ctx is cancelled while waiting, ctx.Err() is returned. This method is not
+// thread-safe so it should be invoked while holding the service lock.
+func (s *Amqp) spreadFirstAttemptDelay(ctx context.Context, logger *log.Logger) error {
+	if s.spreadFirstAttempt <= 0 {
+		return nil
+	}
+
+	delay := randomDelay(s.spreadFirstAttempt)
+	logger.Printf("%s Delaying first connection attempt by %v\n", s.logPrefix(), delay)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clock.Default.After(delay):
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
+)
+
+func TestSpreadFirstAttempt(t *testing.T) {
+	broker := newFakeBroker(t)
+	fake := clock.UseFake(t)
+
+	srv := newTestAdapter(broker.endpoint())
+	jitter := 5 * time.Second
+	if err := srv.SetOptions(SpreadFirstAttempt(jitter)); err != nil {
+		t.Fatalf("Expected SetOptions to succeed; got %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := srv.Dial(); err != nil {
+			t.Fatalf("[dial %d] Expected Dial to succeed; got %v", i, err)
+		}
+		srv.Close()
+	}
+
+	// Each dial waits once before its first attempt
+	sleeps := fake.Sleeps()
+	if len(sleeps) != 10 {
+		t.Fatalf("Expected one delay per dial; got %v", sleeps)
+	}
+	distinct := make(map[time.Duration]bool)
+	for index, delay := range sleeps {
+		if delay < 0 || delay >= jitter {
+			t.Fatalf("[dial %d] Expected the delay to be in [0, %v); got %v", index, jitter, delay)
+		}
+		distinct[delay] = true
+	}
+	if len(distinct) < 2 {
+		t.Fatalf("Expected the delays to be randomized; got %v", sleeps)
+	}
+}
+
+func TestSpreadFirstAttemptCancel(t *testing.T) {
+	defer func(fn func(time.Duration) time.Duration) { randomDelay = fn }(randomDelay)
+	randomDelay = func(max time.Duration) time.Duration { return max - 1 }
+
+	srv := newTestAdapter(newFakeBroker(t).endpoint())
+	if err := srv.SetOptions(SpreadFirstAttempt(time.Hour)); err != nil {
+		t.Fatalf("Expected SetOptions to succeed; got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := srv.DialContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected DialContext to be cancelled while waiting; got %v", err)
+	}
+	if srv.IsConnected() {
+		t.Fatalf("Expected the service not to be connected")
+	}
+}
+
+func TestSpreadFirstAttemptValidation(t *testing.T) {
+	srv := newTestAdapter("")
+	if err := srv.SetOptions(SpreadFirstAttempt(-time.Second)); err == nil {
+		t.Fatalf("Expected SpreadFirstAttempt to reject a negative jitter")
+	}
+
+	if err := SpreadFirstAttempt(time.Second)(&adapters.Breaker{}); err == nil {
+		t.Fatalf("Expected SpreadFirstAttempt to reject services other than amqp")
+	}
+}
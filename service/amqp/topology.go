@@ -0,0 +1,165 @@
+package amqp
+
+import (
+	"fmt"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	amqpDriver "github.com/streadway/amqp"
+)
+
+// An exchange declaration.
+type ExchangeSpec struct {
+	Name       string
+	Kind       string
+	Durable    bool
+	AutoDelete bool
+	Internal   bool
+	Args       amqpDriver.Table
+}
+
+// A queue declaration.
+type QueueSpec struct {
+	Name       string
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+	Args       amqpDriver.Table
+}
+
+// A binding between a queue and an exchange.
+type BindingSpec struct {
+	Queue    string
+	Exchange string
+	Key      string
+	Args     amqpDriver.Table
+}
+
+// A binding that routes messages published to the Source exchange to the
+// Destination exchange.
+type ExchangeBindingSpec struct {
+	Destination string
+	Source      string
+	Key         string
+	Args        amqpDriver.Table
+}
+
+// A set of exchanges, queues and bindings. Exchanges are declared first, followed
+// by queues, queue bindings and finally exchange-to-exchange bindings.
+type Topology struct {
+	Exchanges        []ExchangeSpec
+	Queues           []QueueSpec
+	Bindings         []BindingSpec
+	ExchangeBindings []ExchangeBindingSpec
+}
+
+// The subset of the amqp channel API used for declaring a topology.
+type declarer interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqpDriver.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqpDriver.Table) (amqpDriver.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqpDriver.Table) error
+	ExchangeBind(destination, key, source string, noWait bool, args amqpDriver.Table) error
+	Close() error
+}
+
+// Open a channel for declaring a topology. Tests may override this to record declarations.
+var openDeclarer = func(conn *amqpDriver.Connection) (declarer, error) {
+	return conn.Channel()
+}
+
+// Declare the entities of t on a new channel. Declarations are idempotent so the
+// topology is recorded and automatically re-declared whenever the service connects.
+func (s *Amqp) DeclareTopology(t Topology) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected || s.draining {
+		return adapters.ErrConnectionClosed
+	}
+
+	if err := s.declare(t); err != nil {
+		return err
+	}
+
+	s.topologies = append(s.topologies, t)
+	return nil
+}
+
+// Declare t on a new channel. This method is not thread-safe so it should be
+// invoked while holding the service lock.
+func (s *Amqp) declare(t Topology) error {
+	ch, err := openDeclarer(s.conn)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	return t.apply(ch)
+}
+
+// Declare the topology entities using ch.
+func (t Topology) apply(ch declarer) error {
+	for _, ex := range t.Exchanges {
+		if err := ch.ExchangeDeclare(ex.Name, ex.Kind, ex.Durable, ex.AutoDelete, ex.Internal, false, ex.Args); err != nil {
+			return fmt.Errorf("amqp: could not declare exchange '%s': %w", ex.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		if _, err := ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.Args); err != nil {
+			return fmt.Errorf("amqp: could not declare queue '%s': %w", q.Name, err)
+		}
+	}
+	for _, b := range t.Bindings {
+		if err := ch.QueueBind(b.Queue, b.Key, b.Exchange, false, b.Args); err != nil {
+			return fmt.Errorf("amqp: could not bind queue '%s' to exchange '%s': %w", b.Queue, b.Exchange, err)
+		}
+	}
+	for _, b := range orderExchangeBindings(t.ExchangeBindings) {
+		if err := ch.ExchangeBind(b.Destination, b.Key, b.Source, false, b.Args); err != nil {
+			return fmt.Errorf("amqp: could not bind exchange '%s' to exchange '%s': %w", b.Destination, b.Source, err)
+		}
+	}
+	return nil
+}
+
+// Order exchange bindings so that the bindings routing messages out of an exchange
+// are created before the exchange is bound to its own sources. Messages routed to an
+// intermediate exchange then always find its onward bindings in place. Bindings that
+// form a cycle are kept in their declared order.
+func orderExchangeBindings(bindings []ExchangeBindingSpec) []ExchangeBindingSpec {
+	ordered := make([]ExchangeBindingSpec, 0, len(bindings))
+	done := make([]bool, len(bindings))
+
+	// Check whether any pending binding routes messages out of the destination of b
+	hasPendingOnward := func(b ExchangeBindingSpec, index int) bool {
+		for other, onward := range bindings {
+			if other != index && !done[other] && onward.Source == b.Destination {
+				return true
+			}
+		}
+		return false
+	}
+
+	for len(ordered) < len(bindings) {
+		next := -1
+		for index, b := range bindings {
+			if !done[index] && !hasPendingOnward(b, index) {
+				next = index
+				break
+			}
+		}
+
+		// The pending bindings form a cycle; fall back to the declared order
+		if next == -1 {
+			for index := range bindings {
+				if !done[index] {
+					next = index
+					break
+				}
+			}
+		}
+
+		done[next] = true
+		ordered = append(ordered, bindings[next])
+	}
+	return ordered
+}
@@ -0,0 +1,258 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	amqpDriver "github.com/streadway/amqp"
+)
+
+// A declarer that records declarations instead of sending them to the broker.
+type fakeDeclarer struct {
+	sync.Mutex
+
+	calls  []string
+	closed int
+
+	// If set, queue declarations fail with this error.
+	queueErr error
+}
+
+func (d *fakeDeclarer) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqpDriver.Table) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.calls = append(d.calls, fmt.Sprintf("exchange %s kind=%s durable=%t autoDelete=%t internal=%t args=%v", name, kind, durable, autoDelete, internal, args))
+	return nil
+}
+
+func (d *fakeDeclarer) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqpDriver.Table) (amqpDriver.Queue, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.queueErr != nil {
+		return amqpDriver.Queue{}, d.queueErr
+	}
+	d.calls = append(d.calls, fmt.Sprintf("queue %s durable=%t autoDelete=%t exclusive=%t args=%v", name, durable, autoDelete, exclusive, args))
+	return amqpDriver.Queue{Name: name}, nil
+}
+
+func (d *fakeDeclarer) QueueBind(name, key, exchange string, noWait bool, args amqpDriver.Table) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.calls = append(d.calls, fmt.Sprintf("bind %s to %s key=%s args=%v", name, exchange, key, args))
+	return nil
+}
+
+func (d *fakeDeclarer) ExchangeBind(destination, key, source string, noWait bool, args amqpDriver.Table) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.calls = append(d.calls, fmt.Sprintf("bind exchange %s to %s key=%s args=%v", destination, source, key, args))
+	return nil
+}
+
+func (d *fakeDeclarer) Close() error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.closed++
+	return nil
+}
+
+func (d *fakeDeclarer) recorded() ([]string, int) {
+	d.Lock()
+	defer d.Unlock()
+
+	return append([]string{}, d.calls...), d.closed
+}
+
+// Route topology declarations to d for the duration of a test.
+func useFakeDeclarer(t *testing.T, d *fakeDeclarer) {
+	orig := openDeclarer
+	openDeclarer = func(*amqpDriver.Connection) (declarer, error) { return d, nil }
+	t.Cleanup(func() { openDeclarer = orig })
+}
+
+var testTopology = Topology{
+	Exchanges: []ExchangeSpec{
+		{Name: "events", Kind: "topic", Durable: true},
+	},
+	Queues: []QueueSpec{
+		{Name: "audit", Durable: true, Args: amqpDriver.Table{"x-max-length": int32(100)}},
+		{Name: "scratch", AutoDelete: true, Exclusive: true},
+	},
+	Bindings: []BindingSpec{
+		{Queue: "audit", Exchange: "events", Key: "user.*"},
+	},
+}
+
+var expTopologyCalls = []string{
+	"exchange events kind=topic durable=true autoDelete=false internal=false args=map[]",
+	"queue audit durable=true autoDelete=false exclusive=false args=map[x-max-length:100]",
+	"queue scratch durable=false autoDelete=true exclusive=true args=map[]",
+	"bind audit to events key=user.* args=map[]",
+}
+
+func TestDeclareTopology(t *testing.T) {
+	d := &fakeDeclarer{}
+	useFakeDeclarer(t, d)
+
+	broker := newFakeBroker(t)
+	srv := newTestAdapter(broker.endpoint())
+	if err := srv.DeclareTopology(testTopology); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected DeclareTopology to fail with ErrConnectionClosed while disconnected; got %v", err)
+	}
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	if err := srv.DeclareTopology(testTopology); err != nil {
+		t.Fatalf("Expected DeclareTopology to succeed; got %v", err)
+	}
+	calls, closed := d.recorded()
+	if !reflect.DeepEqual(calls, expTopologyCalls) {
+		t.Fatalf("Expected declarations:\n%s\ngot:\n%s", strings.Join(expTopologyCalls, "\n"), strings.Join(calls, "\n"))
+	}
+	if closed != 1 {
+		t.Fatalf("Expected the declaration channel to be closed; got %d close call(s)", closed)
+	}
+
+	// The topology should be re-declared after reconnecting
+	srv.Close()
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	calls, _ = d.recorded()
+	expCalls := append(append([]string{}, expTopologyCalls...), expTopologyCalls...)
+	if !reflect.DeepEqual(calls, expCalls) {
+		t.Fatalf("Expected declarations after reconnecting:\n%s\ngot:\n%s", strings.Join(expCalls, "\n"), strings.Join(calls, "\n"))
+	}
+}
+
+func TestDeclareTopologyFailure(t *testing.T) {
+	d := &fakeDeclarer{}
+	useFakeDeclarer(t, d)
+
+	broker := newFakeBroker(t)
+	srv := newTestAdapter(broker.endpoint())
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	queueErr := errors.New("access refused")
+	d.Lock()
+	d.queueErr = queueErr
+	d.Unlock()
+
+	err := srv.DeclareTopology(testTopology)
+	if !errors.Is(err, queueErr) || !strings.Contains(err.Error(), "queue 'audit'") {
+		t.Fatalf("Expected DeclareTopology to report the failed declaration; got %v", err)
+	}
+
+	// Topologies that failed to declare should not be re-declared
+	d.Lock()
+	d.queueErr = nil
+	d.calls = nil
+	d.Unlock()
+	srv.Close()
+	if err = srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+	if calls, _ := d.recorded(); len(calls) != 0 {
+		t.Fatalf("Expected no declarations after reconnecting; got %v", calls)
+	}
+}
+
+func TestDialFailsIfTopologyCannotBeRedeclared(t *testing.T) {
+	d := &fakeDeclarer{}
+	useFakeDeclarer(t, d)
+
+	broker := newFakeBroker(t)
+	srv := newTestAdapter(broker.endpoint())
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	if err := srv.DeclareTopology(testTopology); err != nil {
+		t.Fatalf("Expected DeclareTopology to succeed; got %v", err)
+	}
+	srv.Close()
+
+	queueErr := errors.New("access refused")
+	d.Lock()
+	d.queueErr = queueErr
+	d.Unlock()
+
+	if err := srv.Dial(); !errors.Is(err, queueErr) {
+		t.Fatalf("Expected Dial to fail with the declaration error; got %v", err)
+	}
+	if _, err := srv.NewChannel(); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected the service to remain disconnected; got %v", err)
+	}
+}
+
+func TestDeclareTopologyExchangeBindings(t *testing.T) {
+	d := &fakeDeclarer{}
+	useFakeDeclarer(t, d)
+
+	broker := newFakeBroker(t)
+	srv := newTestAdapter(broker.endpoint())
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	// ingress -> events -> {audit, archive}, listed upstream first
+	topology := Topology{
+		ExchangeBindings: []ExchangeBindingSpec{
+			{Destination: "events", Source: "ingress", Key: "#"},
+			{Destination: "audit", Source: "events", Key: "user.*"},
+			{Destination: "archive", Source: "events", Key: "#", Args: amqpDriver.Table{"x-match": "any"}},
+		},
+		Exchanges: []ExchangeSpec{
+			{Name: "ingress", Kind: "topic", Durable: true},
+			{Name: "events", Kind: "topic", Durable: true},
+			{Name: "audit", Kind: "topic", Durable: true},
+			{Name: "archive", Kind: "headers", Durable: true},
+		},
+	}
+	if err := srv.DeclareTopology(topology); err != nil {
+		t.Fatalf("Expected DeclareTopology to succeed; got %v", err)
+	}
+
+	expCalls := []string{
+		"exchange ingress kind=topic durable=true autoDelete=false internal=false args=map[]",
+		"exchange events kind=topic durable=true autoDelete=false internal=false args=map[]",
+		"exchange audit kind=topic durable=true autoDelete=false internal=false args=map[]",
+		"exchange archive kind=headers durable=true autoDelete=false internal=false args=map[]",
+		"bind exchange audit to events key=user.* args=map[]",
+		"bind exchange archive to events key=# args=map[x-match:any]",
+		"bind exchange events to ingress key=# args=map[]",
+	}
+	if calls, _ := d.recorded(); !reflect.DeepEqual(calls, expCalls) {
+		t.Fatalf("Expected declarations:\n%s\ngot:\n%s", strings.Join(expCalls, "\n"), strings.Join(calls, "\n"))
+	}
+}
+
+func TestOrderExchangeBindingsWithCycle(t *testing.T) {
+	bindings := []ExchangeBindingSpec{
+		{Destination: "b", Source: "a"},
+		{Destination: "a", Source: "b"},
+		{Destination: "c", Source: "b"},
+	}
+
+	// b -> c has no onward bindings; the a <-> b cycle keeps its declared order
+	exp := []ExchangeBindingSpec{bindings[2], bindings[0], bindings[1]}
+	if ordered := orderExchangeBindings(bindings); !reflect.DeepEqual(ordered, exp) {
+		t.Fatalf("Expected bindings to be ordered as %v; got %v", exp, ordered)
+	}
+}
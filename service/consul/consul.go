@@ -0,0 +1,455 @@
+package consul
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+var (
+	// The max time a blocking KV query may wait for a change.
+	watchWaitTime = 5 * time.Minute
+
+	// The time to wait before retrying a failed blocking KV query.
+	watchRetryInterval = time.Second
+)
+
+// The service name reported to the metrics sink.
+const serviceName = "consul"
+
+// Adapter is a singleton instance of a consul service
+var Adapter *Consul = &Consul{
+	address:       "127.0.0.1:8500",
+	client:        newClient("127.0.0.1:8500"),
+	logger:        log.New(ioutil.Discard, "", log.LstdFlags),
+	dialPolicy:    dial.ExpBackoff(10, time.Millisecond),
+	metrics:       adapters.NopMetrics,
+	tracer:        adapters.NopTracer,
+	closeNotifier: adapters.NewNotifier(),
+}
+
+// The subset of the consul client API used by the adapter.
+type consulClient interface {
+	Leader() (string, error)
+	KVGet(key string, q *consulApi.QueryOptions) (*consulApi.KVPair, *consulApi.QueryMeta, error)
+}
+
+// Adapts a consul api client to the consulClient interface.
+type apiClient struct {
+	*consulApi.Client
+}
+
+// Get the address of the current raft leader.
+func (c *apiClient) Leader() (string, error) {
+	return c.Status().Leader()
+}
+
+// Lookup a single KV pair.
+func (c *apiClient) KVGet(key string, q *consulApi.QueryOptions) (*consulApi.KVPair, *consulApi.QueryMeta, error) {
+	return c.KV().Get(key, q)
+}
+
+// Create a client for the consul agent at address. The client does not open any
+// connections until it is used.
+func newClient(address string) consulClient {
+	config := consulApi.DefaultConfig()
+	config.Address = address
+
+	// NewClient only fails if the config specifies invalid TLS settings
+	client, _ := consulApi.NewClient(config)
+	return &apiClient{client}
+}
+
+type Consul struct {
+	// The consul agent address to connect to
+	address string
+
+	// The consul client instance
+	client consulClient
+
+	// A logger for service events.
+	logger *log.Logger
+
+	// An optional logger for tagging log lines with the fields of a context.
+	contextLogger adapters.ContextLogger
+
+	// A notifier for close events.
+	closeNotifier *adapters.Notifier
+
+	// Connection status.
+	connected bool
+
+	// The error that caused the most recent dial to fail; nil if it succeeded.
+	lastErr error
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
+	// The dial policy to use.
+	dialPolicy dial.Policy
+
+	// A sink for dial and connection metrics.
+	metrics adapters.Metrics
+
+	// A tracer for creating spans around Dial and Config.
+	tracer adapters.Tracer
+
+	// An optional callback invoked when a dial gives up; see OnDialFailure.
+	onDialFailure func(attempts uint32, lastErr error)
+
+	// A mutex protecting the client
+	sync.Mutex
+}
+
+// Connect to the service. If a dial policy has been specified,
+// the service will keep trying to reconnect until a connection
+// is established or the dial policy aborts the reconnection attempt.
+func (s *Consul) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context. If ctx is cancelled while
+// waiting for the next dial attempt, the dial is aborted and ctx.Err() is returned.
+func (s *Consul) DialContext(ctx context.Context) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// We are already connected
+	if s.connected {
+		return adapters.ErrAlreadyConnected
+	}
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Dial")
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+	span.SetAttribute("endpoint", s.address)
+	attempts := 0
+	defer func() {
+		span.SetAttribute("attempts", attempts)
+		span.End(err)
+		s.lastErr = err
+	}()
+
+	defer dial.Release(s.dialPolicy)
+	s.dialPolicy.ResetAttempts()
+	if _, err = dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		logger.Printf("[CONSUL] Dial cancelled: %v\n", err)
+		return err
+	}
+	logger.Printf("[CONSUL] Connecting to agent %s\n", s.address)
+	start := time.Now()
+	for {
+		s.metrics.IncDialAttempt(serviceName)
+		attempts++
+		_, err = s.client.Leader()
+		dial.RecordOutcome(s.dialPolicy, err == nil)
+		if err == nil {
+			break
+		}
+
+		s.metrics.IncDialFailure(serviceName, err)
+		dialErr := err
+		err = dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			logger.Printf("[CONSUL] Could not connect to agent %s; retrying in %v\n", s.address, wait)
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			logger.Printf("[CONSUL] Could not connect to agent %s after %d attempt(s)\n", s.address, s.dialPolicy.CurAttempt())
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			if s.onDialFailure != nil {
+				go s.onDialFailure(uint32(attempts), dialErr)
+			}
+			return dial.ErrTimeout
+		case err != nil:
+			logger.Printf("[CONSUL] Dial cancelled: %v\n", err)
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			return err
+		}
+	}
+
+	s.connected = true
+	s.metrics.IncDialSuccess(serviceName)
+	s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+	s.metrics.SetConnected(serviceName, true)
+	s.dialPolicy.ResetAttempts()
+	logger.Printf("[CONSUL] Connected to agent %s\n", s.address)
+
+	return nil
+}
+
+// Disconnect.
+func (s *Consul) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil
+	}
+
+	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+	return nil
+}
+
+// Disconnect. The adapter does not hand out any connections so there is nothing to wait
+// for; this is equivalent to Close.
+func (s *Consul) CloseContext(ctx context.Context) error {
+	return s.Close()
+}
+
+// Register a listener for receiving close notifications. The service adapter will emit an error and
+// close the channel if the service is cleanly shut down or close the channel if the connection is reset.
+func (s *Consul) NotifyClose(c adapters.CloseListener) {
+	s.closeNotifier.Add(c)
+}
+
+// Register a callback that is invoked when a dial gives up because its dial policy
+// is exhausted. The callback receives the number of attempts and the error of the
+// last attempt. It fires once per failed dial rather than once per attempt and runs
+// on its own goroutine so it may call back into the service. Passing nil removes it.
+func (s *Consul) OnDialFailure(fn func(attempts uint32, lastErr error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onDialFailure = fn
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *Consul) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Log a warning when more than max close listeners are registered, which usually
+// indicates a listener leak; 0 disables the check. See adapters.MaxCloseListeners.
+func (s *Consul) SetMaxCloseListeners(max int) {
+	s.closeNotifier.SetMaxListeners(max, func(count int) {
+		s.logger.Printf("[CONSUL] Possible close listener leak: %d listeners registered (max %d)\n", count, max)
+	})
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *Consul) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Report whether the service is connected.
+func (s *Consul) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Get the error that caused the most recent dial to fail; nil if the most recent
+// dial succeeded or no dial has been attempted yet.
+func (s *Consul) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Apply a list of options to the service.
+func (s *Consul) SetOptions(opts ...adapters.ServiceOption) error {
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register a logger instance for service events.
+func (s *Consul) SetLogger(logger *log.Logger) {
+	s.logger = logger
+}
+
+// Get the logger registered via SetLogger. Implements the adapters.LoggerGetter interface.
+func (s *Consul) Logger() *log.Logger {
+	return s.logger
+}
+
+// Attach a logger for tagging DialContext and ConfigContext log lines with the fields
+// of the supplied context. Passing nil restores plain logging.
+func (s *Consul) SetContextLogger(logger adapters.ContextLogger) {
+	s.contextLogger = logger
+}
+
+// Set a dial policy for this service.
+func (s *Consul) SetDialPolicy(policy dial.Policy) {
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Get the dial policy used by this service, e.g. for logging its settings via
+// dial.Describe or inspecting CurAttempt while a dial is in progress. The policy
+// is the copy stored by SetDialPolicy so it must not be passed to other services.
+func (s *Consul) DialPolicy() dial.Policy {
+	return s.dialPolicy
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *Consul) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+	s.metrics = m
+}
+
+// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+func (s *Consul) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+	s.tracer = t
+}
+
+// Set the service configuration. Changing the configuration settings for an already connected
+// service will trigger a service shutdown. The service consumer is responsible for handing
+// service close events and triggering a re-dial.
+func (s *Consul) Config(params map[string]string) error {
+	return s.ConfigContext(context.Background(), params)
+}
+
+// Apply configuration settings using the supplied context. If a context logger is
+// attached, log lines are written to the logger obtained for ctx; see Config.
+func (s *Consul) ConfigContext(ctx context.Context, params map[string]string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Config")
+	defer func() { span.End(err) }()
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+
+	s.configChanged = false
+
+	needsReset := false
+
+	address, exists := params["address"]
+	if exists && address != s.address {
+		s.address = address
+		needsReset = true
+	}
+
+	s.configChanged = needsReset
+	span.SetAttribute("reset", needsReset)
+	if needsReset {
+		logger.Printf("[CONSUL] Configuration changed; new settings: address=%s\n", s.address)
+		s.client = newClient(s.address)
+		if s.connected {
+			s.closeNotifier.NotifyAll(nil)
+			s.connected = false
+			s.metrics.SetConnected(serviceName, false)
+		}
+	}
+
+	return nil
+}
+
+// Report whether the most recent Config call modified any setting and triggered a service reset.
+func (s *Consul) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
+
+// Lookup a KV pair using the current client.
+func (s *Consul) kvGet(key string, q *consulApi.QueryOptions) (*consulApi.KVPair, *consulApi.QueryMeta, error) {
+	s.Lock()
+	client := s.client
+	s.Unlock()
+
+	return client.KVGet(key, q)
+}
+
+// Configuration middleware for service adaptors. It returns a ServiceOption that
+// monitors a consul KV key using blocking queries and triggers a service reconfiguration
+// when it changes. The monitor is stopped when the service is shut down.
+func ConsulConf(key string) adapters.ServiceOption {
+	return func(s adapters.Service) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		shutdownChan := adapters.NotifyShutdown(s)
+
+		// Fetch initial settings
+		var lastIndex uint64
+		pair, meta, err := Adapter.kvGet(key, nil)
+		if err != nil {
+			Adapter.logger.Printf("[CONSUL] Error retrieving current settings for key '%s': %v\n", key, err)
+		} else {
+			lastIndex = meta.LastIndex
+			if pair != nil {
+				applyVal(s, pair.Value)
+			}
+		}
+
+		// Abort any pending blocking query when the service shuts down
+		go func() {
+			select {
+			case <-shutdownChan:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// Wait for a key change
+		go func() {
+			defer cancel()
+
+			for {
+				q := &consulApi.QueryOptions{WaitIndex: lastIndex, WaitTime: watchWaitTime}
+				pair, meta, err := Adapter.kvGet(key, q.WithContext(ctx))
+				if ctx.Err() != nil {
+					return
+				}
+
+				if err != nil {
+					Adapter.logger.Printf("[CONSUL] Error watching key '%s': %v\n", key, err)
+					select {
+					case <-time.After(watchRetryInterval):
+						continue
+					case <-ctx.Done():
+						return
+					}
+				}
+
+				// The query timed out without any changes
+				if meta.LastIndex == lastIndex {
+					continue
+				}
+
+				// Reset the index if it goes backwards (e.g. after a snapshot restore)
+				if meta.LastIndex < lastIndex {
+					lastIndex = 0
+					continue
+				}
+
+				lastIndex = meta.LastIndex
+				if pair != nil {
+					applyVal(s, pair.Value)
+				}
+			}
+		}()
+
+		return nil
+	}
+}
+
+// Parse a KV value and apply it to service s. Values that cannot be parsed are logged
+// and ignored.
+func applyVal(s adapters.Service, value []byte) {
+	params, err := adapters.ParseConfigValue(string(value))
+	if err != nil {
+		Adapter.logger.Printf("[CONSUL] Error parsing settings: %v\n", err)
+		return
+	}
+
+	s.Config(params)
+}
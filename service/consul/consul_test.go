@@ -0,0 +1,355 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/adaptertest"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// A fake consul client. Leader calls fail leaderFailures times before succeeding.
+// Blocking KV queries wait until a new pair is pushed to the updates channel.
+type fakeClient struct {
+	leaderFailures int
+	leaderCalls    int
+
+	pair    *consulApi.KVPair
+	updates chan *consulApi.KVPair
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		updates: make(chan *consulApi.KVPair),
+	}
+}
+
+func (c *fakeClient) Leader() (string, error) {
+	c.leaderCalls++
+	if c.leaderCalls <= c.leaderFailures {
+		return "", errors.New("agent unreachable")
+	}
+	return "127.0.0.1:8300", nil
+}
+
+func (c *fakeClient) KVGet(key string, q *consulApi.QueryOptions) (*consulApi.KVPair, *consulApi.QueryMeta, error) {
+	if q == nil || q.WaitIndex == 0 {
+		return c.pair, &consulApi.QueryMeta{LastIndex: c.pair.ModifyIndex}, nil
+	}
+
+	select {
+	case pair := <-c.updates:
+		return pair, &consulApi.QueryMeta{LastIndex: pair.ModifyIndex}, nil
+	case <-q.Context().Done():
+		return nil, nil, q.Context().Err()
+	}
+}
+
+// Wait for the next Config call of srv and get its params.
+func nextConfig(t *testing.T, srv *adaptertest.FakeService) map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	params, err := srv.NextConfig(ctx)
+	if err != nil {
+		t.Fatalf("Timed out waiting for a Config call")
+	}
+	return params
+}
+
+// Ensure that no further Config calls are made to srv.
+func expectNoConfig(t *testing.T, srv *adaptertest.FakeService) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if params, err := srv.NextConfig(ctx); err == nil {
+		t.Fatalf("Expected no further Config calls; got %v", params)
+	}
+}
+
+// Shut down srv. The fake service only notifies its close listeners while connected
+// and Config calls that modify its settings reset the connection, so it is dialed first.
+func shutdown(srv *adaptertest.FakeService) {
+	srv.Dial()
+	srv.Close()
+}
+
+// Swap the adapter client with a fake for the duration of a test.
+func useFakeClient(t *testing.T) *fakeClient {
+	client := newFakeClient()
+	Adapter.Lock()
+	origClient := Adapter.client
+	Adapter.client = client
+	Adapter.Unlock()
+	t.Cleanup(func() {
+		Adapter.Lock()
+		Adapter.client = origClient
+		Adapter.Unlock()
+	})
+	return client
+}
+
+func TestDialRetries(t *testing.T) {
+	client := useFakeClient(t)
+	client.leaderFailures = 2
+
+	srv := &Consul{
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(5, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	err := srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	if client.leaderCalls != 3 {
+		t.Fatalf("Expected 3 Leader calls; got %d", client.leaderCalls)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	srv.Close()
+	if err := <-listener; err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected to receive ErrConnectionClosed; got %v", err)
+	}
+}
+
+// A fake metrics sink that counts received events.
+type fakeMetrics struct {
+	sync.Mutex
+	counts    map[string]int
+	connected bool
+}
+
+func newFakeMetrics() *fakeMetrics {
+	return &fakeMetrics{counts: make(map[string]int)}
+}
+
+func (m *fakeMetrics) inc(service, event string) {
+	m.Lock()
+	defer m.Unlock()
+	m.counts[service+"/"+event]++
+}
+
+func (m *fakeMetrics) IncDialAttempt(service string) { m.inc(service, "attempt") }
+func (m *fakeMetrics) IncDialFailure(service string, err error) {
+	m.inc(service, "failure")
+}
+func (m *fakeMetrics) IncDialSuccess(service string) { m.inc(service, "success") }
+func (m *fakeMetrics) ObserveDialDuration(service string, d time.Duration) {
+	m.inc(service, "duration")
+}
+func (m *fakeMetrics) SetConnected(service string, connected bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.connected = connected
+}
+
+func TestDialMetrics(t *testing.T) {
+	client := useFakeClient(t)
+	client.leaderFailures = 2
+
+	metrics := newFakeMetrics()
+	srv := &Consul{
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(5, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+	srv.SetOptions(adapters.WithMetrics(metrics))
+
+	err := srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	expCounts := map[string]int{
+		"consul/attempt":  3,
+		"consul/failure":  2,
+		"consul/success":  1,
+		"consul/duration": 1,
+	}
+	if !reflect.DeepEqual(metrics.counts, expCounts) {
+		t.Fatalf("Expected metric counts to be %v; got %v", expCounts, metrics.counts)
+	}
+	if !metrics.connected {
+		t.Fatalf("Expected service to be reported as connected")
+	}
+
+	srv.Close()
+	if metrics.connected {
+		t.Fatalf("Expected service to be reported as disconnected after Close")
+	}
+}
+
+// A fake tracer that records all finished spans.
+type fakeTracer struct {
+	sync.Mutex
+	spans []*fakeSpan
+}
+
+type fakeSpan struct {
+	operation  string
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+func (t *fakeTracer) StartSpan(ctx context.Context, operation string) (context.Context, adapters.Span) {
+	t.Lock()
+	defer t.Unlock()
+	span := &fakeSpan{operation: operation, attributes: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *fakeSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *fakeSpan) End(err error) {
+	s.err = err
+	s.ended = true
+}
+
+func TestDialAndConfigSpans(t *testing.T) {
+	client := useFakeClient(t)
+	client.leaderFailures = 2
+
+	tracer := &fakeTracer{}
+	srv := &Consul{
+		address:       "127.0.0.1:8500",
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(5, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+	srv.SetOptions(adapters.WithTracer(tracer))
+
+	err := srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("Expected 1 span; got %d", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.operation != "consul.Dial" {
+		t.Fatalf("Expected span operation to be consul.Dial; got %s", span.operation)
+	}
+	if !span.ended || span.err != nil {
+		t.Fatalf("Expected span to end without an error; got ended=%t, err=%v", span.ended, span.err)
+	}
+	if span.attributes["attempts"] != 3 {
+		t.Fatalf("Expected span attempts attribute to be 3; got %v", span.attributes["attempts"])
+	}
+	if span.attributes["endpoint"] != "127.0.0.1:8500" {
+		t.Fatalf("Expected span endpoint attribute to be 127.0.0.1:8500; got %v", span.attributes["endpoint"])
+	}
+
+	err = srv.Config(map[string]string{"address": "127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	span = tracer.spans[1]
+	if span.operation != "consul.Config" || span.attributes["reset"] != true {
+		t.Fatalf("Expected a consul.Config span with reset=true; got %s with reset=%v", span.operation, span.attributes["reset"])
+	}
+
+	// Config replaced the client; swap in one that never reaches the agent
+	srv.client = &fakeClient{leaderFailures: 10}
+	err = srv.Dial()
+	if err != dial.ErrTimeout {
+		t.Fatalf("Expected Dial to fail with ErrTimeout; got %v", err)
+	}
+	span = tracer.spans[2]
+	if span.err != dial.ErrTimeout {
+		t.Fatalf("Expected span to record ErrTimeout; got %v", span.err)
+	}
+	if span.attributes["attempts"] != 5 {
+		t.Fatalf("Expected span attempts attribute to be 5; got %v", span.attributes["attempts"])
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	client := useFakeClient(t)
+	client.leaderFailures = 10
+
+	srv := &Consul{
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(2, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	err := srv.Dial()
+	if err != dial.ErrTimeout {
+		t.Fatalf("Expected Dial to fail with ErrTimeout; got %v", err)
+	}
+}
+
+func TestDialContextCancel(t *testing.T) {
+	client := useFakeClient(t)
+	client.leaderFailures = 1000
+
+	srv := &Consul{
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(1000, 50*time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := srv.DialContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("Expected DialContext to fail with context.Canceled; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected DialContext to return promptly after cancellation; took %v", elapsed)
+	}
+}
+
+func TestConsulConf(t *testing.T) {
+	client := useFakeClient(t)
+	client.pair = &consulApi.KVPair{Key: "config/redis", Value: []byte("db=1"), ModifyIndex: 10}
+
+	srv := adaptertest.New()
+	err := ConsulConf("config/redis")(srv)
+	if err != nil {
+		t.Fatalf("Error applying ConsulConf option: %v", err)
+	}
+
+	if params := nextConfig(t, srv); params["db"] != "1" {
+		t.Fatalf("Expected initial config to contain db=1; got %v", params)
+	}
+
+	client.updates <- &consulApi.KVPair{Key: "config/redis", Value: []byte(`{"db":"2"}`), ModifyIndex: 11}
+	if params := nextConfig(t, srv); params["db"] != "2" {
+		t.Fatalf("Expected updated config to contain db=2; got %v", params)
+	}
+
+	// A query that times out without changes should not trigger a reconfiguration
+	client.updates <- &consulApi.KVPair{Key: "config/redis", Value: []byte("db=3"), ModifyIndex: 11}
+
+	shutdown(srv)
+	expectNoConfig(t, srv)
+}
@@ -1,9 +1,11 @@
 package etcd
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"regexp"
+	"sort"
 	"strings"
 
 	"errors"
@@ -12,12 +14,15 @@ import (
 
 	"github.com/achilleasa/usrv-service-adapters"
 	"github.com/achilleasa/usrv-service-adapters/dial"
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
 	etcdPkg "github.com/coreos/go-etcd/etcd"
 )
 
-var (
-	etcdValRe = regexp.MustCompile("(\\S+)=(\\S+)")
-)
+// The service name reported to the metrics sink.
+const serviceName = "etcd"
+
+// The error reported to the metrics sink when none of the cluster hosts can be reached.
+var errNoReachableHost = errors.New("Could not connect to any host in the cluster")
 
 // Adapter is a singleton instance of a etcd service
 var Adapter *Etcd = &Etcd{
@@ -25,28 +30,71 @@ var Adapter *Etcd = &Etcd{
 	client:        etcdPkg.NewClient(nil),
 	logger:        log.New(ioutil.Discard, "", log.LstdFlags),
 	dialPolicy:    dial.ExpBackoff(10, time.Millisecond),
+	metrics:       adapters.NopMetrics,
+	tracer:        adapters.NopTracer,
 	closeNotifier: adapters.NewNotifier(),
 }
 
+// The subset of the etcd client API used by the adapter.
+type etcdClient interface {
+	SetCluster(machines []string) bool
+	SyncCluster() bool
+	Close()
+	Get(key string, sort, recursive bool) (*etcdPkg.Response, error)
+	Set(key string, value string, ttl uint64) (*etcdPkg.Response, error)
+	Delete(key string, recursive bool) (*etcdPkg.Response, error)
+	Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcdPkg.Response, stop chan bool) (*etcdPkg.Response, error)
+}
+
 type Etcd struct {
 	// The etcd hosts to connect to
 	hosts []string
 
 	// The etcd client instance
-	client *etcdPkg.Client
+	client etcdClient
+
+	// An optional name for telling instances apart in log lines and metrics.
+	name string
 
 	// A logger for service events.
 	logger *log.Logger
 
+	// An optional logger for tagging log lines with the fields of a context.
+	contextLogger adapters.ContextLogger
+
 	// A notifier for close events.
 	closeNotifier *adapters.Notifier
 
+	// A stream of connection events.
+	events adapters.EventStream
+
 	// Connection status.
 	connected bool
 
+	// The error that caused the most recent dial to fail; nil if it succeeded.
+	lastErr error
+
+	// The number of attempts made by the most recent dial.
+	dialAttempts uint32
+
+	// The time of the most recent successful dial.
+	lastConnectedAt time.Time
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
 	// The dial policy to use.
 	dialPolicy dial.Policy
 
+	// A sink for dial and connection metrics.
+	metrics adapters.Metrics
+
+	// A tracer for creating spans around Dial and Config.
+	tracer adapters.Tracer
+
+	// An optional callback invoked when a dial gives up; see OnDialFailure.
+	onDialFailure func(attempts uint32, lastErr error)
+
 	// A mutex protecting the client
 	sync.Mutex
 }
@@ -55,53 +103,160 @@ type Etcd struct {
 // the service will keep trying to reconnect until a connection
 // is established or the dial policy aborts the reconnection attempt.
 func (s *Etcd) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context. If ctx is cancelled while
+// waiting for the next dial attempt, the dial is aborted and ctx.Err() is returned.
+func (s *Etcd) DialContext(ctx context.Context) (err error) {
 	s.Lock()
 	defer s.Unlock()
 
 	// We are already connected
 	if s.connected {
-		return nil
+		return adapters.ErrAlreadyConnected
 	}
+	s.events.DialStarted()
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Dial")
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+	span.SetAttribute("endpoint", strings.Join(s.hosts, ","))
+	attempts := 0
+	defer func() {
+		span.SetAttribute("attempts", attempts)
+		span.End(err)
+		s.lastErr = err
+		s.dialAttempts = uint32(attempts)
+		if err != nil {
+			s.events.Emit(adapters.Event{Type: adapters.EventDialFailed, Attempt: attempts, Err: err})
+		}
+	}()
 
 	if len(s.hosts) == 0 {
 		return errors.New("No etcd hosts defined")
 	}
 
-	var err error
-	var wait time.Duration
+	defer dial.Release(s.dialPolicy)
 	s.dialPolicy.ResetAttempts()
-	wait, err = s.dialPolicy.NextRetry()
-	s.logger.Printf("[ETCD] Connecting to cluster hosts: %s\n", s.hosts)
+	if _, err = dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		logger.Printf("%s Dial cancelled: %v\n", s.logPrefix(), err)
+		return err
+	}
+	logger.Printf("%s Connecting to cluster hosts: %s\n", s.logPrefix(), s.hosts)
+	start := clock.Default.Now()
 	for {
-		ok := s.client.SetCluster(s.hosts)
-		if ok {
+		s.metrics.IncDialAttempt(s.Name())
+		attempts++
+		dialErr := errNoReachableHost
+		if s.client.SetCluster(s.hosts) {
+			dialErr = s.probeCluster()
+		}
+		dial.RecordOutcome(s.dialPolicy, dialErr == nil)
+		if dialErr == nil {
 			break
 		}
 
-		wait, err = s.dialPolicy.NextRetry()
-		if err != nil {
-			s.logger.Printf("[ETCD] Could not connect any host in the cluster after %d attempt(s)\n", s.dialPolicy.CurAttempt())
+		s.metrics.IncDialFailure(s.Name(), dialErr)
+		err = dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			logger.Printf("%s Could not connect to any host in the cluster: %v; retrying in %v\n", s.logPrefix(), dialErr, wait)
+			s.events.Emit(adapters.Event{Type: adapters.EventRetryScheduled, Attempt: attempts, Wait: wait, Err: dialErr})
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			logger.Printf("%s Could not connect any host in the cluster after %d attempt(s)\n", s.logPrefix(), s.dialPolicy.CurAttempt())
+			s.metrics.ObserveDialDuration(s.Name(), clock.Since(start))
+			if s.onDialFailure != nil {
+				go s.onDialFailure(uint32(attempts), dialErr)
+			}
 			return dial.ErrTimeout
+		case err != nil:
+			logger.Printf("%s Dial cancelled: %v\n", s.logPrefix(), err)
+			s.metrics.ObserveDialDuration(s.Name(), clock.Since(start))
+			return err
 		}
-		s.logger.Printf("[ETCD] Could not connect to any host in the cluster; retrying in %v\n", wait)
-		<-time.After(wait)
 	}
 
 	s.connected = true
+	s.lastConnectedAt = clock.Default.Now()
+	s.events.Emit(adapters.Event{Type: adapters.EventConnect, Attempt: attempts})
+	s.metrics.IncDialSuccess(s.Name())
+	s.metrics.ObserveDialDuration(s.Name(), clock.Since(start))
+	s.metrics.SetConnected(s.Name(), true)
 	s.dialPolicy.ResetAttempts()
-	s.logger.Printf("[ETCD] Connected to cluster\n")
+	logger.Printf("%s Connected to cluster\n", s.logPrefix())
 
 	return nil
 }
 
+// Verify that the cluster is reachable by reading the root directory. SetCluster
+// only records the cluster hosts so it succeeds even if all of them are down. Error
+// responses from etcd (e.g. a permission error) prove that a host is reachable and
+// are not treated as failures. This method is not thread-safe so it should be
+// invoked while holding the service lock.
+func (s *Etcd) probeCluster() error {
+	_, err := s.client.Get("/", false, false)
+	if _, isEtcdErr := err.(*etcdPkg.EtcdError); isEtcdErr {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNoReachableHost, err)
+	}
+	return nil
+}
+
+// Verify that the configured cluster hosts can be reached, retrying according to the
+// dial policy until it gives up or ctx is done. Config switches the client of a
+// connected service to the new hosts in place, so adapters.Reconfigure uses this to
+// detect unreachable hosts. Implements adapters.Prober.
+func (s *Etcd) Probe(ctx context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+
+	defer dial.Release(s.dialPolicy)
+	s.dialPolicy.ResetAttempts()
+	if _, err := dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		return err
+	}
+	for {
+		probeErr := errNoReachableHost
+		if s.client.SetCluster(s.hosts) {
+			probeErr = s.probeCluster()
+		}
+		if probeErr == nil {
+			return nil
+		}
+
+		err := dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			s.logger.Printf("%s Could not reach any host in the cluster: %v; retrying in %v\n", s.logPrefix(), probeErr, wait)
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			return probeErr
+		case err != nil:
+			return err
+		}
+	}
+}
+
 // Disconnect.
-func (s *Etcd) Close() {
+func (s *Etcd) Close() error {
 	s.Lock()
 	defer s.Unlock()
 
 	s.client.Close()
 	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	if s.connected {
+		s.events.Emit(adapters.Event{Type: adapters.EventDisconnect, Err: adapters.ErrConnectionClosed})
+	}
 	s.connected = false
+	s.metrics.SetConnected(s.Name(), false)
+	return nil
+}
+
+// Disconnect. The adapter does not hand out any connections so there is nothing to wait
+// for; this is equivalent to Close.
+func (s *Etcd) CloseContext(ctx context.Context) error {
+	return s.Close()
 }
 
 // Register a listener for receiving close notifications. The service adapter will emit an error and
@@ -110,6 +265,85 @@ func (s *Etcd) NotifyClose(c adapters.CloseListener) {
 	s.closeNotifier.Add(c)
 }
 
+// Register a callback that is invoked when a dial gives up because its dial policy
+// is exhausted. The callback receives the number of attempts and the error of the
+// last attempt. It fires once per failed dial rather than once per attempt and runs
+// on its own goroutine so it may call back into the service. Passing nil removes it.
+func (s *Etcd) OnDialFailure(fn func(attempts uint32, lastErr error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onDialFailure = fn
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *Etcd) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Log a warning when more than max close listeners are registered, which usually
+// indicates a listener leak; 0 disables the check. See adapters.MaxCloseListeners.
+func (s *Etcd) SetMaxCloseListeners(max int) {
+	s.closeNotifier.SetMaxListeners(max, func(count int) {
+		s.logger.Printf("%s Possible close listener leak: %d listeners registered (max %d)\n", s.logPrefix(), count, max)
+	})
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *Etcd) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Report whether the service is connected.
+func (s *Etcd) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Get the error that caused the most recent dial to fail; nil if the most recent
+// dial succeeded or no dial has been attempted yet.
+func (s *Etcd) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Get a snapshot of the connection state of the service. The endpoint is reported as a
+// comma-delimited list of the configured hosts.
+func (s *Etcd) Status() adapters.Status {
+	s.Lock()
+	defer s.Unlock()
+
+	status := adapters.Status{
+		Connected:       s.connected,
+		Endpoint:        strings.Join(s.hosts, ","),
+		DialAttempts:    s.dialAttempts,
+		LastConnectedAt: s.lastConnectedAt,
+	}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	return status
+}
+
+// Get a stream of connection events. All calls return the same channel; if the
+// channel is not drained, new events are dropped.
+func (s *Etcd) Events() <-chan adapters.Event {
+	return s.events.C()
+}
+
+// Block until the service is connected. If the most recent dial fails, its error is
+// returned instead; if ctx expires first, ctx.Err() is returned. Unlike Events, this
+// method does not consume any events.
+func (s *Etcd) WaitForConnection(ctx context.Context) error {
+	return s.events.WaitForConnection(ctx)
+}
+
 // Apply a list of options to the service.
 func (s *Etcd) SetOptions(opts ...adapters.ServiceOption) error {
 	for _, opt := range opts {
@@ -126,28 +360,106 @@ func (s *Etcd) SetLogger(logger *log.Logger) {
 	//etcdPkg.SetLogger(logger)
 }
 
+// Get the logger registered via SetLogger. Implements the adapters.LoggerGetter interface.
+func (s *Etcd) Logger() *log.Logger {
+	return s.logger
+}
+
+// Set a name for telling this instance apart from other Etcd instances managed by the
+// same process. The name is included in the prefix of log lines (e.g. "[ETCD orders]")
+// and replaces "etcd" as the service label of reported metrics. It should be set
+// before the service is dialed.
+func (s *Etcd) SetName(name string) {
+	s.name = name
+}
+
+// Get the name set via SetName or "etcd" if no name was set.
+func (s *Etcd) Name() string {
+	if s.name == "" {
+		return serviceName
+	}
+	return s.name
+}
+
+// Get the prefix of the log lines emitted by the service.
+func (s *Etcd) logPrefix() string {
+	if s.name == "" {
+		return "[ETCD]"
+	}
+	return "[ETCD " + s.name + "]"
+}
+
+// Attach a logger for tagging DialContext and ConfigContext log lines with the fields
+// of the supplied context. Passing nil restores plain logging.
+func (s *Etcd) SetContextLogger(logger adapters.ContextLogger) {
+	s.contextLogger = logger
+}
+
 // Set a dial policy for this service.
 func (s *Etcd) SetDialPolicy(policy dial.Policy) {
-	s.dialPolicy = policy
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Get the dial policy used by this service, e.g. for logging its settings via
+// dial.Describe or inspecting CurAttempt while a dial is in progress. The policy
+// is the copy stored by SetDialPolicy so it must not be passed to other services.
+func (s *Etcd) DialPolicy() dial.Policy {
+	return s.dialPolicy
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *Etcd) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+	s.metrics = m
+}
+
+// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+func (s *Etcd) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+	s.tracer = t
 }
 
 // Set the service configuration. Changing the configuration settings for an already connected
 // service will trigger a service shutdown. The service consumer is responsible for handing
 // service close events and triggering a re-dial.
 func (s *Etcd) Config(params map[string]string) error {
+	return s.ConfigContext(context.Background(), params)
+}
+
+// Apply configuration settings using the supplied context. If a context logger is
+// attached, log lines are written to the logger obtained for ctx; see Config.
+func (s *Etcd) ConfigContext(ctx context.Context, params map[string]string) (err error) {
 	s.Lock()
 	defer s.Unlock()
 
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Config")
+	defer func() { span.End(err) }()
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+
+	s.configChanged = false
+
 	needsReset := false
 
-	hosts, exists := params["hosts"]
-	if exists {
+	schema := adapters.ConfigSchema(params)
+
+	hosts := schema.String("hosts", strings.Join(s.hosts, ","))
+	if hosts != strings.Join(s.hosts, ",") {
 		needsReset = true
-		s.hosts = strings.Split(hosts, ",")
+		s.hosts = make([]string, 0)
+		if hosts != "" {
+			s.hosts = strings.Split(hosts, ",")
+		}
 	}
 
+	s.configChanged = needsReset
+	span.SetAttribute("reset", needsReset)
 	if needsReset {
-		s.logger.Printf("[ETCD] Configuration changed; new settings: hosts=%s\n", hosts)
+		logger.Printf("%s Configuration changed; new settings: hosts=%s\n", s.logPrefix(), hosts)
+		s.events.Emit(adapters.Event{Type: adapters.EventConfigChanged})
 		s.client.SetCluster(s.hosts)
 		s.client.SyncCluster()
 		s.closeNotifier.NotifyAll(nil)
@@ -156,30 +468,154 @@ func (s *Etcd) Config(params map[string]string) error {
 	return nil
 }
 
+// Report whether the most recent Config call modified any setting and triggered a service reset.
+func (s *Etcd) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
+
+// Get the configured etcd hosts as a comma-delimited list.
+func (s *Etcd) Endpoint() string {
+	s.Lock()
+	defer s.Unlock()
+
+	return strings.Join(s.hosts, ",")
+}
+
+// Set the etcd hosts from a comma-delimited list. This is equivalent to calling Config
+// with the "hosts" setting.
+func (s *Etcd) SetEndpoint(hosts string) error {
+	return s.Config(map[string]string{"hosts": hosts})
+}
+
+// Fetch an etcd key. If the request fails, it will be retried according to the
+// configured dial policy until the policy gives up or ctx is done. Missing keys are
+// reported immediately without retrying. The service lock is only held while looking
+// up the client so that retries do not block other callers of the service.
+func (s *Etcd) get(ctx context.Context, key string, sort, recursive bool) (*etcdPkg.Response, error) {
+	s.Lock()
+	client, policy, logger := s.client, dial.Clone(s.dialPolicy), s.logger
+	s.Unlock()
+
+	defer dial.Release(policy)
+	for {
+		res, err := client.Get(key, sort, recursive)
+		if err == nil || isKeyNotFound(err) {
+			return res, err
+		}
+
+		sleepErr := dial.SleepNotify(ctx, policy, func(wait time.Duration) {
+			logger.Printf("%s Could not retrieve key '%s'; retrying in %v\n", s.logPrefix(), key, wait)
+		})
+		if sleepErr == dial.ErrTimeout {
+			logger.Printf("%s Could not retrieve key '%s' after %d attempt(s)\n", s.logPrefix(), key, policy.CurAttempt())
+			return nil, err
+		} else if sleepErr != nil {
+			return nil, sleepErr
+		}
+	}
+}
+
+// Write value to an etcd key without a TTL, e.g. for seeding default settings
+// from an admin tool. Existing values are overwritten.
+func (s *Etcd) Put(key, value string) error {
+	return s.set(key, value, 0)
+}
+
+// Write params to an etcd key in a format understood by AutoConf and the other
+// configuration middleware (see adapters.FormatConfigValue).
+func (s *Etcd) PutConfig(key string, params map[string]string) error {
+	return s.Put(key, adapters.FormatConfigValue(params))
+}
+
+// Check if err is an etcd "key not found" error.
+func isKeyNotFound(err error) bool {
+	etcdErr, ok := err.(*etcdPkg.EtcdError)
+	return ok && etcdErr.ErrorCode == 100
+}
+
 // Configuration middleware for service adaptors. It returns a ServiceOption that
 // monitors an etcd path and triggers a service reconfiguration when it changes.
+// The monitor is stopped when the service is shut down.
 func AutoConf(etcdKey string) adapters.ServiceOption {
-	// Create a monitor for the path
-	monitorChan := make(chan *etcdPkg.Response)
-	go Adapter.client.Watch(etcdKey, 0, false, monitorChan, nil)
 	return func(s adapters.Service) error {
+		// Create a monitor for the path
+		monitorChan := watch(s, etcdKey, false)
+
 		// Fetch initial settings
-		cur, err := Adapter.client.Get(etcdKey, false, false)
+		cur, err := Adapter.get(context.Background(), etcdKey, false, false)
 		if err != nil {
-			Adapter.logger.Printf("[ETCD] Error retrieving current settings for key '%s': %v\n", etcdKey, err)
+			Adapter.logger.Printf("%s Error retrieving current settings for key '%s': %v\n", Adapter.logPrefix(), etcdKey, err)
 		} else if cur != nil {
-			s.Config(tokenizeVal(cur.Node.Value))
+			s.Config(parseVal(cur.Node.Value))
 		}
 
 		// Wait for a path change
 		go func() {
-			for {
-				r := <-monitorChan
-				if r == nil {
+			for r := range monitorChan {
+				if r.Node == nil {
+					continue
+				}
+
+				s.Config(parseVal(r.Node.Value))
+			}
+		}()
+
+		return nil
+	}
+}
+
+// Configuration middleware for service adaptors. It returns a ServiceOption that
+// recursively monitors an etcd directory and triggers a service reconfiguration when
+// any of its child keys change. The values of all child keys are merged into a single
+// configuration map. Settings that are no longer defined once a key is deleted are
+// reverted to their default value if the service implements adapters.ConfigDefaulter.
+// Values that cannot be parsed are logged and ignored so that the last good settings
+// of their key remain in effect. The monitor is stopped when the service is shut down.
+func AutoConfPrefix(prefix string) adapters.ServiceOption {
+	return func(s adapters.Service) error {
+		// Create a recursive monitor for the path
+		monitorChan := watch(s, prefix, true)
+
+		// Parsed values of all leaf nodes under the prefix indexed by key
+		nodeVals := make(map[string]map[string]string)
+
+		// The most recently merged settings
+		var merged map[string]string
+
+		// Fetch initial settings
+		cur, err := Adapter.get(context.Background(), prefix, true, true)
+		if err != nil {
+			Adapter.logger.Printf("%s Error retrieving current settings for prefix '%s': %v\n", Adapter.logPrefix(), prefix, err)
+		} else if cur != nil {
+			collectNodeVals(cur.Node, nodeVals)
+			merged = mergeNodeVals(nodeVals)
+			s.Config(merged)
+		}
+
+		// Wait for a change to any key under the prefix
+		go func() {
+			for r := range monitorChan {
+				if r.Node == nil {
 					continue
 				}
 
-				s.Config(tokenizeVal(r.Node.Value))
+				switch r.Action {
+				case "delete", "expire", "compareAndDelete":
+					removeNodeVals(r.Node.Key, nodeVals)
+				case "get":
+					// A snapshot of the prefix fetched after the watch was re-established
+					retainNodeVals(r.Node, nodeVals)
+					collectNodeVals(r.Node, nodeVals)
+				default:
+					collectNodeVals(r.Node, nodeVals)
+				}
+
+				next := mergeNodeVals(nodeVals)
+				s.Config(revertRemovedSettings(s, merged, next))
+				merged = next
 			}
 		}()
 
@@ -187,16 +623,348 @@ func AutoConf(etcdKey string) adapters.ServiceOption {
 	}
 }
 
-// Tokenize a received etcdValue with format k1=v1 k2=v2 into a map.
-func tokenizeVal(etcdValue string) map[string]string {
+// Configuration middleware for service adaptors. It returns a ServiceOption that
+// monitors a list of etcd keys and triggers a service reconfiguration when any of
+// them changes. The settings of all keys are merged in the order the keys are
+// specified so that later keys override settings of earlier ones (e.g. global
+// defaults followed by per-environment and per-instance overrides). Missing or
+// deleted keys contribute no settings; as with AutoConfPrefix, settings that are
+// no longer defined by any key are reverted to their default value if the service
+// implements adapters.ConfigDefaulter and values that cannot be parsed are ignored.
+// The monitors are stopped when the service is shut down.
+func AutoConfMerged(keys ...string) adapters.ServiceOption {
+	return func(s adapters.Service) error {
+		if len(keys) == 0 {
+			return errors.New("AutoConfMerged requires at least one etcd key")
+		}
+
+		// Create a monitor for each key
+		monitorChans := make([]<-chan *etcdPkg.Response, len(keys))
+		for index, key := range keys {
+			monitorChans[index] = watch(s, key, false)
+		}
+
+		// Fetch initial settings; layers holds the parsed value of each key
+		layers := make([]map[string]string, len(keys))
+		found := false
+		for index, key := range keys {
+			cur, err := Adapter.get(context.Background(), key, false, false)
+			switch {
+			case isKeyNotFound(err):
+			case err != nil:
+				Adapter.logger.Printf("%s Error retrieving current settings for key '%s': %v\n", Adapter.logPrefix(), key, err)
+			case cur != nil && cur.Node != nil:
+				if layers[index], err = parseNodeVal(cur.Node); err == nil {
+					found = true
+				}
+			}
+		}
+
+		// The most recently merged settings
+		var merged map[string]string
+		if found {
+			merged = mergeLayers(layers)
+			s.Config(merged)
+		}
+
+		// Wait for a change to any of the keys and re-merge all layers
+		var mu sync.Mutex
+		for index, monitorChan := range monitorChans {
+			go func(index int, monitorChan <-chan *etcdPkg.Response) {
+				for r := range monitorChan {
+					if r.Node == nil {
+						continue
+					}
+
+					mu.Lock()
+					switch r.Action {
+					case "delete", "expire", "compareAndDelete":
+						layers[index] = nil
+					default:
+						layer, err := parseNodeVal(r.Node)
+						if err != nil {
+							mu.Unlock()
+							continue
+						}
+						layers[index] = layer
+					}
+					next := mergeLayers(layers)
+					s.Config(revertRemovedSettings(s, merged, next))
+					merged = next
+					mu.Unlock()
+				}
+			}(index, monitorChan)
+		}
+
+		return nil
+	}
+}
+
+// Parse the value of a leaf node. Values that cannot be parsed are logged and an
+// error is returned.
+func parseNodeVal(node *etcdPkg.Node) (map[string]string, error) {
+	params, err := adapters.ParseConfigValue(node.Value)
+	if err != nil {
+		Adapter.logger.Printf("%s Ignoring unparsable value of key '%s': %v\n", Adapter.logPrefix(), node.Key, err)
+	}
+	return params, err
+}
+
+// Merge the parsed layer values into a single configuration map. Settings of later
+// layers override the settings of earlier layers.
+func mergeLayers(layers []map[string]string) map[string]string {
+	params := make(map[string]string)
+	for _, layer := range layers {
+		for setting, value := range layer {
+			params[setting] = value
+		}
+	}
+
+	return params
+}
+
+// Watch an etcd path and forward the received responses to the returned channel
+// until service s is shut down. Connection resets (e.g. due to a configuration change)
+// do not stop the watch. If the watch terminates (e.g. because the etcd cluster is
+// unreachable), it is re-established using the dial policy of the etcd Adapter and
+// resumes from the index following the last received response. Watches never give
+// up: when the policy runs out of attempts, it is reset and the backoff starts over.
+// The returned channel is closed once service s is shut down.
+func watch(s adapters.Service, key string, recursive bool) <-chan *etcdPkg.Response {
+	monitorChan := make(chan *etcdPkg.Response)
+	shutdownChan := adapters.NotifyShutdown(s)
+
+	Adapter.Lock()
+	client, policy := Adapter.client, dial.Clone(Adapter.dialPolicy)
+	Adapter.Unlock()
+
+	// Cancelled once service s is shut down so that pending backoffs are aborted
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-shutdownChan
+		cancel()
+	}()
+
+	go func() {
+		defer close(monitorChan)
+		defer dial.Release(policy)
+
+		var waitIndex uint64
+		for {
+			stopChan := make(chan bool)
+			watchChan := make(chan *etcdPkg.Response)
+			errChan := make(chan error, 1)
+
+			// The client closes watchChan when the watch terminates
+			go func(waitIndex uint64) {
+				_, err := client.Watch(key, waitIndex, recursive, watchChan, stopChan)
+				errChan <- err
+			}(waitIndex)
+
+			// The budget token acquired for the backoff, if any, only covers
+			// re-establishing the watch; holding it while the watch is idle would
+			// starve the other users of a shared budget
+			dial.Release(policy)
+
+		receive:
+			for {
+				select {
+				case r, ok := <-watchChan:
+					if !ok {
+						break receive
+					}
+					if r == nil {
+						continue
+					}
+					if r.Node != nil {
+						waitIndex = r.Node.ModifiedIndex + 1
+					}
+					policy.ResetAttempts()
+					monitorChan <- r
+				case <-shutdownChan:
+					// Service shut down; stop the watch and drain any pending response
+					close(stopChan)
+					for range watchChan {
+					}
+					return
+				}
+			}
+
+			// The watch terminated; if the index was cleared from the etcd event
+			// history, the watch can only resume from the current index
+			err := <-errChan
+			cleared := isIndexCleared(err)
+			if cleared {
+				waitIndex = 0
+			}
+
+			// Back off before re-establishing the watch. Watches are retried forever:
+			// once the policy gives up, its attempts are reset and the backoff
+			// starts over
+			scheduled := func(wait time.Duration) {
+				Adapter.logger.Printf("%s Watch of key '%s' terminated: %v; re-establishing in %v\n", Adapter.logPrefix(), key, err, wait)
+			}
+			sleepErr := dial.SleepNotify(ctx, policy, scheduled)
+			if sleepErr == dial.ErrTimeout {
+				policy.ResetAttempts()
+				sleepErr = dial.SleepNotify(ctx, policy, scheduled)
+			}
+			if sleepErr == context.Canceled {
+				return
+			}
+
+			// Changes made while the watch was down are no longer available from the
+			// event history; fetch the current value instead so they are not lost
+			if cleared {
+				if r, index := fetchCurrent(client, key, recursive); r != nil {
+					waitIndex = index + 1
+					select {
+					case monitorChan <- r:
+					case <-shutdownChan:
+						return
+					}
+				}
+			}
+			Adapter.logger.Printf("%s Resuming watch of key '%s' from index %d\n", Adapter.logPrefix(), key, waitIndex)
+		}
+	}()
+
+	return monitorChan
+}
+
+// Fetch the current value of a watched key and the etcd index it was read at. A
+// missing key is reported as a delete response. Returns a nil response if the key
+// could not be retrieved.
+func fetchCurrent(client etcdClient, key string, recursive bool) (*etcdPkg.Response, uint64) {
+	r, err := client.Get(key, true, recursive)
+	switch {
+	case isKeyNotFound(err):
+		r = &etcdPkg.Response{Action: "delete", Node: &etcdPkg.Node{Key: key, Dir: recursive}}
+		return r, err.(*etcdPkg.EtcdError).Index
+	case err != nil:
+		Adapter.logger.Printf("%s Error retrieving current value of key '%s': %v\n", Adapter.logPrefix(), key, err)
+		return nil, 0
+	case r == nil || r.Node == nil:
+		return nil, 0
+	case r.EtcdIndex == 0:
+		return r, r.Node.ModifiedIndex
+	}
+
+	return r, r.EtcdIndex
+}
+
+// Check if err is an etcd "event index cleared" error.
+func isIndexCleared(err error) bool {
+	etcdErr, ok := err.(*etcdPkg.EtcdError)
+	return ok && etcdErr.ErrorCode == 401
+}
+
+// Recursively collect the parsed values of all leaf nodes rooted at node into nodeVals.
+// Values that cannot be parsed are logged and skipped so that nodeVals retains the last
+// good value of their keys.
+func collectNodeVals(node *etcdPkg.Node, nodeVals map[string]map[string]string) {
+	if node == nil {
+		return
+	}
+
+	if !node.Dir {
+		if params, err := parseNodeVal(node); err == nil {
+			nodeVals[node.Key] = params
+		}
+		return
+	}
+
+	for _, child := range node.Nodes {
+		collectNodeVals(child, nodeVals)
+	}
+}
+
+// Remove the keys of nodeVals that are not leaf nodes rooted at node.
+func retainNodeVals(node *etcdPkg.Node, nodeVals map[string]map[string]string) {
+	leaves := make(map[string]bool)
+	var collect func(node *etcdPkg.Node)
+	collect = func(node *etcdPkg.Node) {
+		if !node.Dir {
+			leaves[node.Key] = true
+			return
+		}
+		for _, child := range node.Nodes {
+			collect(child)
+		}
+	}
+	collect(node)
+
+	for k := range nodeVals {
+		if !leaves[k] {
+			delete(nodeVals, k)
+		}
+	}
+}
+
+// Remove key and, if key is a directory, all keys nested under it from nodeVals.
+func removeNodeVals(key string, nodeVals map[string]map[string]string) {
+	dirPrefix := strings.TrimSuffix(key, "/") + "/"
+	for k := range nodeVals {
+		if k == key || strings.HasPrefix(k, dirPrefix) {
+			delete(nodeVals, k)
+		}
+	}
+}
+
+// Merge the collected node values into a single configuration map. Nodes are
+// processed in key order so that the result is deterministic when the same setting
+// appears under multiple keys.
+func mergeNodeVals(nodeVals map[string]map[string]string) map[string]string {
+	keys := make([]string, 0, len(nodeVals))
+	for k := range nodeVals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
 	params := make(map[string]string)
-	matches := etcdValRe.FindAllStringSubmatch(etcdValue, -1)
+	for _, k := range keys {
+		for setting, value := range nodeVals[k] {
+			params[setting] = value
+		}
+	}
+
+	return params
+}
+
+// Get the settings to apply to service s when its configuration changes from prev
+// to next. Settings defined in prev but not in next are reverted to their default
+// value if s implements adapters.ConfigDefaulter; settings without a known default
+// keep their current value. Neither prev nor next is modified.
+func revertRemovedSettings(s adapters.Service, prev, next map[string]string) map[string]string {
+	defaulter, ok := s.(adapters.ConfigDefaulter)
+	if !ok {
+		return next
+	}
+
+	defaults := defaulter.ConfigDefaults()
+	params := make(map[string]string, len(next))
+	for k, v := range next {
+		params[k] = v
+	}
+	for k := range prev {
+		if _, defined := next[k]; defined {
+			continue
+		}
+		if def, known := defaults[k]; known {
+			params[k] = def
+		}
+	}
+
+	return params
+}
 
-	// index 0 is the full capture
-	// index 1 is the key
-	// index 2 is the value
-	for _, match := range matches {
-		params[match[1]] = match[2]
+// Parse a received etcdValue into a map using adapters.ParseConfigValue. Values that
+// cannot be parsed are logged and yield an empty map.
+func parseVal(etcdValue string) map[string]string {
+	params, err := adapters.ParseConfigValue(etcdValue)
+	if err != nil {
+		Adapter.logger.Printf("%s Error parsing JSON settings: %v\n", Adapter.logPrefix(), err)
+		return make(map[string]string)
 	}
 
 	return params
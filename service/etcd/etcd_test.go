@@ -0,0 +1,1419 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/adaptertest"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
+	etcdPkg "github.com/coreos/go-etcd/etcd"
+)
+
+// A fake etcd client that serves canned Get responses and forwards
+// events pushed to its events channel to any active watcher.
+type fakeClient struct {
+	getResponse *etcdPkg.Response
+	getErr      error
+	events      chan *etcdPkg.Response
+
+	// Per-key Get responses that take precedence over getResponse; keys mapped to
+	// nil are reported as missing.
+	getResponses map[string]*etcdPkg.Response
+
+	// Per-key event channels whose events are only forwarded to watchers of that key.
+	keyEvents map[string]chan *etcdPkg.Response
+
+	// Errors pushed to this channel terminate the active watch with that error.
+	watchDrops chan error
+
+	// The number of Get calls that should fail before succeeding.
+	getFailures int
+	getCalls    int
+
+	// If set, SetCluster fails to reach any of the cluster hosts.
+	clusterDown bool
+
+	// The number of SetCluster calls that should fail before succeeding.
+	setClusterFailures int
+	setClusterCalls    int
+
+	// A mutex protecting the stored keys and setErr which are accessed by
+	// registration goroutines.
+	mu sync.Mutex
+
+	// The keys stored via Set and their expiration time.
+	keys map[string]fakeKey
+
+	// If set, Set fails with this error.
+	setErr error
+
+	// The wait index of each Watch call.
+	watchIndexes []uint64
+}
+
+// A key stored by the fake client.
+type fakeKey struct {
+	value   string
+	expires time.Time
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		events:     make(chan *etcdPkg.Response),
+		watchDrops: make(chan error),
+		keys:       make(map[string]fakeKey),
+		keyEvents:  make(map[string]chan *etcdPkg.Response),
+	}
+}
+
+func (c *fakeClient) Set(key string, value string, ttl uint64) (*etcdPkg.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.setErr != nil {
+		return nil, c.setErr
+	}
+	c.keys[key] = fakeKey{value: value, expires: time.Now().Add(time.Duration(ttl) * time.Second)}
+	return &etcdPkg.Response{Action: "set", Node: &etcdPkg.Node{Key: key, Value: value}}, nil
+}
+
+func (c *fakeClient) Delete(key string, recursive bool) (*etcdPkg.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.keys[key]; !exists {
+		return nil, &etcdPkg.EtcdError{ErrorCode: 100, Message: "Key not found"}
+	}
+	delete(c.keys, key)
+	return &etcdPkg.Response{Action: "delete", Node: &etcdPkg.Node{Key: key}}, nil
+}
+
+// Get the value of a stored key unless it has been deleted or its TTL expired.
+func (c *fakeClient) lookup(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k, exists := c.keys[key]
+	if !exists || time.Now().After(k.expires) {
+		return "", false
+	}
+	return k.value, true
+}
+
+func (c *fakeClient) failSet(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setErr = err
+}
+
+func (c *fakeClient) SyncCluster() bool { return true }
+func (c *fakeClient) Close()            {}
+
+func (c *fakeClient) SetCluster(machines []string) bool {
+	c.setClusterCalls++
+	if c.setClusterCalls <= c.setClusterFailures {
+		return false
+	}
+	return !c.clusterDown
+}
+
+func (c *fakeClient) Get(key string, sort, recursive bool) (*etcdPkg.Response, error) {
+	c.getCalls++
+	if c.getCalls <= c.getFailures {
+		return nil, errors.New("etcd cluster is unavailable")
+	}
+	if r, exists := c.getResponses[key]; exists {
+		if r == nil {
+			return nil, &etcdPkg.EtcdError{ErrorCode: 100, Message: "Key not found"}
+		}
+		return r, nil
+	}
+	return c.getResponse, c.getErr
+}
+
+// Get the channel for pushing events to the watchers of key.
+func (c *fakeClient) eventsFor(key string) chan *etcdPkg.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.keyEvents[key]; !exists {
+		c.keyEvents[key] = make(chan *etcdPkg.Response)
+	}
+	return c.keyEvents[key]
+}
+
+func (c *fakeClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcdPkg.Response, stop chan bool) (*etcdPkg.Response, error) {
+	c.mu.Lock()
+	c.watchIndexes = append(c.watchIndexes, waitIndex)
+	c.mu.Unlock()
+
+	keyEvents := c.eventsFor(prefix)
+
+	defer close(receiver)
+	for {
+		select {
+		case r := <-c.events:
+			receiver <- r
+		case r := <-keyEvents:
+			receiver <- r
+		case err := <-c.watchDrops:
+			return nil, err
+		case <-stop:
+			return nil, etcdPkg.ErrWatchStoppedByUser
+		}
+	}
+}
+
+// Get the wait index of each Watch call.
+func (c *fakeClient) watches() []uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]uint64(nil), c.watchIndexes...)
+}
+
+// Wait for the next Config call of srv and get its params.
+func nextConfig(t *testing.T, srv *adaptertest.FakeService) map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	params, err := srv.NextConfig(ctx)
+	if err != nil {
+		t.Fatalf("Timed out waiting for a Config call")
+	}
+	return params
+}
+
+// Ensure that no further Config calls are made to srv.
+func expectNoConfig(t *testing.T, srv *adaptertest.FakeService) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if params, err := srv.NextConfig(ctx); err == nil {
+		t.Fatalf("Expected no further Config calls; got %v", params)
+	}
+}
+
+// Shut down srv. The fake service only notifies its close listeners while connected
+// and Config calls that modify its settings reset the connection, so it is dialed first.
+func shutdown(srv *adaptertest.FakeService) {
+	srv.Dial()
+	srv.Close()
+}
+
+// Swap the adapter client with a fake for the duration of a test.
+func useFakeClient(t *testing.T) *fakeClient {
+	client := newFakeClient()
+	origClient := Adapter.client
+	Adapter.client = client
+	t.Cleanup(func() { Adapter.client = origClient })
+	return client
+}
+
+func TestParseTokenizedVal(t *testing.T) {
+	params := parseVal("endpoint=127.0.0.1:6379   db=1\n\tconnTimeout=2")
+	expected := map[string]string{
+		"endpoint":    "127.0.0.1:6379",
+		"db":          "1",
+		"connTimeout": "2",
+	}
+
+	if !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected tokenized value to be %v; got %v", expected, params)
+	}
+}
+
+func TestParseVal(t *testing.T) {
+	specs := []struct {
+		value    string
+		expected map[string]string
+	}{
+		{
+			value:    "endpoint=host:6379 password=secret",
+			expected: map[string]string{"endpoint": "host:6379", "password": "secret"},
+		},
+		{
+			value:    ` {"endpoint":"host:6379","password":"a b c"}`,
+			expected: map[string]string{"endpoint": "host:6379", "password": "a b c"},
+		},
+		{
+			value:    `{"endpoint":`,
+			expected: map[string]string{},
+		},
+	}
+
+	for index, spec := range specs {
+		params := parseVal(spec.value)
+		if !reflect.DeepEqual(params, spec.expected) {
+			t.Fatalf("[spec %d] Expected parsed value to be %v; got %v", index, spec.expected, params)
+		}
+	}
+}
+
+func TestAutoConfPrefix(t *testing.T) {
+	client := useFakeClient(t)
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node: &etcdPkg.Node{
+			Key: "/config/redis",
+			Dir: true,
+			Nodes: etcdPkg.Nodes{
+				{Key: "/config/redis/endpoint", Value: "endpoint=127.0.0.1:6379"},
+				{
+					Key: "/config/redis/auth",
+					Dir: true,
+					Nodes: etcdPkg.Nodes{
+						{Key: "/config/redis/auth/db", Value: "db=1 password=secret"},
+					},
+				},
+			},
+		},
+	}
+
+	srv := adaptertest.New()
+	err := AutoConfPrefix("/config/redis")(srv)
+	if err != nil {
+		t.Fatalf("Error applying AutoConfPrefix option: %v", err)
+	}
+
+	expected := map[string]string{
+		"endpoint": "127.0.0.1:6379",
+		"db":       "1",
+		"password": "secret",
+	}
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected initial config to be %v; got %v", expected, params)
+	}
+
+	// Update a child key
+	client.events <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/redis/endpoint", Value: "endpoint=10.0.0.1:6379"},
+	}
+	expected["endpoint"] = "10.0.0.1:6379"
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected config after update to be %v; got %v", expected, params)
+	}
+
+	// Delete a child directory
+	client.events <- &etcdPkg.Response{
+		Action: "delete",
+		Node:   &etcdPkg.Node{Key: "/config/redis/auth", Dir: true},
+	}
+	expected = map[string]string{
+		"endpoint": "10.0.0.1:6379",
+	}
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected config after deletion to be %v; got %v", expected, params)
+	}
+}
+
+func TestAutoConfPrefixRevertsDeletedSettings(t *testing.T) {
+	client := useFakeClient(t)
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node: &etcdPkg.Node{
+			Key: "/config/redis",
+			Dir: true,
+			Nodes: etcdPkg.Nodes{
+				{Key: "/config/redis/endpoint", Value: "endpoint=127.0.0.1:6379"},
+				{Key: "/config/redis/db", Value: "db=1"},
+			},
+		},
+	}
+
+	srv := adaptertest.New().Defaults(map[string]string{
+		"endpoint": "localhost:6379",
+		"db":       "0",
+	})
+	err := AutoConfPrefix("/config/redis")(srv)
+	if err != nil {
+		t.Fatalf("Error applying AutoConfPrefix option: %v", err)
+	}
+	nextConfig(t, srv)
+
+	client.events <- &etcdPkg.Response{
+		Action: "delete",
+		Node:   &etcdPkg.Node{Key: "/config/redis/db"},
+	}
+	expected := map[string]string{
+		"endpoint": "127.0.0.1:6379",
+		"db":       "0",
+	}
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected config after deletion to be %v; got %v", expected, params)
+	}
+	if db := srv.Settings()["db"]; db != "0" {
+		t.Fatalf("Expected deleted setting 'db' to be reverted to its default value 0; got %s", db)
+	}
+}
+
+func TestAutoConfPrefixIgnoresUnparsableValues(t *testing.T) {
+	client := useFakeClient(t)
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node: &etcdPkg.Node{
+			Key: "/config/redis",
+			Dir: true,
+			Nodes: etcdPkg.Nodes{
+				{Key: "/config/redis/endpoint", Value: "endpoint=127.0.0.1:6379"},
+				{Key: "/config/redis/db", Value: `{"db":"1"}`},
+			},
+		},
+	}
+
+	srv := adaptertest.New().Defaults(map[string]string{
+		"endpoint": "localhost:6379",
+		"db":       "0",
+	})
+	err := AutoConfPrefix("/config/redis")(srv)
+	if err != nil {
+		t.Fatalf("Error applying AutoConfPrefix option: %v", err)
+	}
+	nextConfig(t, srv)
+
+	// A malformed value must not revert the settings of its key
+	client.events <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/redis/db", Value: `{"db":`},
+	}
+	expected := map[string]string{
+		"endpoint": "127.0.0.1:6379",
+		"db":       "1",
+	}
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected config after a malformed update to be %v; got %v", expected, params)
+	}
+	if db := srv.Settings()["db"]; db != "1" {
+		t.Fatalf("Expected setting 'db' to keep its last good value 1; got %s", db)
+	}
+}
+
+func TestRevertRemovedSettings(t *testing.T) {
+	prev := map[string]string{"endpoint": "10.0.0.1:6379", "db": "1", "custom": "foo"}
+	next := map[string]string{"endpoint": "10.0.0.2:6379"}
+
+	srv := adaptertest.New().Defaults(map[string]string{"endpoint": "localhost:6379", "db": "0"})
+	params := revertRemovedSettings(srv, prev, next)
+	expected := map[string]string{"endpoint": "10.0.0.2:6379", "db": "0"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected params to be %v; got %v", expected, params)
+	}
+	if len(next) != 1 {
+		t.Fatalf("Expected next to be left unmodified; got %v", next)
+	}
+
+	// Services that do not report their defaults keep the removed settings
+	if params = revertRemovedSettings(Adapter, prev, next); !reflect.DeepEqual(params, next) {
+		t.Fatalf("Expected params to be %v; got %v", next, params)
+	}
+}
+
+func TestConfigEmptyHosts(t *testing.T) {
+	srv := &Etcd{
+		hosts:         []string{"http://10.0.0.1:2379"},
+		client:        etcdPkg.NewClient(nil),
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(1, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	if err := srv.Config(map[string]string{"hosts": ""}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if len(srv.hosts) != 0 {
+		t.Fatalf("Expected an empty hosts setting to clear the host list; got %q", srv.hosts)
+	}
+	if err := srv.Dial(); err == nil {
+		t.Fatalf("Expected Dial to fail without any hosts")
+	}
+}
+
+func TestAutoConfMerged(t *testing.T) {
+	client := useFakeClient(t)
+	client.getResponses = map[string]*etcdPkg.Response{
+		"/config/defaults/redis": {
+			Action: "get",
+			Node:   &etcdPkg.Node{Key: "/config/defaults/redis", Value: "endpoint=127.0.0.1:6379 db=1 connTimeout=1"},
+		},
+		"/config/prod/redis": {
+			Action: "get",
+			Node:   &etcdPkg.Node{Key: "/config/prod/redis", Value: "db=2"},
+		},
+		"/config/instance-1/redis": nil,
+	}
+
+	srv := adaptertest.New()
+	err := AutoConfMerged("/config/defaults/redis", "/config/prod/redis", "/config/instance-1/redis")(srv)
+	if err != nil {
+		t.Fatalf("Error applying AutoConfMerged option: %v", err)
+	}
+
+	expected := map[string]string{"endpoint": "127.0.0.1:6379", "db": "2", "connTimeout": "1"}
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected initial config to be %v; got %v", expected, params)
+	}
+
+	// Changes to lower priority keys should not override the higher priority ones
+	client.eventsFor("/config/defaults/redis") <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/defaults/redis", Value: "endpoint=10.0.0.1:6379 db=0 connTimeout=1"},
+	}
+	expected["endpoint"] = "10.0.0.1:6379"
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected config after updating the defaults to be %v; got %v", expected, params)
+	}
+
+	// Keys created after the option was applied are merged too
+	client.eventsFor("/config/instance-1/redis") <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/instance-1/redis", Value: "connTimeout=5"},
+	}
+	expected["connTimeout"] = "5"
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected config after adding an instance override to be %v; got %v", expected, params)
+	}
+
+	// Deleting an override restores the value of the lower priority key
+	client.eventsFor("/config/prod/redis") <- &etcdPkg.Response{
+		Action: "delete",
+		Node:   &etcdPkg.Node{Key: "/config/prod/redis"},
+	}
+	expected["db"] = "0"
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected config after deleting the env override to be %v; got %v", expected, params)
+	}
+
+	if err = AutoConfMerged()(srv); err == nil {
+		t.Fatalf("Expected AutoConfMerged to require at least one key")
+	}
+}
+
+func TestAutoConfMergedRevertsDeletedSettings(t *testing.T) {
+	client := useFakeClient(t)
+	client.getResponses = map[string]*etcdPkg.Response{
+		"/config/defaults/redis": {
+			Action: "get",
+			Node:   &etcdPkg.Node{Key: "/config/defaults/redis", Value: "endpoint=127.0.0.1:6379"},
+		},
+		"/config/prod/redis": {
+			Action: "get",
+			Node:   &etcdPkg.Node{Key: "/config/prod/redis", Value: "db=2"},
+		},
+	}
+
+	srv := adaptertest.New().Defaults(map[string]string{
+		"endpoint": "localhost:6379",
+		"db":       "0",
+	})
+	err := AutoConfMerged("/config/defaults/redis", "/config/prod/redis")(srv)
+	if err != nil {
+		t.Fatalf("Error applying AutoConfMerged option: %v", err)
+	}
+	nextConfig(t, srv)
+
+	// A malformed value is ignored and the last good settings remain in effect
+	client.eventsFor("/config/prod/redis") <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/prod/redis", Value: `{"db":`},
+	}
+	expectNoConfig(t, srv)
+
+	// Settings only defined by a deleted key are reverted to their default value
+	client.eventsFor("/config/prod/redis") <- &etcdPkg.Response{
+		Action: "delete",
+		Node:   &etcdPkg.Node{Key: "/config/prod/redis"},
+	}
+	expected := map[string]string{"endpoint": "127.0.0.1:6379", "db": "0"}
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected config after deleting the env override to be %v; got %v", expected, params)
+	}
+	if db := srv.Settings()["db"]; db != "0" {
+		t.Fatalf("Expected deleted setting 'db' to be reverted to its default value 0; got %s", db)
+	}
+}
+
+func TestAutoConfStopsOnClose(t *testing.T) {
+	client := useFakeClient(t)
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=1"},
+	}
+
+	before := runtime.NumGoroutine()
+
+	srv := adaptertest.New()
+	err := AutoConf("/config/redis")(srv)
+	if err != nil {
+		t.Fatalf("Error applying AutoConf option: %v", err)
+	}
+	nextConfig(t, srv)
+
+	// A connection reset should not stop the watch
+	srv.Dial()
+	srv.Drop()
+	client.events <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=2"},
+	}
+	if params := nextConfig(t, srv); params["db"] != "2" {
+		t.Fatalf("Expected config after reset to contain db=2; got %v", params)
+	}
+
+	if running := runtime.NumGoroutine(); running <= before {
+		t.Fatalf("Expected AutoConf to spawn watch goroutines; goroutine count %d -> %d", before, running)
+	}
+
+	shutdown(srv)
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected goroutine count to drop to %d after Close; got %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAutoConfReestablishesWatch(t *testing.T) {
+	client := useFakeClient(t)
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=1"},
+	}
+
+	srv := adaptertest.New()
+	defer shutdown(srv)
+	if err := AutoConf("/config/redis")(srv); err != nil {
+		t.Fatalf("Error applying AutoConf option: %v", err)
+	}
+	nextConfig(t, srv)
+
+	client.events <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=2", ModifiedIndex: 7},
+	}
+	if params := nextConfig(t, srv); params["db"] != "2" {
+		t.Fatalf("Expected config to contain db=2; got %v", params)
+	}
+
+	// Drop the watch twice; the second drop reports that index 8 is no longer
+	// available so the watch should resume from the current index
+	client.watchDrops <- errors.New("cluster is unreachable")
+	client.events <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=3", ModifiedIndex: 9},
+	}
+	if params := nextConfig(t, srv); params["db"] != "3" {
+		t.Fatalf("Expected config after re-establishing the watch to contain db=3; got %v", params)
+	}
+
+	// The key was modified while its events were cleared; the current value should be
+	// fetched and the watch resumed from the index it was read at
+	client.getResponse = &etcdPkg.Response{
+		Action:    "get",
+		Node:      &etcdPkg.Node{Key: "/config/redis", Value: "db=4", ModifiedIndex: 20},
+		EtcdIndex: 25,
+	}
+	client.watchDrops <- &etcdPkg.EtcdError{ErrorCode: 401, Message: "The event in requested index is outdated and cleared"}
+	if params := nextConfig(t, srv); params["db"] != "4" {
+		t.Fatalf("Expected config after re-fetching the key to contain db=4; got %v", params)
+	}
+
+	client.events <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=5", ModifiedIndex: 26},
+	}
+	if params := nextConfig(t, srv); params["db"] != "5" {
+		t.Fatalf("Expected config after re-establishing the watch to contain db=5; got %v", params)
+	}
+
+	if watches := client.watches(); !reflect.DeepEqual(watches, []uint64{0, 8, 26}) {
+		t.Fatalf("Expected watches to resume from indexes [0 8 26]; got %v", watches)
+	}
+}
+
+func TestAutoConfPrefixResyncsAfterIndexCleared(t *testing.T) {
+	client := useFakeClient(t)
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node: &etcdPkg.Node{
+			Key: "/config/redis",
+			Dir: true,
+			Nodes: etcdPkg.Nodes{
+				{Key: "/config/redis/endpoint", Value: "endpoint=127.0.0.1:6379"},
+				{Key: "/config/redis/db", Value: "db=1"},
+			},
+		},
+	}
+
+	srv := adaptertest.New()
+	defer shutdown(srv)
+	if err := AutoConfPrefix("/config/redis")(srv); err != nil {
+		t.Fatalf("Error applying AutoConfPrefix option: %v", err)
+	}
+	nextConfig(t, srv)
+
+	// While the events were cleared, db was deleted and endpoint was modified
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node: &etcdPkg.Node{
+			Key: "/config/redis",
+			Dir: true,
+			Nodes: etcdPkg.Nodes{
+				{Key: "/config/redis/endpoint", Value: "endpoint=10.0.0.1:6379"},
+			},
+		},
+		EtcdIndex: 30,
+	}
+	client.watchDrops <- &etcdPkg.EtcdError{ErrorCode: 401, Message: "The event in requested index is outdated and cleared"}
+
+	expected := map[string]string{"endpoint": "10.0.0.1:6379"}
+	if params := nextConfig(t, srv); !reflect.DeepEqual(params, expected) {
+		t.Fatalf("Expected config after re-fetching the prefix to be %v; got %v", expected, params)
+	}
+}
+
+func TestAutoConfRetriesWatchForever(t *testing.T) {
+	origPolicy := Adapter.dialPolicy
+	Adapter.dialPolicy = dial.Periodic(1, time.Hour)
+	defer func() { Adapter.dialPolicy = origPolicy }()
+
+	fake := clock.UseFake(t)
+	client := useFakeClient(t)
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=1"},
+	}
+
+	srv := adaptertest.New()
+	defer shutdown(srv)
+	if err := AutoConf("/config/redis")(srv); err != nil {
+		t.Fatalf("Error applying AutoConf option: %v", err)
+	}
+	nextConfig(t, srv)
+
+	// The policy only allows a single attempt; it should be reset after each
+	// failure so the watch keeps being re-established
+	for i := 0; i < 3; i++ {
+		client.watchDrops <- errors.New("cluster is unreachable")
+	}
+	client.events <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=2", ModifiedIndex: 7},
+	}
+	if params := nextConfig(t, srv); params["db"] != "2" {
+		t.Fatalf("Expected config after re-establishing the watch to contain db=2; got %v", params)
+	}
+
+	if sleeps := fake.Sleeps(); !reflect.DeepEqual(sleeps, []time.Duration{time.Hour, time.Hour, time.Hour}) {
+		t.Fatalf("Expected three 1h backoffs; got %v", sleeps)
+	}
+}
+
+func TestAutoConfReleasesBudgetTokenWhileWatching(t *testing.T) {
+	budget := dial.SharedBudget(1)
+	origPolicy := Adapter.dialPolicy
+	Adapter.dialPolicy = budget.Wrap(dial.Periodic(5, time.Millisecond))
+	defer func() { Adapter.dialPolicy = origPolicy }()
+
+	client := useFakeClient(t)
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=1"},
+	}
+
+	srv := adaptertest.New()
+	defer shutdown(srv)
+	if err := AutoConf("/config/redis")(srv); err != nil {
+		t.Fatalf("Error applying AutoConf option: %v", err)
+	}
+	nextConfig(t, srv)
+
+	// Drop the watch and wait for it to be re-established
+	client.watchDrops <- errors.New("cluster is unreachable")
+	deadline := time.Now().Add(time.Second)
+	for len(client.watches()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the watch to be re-established")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// While the watch is idle, other policies sharing the budget can still retry
+	acquired := make(chan struct{})
+	go func() {
+		other := budget.Wrap(dial.Periodic(1, time.Millisecond))
+		other.NextRetry()
+		dial.Release(other)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the idle watch not to hold the budget token; %d token(s) in use", budget.InUse())
+	}
+}
+
+func TestAutoConfSkipsResetForIdenticalConfig(t *testing.T) {
+	client := useFakeClient(t)
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "endpoint=127.0.0.1:6379 db=1"},
+	}
+
+	srv := adaptertest.New()
+	err := AutoConf("/config/redis")(srv)
+	if err != nil {
+		t.Fatalf("Error applying AutoConf option: %v", err)
+	}
+	nextConfig(t, srv)
+
+	err = srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer shutdown(srv)
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	// Push the same config again; the connection should not be reset
+	client.events <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=1 endpoint=127.0.0.1:6379"},
+	}
+	nextConfig(t, srv)
+	if srv.ConfigChanged() {
+		t.Fatalf("Expected ConfigChanged() to report no change for identical config")
+	}
+	select {
+	case <-listener:
+		t.Fatalf("Expected identical config not to reset the connection")
+	default:
+	}
+
+	client.events <- &etcdPkg.Response{
+		Action: "set",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "endpoint=127.0.0.1:6379 db=2"},
+	}
+	nextConfig(t, srv)
+	select {
+	case err, ok := <-listener:
+		if ok {
+			t.Fatalf("Expected listener to be closed without an error; got %v", err)
+		}
+	default:
+		t.Fatalf("Expected a config change to reset the connection")
+	}
+}
+
+func TestAutoConfRetriesInitialGet(t *testing.T) {
+	origPolicy := Adapter.dialPolicy
+	Adapter.dialPolicy = dial.Periodic(5, time.Millisecond)
+	defer func() { Adapter.dialPolicy = origPolicy }()
+
+	client := useFakeClient(t)
+	client.getFailures = 3
+	client.getResponse = &etcdPkg.Response{
+		Action: "get",
+		Node:   &etcdPkg.Node{Key: "/config/redis", Value: "db=1"},
+	}
+
+	srv := adaptertest.New()
+	err := AutoConf("/config/redis")(srv)
+	if err != nil {
+		t.Fatalf("Error applying AutoConf option: %v", err)
+	}
+
+	if params := nextConfig(t, srv); params["db"] != "1" {
+		t.Fatalf("Expected initial config to contain db=1; got %v", params)
+	}
+	if client.getCalls != 4 {
+		t.Fatalf("Expected 4 Get calls; got %d", client.getCalls)
+	}
+}
+
+func TestAutoConfGivesUpInitialGet(t *testing.T) {
+	origPolicy := Adapter.dialPolicy
+	Adapter.dialPolicy = dial.Periodic(2, time.Millisecond)
+	defer func() { Adapter.dialPolicy = origPolicy }()
+
+	client := useFakeClient(t)
+	client.getFailures = 10
+
+	srv := adaptertest.New()
+	err := AutoConf("/config/redis")(srv)
+	if err != nil {
+		t.Fatalf("Error applying AutoConf option: %v", err)
+	}
+
+	// Expect the initial attempt plus 2 retries
+	if client.getCalls != 3 {
+		t.Fatalf("Expected 3 Get calls; got %d", client.getCalls)
+	}
+	expectNoConfig(t, srv)
+}
+
+func TestGetRetriesWithFakeClock(t *testing.T) {
+	client := newFakeClient()
+	client.getFailures = 3
+	client.getResponse = &etcdPkg.Response{Action: "get", Node: &etcdPkg.Node{Key: "/config/redis", Value: "db=1"}}
+
+	fake := clock.UseFake(t)
+	srv := &Etcd{
+		client:     client,
+		logger:     Adapter.logger,
+		dialPolicy: dial.Periodic(5, time.Hour),
+	}
+
+	res, err := srv.get(context.Background(), "/config/redis", false, false)
+	if err != nil {
+		t.Fatalf("Expected get to succeed; got %v", err)
+	}
+	if res.Node.Value != "db=1" {
+		t.Fatalf("Expected value db=1; got %q", res.Node.Value)
+	}
+	if sleeps := fake.Sleeps(); !reflect.DeepEqual(sleeps, []time.Duration{time.Hour, time.Hour, time.Hour}) {
+		t.Fatalf("Expected three 1h sleeps; got %v", sleeps)
+	}
+}
+
+func TestGetReleasesLockWhileRetrying(t *testing.T) {
+	client := newFakeClient()
+	client.getFailures = 1000
+
+	srv := &Etcd{
+		client:     client,
+		logger:     Adapter.logger,
+		dialPolicy: dial.Periodic(1000, 10*time.Millisecond),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := srv.get(ctx, "/config/redis", false, false)
+		errChan <- err
+	}()
+
+	// Other callers can acquire the service lock while get is retrying
+	locked := make(chan struct{})
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		srv.Lock()
+		srv.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the service lock not to be held while get is retrying")
+	}
+
+	cancel()
+	select {
+	case err := <-errChan:
+		if err != context.Canceled {
+			t.Fatalf("Expected get to fail with context.Canceled; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for get to honor the cancelled context")
+	}
+}
+
+func TestDialContextCancel(t *testing.T) {
+	client := newFakeClient()
+	client.clusterDown = true
+
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:1"},
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(1000, 50*time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := srv.DialContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("Expected DialContext to fail with context.Canceled; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected DialContext to return promptly after cancellation; took %v", elapsed)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:4001"},
+		client:        newFakeClient(),
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(1, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	err := srv.Config(map[string]string{"hosts": "http://10.0.0.1:4001,http://10.0.0.2:4001"})
+	if err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if endpoint := srv.Endpoint(); endpoint != "http://10.0.0.1:4001,http://10.0.0.2:4001" {
+		t.Fatalf("Expected Endpoint() to return the joined host list; got %s", endpoint)
+	}
+
+	if err = srv.SetEndpoint("http://10.0.0.3:4001"); err != nil {
+		t.Fatalf("Expected SetEndpoint to succeed; got %v", err)
+	}
+	if endpoint := srv.Endpoint(); endpoint != "http://10.0.0.3:4001" {
+		t.Fatalf("Expected Endpoint() to return http://10.0.0.3:4001; got %s", endpoint)
+	}
+}
+
+func TestDialWhenConnected(t *testing.T) {
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:4001"},
+		client:        newFakeClient(),
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(1, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	if err := srv.Dial(); err != adapters.ErrAlreadyConnected {
+		t.Fatalf("Expected a second Dial to return ErrAlreadyConnected; got %v", err)
+	}
+}
+
+// Read len(expected) events from events and compare their types with expected.
+func expectEvents(t *testing.T, events <-chan adapters.Event, expected ...adapters.EventType) []adapters.Event {
+	received := make([]adapters.Event, 0, len(expected))
+	for index, evtType := range expected {
+		select {
+		case evt := <-events:
+			if evt.Type != evtType {
+				t.Fatalf("[event %d] Expected event type %s; got %s", index, evtType, evt.Type)
+			}
+			received = append(received, evt)
+		case <-time.After(time.Second):
+			t.Fatalf("[event %d] Timed out waiting for %s event", index, evtType)
+		}
+	}
+	return received
+}
+
+func TestDialRetriesWithFakeClock(t *testing.T) {
+	client := newFakeClient()
+	client.setClusterFailures = 3
+
+	fake := clock.UseFake(t)
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:4001"},
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(5, time.Hour),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	start := time.Now()
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected retries to complete without real delays; took %v", elapsed)
+	}
+	if sleeps := fake.Sleeps(); !reflect.DeepEqual(sleeps, []time.Duration{time.Hour, time.Hour, time.Hour}) {
+		t.Fatalf("Expected three 1h sleeps; got %v", sleeps)
+	}
+	if client.setClusterCalls != 4 {
+		t.Fatalf("Expected 4 dial attempts; got %d", client.setClusterCalls)
+	}
+}
+
+func TestDialUnreachableCluster(t *testing.T) {
+	clock.UseFake(t)
+
+	specs := []struct {
+		descr       string
+		getFailures int
+		getErr      error
+		expErr      error
+		expAttempts int
+	}{
+		{"all hosts down", 1000, nil, dial.ErrTimeout, 3},
+		{"hosts recover", 2, nil, nil, 3},
+		{"etcd error response", 0, &etcdPkg.EtcdError{ErrorCode: 110, Message: "The request requires user authentication"}, nil, 1},
+	}
+
+	for _, spec := range specs {
+		client := newFakeClient()
+		client.getFailures = spec.getFailures
+		client.getErr = spec.getErr
+		srv := &Etcd{
+			hosts:         []string{"http://127.0.0.1:4001"},
+			client:        client,
+			logger:        Adapter.logger,
+			dialPolicy:    dial.Periodic(3, time.Second),
+			metrics:       adapters.NopMetrics,
+			tracer:        adapters.NopTracer,
+			closeNotifier: adapters.NewNotifier(),
+		}
+
+		// SetCluster always succeeds so only the probe can detect the unreachable hosts
+		err := srv.Dial()
+		if err != spec.expErr {
+			t.Fatalf("[%s] Expected Dial to return %v; got %v", spec.descr, spec.expErr, err)
+		}
+		if srv.IsConnected() != (spec.expErr == nil) {
+			t.Fatalf("[%s] Expected connected to be %t", spec.descr, spec.expErr == nil)
+		}
+		if client.getCalls != spec.expAttempts {
+			t.Fatalf("[%s] Expected %d probe(s); got %d", spec.descr, spec.expAttempts, client.getCalls)
+		}
+	}
+}
+
+func TestReconfigureUnreachableCluster(t *testing.T) {
+	client := newFakeClient()
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:4001"},
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(1000, 10*time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	if err := adapters.Reconfigure(context.Background(), srv, map[string]string{"hosts": "http://10.0.0.1:4001"}); err != nil {
+		t.Fatalf("Expected Reconfigure to succeed; got %v", err)
+	}
+	if !srv.IsConnected() {
+		t.Fatalf("Expected the service to remain connected")
+	}
+
+	client.clusterDown = true
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := adapters.Reconfigure(ctx, srv, map[string]string{"hosts": "http://10.0.0.2:4001"})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected Reconfigure to fail with context.DeadlineExceeded; got %v", err)
+	}
+
+	// The service is closed once the pending probe observes the expired context
+	deadline := time.Now().Add(time.Second)
+	for srv.IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the service to be left disconnected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	// Fail the first dial attempt
+	client := newFakeClient()
+	client.setClusterFailures = 1
+
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:4001"},
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(2, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	if err := srv.Config(map[string]string{"hosts": "http://10.0.0.1:4001"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	srv.Close()
+
+	events := expectEvents(t, srv.Events(),
+		adapters.EventRetryScheduled,
+		adapters.EventConnect,
+		adapters.EventConfigChanged,
+		adapters.EventDisconnect,
+	)
+	if events[0].Attempt != 1 || events[0].Wait != time.Millisecond || events[0].Err == nil {
+		t.Fatalf("Expected retry event for attempt 1 with a 1ms wait and an error; got %+v", events[0])
+	}
+	if events[1].Attempt != 2 {
+		t.Fatalf("Expected connect event for attempt 2; got %+v", events[1])
+	}
+}
+
+func TestWaitForConnection(t *testing.T) {
+	// Connect after a few failed dial attempts
+	client := newFakeClient()
+	client.setClusterFailures = 3
+
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:4001"},
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(10, 10*time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	go srv.Dial()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.WaitForConnection(ctx); err != nil {
+		t.Fatalf("Expected WaitForConnection to succeed; got %v", err)
+	}
+
+	srv.Lock()
+	connected := srv.connected
+	srv.Unlock()
+	if !connected {
+		t.Fatalf("Expected service to be connected")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	client := useFakeClient(t)
+	srv := adaptertest.New()
+
+	if err := Register("/services/api/node1", "10.0.0.1:8080", 500*time.Millisecond)(srv); err == nil {
+		t.Fatalf("Expected Register to reject TTLs shorter than 1s")
+	}
+	if err := Register("/services/api/node1", "10.0.0.1:8080", time.Second)(srv); err != nil {
+		t.Fatalf("Expected Register to succeed; got %v", err)
+	}
+	if val, exists := client.lookup("/services/api/node1"); !exists || val != "10.0.0.1:8080" {
+		t.Fatalf("Expected key to be registered with value 10.0.0.1:8080; got %q (exists: %t)", val, exists)
+	}
+
+	// The TTL should be refreshed while the service is running
+	time.Sleep(1500 * time.Millisecond)
+	if _, exists := client.lookup("/services/api/node1"); !exists {
+		t.Fatalf("Expected key TTL to be refreshed")
+	}
+
+	// Shutting down the service should remove the key
+	shutdown(srv)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, exists := client.lookup("/services/api/node1"); !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected key to be deregistered after the service was closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegisterKeepAliveFailure(t *testing.T) {
+	client := useFakeClient(t)
+	srv := adaptertest.New()
+	defer shutdown(srv)
+
+	listener := make(adapters.CloseListener, 1)
+	Adapter.NotifyClose(listener)
+
+	if err := Register("/services/api/node1", "10.0.0.1:8080", time.Second)(srv); err != nil {
+		t.Fatalf("Expected Register to succeed; got %v", err)
+	}
+	client.failSet(errors.New("etcd cluster is unavailable"))
+
+	select {
+	case err := <-listener:
+		if err != ErrRegistrationLost {
+			t.Fatalf("Expected to receive ErrRegistrationLost; got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for the registration to be reported as lost")
+	}
+	if _, exists := client.lookup("/services/api/node1"); exists {
+		t.Fatalf("Expected key TTL to expire")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	client := newFakeClient()
+	client.setClusterFailures = 3
+
+	fake := clock.UseFake(t)
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:4001", "http://127.0.0.1:4002"},
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(2, time.Hour),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	if err := srv.Dial(); err == nil {
+		t.Fatalf("Expected Dial to fail")
+	}
+	status := srv.Status()
+	if status.Connected || status.LastError == "" || status.DialAttempts != 2 || !status.LastConnectedAt.IsZero() {
+		t.Fatalf("Expected the status to reflect the failed dial; got %+v", status)
+	}
+
+	// The first attempt of the next dial fails as well
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	exp := adapters.Status{
+		Connected:       true,
+		Endpoint:        "http://127.0.0.1:4001,http://127.0.0.1:4002",
+		DialAttempts:    2,
+		LastConnectedAt: fake.Now(),
+	}
+	if status = srv.Status(); !reflect.DeepEqual(status, exp) {
+		t.Fatalf("Expected status %+v; got %+v", exp, status)
+	}
+}
+
+func TestSetName(t *testing.T) {
+	var buf bytes.Buffer
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:4001"},
+		client:        newFakeClient(),
+		logger:        log.New(&buf, "", 0),
+		dialPolicy:    dial.Periodic(1, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+	srv.SetName("config")
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	exp := "[ETCD config] Connecting to cluster hosts: [http://127.0.0.1:4001]\n[ETCD config] Connected to cluster\n"
+	if out := buf.String(); out != exp {
+		t.Fatalf("Expected log output %q; got %q", exp, out)
+	}
+}
+
+func TestOnDialFailure(t *testing.T) {
+	client := newFakeClient()
+	client.setClusterFailures = 1000
+
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:4001"},
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(3, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+	calls := make(chan uint32, 10)
+	srv.OnDialFailure(func(attempts uint32, lastErr error) {
+		if lastErr != errNoReachableHost {
+			t.Errorf("Expected the callback to receive errNoReachableHost; got %v", lastErr)
+		}
+		calls <- attempts
+	})
+
+	for dials := 0; dials < 2; dials++ {
+		if err := srv.Dial(); err != dial.ErrTimeout {
+			t.Fatalf("Expected Dial to fail with ErrTimeout; got %v", err)
+		}
+	}
+
+	// Expect exactly one call per exhausted dial
+	for dials := 0; dials < 2; dials++ {
+		select {
+		case attempts := <-calls:
+			if attempts != 3 {
+				t.Fatalf("Expected the callback to receive 3 attempts; got %d", attempts)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the dial failure callback")
+		}
+	}
+	select {
+	case attempts := <-calls:
+		t.Fatalf("Expected no further callbacks; got one with %d attempts", attempts)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPutConfig(t *testing.T) {
+	client := newFakeClient()
+	srv := &Etcd{
+		hosts:         []string{"http://127.0.0.1:4001"},
+		client:        client,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(1, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+
+	specs := []struct {
+		params   map[string]string
+		expValue string
+	}{
+		{map[string]string{"endpoint": "10.0.0.1:6379", "db": "2"}, "db=2 endpoint=10.0.0.1:6379"},
+		{map[string]string{"endpoint": "10.0.0.1:6379", "password": "a b c"}, `{"endpoint":"10.0.0.1:6379","password":"a b c"}`},
+	}
+
+	for index, spec := range specs {
+		if err := srv.PutConfig("/config/redis", spec.params); err != nil {
+			t.Fatalf("[spec %d] Expected PutConfig to succeed; got %v", index, err)
+		}
+
+		client.mu.Lock()
+		value := client.keys["/config/redis"].value
+		client.mu.Unlock()
+		if value != spec.expValue {
+			t.Fatalf("[spec %d] Expected the key to be set to %q; got %q", index, spec.expValue, value)
+		}
+		if params := parseVal(value); !reflect.DeepEqual(params, spec.params) {
+			t.Fatalf("[spec %d] Expected the value to parse back to %v; got %v", index, spec.params, params)
+		}
+	}
+
+	client.setErr = errors.New("etcd cluster is unavailable")
+	if err := srv.Put("/config/redis", "db=1"); err != client.setErr {
+		t.Fatalf("Expected Put to return the client error; got %v", err)
+	}
+}
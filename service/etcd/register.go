@@ -0,0 +1,88 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+)
+
+// ErrRegistrationLost is emitted to the close listeners of the etcd Adapter when the
+// TTL of a key created by Register could not be refreshed before the key expired.
+var ErrRegistrationLost = errors.New("etcd registration lost")
+
+// Registration middleware for service adaptors. It returns a ServiceOption that
+// stores value under an etcd key with a TTL and keeps refreshing the TTL until the
+// service is shut down; the key is then deleted. The TTL is refreshed every ttl/3 and
+// is truncated to whole seconds. If the key expires because all refresh attempts
+// within its TTL failed, ErrRegistrationLost is emitted to the close listeners of the
+// etcd Adapter and the registration is abandoned.
+func Register(key, value string, ttl time.Duration) adapters.ServiceOption {
+	return func(s adapters.Service) error {
+		if ttl < time.Second {
+			return fmt.Errorf("invalid value for 'ttl': %v; etcd TTLs must be at least 1s", ttl)
+		}
+
+		ttl = ttl.Truncate(time.Second)
+		if err := Adapter.set(key, value, ttl); err != nil {
+			return err
+		}
+
+		go keepRegistered(key, value, ttl, adapters.NotifyShutdown(s))
+		return nil
+	}
+}
+
+// Refresh the TTL of key until shutdownChan is closed and then delete the key.
+func keepRegistered(key, value string, ttl time.Duration, shutdownChan <-chan struct{}) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	lastRefresh := time.Now()
+	for {
+		select {
+		case <-shutdownChan:
+			if err := Adapter.delete(key); err != nil {
+				Adapter.logger.Printf("%s Could not deregister key '%s': %v\n", Adapter.logPrefix(), key, err)
+			}
+			return
+		case now := <-ticker.C:
+			err := Adapter.set(key, value, ttl)
+			if err == nil {
+				lastRefresh = time.Now()
+				continue
+			}
+
+			if now.Sub(lastRefresh) < ttl {
+				Adapter.logger.Printf("%s Could not refresh TTL of key '%s': %v; retrying in %v\n", Adapter.logPrefix(), key, err, ttl/3)
+				continue
+			}
+
+			Adapter.logger.Printf("%s Registration of key '%s' expired: %v\n", Adapter.logPrefix(), key, err)
+			Adapter.closeNotifier.NotifyAll(ErrRegistrationLost)
+			return
+		}
+	}
+}
+
+// Set key to value with the supplied TTL.
+func (s *Etcd) set(key, value string, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.client.Set(key, value, uint64(ttl/time.Second))
+	return err
+}
+
+// Delete key. Missing keys (e.g. keys whose TTL expired) are not reported as an error.
+func (s *Etcd) delete(key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.client.Delete(key, false)
+	if isKeyNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,578 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	kafkaDriver "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// The service name reported to the metrics sink.
+const serviceName = "kafka"
+
+// Adapter is a singleton instance of a kafka service
+var Adapter *Kafka = &Kafka{
+	brokers:           []string{"localhost:9092"},
+	clientID:          "usrv",
+	connectionTimeout: time.Second * 1,
+	probeInterval:     time.Second * 10,
+	logger:            log.New(ioutil.Discard, "", log.LstdFlags),
+	dialPolicy:        dial.ExpBackoff(10, time.Millisecond),
+	metrics:           adapters.NopMetrics,
+	tracer:            adapters.NopTracer,
+	closeNotifier:     adapters.NewNotifier(),
+}
+
+type Kafka struct {
+
+	// The kafka brokers to connect to. Set manually by the user or discovered
+	// by a configuration service (e.g. etcd)
+	brokers []string
+
+	// The client ID reported to the brokers.
+	clientID string
+
+	// TLS settings.
+	useTLS        bool
+	tlsSkipVerify bool
+
+	// SASL settings; an empty mechanism disables SASL authentication.
+	saslMechanism string
+	saslUser      string
+	saslPassword  string
+
+	// Connection timeout
+	connectionTimeout time.Duration
+
+	// The interval between watchdog metadata probes. A zero value disables the watchdog.
+	probeInterval time.Duration
+
+	// A logger for service events.
+	logger *log.Logger
+
+	// An optional logger for tagging log lines with the fields of a context.
+	contextLogger adapters.ContextLogger
+
+	// A mutex protecting dial attempts.
+	sync.Mutex
+
+	// The dial policy to use.
+	dialPolicy dial.Policy
+
+	// A sink for dial and connection metrics.
+	metrics adapters.Metrics
+
+	// A tracer for creating spans around Dial and Config.
+	tracer adapters.Tracer
+
+	// An optional callback invoked when a dial gives up; see OnDialFailure.
+	onDialFailure func(attempts uint32, lastErr error)
+
+	// Connection status.
+	connected bool
+
+	// The error that caused the most recent dial to fail; nil if it succeeded.
+	lastErr error
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
+	// The dialer and transport used for connecting to the brokers. Both are
+	// populated by Dial using the current TLS and SASL settings.
+	dialer    *kafkaDriver.Dialer
+	transport *kafkaDriver.Transport
+
+	// A channel for stopping the watchdog.
+	stopWatchdog chan struct{}
+
+	// A notifier for close events.
+	closeNotifier *adapters.Notifier
+}
+
+// Connect to the service. If a dial policy has been specified,
+// the service will keep trying to reconnect until a connection
+// is established or the dial policy aborts the reconnection attempt.
+func (s *Kafka) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context. If ctx is cancelled while
+// waiting for the next dial attempt, the dial is aborted and ctx.Err() is returned.
+func (s *Kafka) DialContext(ctx context.Context) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// We are already connected
+	if s.connected {
+		return adapters.ErrAlreadyConnected
+	}
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Dial")
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+	span.SetAttribute("endpoint", strings.Join(s.brokers, ","))
+	attempts := 0
+	defer func() {
+		span.SetAttribute("attempts", attempts)
+		span.End(err)
+		s.lastErr = err
+	}()
+
+	mechanism, err := s.saslMechanismImpl()
+	if err != nil {
+		logger.Printf("[KAFKA] Invalid SASL settings: %v\n", err)
+		return err
+	}
+
+	var tlsConfig *tls.Config
+	if s.useTLS {
+		tlsConfig = &tls.Config{InsecureSkipVerify: s.tlsSkipVerify}
+	}
+
+	dialer := &kafkaDriver.Dialer{
+		ClientID:      s.clientID,
+		Timeout:       s.connectionTimeout,
+		DualStack:     true,
+		TLS:           tlsConfig,
+		SASLMechanism: mechanism,
+	}
+
+	defer dial.Release(s.dialPolicy)
+	s.dialPolicy.ResetAttempts()
+	if _, err = dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		logger.Printf("[KAFKA] Dial cancelled: %v\n", err)
+		return err
+	}
+	logger.Printf("[KAFKA] Connecting to brokers %s\n", s.brokers)
+	start := time.Now()
+	for {
+		s.metrics.IncDialAttempt(serviceName)
+		attempts++
+		err = probe(ctx, dialer, s.brokers)
+		dial.RecordOutcome(s.dialPolicy, err == nil)
+		if err == nil {
+			break
+		}
+
+		s.metrics.IncDialFailure(serviceName, err)
+		dialErr := err
+		err = dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			logger.Printf("[KAFKA] Could not connect to brokers %s; retrying in %v\n", s.brokers, wait)
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			logger.Printf("[KAFKA] Could not connect to brokers %s after %d attempt(s)\n", s.brokers, s.dialPolicy.CurAttempt())
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			if s.onDialFailure != nil {
+				go s.onDialFailure(uint32(attempts), dialErr)
+			}
+			return dial.ErrTimeout
+		case err != nil:
+			logger.Printf("[KAFKA] Dial cancelled: %v\n", err)
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			return err
+		}
+	}
+
+	s.dialer = dialer
+	s.transport = &kafkaDriver.Transport{
+		ClientID:    s.clientID,
+		DialTimeout: s.connectionTimeout,
+		TLS:         tlsConfig,
+		SASL:        mechanism,
+	}
+	s.connected = true
+	s.metrics.IncDialSuccess(serviceName)
+	s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+	s.metrics.SetConnected(serviceName, true)
+	s.dialPolicy.ResetAttempts()
+	logger.Printf("[KAFKA] Connected to brokers %s\n", s.brokers)
+
+	// Start watchdog
+	s.stopWatchdog = make(chan struct{})
+	if s.probeInterval > 0 {
+		go s.watchdog(dialer, s.brokers, s.stopWatchdog, s.probeInterval)
+	}
+
+	return nil
+}
+
+// Connect to the first reachable broker and request the cluster metadata.
+func probe(ctx context.Context, dialer *kafkaDriver.Dialer, brokers []string) error {
+	var err error
+	for _, broker := range brokers {
+		err = probeBroker(ctx, dialer, broker)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Connect to a broker and request the cluster metadata.
+func probeBroker(ctx context.Context, dialer *kafkaDriver.Dialer, broker string) error {
+	ctx, cancel := context.WithTimeout(ctx, dialer.Timeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(dialer.Timeout))
+	_, err = conn.Brokers()
+	return err
+}
+
+// Build the SASL mechanism for the configured SASL settings. This method is not
+// thread-safe so it should be invoked while holding the service lock.
+func (s *Kafka) saslMechanismImpl() (sasl.Mechanism, error) {
+	switch strings.ToLower(s.saslMechanism) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: s.saslUser, Password: s.saslPassword}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, s.saslUser, s.saslPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, s.saslUser, s.saslPassword)
+	}
+
+	return nil, fmt.Errorf("unsupported SASL mechanism: %s", s.saslMechanism)
+}
+
+// Disconnect.
+func (s *Kafka) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil
+	}
+
+	// Stop watchdog and notify any registered listeners
+	s.disconnect()
+	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	return nil
+}
+
+// Disconnect. Writers and readers are owned by the caller so there is nothing to wait
+// for; this is equivalent to Close.
+func (s *Kafka) CloseContext(ctx context.Context) error {
+	return s.Close()
+}
+
+// Stop the watchdog and release any idle transport connections. This method is
+// not thread-safe so it should be invoked while holding the service lock.
+func (s *Kafka) disconnect() {
+	close(s.stopWatchdog)
+	s.transport.CloseIdleConnections()
+	s.dialer = nil
+	s.transport = nil
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+}
+
+// Register a listener for receiving close notifications. The service adapter will emit an error and
+// close the channel if the service is cleanly shut down or close the channel if the connection is reset.
+func (s *Kafka) NotifyClose(c adapters.CloseListener) {
+	s.closeNotifier.Add(c)
+}
+
+// Register a callback that is invoked when a dial gives up because its dial policy
+// is exhausted. The callback receives the number of attempts and the error of the
+// last attempt. It fires once per failed dial rather than once per attempt and runs
+// on its own goroutine so it may call back into the service. Passing nil removes it.
+func (s *Kafka) OnDialFailure(fn func(attempts uint32, lastErr error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onDialFailure = fn
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *Kafka) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Log a warning when more than max close listeners are registered, which usually
+// indicates a listener leak; 0 disables the check. See adapters.MaxCloseListeners.
+func (s *Kafka) SetMaxCloseListeners(max int) {
+	s.closeNotifier.SetMaxListeners(max, func(count int) {
+		s.logger.Printf("[KAFKA] Possible close listener leak: %d listeners registered (max %d)\n", count, max)
+	})
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *Kafka) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Report whether the service is connected.
+func (s *Kafka) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Get the error that caused the most recent dial to fail; nil if the most recent
+// dial succeeded or no dial has been attempted yet.
+func (s *Kafka) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Apply a list of options to the service.
+func (s *Kafka) SetOptions(opts ...adapters.ServiceOption) error {
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register a logger instance for service events.
+func (s *Kafka) SetLogger(logger *log.Logger) {
+	s.logger = logger
+}
+
+// Get the logger registered via SetLogger. Implements the adapters.LoggerGetter interface.
+func (s *Kafka) Logger() *log.Logger {
+	return s.logger
+}
+
+// Attach a logger for tagging DialContext and ConfigContext log lines with the fields
+// of the supplied context. Passing nil restores plain logging.
+func (s *Kafka) SetContextLogger(logger adapters.ContextLogger) {
+	s.contextLogger = logger
+}
+
+// Set a dial policy for this service.
+func (s *Kafka) SetDialPolicy(policy dial.Policy) {
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Get the dial policy used by this service, e.g. for logging its settings via
+// dial.Describe or inspecting CurAttempt while a dial is in progress. The policy
+// is the copy stored by SetDialPolicy so it must not be passed to other services.
+func (s *Kafka) DialPolicy() dial.Policy {
+	return s.dialPolicy
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *Kafka) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+	s.metrics = m
+}
+
+// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+func (s *Kafka) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+	s.tracer = t
+}
+
+// Set the service configuration. Changing the configuration settings for an already connected
+// service will trigger a service shutdown. The service consumer is responsible for handing
+// service close events and triggering a re-dial.
+func (s *Kafka) Config(params map[string]string) error {
+	return s.ConfigContext(context.Background(), params)
+}
+
+// Apply configuration settings using the supplied context. If a context logger is
+// attached, log lines are written to the logger obtained for ctx; see Config.
+func (s *Kafka) ConfigContext(ctx context.Context, params map[string]string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Config")
+	defer func() { span.End(err) }()
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+
+	s.configChanged = false
+
+	needsReset := false
+
+	brokers, exists := params["brokers"]
+	if exists && brokers != strings.Join(s.brokers, ",") {
+		s.brokers = strings.Split(brokers, ",")
+		needsReset = true
+	}
+
+	clientID, exists := params["clientID"]
+	if exists && clientID != s.clientID {
+		s.clientID = clientID
+		needsReset = true
+	}
+
+	boolSettings := []struct {
+		name  string
+		value *bool
+	}{
+		{"tls", &s.useTLS},
+		{"tlsSkipVerify", &s.tlsSkipVerify},
+	}
+	for _, setting := range boolSettings {
+		val, exists := params[setting.name]
+		if !exists {
+			continue
+		}
+		boolVal, err := strconv.ParseBool(val)
+		if err != nil {
+			err := fmt.Errorf("invalid value for setting '%s': %s", setting.name, val)
+			logger.Printf("[KAFKA] Configuration error: %s\n", err.Error())
+			return err
+		}
+		if boolVal != *setting.value {
+			*setting.value = boolVal
+			needsReset = true
+		}
+	}
+
+	saslMechanism, exists := params["saslMechanism"]
+	if exists && saslMechanism != s.saslMechanism {
+		s.saslMechanism = saslMechanism
+		needsReset = true
+	}
+
+	saslUser, exists := params["saslUser"]
+	if exists && saslUser != s.saslUser {
+		s.saslUser = saslUser
+		needsReset = true
+	}
+
+	saslPassword, exists := params["saslPassword"]
+	if exists && saslPassword != s.saslPassword {
+		s.saslPassword = saslPassword
+		needsReset = true
+	}
+
+	timeoutVal, exists := params["connTimeout"]
+	if exists {
+		timeout, err := strconv.Atoi(timeoutVal)
+		if err != nil {
+			err := fmt.Errorf("invalid value for setting 'connTimeout': %s", timeoutVal)
+			logger.Printf("[KAFKA] Configuration error: %s\n", err.Error())
+			return err
+		}
+		if connectionTimeout := time.Duration(timeout) * time.Second; connectionTimeout != s.connectionTimeout {
+			s.connectionTimeout = connectionTimeout
+			needsReset = true
+		}
+	}
+
+	s.configChanged = needsReset
+	span.SetAttribute("reset", needsReset)
+	if needsReset {
+		logger.Printf("[KAFKA] Configuration changed; new settings: brokers=%s, clientID=%s, tls=%t, tlsSkipVerify=%t, saslMechanism=%s, saslUser=%s, saslPassword=%s, connTimeout=%v\n",
+			strings.Join(s.brokers, ","),
+			s.clientID,
+			s.useTLS,
+			s.tlsSkipVerify,
+			s.saslMechanism,
+			s.saslUser,
+			strings.Repeat("*", len(s.saslPassword)),
+			s.connectionTimeout,
+		)
+		if s.connected {
+			s.disconnect()
+			s.closeNotifier.NotifyAll(nil)
+		}
+	}
+
+	return nil
+}
+
+// Report whether the most recent Config call modified any setting and triggered a service reset.
+func (s *Kafka) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
+
+// Create a writer for publishing messages to topic. The caller is responsible for
+// closing the writer when done with it.
+func (s *Kafka) Writer(topic string) (*kafkaDriver.Writer, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil, adapters.ErrConnectionClosed
+	}
+
+	return &kafkaDriver.Writer{
+		Addr:      kafkaDriver.TCP(s.brokers...),
+		Topic:     topic,
+		Transport: s.transport,
+	}, nil
+}
+
+// Create a reader for consuming messages from topic as a member of the specified
+// consumer group. The caller is responsible for closing the reader when done with it.
+func (s *Kafka) Reader(topic, group string) (*kafkaDriver.Reader, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil, adapters.ErrConnectionClosed
+	}
+
+	return kafkaDriver.NewReader(kafkaDriver.ReaderConfig{
+		Brokers: s.brokers,
+		GroupID: group,
+		Topic:   topic,
+		Dialer:  s.dialer,
+	}), nil
+}
+
+// A worker that periodically requests the cluster metadata and resets the service
+// when none of the brokers are reachable.
+func (s *Kafka) watchdog(dialer *kafkaDriver.Dialer, brokers []string, stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := probe(context.Background(), dialer, brokers); err == nil {
+				continue
+			}
+
+			// Reset connection unless the service was closed or reconfigured in the meantime
+			s.Lock()
+			select {
+			case <-stop:
+			default:
+				s.disconnect()
+				s.closeNotifier.NotifyAll(nil)
+				s.logger.Printf("[KAFKA] Lost connection to brokers %s\n", brokers)
+			}
+			s.Unlock()
+			return
+		}
+	}
+}
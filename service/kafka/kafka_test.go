@@ -0,0 +1,189 @@
+package kafka
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	kafkaDriver "github.com/segmentio/kafka-go"
+)
+
+func newTestAdapter(brokers ...string) *Kafka {
+	return &Kafka{
+		brokers:           brokers,
+		clientID:          "usrv-test",
+		connectionTimeout: 100 * time.Millisecond,
+		logger:            Adapter.logger,
+		dialPolicy:        dial.Periodic(1, time.Millisecond),
+		metrics:           adapters.NopMetrics,
+		tracer:            adapters.NopTracer,
+		closeNotifier:     adapters.NewNotifier(),
+	}
+}
+
+func TestConfig(t *testing.T) {
+	srv := newTestAdapter()
+
+	err := srv.Config(map[string]string{
+		"brokers":       "10.0.0.1:9092,10.0.0.2:9092",
+		"clientID":      "svc",
+		"tls":           "true",
+		"tlsSkipVerify": "1",
+		"saslMechanism": "SCRAM-SHA-512",
+		"saslUser":      "user",
+		"saslPassword":  "secret",
+		"connTimeout":   "3",
+	})
+	if err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+
+	if strings.Join(srv.brokers, ",") != "10.0.0.1:9092,10.0.0.2:9092" {
+		t.Fatalf("Expected brokers to be [10.0.0.1:9092 10.0.0.2:9092]; got %v", srv.brokers)
+	}
+	if srv.clientID != "svc" {
+		t.Fatalf("Expected clientID to be svc; got %s", srv.clientID)
+	}
+	if !srv.useTLS || !srv.tlsSkipVerify {
+		t.Fatalf("Expected tls and tlsSkipVerify to be enabled")
+	}
+	if srv.connectionTimeout != 3*time.Second {
+		t.Fatalf("Expected connTimeout to be 3s; got %v", srv.connectionTimeout)
+	}
+
+	mechanism, err := srv.saslMechanismImpl()
+	if err != nil {
+		t.Fatalf("Expected SASL mechanism to be valid; got %v", err)
+	}
+	if mechanism.Name() != "SCRAM-SHA-512" {
+		t.Fatalf("Expected SASL mechanism to be SCRAM-SHA-512; got %s", mechanism.Name())
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	srv := newTestAdapter()
+
+	invalidSettings := []map[string]string{
+		{"tls": "maybe"},
+		{"tlsSkipVerify": "maybe"},
+		{"connTimeout": "soon"},
+	}
+	for _, params := range invalidSettings {
+		if err := srv.Config(params); err == nil {
+			t.Fatalf("Expected Config(%v) to fail", params)
+		}
+	}
+
+	srv.Config(map[string]string{"saslMechanism": "gssapi"})
+	err := srv.Dial()
+	if err == nil || !strings.Contains(err.Error(), "unsupported SASL mechanism") {
+		t.Fatalf("Expected Dial to fail with an unsupported SASL mechanism error; got %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	err := srv.Dial()
+	if err != dial.ErrTimeout {
+		t.Fatalf("Expected Dial to fail with ErrTimeout; got %v", err)
+	}
+
+	_, err = srv.Writer("test")
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected Writer() to return ErrConnectionClosed; got %v", err)
+	}
+
+	_, err = srv.Reader("test", "group")
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected Reader() to return ErrConnectionClosed; got %v", err)
+	}
+}
+
+func TestWatchdogResetsService(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	// Simulate a connected service whose brokers are no longer reachable
+	dialer := &kafkaDriver.Dialer{Timeout: srv.connectionTimeout}
+	srv.dialer = dialer
+	srv.transport = &kafkaDriver.Transport{}
+	srv.connected = true
+	srv.stopWatchdog = make(chan struct{})
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	go srv.watchdog(dialer, srv.brokers, srv.stopWatchdog, 10*time.Millisecond)
+
+	select {
+	case err, ok := <-listener:
+		if ok {
+			t.Fatalf("Expected listener to be closed without an error; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for a connection reset")
+	}
+
+	_, err := srv.Writer("test")
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected Writer() to return ErrConnectionClosed after a reset; got %v", err)
+	}
+}
+
+// Requires a running kafka broker; set USRV_KAFKA_BROKERS to enable.
+func TestWriteAndRead(t *testing.T) {
+	brokers := os.Getenv("USRV_KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("USRV_KAFKA_BROKERS not set")
+	}
+
+	srv := newTestAdapter(strings.Split(brokers, ",")...)
+	srv.connectionTimeout = 5 * time.Second
+	err := srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	topic := "usrv-test-" + time.Now().Format("20060102150405")
+	writer, err := srv.Writer(topic)
+	if err != nil {
+		t.Fatalf("Expected Writer() to succeed; got %v", err)
+	}
+	defer writer.Close()
+	writer.AllowAutoTopicCreation = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Retry until the auto-created topic has a leader
+	msg := kafkaDriver.Message{Value: []byte("hello")}
+	for {
+		err = writer.WriteMessages(ctx, msg)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Expected WriteMessages to succeed; got %v", err)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+
+	reader, err := srv.Reader(topic, "")
+	if err != nil {
+		t.Fatalf("Expected Reader() to succeed; got %v", err)
+	}
+	defer reader.Close()
+
+	got, err := reader.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("Expected ReadMessage to succeed; got %v", err)
+	}
+	if string(got.Value) != "hello" {
+		t.Fatalf("Expected message value to be hello; got %s", got.Value)
+	}
+}
@@ -0,0 +1,444 @@
+package memcached
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	// The key used for probing server reachability. It does not need to exist.
+	probeKey = "__usrv_probe__"
+)
+
+// The service name reported to the metrics sink.
+const serviceName = "memcached"
+
+// Adapter is a singleton instance of a memcached service
+var Adapter *Memcached = &Memcached{
+	servers:       []string{"localhost:11211"},
+	timeout:       memcache.DefaultTimeout,
+	probeInterval: time.Second * 5,
+	probeFailures: 3,
+	logger:        log.New(ioutil.Discard, "", log.LstdFlags),
+	dialPolicy:    dial.ExpBackoff(10, time.Millisecond),
+	metrics:       adapters.NopMetrics,
+	tracer:        adapters.NopTracer,
+	closeNotifier: adapters.NewNotifier(),
+}
+
+type Memcached struct {
+
+	// The memcached servers to connect to. Set manually by the user or discovered
+	// by a configuration service (e.g. etcd)
+	servers []string
+
+	// Socket read/write timeout.
+	timeout time.Duration
+
+	// The interval between watchdog probes. A zero value disables the watchdog.
+	probeInterval time.Duration
+
+	// The number of consecutive failed probes that trigger a service reset.
+	probeFailures int
+
+	// A logger for service events.
+	logger *log.Logger
+
+	// An optional logger for tagging log lines with the fields of a context.
+	contextLogger adapters.ContextLogger
+
+	// A mutex protecting dial attempts.
+	sync.Mutex
+
+	// The dial policy to use.
+	dialPolicy dial.Policy
+
+	// A sink for dial and connection metrics.
+	metrics adapters.Metrics
+
+	// A tracer for creating spans around Dial and Config.
+	tracer adapters.Tracer
+
+	// An optional callback invoked when a dial gives up; see OnDialFailure.
+	onDialFailure func(attempts uint32, lastErr error)
+
+	// Connection status.
+	connected bool
+
+	// The error that caused the most recent dial to fail; nil if it succeeded.
+	lastErr error
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
+	// The memcached client. The client maintains its own connection pool.
+	client *memcache.Client
+
+	// A channel for stopping the watchdog.
+	stopWatchdog chan struct{}
+
+	// A notifier for close events.
+	closeNotifier *adapters.Notifier
+}
+
+// Connect to the service. If a dial policy has been specified,
+// the service will keep trying to reconnect until a connection
+// is established or the dial policy aborts the reconnection attempt.
+func (s *Memcached) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context. If ctx is cancelled while
+// waiting for the next dial attempt, the dial is aborted and ctx.Err() is returned.
+func (s *Memcached) DialContext(ctx context.Context) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// We are already connected
+	if s.connected {
+		return adapters.ErrAlreadyConnected
+	}
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Dial")
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+	span.SetAttribute("endpoint", strings.Join(s.servers, ","))
+	attempts := 0
+	defer func() {
+		span.SetAttribute("attempts", attempts)
+		span.End(err)
+		s.lastErr = err
+	}()
+
+	client := memcache.New(s.servers...)
+	client.Timeout = s.timeout
+
+	defer dial.Release(s.dialPolicy)
+	s.dialPolicy.ResetAttempts()
+	if _, err = dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		logger.Printf("[MEMCACHED] Dial cancelled: %v\n", err)
+		client.Close()
+		return err
+	}
+	logger.Printf("[MEMCACHED] Connecting to servers %s\n", s.servers)
+	start := time.Now()
+	for {
+		s.metrics.IncDialAttempt(serviceName)
+		attempts++
+		err = probe(client)
+		dial.RecordOutcome(s.dialPolicy, err == nil)
+		if err == nil {
+			break
+		}
+
+		s.metrics.IncDialFailure(serviceName, err)
+		dialErr := err
+		err = dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			logger.Printf("[MEMCACHED] Could not connect to servers %s; retrying in %v\n", s.servers, wait)
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			logger.Printf("[MEMCACHED] Could not connect to servers %s after %d attempt(s)\n", s.servers, s.dialPolicy.CurAttempt())
+			client.Close()
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			if s.onDialFailure != nil {
+				go s.onDialFailure(uint32(attempts), dialErr)
+			}
+			return dial.ErrTimeout
+		case err != nil:
+			logger.Printf("[MEMCACHED] Dial cancelled: %v\n", err)
+			client.Close()
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			return err
+		}
+	}
+
+	s.client = client
+	s.connected = true
+	s.metrics.IncDialSuccess(serviceName)
+	s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+	s.metrics.SetConnected(serviceName, true)
+	s.dialPolicy.ResetAttempts()
+	logger.Printf("[MEMCACHED] Connected to servers %s\n", s.servers)
+
+	// Start watchdog
+	s.stopWatchdog = make(chan struct{})
+	if s.probeInterval > 0 {
+		go s.watchdog(client, s.stopWatchdog, s.probeInterval, s.probeFailures)
+	}
+
+	return nil
+}
+
+// Check whether the server responsible for probeKey is reachable. A cache miss
+// indicates a healthy server.
+func probe(client *memcache.Client) error {
+	_, err := client.Get(probeKey)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+// Disconnect.
+func (s *Memcached) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil
+	}
+
+	// Close connection and notify any registered listeners
+	err := s.disconnect()
+	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	return err
+}
+
+// Disconnect. The memcached client manages its own connections so there is nothing to wait
+// for; this is equivalent to Close.
+func (s *Memcached) CloseContext(ctx context.Context) error {
+	return s.Close()
+}
+
+// Stop the watchdog and close any idle client connections. This method is not
+// thread-safe so it should be invoked while holding the service lock.
+func (s *Memcached) disconnect() error {
+	close(s.stopWatchdog)
+	err := s.client.Close()
+	s.client = nil
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+	return err
+}
+
+// Register a listener for receiving close notifications. The service adapter will emit an error and
+// close the channel if the service is cleanly shut down or close the channel if the connection is reset.
+func (s *Memcached) NotifyClose(c adapters.CloseListener) {
+	s.closeNotifier.Add(c)
+}
+
+// Register a callback that is invoked when a dial gives up because its dial policy
+// is exhausted. The callback receives the number of attempts and the error of the
+// last attempt. It fires once per failed dial rather than once per attempt and runs
+// on its own goroutine so it may call back into the service. Passing nil removes it.
+func (s *Memcached) OnDialFailure(fn func(attempts uint32, lastErr error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onDialFailure = fn
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *Memcached) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Log a warning when more than max close listeners are registered, which usually
+// indicates a listener leak; 0 disables the check. See adapters.MaxCloseListeners.
+func (s *Memcached) SetMaxCloseListeners(max int) {
+	s.closeNotifier.SetMaxListeners(max, func(count int) {
+		s.logger.Printf("[MEMCACHED] Possible close listener leak: %d listeners registered (max %d)\n", count, max)
+	})
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *Memcached) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Report whether the service is connected.
+func (s *Memcached) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Get the error that caused the most recent dial to fail; nil if the most recent
+// dial succeeded or no dial has been attempted yet.
+func (s *Memcached) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Apply a list of options to the service.
+func (s *Memcached) SetOptions(opts ...adapters.ServiceOption) error {
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register a logger instance for service events.
+func (s *Memcached) SetLogger(logger *log.Logger) {
+	s.logger = logger
+}
+
+// Get the logger registered via SetLogger. Implements the adapters.LoggerGetter interface.
+func (s *Memcached) Logger() *log.Logger {
+	return s.logger
+}
+
+// Attach a logger for tagging DialContext and ConfigContext log lines with the fields
+// of the supplied context. Passing nil restores plain logging.
+func (s *Memcached) SetContextLogger(logger adapters.ContextLogger) {
+	s.contextLogger = logger
+}
+
+// Set a dial policy for this service.
+func (s *Memcached) SetDialPolicy(policy dial.Policy) {
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Get the dial policy used by this service, e.g. for logging its settings via
+// dial.Describe or inspecting CurAttempt while a dial is in progress. The policy
+// is the copy stored by SetDialPolicy so it must not be passed to other services.
+func (s *Memcached) DialPolicy() dial.Policy {
+	return s.dialPolicy
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *Memcached) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+	s.metrics = m
+}
+
+// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+func (s *Memcached) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+	s.tracer = t
+}
+
+// Set the service configuration. Changing the configuration settings for an already connected
+// service will trigger a service shutdown. The service consumer is responsible for handing
+// service close events and triggering a re-dial.
+func (s *Memcached) Config(params map[string]string) error {
+	return s.ConfigContext(context.Background(), params)
+}
+
+// Apply configuration settings using the supplied context. If a context logger is
+// attached, log lines are written to the logger obtained for ctx; see Config.
+func (s *Memcached) ConfigContext(ctx context.Context, params map[string]string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Config")
+	defer func() { span.End(err) }()
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+
+	s.configChanged = false
+
+	needsReset := false
+
+	servers, exists := params["servers"]
+	if exists && servers != strings.Join(s.servers, ",") {
+		s.servers = strings.Split(servers, ",")
+		needsReset = true
+	}
+
+	timeoutVal, exists := params["timeout"]
+	if exists {
+		timeout, err := strconv.Atoi(timeoutVal)
+		if err != nil {
+			err := fmt.Errorf("invalid value for setting 'timeout': %s", timeoutVal)
+			logger.Printf("[MEMCACHED] Configuration error: %s\n", err.Error())
+			return err
+		}
+		if timeout := time.Duration(timeout) * time.Millisecond; timeout != s.timeout {
+			s.timeout = timeout
+			needsReset = true
+		}
+	}
+
+	s.configChanged = needsReset
+	span.SetAttribute("reset", needsReset)
+	if needsReset {
+		logger.Printf("[MEMCACHED] Configuration changed; new settings: servers=%s, timeout=%v\n",
+			strings.Join(s.servers, ","),
+			s.timeout,
+		)
+		if s.connected {
+			s.disconnect()
+			s.closeNotifier.NotifyAll(nil)
+		}
+	}
+
+	return nil
+}
+
+// Report whether the most recent Config call modified any setting and triggered a service reset.
+func (s *Memcached) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
+
+// Get the memcached client. The client is safe for concurrent use.
+func (s *Memcached) Client() (*memcache.Client, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil, adapters.ErrConnectionClosed
+	}
+
+	return s.client, nil
+}
+
+// A worker that periodically probes the servers and resets the service after
+// maxFailures consecutive failed probes.
+func (s *Memcached) watchdog(client *memcache.Client, stop chan struct{}, interval time.Duration, maxFailures int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := probe(client); err == nil {
+				failures = 0
+				continue
+			}
+
+			failures++
+			if failures < maxFailures {
+				continue
+			}
+
+			// Reset connection unless the service was closed or reconfigured in the meantime
+			s.Lock()
+			select {
+			case <-stop:
+			default:
+				s.disconnect()
+				s.closeNotifier.NotifyAll(nil)
+				s.logger.Printf("[MEMCACHED] Lost connection to servers %s\n", s.servers)
+			}
+			s.Unlock()
+			return
+		}
+	}
+}
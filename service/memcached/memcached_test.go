@@ -0,0 +1,151 @@
+package memcached
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+)
+
+// A fake memcached server that responds to every get request with a cache miss.
+type fakeServer struct {
+	listener net.Listener
+
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting fake memcached server: %v", err)
+	}
+
+	server := &fakeServer{listener: listener}
+	go server.serve()
+	t.Cleanup(server.stop)
+	return server
+}
+
+func (f *fakeServer) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeServer) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		f.mutex.Lock()
+		f.conns = append(f.conns, conn)
+		f.mutex.Unlock()
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+			for {
+				line, err := rw.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if strings.HasPrefix(line, "get") {
+					rw.WriteString("END\r\n")
+					rw.Flush()
+				}
+			}
+		}(conn)
+	}
+}
+
+func (f *fakeServer) stop() {
+	f.listener.Close()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func newTestAdapter(servers ...string) *Memcached {
+	return &Memcached{
+		servers:       servers,
+		timeout:       100 * time.Millisecond,
+		probeFailures: 2,
+		logger:        Adapter.logger,
+		dialPolicy:    dial.Periodic(2, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
+	}
+}
+
+func TestDialAndClose(t *testing.T) {
+	server := newFakeServer(t)
+
+	srv := newTestAdapter(server.addr())
+	err := srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	client, err := srv.Client()
+	if err != nil || client == nil {
+		t.Fatalf("Expected Client() to return the client; got %v, %v", client, err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	srv.Close()
+	if err := <-listener; err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected to receive ErrConnectionClosed; got %v", err)
+	}
+
+	_, err = srv.Client()
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected Client() to return ErrConnectionClosed after Close; got %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	server := newFakeServer(t)
+	addr := server.addr()
+	server.stop()
+
+	srv := newTestAdapter(addr)
+	err := srv.Dial()
+	if err != dial.ErrTimeout {
+		t.Fatalf("Expected Dial to fail with ErrTimeout; got %v", err)
+	}
+}
+
+func TestWatchdogResetsOnSustainedFailure(t *testing.T) {
+	server := newFakeServer(t)
+
+	srv := newTestAdapter(server.addr())
+	srv.probeInterval = time.Millisecond
+	err := srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	server.stop()
+
+	select {
+	case err, ok := <-listener:
+		if ok {
+			t.Fatalf("Expected listener to be closed without an error; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the watchdog to reset the connection")
+	}
+}
@@ -0,0 +1,500 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	"go.mongodb.org/mongo-driver/event"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// The service name reported to the metrics sink.
+const serviceName = "mongo"
+
+// Adapter is a singleton instance of a mongo service
+var Adapter *Mongo = &Mongo{
+	uri:                    "mongodb://localhost:27017",
+	database:               "test",
+	connectionTimeout:      time.Second * 1,
+	serverSelectionTimeout: time.Second * 1,
+	logger:                 log.New(ioutil.Discard, "", log.LstdFlags),
+	dialPolicy:             dial.ExpBackoff(10, time.Millisecond),
+	metrics:                adapters.NopMetrics,
+	tracer:                 adapters.NopTracer,
+	closeNotifier:          adapters.NewNotifier(),
+}
+
+type Mongo struct {
+
+	// The mongo connection URI. Set manually by the user or discovered
+	// by a configuration service (e.g. etcd)
+	uri string
+
+	// The name of the database returned by Database().
+	database string
+
+	// Connection timeout
+	connectionTimeout time.Duration
+
+	// The max time to wait for a suitable server before failing an operation.
+	serverSelectionTimeout time.Duration
+
+	// A logger for service events.
+	logger *log.Logger
+
+	// An optional logger for tagging log lines with the fields of a context.
+	contextLogger adapters.ContextLogger
+
+	// A mutex protecting dial attempts.
+	sync.Mutex
+
+	// The dial policy to use.
+	dialPolicy dial.Policy
+
+	// A sink for dial and connection metrics.
+	metrics adapters.Metrics
+
+	// A tracer for creating spans around Dial and Config.
+	tracer adapters.Tracer
+
+	// An optional callback invoked when a dial gives up; see OnDialFailure.
+	onDialFailure func(attempts uint32, lastErr error)
+
+	// Connection status.
+	connected bool
+
+	// The error that caused the most recent dial to fail; nil if it succeeded.
+	lastErr error
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
+	// Mongo client handle.
+	client *mongoDriver.Client
+
+	// A counter incremented on each successful dial. It allows pool monitor
+	// callbacks to detect events emitted by stale clients.
+	generation uint64
+
+	// A notifier for close events.
+	closeNotifier *adapters.Notifier
+}
+
+// Connect to the service. If a dial policy has been specified,
+// the service will keep trying to reconnect until a connection
+// is established or the dial policy aborts the reconnection attempt.
+func (s *Mongo) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context. If ctx is cancelled while
+// waiting for the next dial attempt, the dial is aborted and ctx.Err() is returned.
+func (s *Mongo) DialContext(ctx context.Context) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// We are already connected
+	if s.connected {
+		return adapters.ErrAlreadyConnected
+	}
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Dial")
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+	span.SetAttribute("endpoint", s.serverName())
+	attempts := 0
+	defer func() {
+		span.SetAttribute("attempts", attempts)
+		span.End(err)
+		s.lastErr = err
+	}()
+
+	generation := s.generation + 1
+	opts := options.Client().
+		ApplyURI(s.uri).
+		SetConnectTimeout(s.connectionTimeout).
+		SetServerSelectionTimeout(s.serverSelectionTimeout).
+		SetPoolMonitor(&event.PoolMonitor{
+			Event: func(evt *event.PoolEvent) {
+				s.onPoolEvent(generation, evt)
+			},
+		})
+
+	client, err := mongoDriver.Connect(ctx, opts)
+	if err != nil {
+		logger.Printf("[MONGO] Invalid client options: %v\n", err)
+		return err
+	}
+
+	defer dial.Release(s.dialPolicy)
+	s.dialPolicy.ResetAttempts()
+	if _, err = dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		logger.Printf("[MONGO] Dial cancelled: %v\n", err)
+		client.Disconnect(context.Background())
+		return err
+	}
+	logger.Printf("[MONGO] Connecting to %s\n", s.serverName())
+	start := time.Now()
+	for {
+		s.metrics.IncDialAttempt(serviceName)
+		attempts++
+		err = s.ping(ctx, client)
+		dial.RecordOutcome(s.dialPolicy, err == nil)
+		if err == nil {
+			break
+		}
+
+		s.metrics.IncDialFailure(serviceName, err)
+		dialErr := err
+		err = dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			logger.Printf("[MONGO] Could not connect to %s; retrying in %v\n", s.serverName(), wait)
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			logger.Printf("[MONGO] Could not connect to %s after %d attempt(s)\n", s.serverName(), s.dialPolicy.CurAttempt())
+			client.Disconnect(context.Background())
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			if s.onDialFailure != nil {
+				go s.onDialFailure(uint32(attempts), dialErr)
+			}
+			return dial.ErrTimeout
+		case err != nil:
+			logger.Printf("[MONGO] Dial cancelled: %v\n", err)
+			client.Disconnect(context.Background())
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			return err
+		}
+	}
+
+	s.client = client
+	s.generation = generation
+	s.connected = true
+	s.metrics.IncDialSuccess(serviceName)
+	s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+	s.metrics.SetConnected(serviceName, true)
+	s.dialPolicy.ResetAttempts()
+	logger.Printf("[MONGO] Connected to %s\n", s.serverName())
+
+	return nil
+}
+
+// Ping the primary using the configured server selection timeout. This method is
+// not thread-safe so it should be invoked while holding the service lock.
+func (s *Mongo) ping(ctx context.Context, client *mongoDriver.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, s.serverSelectionTimeout)
+	defer cancel()
+
+	return client.Ping(ctx, readpref.Primary())
+}
+
+// Disconnect.
+func (s *Mongo) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil
+	}
+
+	// Close connection and notify any registered listeners
+	s.disconnect()
+	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	return nil
+}
+
+// Disconnect after waiting for any in-use connections to be returned to the pool.
+// New requests are rejected while waiting. If ctx expires before all connections
+// are returned, they are forcibly closed and an error is returned.
+func (s *Mongo) CloseContext(ctx context.Context) error {
+	s.Lock()
+	if !s.connected {
+		s.Unlock()
+		return nil
+	}
+
+	client := s.client
+	s.client = nil
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+	s.Unlock()
+
+	err := client.Disconnect(ctx)
+	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	return err
+}
+
+// Disconnect the client. This method is not thread-safe so it should be invoked
+// while holding the service lock.
+func (s *Mongo) disconnect() {
+	client := s.client
+	s.client = nil
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+
+	// Disconnect blocks until in-flight operations complete so run it in the background
+	go client.Disconnect(context.Background())
+}
+
+// Register a listener for receiving close notifications. The service adapter will emit an error and
+// close the channel if the service is cleanly shut down or close the channel if the connection is reset.
+func (s *Mongo) NotifyClose(c adapters.CloseListener) {
+	s.closeNotifier.Add(c)
+}
+
+// Register a callback that is invoked when a dial gives up because its dial policy
+// is exhausted. The callback receives the number of attempts and the error of the
+// last attempt. It fires once per failed dial rather than once per attempt and runs
+// on its own goroutine so it may call back into the service. Passing nil removes it.
+func (s *Mongo) OnDialFailure(fn func(attempts uint32, lastErr error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onDialFailure = fn
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *Mongo) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Log a warning when more than max close listeners are registered, which usually
+// indicates a listener leak; 0 disables the check. See adapters.MaxCloseListeners.
+func (s *Mongo) SetMaxCloseListeners(max int) {
+	s.closeNotifier.SetMaxListeners(max, func(count int) {
+		s.logger.Printf("[MONGO] Possible close listener leak: %d listeners registered (max %d)\n", count, max)
+	})
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *Mongo) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Report whether the service is connected.
+func (s *Mongo) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Get the error that caused the most recent dial to fail; nil if the most recent
+// dial succeeded or no dial has been attempted yet.
+func (s *Mongo) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Apply a list of options to the service.
+func (s *Mongo) SetOptions(opts ...adapters.ServiceOption) error {
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register a logger instance for service events.
+func (s *Mongo) SetLogger(logger *log.Logger) {
+	s.logger = logger
+}
+
+// Get the logger registered via SetLogger. Implements the adapters.LoggerGetter interface.
+func (s *Mongo) Logger() *log.Logger {
+	return s.logger
+}
+
+// Attach a logger for tagging DialContext and ConfigContext log lines with the fields
+// of the supplied context. Passing nil restores plain logging.
+func (s *Mongo) SetContextLogger(logger adapters.ContextLogger) {
+	s.contextLogger = logger
+}
+
+// Set a dial policy for this service.
+func (s *Mongo) SetDialPolicy(policy dial.Policy) {
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Get the dial policy used by this service, e.g. for logging its settings via
+// dial.Describe or inspecting CurAttempt while a dial is in progress. The policy
+// is the copy stored by SetDialPolicy so it must not be passed to other services.
+func (s *Mongo) DialPolicy() dial.Policy {
+	return s.dialPolicy
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *Mongo) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+	s.metrics = m
+}
+
+// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+func (s *Mongo) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+	s.tracer = t
+}
+
+// Set the service configuration. Changing the configuration settings for an already connected
+// service will trigger a service shutdown. The service consumer is responsible for handing
+// service close events and triggering a re-dial.
+func (s *Mongo) Config(params map[string]string) error {
+	return s.ConfigContext(context.Background(), params)
+}
+
+// Apply configuration settings using the supplied context. If a context logger is
+// attached, log lines are written to the logger obtained for ctx; see Config.
+func (s *Mongo) ConfigContext(ctx context.Context, params map[string]string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Config")
+	defer func() { span.End(err) }()
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+
+	s.configChanged = false
+
+	needsReset := false
+
+	uri, exists := params["uri"]
+	if exists && uri != s.uri {
+		s.uri = uri
+		needsReset = true
+	}
+
+	database, exists := params["database"]
+	if exists && database != s.database {
+		s.database = database
+		needsReset = true
+	}
+
+	timeoutVal, exists := params["connTimeout"]
+	if exists {
+		timeout, err := strconv.Atoi(timeoutVal)
+		if err != nil {
+			err := fmt.Errorf("invalid value for setting 'connTimeout': %s", timeoutVal)
+			logger.Printf("[MONGO] Configuration error: %s\n", err.Error())
+			return err
+		}
+		if connectionTimeout := time.Duration(timeout) * time.Second; connectionTimeout != s.connectionTimeout {
+			s.connectionTimeout = connectionTimeout
+			needsReset = true
+		}
+	}
+
+	selectionTimeoutVal, exists := params["serverSelectionTimeout"]
+	if exists {
+		timeout, err := strconv.Atoi(selectionTimeoutVal)
+		if err != nil {
+			err := fmt.Errorf("invalid value for setting 'serverSelectionTimeout': %s", selectionTimeoutVal)
+			logger.Printf("[MONGO] Configuration error: %s\n", err.Error())
+			return err
+		}
+		if serverSelectionTimeout := time.Duration(timeout) * time.Second; serverSelectionTimeout != s.serverSelectionTimeout {
+			s.serverSelectionTimeout = serverSelectionTimeout
+			needsReset = true
+		}
+	}
+
+	s.configChanged = needsReset
+	span.SetAttribute("reset", needsReset)
+	if needsReset {
+		logger.Printf("[MONGO] Configuration changed; new settings: uri=%s, database=%s, connTimeout=%v, serverSelectionTimeout=%v\n",
+			s.serverName(),
+			s.database,
+			s.connectionTimeout,
+			s.serverSelectionTimeout,
+		)
+		if s.connected {
+			s.disconnect()
+			s.closeNotifier.NotifyAll(nil)
+		}
+	}
+
+	return nil
+}
+
+// Report whether the most recent Config call modified any setting and triggered a service reset.
+func (s *Mongo) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
+
+// Get a handle to the configured database.
+func (s *Mongo) Database() (*mongoDriver.Database, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil, adapters.ErrConnectionClosed
+	}
+
+	return s.client.Database(s.database), nil
+}
+
+// Invoked by the driver's pool monitor. When the driver clears a connection pool
+// after detecting a network error, the service is reset so that consumers can re-dial.
+func (s *Mongo) onPoolEvent(generation uint64, evt *event.PoolEvent) {
+	if evt.Type != event.PoolCleared {
+		return
+	}
+
+	// Monitor callbacks run on driver goroutines; reset asynchronously to avoid
+	// calling back into the driver from within the callback.
+	go func() {
+		s.Lock()
+		defer s.Unlock()
+
+		// Ignore events from clients that were closed or replaced in the meantime
+		if !s.connected || s.generation != generation {
+			return
+		}
+
+		s.disconnect()
+		s.closeNotifier.NotifyAll(nil)
+		s.logger.Printf("[MONGO] Lost connection to %s (%s)\n", evt.Address, evt.Reason)
+	}()
+}
+
+// Get the hosts and database of the configured uri without the credentials and
+// connection options so that it can be safely logged and attached to trace spans.
+// This method is not thread-safe so it should be invoked while holding the service lock.
+func (s *Mongo) serverName() string {
+	return redactURI(s.uri)
+}
+
+// Strip the user info and options (which may also contain credentials) from a
+// mongo connection string. Connection strings may list multiple hosts so they are
+// processed as plain strings rather than parsed as URLs.
+func redactURI(uri string) string {
+	scheme, rest := "", uri
+	if idx := strings.Index(uri, "://"); idx != -1 {
+		scheme, rest = uri[:idx+3], uri[idx+3:]
+	}
+	if idx := strings.IndexByte(rest, '?'); idx != -1 {
+		rest = rest[:idx]
+	}
+	if idx := strings.LastIndexByte(rest, '@'); idx != -1 {
+		rest = rest[idx+1:]
+	}
+	return scheme + rest
+}
@@ -0,0 +1,495 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	natsDriver "github.com/nats-io/nats.go"
+)
+
+// The service name reported to the metrics sink.
+const serviceName = "nats"
+
+// Adapter is a singleton instance of a nats service
+var Adapter *Nats = &Nats{
+	servers:           []string{natsDriver.DefaultURL},
+	connectionTimeout: time.Second * 1,
+	maxReconnects:     natsDriver.DefaultMaxReconnect,
+	logger:            log.New(ioutil.Discard, "", log.LstdFlags),
+	dialPolicy:        dial.ExpBackoff(10, time.Millisecond),
+	metrics:           adapters.NopMetrics,
+	tracer:            adapters.NopTracer,
+	closeNotifier:     adapters.NewNotifier(),
+}
+
+type Nats struct {
+
+	// The nats server URLs to connect to. Set manually by the user or discovered
+	// by a configuration service (e.g. etcd)
+	servers []string
+
+	// Connection timeout
+	connectionTimeout time.Duration
+
+	// The number of reconnect attempts performed by the nats client after
+	// losing the connection. A negative value retries forever.
+	maxReconnects int
+
+	// A logger for service events.
+	logger *log.Logger
+
+	// An optional logger for tagging log lines with the fields of a context.
+	contextLogger adapters.ContextLogger
+
+	// A mutex protecting dial attempts.
+	sync.Mutex
+
+	// The dial policy to use.
+	dialPolicy dial.Policy
+
+	// A sink for dial and connection metrics.
+	metrics adapters.Metrics
+
+	// A tracer for creating spans around Dial and Config.
+	tracer adapters.Tracer
+
+	// An optional callback invoked when a dial gives up; see OnDialFailure.
+	onDialFailure func(attempts uint32, lastErr error)
+
+	// Connection status.
+	connected bool
+
+	// The error that caused the most recent dial to fail; nil if it succeeded.
+	lastErr error
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
+	// Nats connection handle.
+	conn *natsDriver.Conn
+
+	// A notifier for close events.
+	closeNotifier *adapters.Notifier
+}
+
+// Connect to the service. If a dial policy has been specified,
+// the service will keep trying to reconnect until a connection
+// is established or the dial policy aborts the reconnection attempt.
+//
+// Once connected, the nats client transparently reconnects if the connection
+// is lost. If all reconnect attempts fail, the service is reset.
+func (s *Nats) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context. If ctx is cancelled while
+// waiting for the next dial attempt, the dial is aborted and ctx.Err() is returned.
+func (s *Nats) DialContext(ctx context.Context) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// We are already connected
+	if s.connected {
+		return adapters.ErrAlreadyConnected
+	}
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Dial")
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+	span.SetAttribute("endpoint", redactServers(s.servers))
+	attempts := 0
+	defer func() {
+		span.SetAttribute("attempts", attempts)
+		span.End(err)
+		s.lastErr = err
+	}()
+
+	endpoint, redacted := strings.Join(s.servers, ","), redactServers(s.servers)
+	opts := []natsDriver.Option{
+		natsDriver.Timeout(s.connectionTimeout),
+		natsDriver.MaxReconnects(s.maxReconnects),
+		natsDriver.DisconnectErrHandler(s.onDisconnect),
+		natsDriver.ReconnectHandler(s.onReconnect),
+		natsDriver.ClosedHandler(s.onClosed),
+	}
+
+	defer dial.Release(s.dialPolicy)
+	s.dialPolicy.ResetAttempts()
+	if _, err = dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		logger.Printf("[NATS] Dial cancelled: %v\n", err)
+		return err
+	}
+	logger.Printf("[NATS] Connecting to endpoint %s\n", redacted)
+	start := time.Now()
+	for {
+		s.metrics.IncDialAttempt(serviceName)
+		attempts++
+		s.conn, err = natsDriver.Connect(endpoint, opts...)
+		dial.RecordOutcome(s.dialPolicy, err == nil)
+		if err == nil {
+			break
+		}
+
+		s.metrics.IncDialFailure(serviceName, err)
+		dialErr := err
+		err = dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			logger.Printf("[NATS] Could not connect to endpoint %s; retrying in %v\n", redacted, wait)
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			logger.Printf("[NATS] Could not connect to endpoint %s after %d attempt(s)\n", redacted, s.dialPolicy.CurAttempt())
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			if s.onDialFailure != nil {
+				go s.onDialFailure(uint32(attempts), dialErr)
+			}
+			return dial.ErrTimeout
+		case err != nil:
+			logger.Printf("[NATS] Dial cancelled: %v\n", err)
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			return err
+		}
+	}
+
+	s.connected = true
+	s.metrics.IncDialSuccess(serviceName)
+	s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+	s.metrics.SetConnected(serviceName, true)
+	s.dialPolicy.ResetAttempts()
+	logger.Printf("[NATS] Connected to endpoint %s\n", s.conn.ConnectedUrlRedacted())
+
+	return nil
+}
+
+// Disconnect.
+func (s *Nats) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil
+	}
+
+	// Close connection and notify any registered listeners
+	s.disconnect()
+	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	return nil
+}
+
+// Disconnect after draining the connection. Draining processes any pending messages
+// of active subscriptions and flushes buffered publishes before closing the connection.
+// If ctx expires before draining completes, the connection is closed anyway and
+// ctx.Err() is returned.
+func (s *Nats) CloseContext(ctx context.Context) error {
+	s.Lock()
+	if !s.connected {
+		s.Unlock()
+		return nil
+	}
+
+	// Detach the connection so new requests are rejected and the closed handler ignores it
+	conn := s.conn
+	s.conn = nil
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+	s.Unlock()
+
+	drained := make(chan struct{})
+	conn.SetClosedHandler(func(*natsDriver.Conn) { close(drained) })
+	err := conn.Drain()
+	if err == nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	conn.Close()
+
+	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	return err
+}
+
+// Close the nats connection. This method is not thread-safe so it should be
+// invoked while holding the service lock.
+func (s *Nats) disconnect() {
+	conn := s.conn
+	s.conn = nil
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+
+	// Clearing s.conn first tells the closed handler that the close was requested by us
+	conn.Close()
+}
+
+// Register a listener for receiving close notifications. The service adapter will emit an error and
+// close the channel if the service is cleanly shut down or close the channel if the connection is reset.
+func (s *Nats) NotifyClose(c adapters.CloseListener) {
+	s.closeNotifier.Add(c)
+}
+
+// Register a callback that is invoked when a dial gives up because its dial policy
+// is exhausted. The callback receives the number of attempts and the error of the
+// last attempt. It fires once per failed dial rather than once per attempt and runs
+// on its own goroutine so it may call back into the service. Passing nil removes it.
+func (s *Nats) OnDialFailure(fn func(attempts uint32, lastErr error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onDialFailure = fn
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *Nats) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Log a warning when more than max close listeners are registered, which usually
+// indicates a listener leak; 0 disables the check. See adapters.MaxCloseListeners.
+func (s *Nats) SetMaxCloseListeners(max int) {
+	s.closeNotifier.SetMaxListeners(max, func(count int) {
+		s.logger.Printf("[NATS] Possible close listener leak: %d listeners registered (max %d)\n", count, max)
+	})
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *Nats) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Report whether the service is connected.
+func (s *Nats) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Get the error that caused the most recent dial to fail; nil if the most recent
+// dial succeeded or no dial has been attempted yet.
+func (s *Nats) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Apply a list of options to the service.
+func (s *Nats) SetOptions(opts ...adapters.ServiceOption) error {
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register a logger instance for service events.
+func (s *Nats) SetLogger(logger *log.Logger) {
+	s.logger = logger
+}
+
+// Get the logger registered via SetLogger. Implements the adapters.LoggerGetter interface.
+func (s *Nats) Logger() *log.Logger {
+	return s.logger
+}
+
+// Attach a logger for tagging DialContext and ConfigContext log lines with the fields
+// of the supplied context. Passing nil restores plain logging.
+func (s *Nats) SetContextLogger(logger adapters.ContextLogger) {
+	s.contextLogger = logger
+}
+
+// Set a dial policy for this service.
+func (s *Nats) SetDialPolicy(policy dial.Policy) {
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Get the dial policy used by this service, e.g. for logging its settings via
+// dial.Describe or inspecting CurAttempt while a dial is in progress. The policy
+// is the copy stored by SetDialPolicy so it must not be passed to other services.
+func (s *Nats) DialPolicy() dial.Policy {
+	return s.dialPolicy
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *Nats) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+	s.metrics = m
+}
+
+// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+func (s *Nats) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+	s.tracer = t
+}
+
+// Set the service configuration. Changing the configuration settings for an already connected
+// service will trigger a service shutdown. The service consumer is responsible for handing
+// service close events and triggering a re-dial.
+func (s *Nats) Config(params map[string]string) error {
+	return s.ConfigContext(context.Background(), params)
+}
+
+// Apply configuration settings using the supplied context. If a context logger is
+// attached, log lines are written to the logger obtained for ctx; see Config.
+func (s *Nats) ConfigContext(ctx context.Context, params map[string]string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Config")
+	defer func() { span.End(err) }()
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+
+	s.configChanged = false
+
+	needsReset := false
+
+	endpoint, exists := params["endpoint"]
+	if exists && endpoint != strings.Join(s.servers, ",") {
+		s.servers = strings.Split(endpoint, ",")
+		needsReset = true
+	}
+
+	timeoutVal, exists := params["connTimeout"]
+	if exists {
+		timeout, err := strconv.Atoi(timeoutVal)
+		if err != nil {
+			err := fmt.Errorf("invalid value for setting 'connTimeout': %s", timeoutVal)
+			logger.Printf("[NATS] Configuration error: %s\n", err.Error())
+			return err
+		}
+		if connectionTimeout := time.Duration(timeout) * time.Second; connectionTimeout != s.connectionTimeout {
+			s.connectionTimeout = connectionTimeout
+			needsReset = true
+		}
+	}
+
+	maxReconnectsVal, exists := params["maxReconnects"]
+	if exists {
+		maxReconnects, err := strconv.Atoi(maxReconnectsVal)
+		if err != nil {
+			err := fmt.Errorf("invalid value for setting 'maxReconnects': %s", maxReconnectsVal)
+			logger.Printf("[NATS] Configuration error: %s\n", err.Error())
+			return err
+		}
+		if maxReconnects != s.maxReconnects {
+			s.maxReconnects = maxReconnects
+			needsReset = true
+		}
+	}
+
+	s.configChanged = needsReset
+	span.SetAttribute("reset", needsReset)
+	if needsReset {
+		logger.Printf("[NATS] Configuration changed; new settings: endpoint=%s, connTimeout=%v, maxReconnects=%d\n",
+			redactServers(s.servers),
+			s.connectionTimeout,
+			s.maxReconnects,
+		)
+		if s.connected {
+			s.disconnect()
+			s.closeNotifier.NotifyAll(nil)
+		}
+	}
+
+	return nil
+}
+
+// Report whether the most recent Config call modified any setting and triggered a service reset.
+func (s *Nats) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
+
+// Publish data to the given subject.
+func (s *Nats) Publish(subj string, data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return adapters.ErrConnectionClosed
+	}
+
+	return s.conn.Publish(subj, data)
+}
+
+// Create a synchronous subscription to the given subject. Messages can be
+// retrieved using the subscription's NextMsg method.
+func (s *Nats) Subscribe(subj string) (*natsDriver.Subscription, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil, adapters.ErrConnectionClosed
+	}
+
+	return s.conn.SubscribeSync(subj)
+}
+
+// Invoked by the nats client when the connection to the server is lost.
+func (s *Nats) onDisconnect(conn *natsDriver.Conn, err error) {
+	if err != nil {
+		s.logger.Printf("[NATS] Lost connection to server (%v); reconnecting\n", err)
+	}
+}
+
+// Invoked by the nats client after successfully reconnecting to a server.
+func (s *Nats) onReconnect(conn *natsDriver.Conn) {
+	s.logger.Printf("[NATS] Reconnected to endpoint %s\n", conn.ConnectedUrlRedacted())
+}
+
+// Invoked by the nats client when the connection is permanently closed, either
+// because we closed it or because it ran out of reconnect attempts.
+func (s *Nats) onClosed(conn *natsDriver.Conn) {
+	s.Lock()
+	defer s.Unlock()
+
+	// Closed by us or belongs to a previous connection
+	if s.conn != conn {
+		return
+	}
+
+	// Reset connection
+	s.conn = nil
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+	s.closeNotifier.NotifyAll(nil)
+	s.logger.Printf("[NATS] Could not re-establish connection to endpoint %s\n", redactServers(s.servers))
+}
+
+// Strip the credentials from a list of nats server URLs so it can be safely logged
+// or reported by spans. Servers without a scheme are parsed as nats URLs. URLs that
+// cannot be parsed are replaced by a placeholder as they may still contain a password.
+func redactServers(servers []string) string {
+	redacted := make([]string, len(servers))
+	for index, server := range servers {
+		scheme := ""
+		if !strings.Contains(server, "://") {
+			scheme = "nats://"
+		}
+		uri, err := url.Parse(scheme + server)
+		if err != nil {
+			redacted[index] = "(invalid endpoint)"
+			continue
+		}
+		uri.User = nil
+		redacted[index] = strings.TrimPrefix(uri.String(), scheme)
+	}
+	return strings.Join(redacted, ",")
+}
@@ -0,0 +1,660 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	_ "github.com/lib/pq"
+)
+
+var (
+	// The function used for opening database handles. Tests may override it
+	// to inject a mocked database.
+	openDB = sql.Open
+)
+
+// The service name reported to the metrics sink.
+const serviceName = "postgres"
+
+// Adapter is a singleton instance of a postgres service
+var Adapter *Postgres = &Postgres{
+	host:              "localhost",
+	port:              5432,
+	dbName:            "postgres",
+	user:              "postgres",
+	connectionTimeout: time.Second * 1,
+	pingInterval:      time.Second * 5,
+	logger:            log.New(ioutil.Discard, "", log.LstdFlags),
+	dialPolicy:        dial.ExpBackoff(10, time.Millisecond),
+	metrics:           adapters.NopMetrics,
+	tracer:            adapters.NopTracer,
+	closeNotifier:     adapters.NewNotifier(),
+}
+
+type Postgres struct {
+
+	// A full connection string. If set, it overrides the individual
+	// connection settings (host, port, db, user and password).
+	dsn string
+
+	// The postgres server host and port.
+	host string
+	port int
+
+	// The database name.
+	dbName string
+
+	// Auth credentials.
+	user     string
+	password string
+
+	// Pool tuning settings; zero values use the database/sql defaults.
+	maxOpen         int
+	maxIdle         int
+	connMaxLifetime time.Duration
+
+	// Connection timeout
+	connectionTimeout time.Duration
+
+	// The interval between watchdog pings. A zero value disables the watchdog.
+	pingInterval time.Duration
+
+	// A logger for service events.
+	logger *log.Logger
+
+	// An optional logger for tagging log lines with the fields of a context.
+	contextLogger adapters.ContextLogger
+
+	// A mutex protecting dial attempts.
+	sync.Mutex
+
+	// The dial policy to use.
+	dialPolicy dial.Policy
+
+	// A sink for dial and connection metrics.
+	metrics adapters.Metrics
+
+	// A tracer for creating spans around Dial and Config.
+	tracer adapters.Tracer
+
+	// An optional callback invoked when a dial gives up; see OnDialFailure.
+	onDialFailure func(attempts uint32, lastErr error)
+
+	// Connection status.
+	connected bool
+
+	// The error that caused the most recent dial to fail; nil if it succeeded.
+	lastErr error
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
+	// Set while CloseContext waits for borrowed connections to be returned.
+	draining bool
+
+	// The pooled database handle.
+	db *sql.DB
+
+	// A channel for stopping the watchdog.
+	stopWatchdog chan struct{}
+
+	// A notifier for close events.
+	closeNotifier *adapters.Notifier
+}
+
+// Connect to the service. If a dial policy has been specified,
+// the service will keep trying to reconnect until a connection
+// is established or the dial policy aborts the reconnection attempt.
+func (s *Postgres) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context. If ctx is cancelled while
+// waiting for the next dial attempt, the dial is aborted and ctx.Err() is returned.
+func (s *Postgres) DialContext(ctx context.Context) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// We are already connected
+	if s.connected {
+		return adapters.ErrAlreadyConnected
+	}
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Dial")
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+	span.SetAttribute("endpoint", s.serverName())
+	attempts := 0
+	defer func() {
+		span.SetAttribute("attempts", attempts)
+		span.End(err)
+		s.lastErr = err
+	}()
+
+	db, err := openDB("postgres", s.connString())
+	if err != nil {
+		logger.Printf("[POSTGRES] Could not open connection pool: %v\n", err)
+		return err
+	}
+	db.SetMaxOpenConns(s.maxOpen)
+	db.SetConnMaxLifetime(s.connMaxLifetime)
+	if s.maxIdle > 0 {
+		db.SetMaxIdleConns(s.maxIdle)
+	}
+
+	defer dial.Release(s.dialPolicy)
+	s.dialPolicy.ResetAttempts()
+	if _, err = dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		logger.Printf("[POSTGRES] Dial cancelled: %v\n", err)
+		db.Close()
+		return err
+	}
+	logger.Printf("[POSTGRES] Connecting to server %s\n", s.serverName())
+	start := time.Now()
+	for {
+		s.metrics.IncDialAttempt(serviceName)
+		attempts++
+		err = ping(ctx, db, s.connectionTimeout)
+		dial.RecordOutcome(s.dialPolicy, err == nil)
+		if err == nil {
+			break
+		}
+
+		s.metrics.IncDialFailure(serviceName, err)
+		dialErr := err
+		err = dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			logger.Printf("[POSTGRES] Could not connect to server %s; retrying in %v\n", s.serverName(), wait)
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			logger.Printf("[POSTGRES] Could not connect to server %s after %d attempt(s)\n", s.serverName(), s.dialPolicy.CurAttempt())
+			db.Close()
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			if s.onDialFailure != nil {
+				go s.onDialFailure(uint32(attempts), dialErr)
+			}
+			return dial.ErrTimeout
+		case err != nil:
+			logger.Printf("[POSTGRES] Dial cancelled: %v\n", err)
+			db.Close()
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			return err
+		}
+	}
+
+	s.db = db
+	s.connected = true
+	s.metrics.IncDialSuccess(serviceName)
+	s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+	s.metrics.SetConnected(serviceName, true)
+	s.dialPolicy.ResetAttempts()
+	logger.Printf("[POSTGRES] Connected to server %s\n", s.serverName())
+
+	// Start watchdog
+	s.stopWatchdog = make(chan struct{})
+	if s.pingInterval > 0 {
+		go s.watchdog(db, s.stopWatchdog, s.pingInterval, s.connectionTimeout)
+	}
+
+	return nil
+}
+
+// Ping the database using the specified timeout.
+func ping(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return db.PingContext(ctx)
+}
+
+// Disconnect.
+func (s *Postgres) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil
+	}
+
+	// Close connection and notify any registered listeners
+	err := s.disconnect()
+	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	return err
+}
+
+// Disconnect after waiting for any in-use connections to be returned to the pool.
+// New connection requests are rejected while waiting. If ctx expires before all
+// connections are returned, the service is closed anyway and ctx.Err() is returned.
+func (s *Postgres) CloseContext(ctx context.Context) error {
+	s.Lock()
+	if !s.connected {
+		s.Unlock()
+		return nil
+	}
+	s.draining = true
+	db := s.db
+	s.Unlock()
+
+	err := adapters.WaitForRelease(ctx, func() int {
+		return db.Stats().InUse
+	})
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Unless the connection was reset in the meantime
+	s.draining = false
+	if s.connected && s.db == db {
+		if closeErr := s.disconnect(); err == nil {
+			err = closeErr
+		}
+		s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	}
+
+	return err
+}
+
+// Stop the watchdog and close the connection pool. This method is not thread-safe
+// so it should be invoked while holding the service lock.
+func (s *Postgres) disconnect() error {
+	close(s.stopWatchdog)
+	err := s.db.Close()
+	s.db = nil
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+	return err
+}
+
+// Register a listener for receiving close notifications. The service adapter will emit an error and
+// close the channel if the service is cleanly shut down or close the channel if the connection is reset.
+func (s *Postgres) NotifyClose(c adapters.CloseListener) {
+	s.closeNotifier.Add(c)
+}
+
+// Register a callback that is invoked when a dial gives up because its dial policy
+// is exhausted. The callback receives the number of attempts and the error of the
+// last attempt. It fires once per failed dial rather than once per attempt and runs
+// on its own goroutine so it may call back into the service. Passing nil removes it.
+func (s *Postgres) OnDialFailure(fn func(attempts uint32, lastErr error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onDialFailure = fn
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *Postgres) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Log a warning when more than max close listeners are registered, which usually
+// indicates a listener leak; 0 disables the check. See adapters.MaxCloseListeners.
+func (s *Postgres) SetMaxCloseListeners(max int) {
+	s.closeNotifier.SetMaxListeners(max, func(count int) {
+		s.logger.Printf("[POSTGRES] Possible close listener leak: %d listeners registered (max %d)\n", count, max)
+	})
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *Postgres) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Report whether the service is connected.
+func (s *Postgres) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Get the error that caused the most recent dial to fail; nil if the most recent
+// dial succeeded or no dial has been attempted yet.
+func (s *Postgres) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Apply a list of options to the service.
+func (s *Postgres) SetOptions(opts ...adapters.ServiceOption) error {
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register a logger instance for service events.
+func (s *Postgres) SetLogger(logger *log.Logger) {
+	s.logger = logger
+}
+
+// Get the logger registered via SetLogger. Implements the adapters.LoggerGetter interface.
+func (s *Postgres) Logger() *log.Logger {
+	return s.logger
+}
+
+// Attach a logger for tagging DialContext and ConfigContext log lines with the fields
+// of the supplied context. Passing nil restores plain logging.
+func (s *Postgres) SetContextLogger(logger adapters.ContextLogger) {
+	s.contextLogger = logger
+}
+
+// Set a dial policy for this service.
+func (s *Postgres) SetDialPolicy(policy dial.Policy) {
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Get the dial policy used by this service, e.g. for logging its settings via
+// dial.Describe or inspecting CurAttempt while a dial is in progress. The policy
+// is the copy stored by SetDialPolicy so it must not be passed to other services.
+func (s *Postgres) DialPolicy() dial.Policy {
+	return s.dialPolicy
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *Postgres) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+	s.metrics = m
+}
+
+// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+func (s *Postgres) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+	s.tracer = t
+}
+
+// Set the service configuration. Changing the configuration settings for an already connected
+// service will trigger a service shutdown. The service consumer is responsible for handing
+// service close events and triggering a re-dial.
+func (s *Postgres) Config(params map[string]string) error {
+	return s.ConfigContext(context.Background(), params)
+}
+
+// Apply configuration settings using the supplied context. If a context logger is
+// attached, log lines are written to the logger obtained for ctx; see Config.
+func (s *Postgres) ConfigContext(ctx context.Context, params map[string]string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Config")
+	defer func() { span.End(err) }()
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+
+	s.configChanged = false
+
+	// Validate all settings before applying any of them so that a bad value
+	// does not leave the service with a partially applied configuration.
+	cfg, needsReset, err := s.parseConfig(params)
+	if err != nil {
+		logger.Printf("[POSTGRES] Configuration error: %s\n", err.Error())
+		return err
+	}
+
+	s.applyConfig(cfg)
+
+	s.configChanged = needsReset
+	span.SetAttribute("reset", needsReset)
+	if needsReset {
+		logger.Printf("[POSTGRES] Configuration changed; new settings: server=%s, maxOpen=%d, maxIdle=%d, connMaxLifetime=%v, connTimeout=%v, pingInterval=%v\n",
+			s.serverName(),
+			s.maxOpen,
+			s.maxIdle,
+			s.connMaxLifetime,
+			s.connectionTimeout,
+			s.pingInterval,
+		)
+
+		if s.connected {
+			s.disconnect()
+			s.closeNotifier.NotifyAll(nil)
+		}
+	}
+
+	return nil
+}
+
+// The settings that can be modified via Config.
+type config struct {
+	dsn               string
+	host              string
+	port              int
+	dbName            string
+	user              string
+	password          string
+	maxOpen           int
+	maxIdle           int
+	connMaxLifetime   time.Duration
+	connectionTimeout time.Duration
+	pingInterval      time.Duration
+}
+
+// Parse params on top of the current settings without modifying the service. It
+// returns the resulting settings and whether they differ from the current ones.
+// This method is not thread-safe so it should be called while holding the lock.
+func (s *Postgres) parseConfig(params map[string]string) (config, bool, error) {
+	cfg := config{
+		dsn:               s.dsn,
+		host:              s.host,
+		port:              s.port,
+		dbName:            s.dbName,
+		user:              s.user,
+		password:          s.password,
+		maxOpen:           s.maxOpen,
+		maxIdle:           s.maxIdle,
+		connMaxLifetime:   s.connMaxLifetime,
+		connectionTimeout: s.connectionTimeout,
+		pingInterval:      s.pingInterval,
+	}
+	cur := cfg
+
+	stringSettings := []struct {
+		name  string
+		value *string
+	}{
+		{"dsn", &cfg.dsn},
+		{"host", &cfg.host},
+		{"db", &cfg.dbName},
+		{"user", &cfg.user},
+		{"password", &cfg.password},
+	}
+	for _, setting := range stringSettings {
+		if val, exists := params[setting.name]; exists {
+			*setting.value = val
+		}
+	}
+
+	intSettings := []struct {
+		name  string
+		value *int
+	}{
+		{"port", &cfg.port},
+		{"maxOpen", &cfg.maxOpen},
+		{"maxIdle", &cfg.maxIdle},
+	}
+	for _, setting := range intSettings {
+		val, exists := params[setting.name]
+		if !exists {
+			continue
+		}
+		intVal, err := strconv.Atoi(val)
+		if err != nil {
+			return cur, false, fmt.Errorf("invalid value for setting '%s': %s", setting.name, val)
+		}
+		*setting.value = intVal
+	}
+
+	durationSettings := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"connMaxLifetime", &cfg.connMaxLifetime},
+		{"connTimeout", &cfg.connectionTimeout},
+		{"pingInterval", &cfg.pingInterval},
+	}
+	for _, setting := range durationSettings {
+		val, exists := params[setting.name]
+		if !exists {
+			continue
+		}
+		seconds, err := strconv.Atoi(val)
+		if err != nil {
+			return cur, false, fmt.Errorf("invalid value for setting '%s': %s", setting.name, val)
+		}
+		*setting.value = time.Duration(seconds) * time.Second
+	}
+
+	return cfg, cfg != cur, nil
+}
+
+// Apply settings parsed via parseConfig. This method is not thread-safe so it should
+// be called while holding the lock.
+func (s *Postgres) applyConfig(cfg config) {
+	s.dsn = cfg.dsn
+	s.host = cfg.host
+	s.port = cfg.port
+	s.dbName = cfg.dbName
+	s.user = cfg.user
+	s.password = cfg.password
+	s.maxOpen = cfg.maxOpen
+	s.maxIdle = cfg.maxIdle
+	s.connMaxLifetime = cfg.connMaxLifetime
+	s.connectionTimeout = cfg.connectionTimeout
+	s.pingInterval = cfg.pingInterval
+}
+
+// Report whether the most recent Config call modified any setting and triggered a service reset.
+func (s *Postgres) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
+
+// Get the pooled database handle. The handle is safe for concurrent use; callers
+// should not close it.
+func (s *Postgres) DB() (*sql.DB, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected || s.draining {
+		return nil, adapters.ErrConnectionClosed
+	}
+
+	return s.db, nil
+}
+
+// Fetch a dedicated connection from the pool. Callers must close the connection
+// to return it to the pool when they are done with it.
+func (s *Postgres) GetConnection() (*sql.Conn, error) {
+	s.Lock()
+	db, timeout := s.db, s.connectionTimeout
+	connected := s.connected && !s.draining
+	s.Unlock()
+
+	if !connected {
+		return nil, adapters.ErrConnectionClosed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return db.Conn(ctx)
+}
+
+// Build the connection string used for opening the database. This method is not
+// thread-safe so it should be invoked while holding the service lock.
+func (s *Postgres) connString() string {
+	if s.dsn != "" {
+		return s.dsn
+	}
+
+	settings := map[string]string{
+		"host":     s.host,
+		"port":     strconv.Itoa(s.port),
+		"dbname":   s.dbName,
+		"user":     s.user,
+		"password": s.password,
+	}
+	if s.connectionTimeout > 0 {
+		settings["connect_timeout"] = strconv.Itoa(int(s.connectionTimeout / time.Second))
+	}
+
+	keys := make([]string, 0, len(settings))
+	for k, v := range settings {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	tokens := make([]string, len(keys))
+	for index, k := range keys {
+		tokens[index] = k + "=" + quoteConnValue(settings[k])
+	}
+
+	return strings.Join(tokens, " ")
+}
+
+// Get a printable description of the configured server that does not leak any credentials.
+func (s *Postgres) serverName() string {
+	if s.dsn != "" {
+		return "(dsn)"
+	}
+	return fmt.Sprintf("%s:%d/%s", s.host, s.port, s.dbName)
+}
+
+// Quote a connection string value, escaping any backslashes and single quotes.
+func quoteConnValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return "'" + value + "'"
+}
+
+// A worker that periodically pings the database and resets the service when the
+// server becomes unreachable.
+func (s *Postgres) watchdog(db *sql.DB, stop chan struct{}, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := ping(context.Background(), db, timeout); err == nil {
+				continue
+			}
+
+			// Reset connection unless the service was closed or reconfigured in the meantime
+			s.Lock()
+			select {
+			case <-stop:
+			default:
+				s.disconnect()
+				s.closeNotifier.NotifyAll(nil)
+				s.logger.Printf("[POSTGRES] Lost connection to server %s\n", s.serverName())
+			}
+			s.Unlock()
+			return
+		}
+	}
+}
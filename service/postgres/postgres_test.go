@@ -0,0 +1,285 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+)
+
+// Create a postgres adapter backed by a mocked database.
+func newMockedAdapter(t *testing.T) (*Postgres, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Error creating sqlmock: %v", err)
+	}
+
+	origOpenDB := openDB
+	openDB = func(driverName, dsn string) (*sql.DB, error) {
+		return db, nil
+	}
+	t.Cleanup(func() { openDB = origOpenDB })
+
+	srv := &Postgres{
+		host:              "localhost",
+		port:              5432,
+		dbName:            "postgres",
+		connectionTimeout: time.Second,
+		logger:            Adapter.logger,
+		dialPolicy:        dial.Periodic(5, time.Millisecond),
+		metrics:           adapters.NopMetrics,
+		tracer:            adapters.NopTracer,
+		closeNotifier:     adapters.NewNotifier(),
+	}
+
+	return srv, mock
+}
+
+func TestDialRetriesPing(t *testing.T) {
+	srv, mock := newMockedAdapter(t)
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectPing()
+
+	err := srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := srv.DB()
+	if err != nil || db == nil {
+		t.Fatalf("Expected DB() to return the database handle; got %v, %v", db, err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	mock.ExpectClose()
+	srv.Close()
+	if err := <-listener; err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected to receive ErrConnectionClosed; got %v", err)
+	}
+
+	_, err = srv.DB()
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected DB() to return ErrConnectionClosed after Close; got %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	srv, mock := newMockedAdapter(t)
+	srv.dialPolicy = dial.Periodic(1, time.Millisecond)
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectClose()
+
+	err := srv.Dial()
+	if err != dial.ErrTimeout {
+		t.Fatalf("Expected Dial to fail with ErrTimeout; got %v", err)
+	}
+}
+
+func TestWatchdogResetsOnPingFailure(t *testing.T) {
+	srv, mock := newMockedAdapter(t)
+	srv.pingInterval = time.Millisecond
+	mock.ExpectPing()
+	mock.ExpectPing().WillReturnError(errors.New("server closed the connection unexpectedly"))
+	mock.ExpectClose()
+
+	err := srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	select {
+	case err, ok := <-listener:
+		if ok {
+			t.Fatalf("Expected listener to be closed without an error; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the watchdog to reset the connection")
+	}
+
+	_, err = srv.DB()
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected DB() to return ErrConnectionClosed after a reset; got %v", err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	srv, _ := newMockedAdapter(t)
+
+	err := srv.Config(map[string]string{
+		"host":            "db.local",
+		"user":            "app",
+		"password":        "it's a secret",
+		"db":              "orders",
+		"maxOpen":         "10",
+		"connMaxLifetime": "60",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected config error: %v", err)
+	}
+
+	expected := `connect_timeout='1' dbname='orders' host='db.local' password='it\'s a secret' port='5432' user='app'`
+	if connString := srv.connString(); connString != expected {
+		t.Fatalf("Expected connection string to be %q; got %q", expected, connString)
+	}
+	if srv.maxOpen != 10 {
+		t.Fatalf("Expected maxOpen to be 10; got %d", srv.maxOpen)
+	}
+	if srv.connMaxLifetime != time.Minute {
+		t.Fatalf("Expected connMaxLifetime to be %v; got %v", time.Minute, srv.connMaxLifetime)
+	}
+
+	err = srv.Config(map[string]string{"maxIdle": "lots"})
+	if err == nil {
+		t.Fatalf("Expected an error for an invalid maxIdle value")
+	}
+}
+
+func TestConfigInvalidValueLeavesSettingsUntouched(t *testing.T) {
+	srv, _ := newMockedAdapter(t)
+	expected := srv.connString()
+
+	err := srv.Config(map[string]string{
+		"host":    "db.local",
+		"user":    "app",
+		"port":    "not-a-port",
+		"maxOpen": "10",
+	})
+	if err == nil {
+		t.Fatalf("Expected an error for an invalid port value")
+	}
+
+	if connString := srv.connString(); connString != expected {
+		t.Fatalf("Expected connection string to remain %q; got %q", expected, connString)
+	}
+	if srv.maxOpen == 10 {
+		t.Fatalf("Expected maxOpen not to be modified")
+	}
+	if srv.ConfigChanged() {
+		t.Fatalf("Expected ConfigChanged to report false")
+	}
+}
+
+func TestCloseContextWaitsForConnections(t *testing.T) {
+	srv, mock := newMockedAdapter(t)
+	srv.pingInterval = 0
+	mock.ExpectPing()
+
+	err := srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	mock.ExpectClose()
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- srv.CloseContext(context.Background())
+	}()
+
+	select {
+	case err := <-closed:
+		t.Fatalf("Expected CloseContext to block while a connection is in use; got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = srv.GetConnection()
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected GetConnection to return ErrConnectionClosed while closing; got %v", err)
+	}
+
+	conn.Close()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Expected CloseContext to succeed; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for CloseContext to return")
+	}
+
+	if err := <-listener; err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected to receive ErrConnectionClosed; got %v", err)
+	}
+}
+
+func TestCloseContextDeadline(t *testing.T) {
+	srv, mock := newMockedAdapter(t)
+	srv.pingInterval = 0
+	mock.ExpectPing()
+
+	err := srv.Dial()
+	if err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	defer conn.Close()
+
+	mock.ExpectClose()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err = srv.CloseContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected CloseContext to fail with context.DeadlineExceeded; got %v", err)
+	}
+
+	_, err = srv.DB()
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected DB() to return ErrConnectionClosed after CloseContext; got %v", err)
+	}
+}
+
+func TestCloseReturnsDriverError(t *testing.T) {
+	srv, mock := newMockedAdapter(t)
+	srv.pingInterval = 0
+	mock.ExpectPing()
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	closeErr := errors.New("server rejected close")
+	mock.ExpectClose().WillReturnError(closeErr)
+
+	if err := srv.Close(); err != closeErr {
+		t.Fatalf("Expected Close to fail with %v; got %v", closeErr, err)
+	}
+
+	// The service should be closed regardless of the error
+	if err := <-listener; err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected to receive ErrConnectionClosed; got %v", err)
+	}
+	if _, err := srv.DB(); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected DB() to return ErrConnectionClosed after Close; got %v", err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Expected closing a closed service to be a no-op; got %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// The number of hash slots in a redis cluster.
+const clusterSlots = 16384
+
+// The max number of MOVED/ASK redirections followed by a single command.
+const maxRedirects = 5
+
+var (
+	ErrClusterMode = errors.New("redis: GetConnection is not supported in cluster mode; use DoContext")
+)
+
+// A cluster-aware client that maintains a connection pool per cluster node and
+// routes commands to the node serving the hash slot of their key. The slot
+// mapping is populated via a background topology refresh (CLUSTER SLOTS) that
+// the adapter triggers when dialing and kept up to date by MOVED redirections,
+// which update the mapping and trigger a new refresh. Commands for unknown slots
+// are sent to the first reachable seed node.
+type cluster struct {
+
+	// A mutex protecting the cluster state.
+	sync.Mutex
+
+	// The seed node addresses.
+	seeds []string
+
+	// Dial a connection to a cluster node.
+	dialNode func(addr string) (redisDriver.Conn, error)
+
+	// Connection pools indexed by node address.
+	pools map[string]*redisDriver.Pool
+
+	// The node address serving each slot; empty if unknown.
+	slots [clusterSlots]string
+
+	// Set while a background topology refresh is running.
+	refreshing bool
+
+	// Set once the cluster has been closed.
+	closed bool
+}
+
+func newCluster(seeds []string, dialNode func(addr string) (redisDriver.Conn, error)) *cluster {
+	return &cluster{
+		seeds:    seeds,
+		dialNode: dialNode,
+		pools:    make(map[string]*redisDriver.Pool),
+	}
+}
+
+// Run a command on the node serving the slot of the command's key (its first
+// argument), following any MOVED or ASK redirections.
+func (c *cluster) do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	addr := c.nodeFor(args)
+	asking := false
+	for redirects := 0; ; redirects++ {
+		var reply interface{}
+		var err error
+		if addr == "" {
+			reply, err = c.doOnSeed(ctx, cmd, args...)
+		} else {
+			reply, err = c.doOnNode(ctx, addr, asking, cmd, args...)
+		}
+
+		replyErr, isReplyErr := err.(redisDriver.Error)
+		if !isReplyErr {
+			return reply, err
+		}
+		kind, slot, target, isRedirect := parseRedirect(replyErr)
+		if !isRedirect {
+			return reply, err
+		}
+		if redirects == maxRedirects {
+			return nil, fmt.Errorf("redis: too many cluster redirections: %w", err)
+		}
+
+		// ASK redirections are one-off and must not update the slot mapping
+		addr, asking = target, kind == "ASK"
+		if kind == "MOVED" {
+			c.Lock()
+			c.slots[slot] = target
+			c.Unlock()
+			c.refreshAsync()
+		}
+	}
+}
+
+// Run a command on the node with the supplied address.
+func (c *cluster) doOnNode(ctx context.Context, addr string, asking bool, cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := c.conn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return doOnConn(conn, asking, cmd, args...)
+}
+
+// Run a command on the first seed node that a connection can be established to.
+// The remaining seeds are only tried if connecting to the previous ones fails so
+// that commands are never run more than once.
+func (c *cluster) doOnSeed(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	var err error
+	for _, addr := range c.seeds {
+		var conn redisDriver.Conn
+		if conn, err = c.conn(ctx, addr); err == nil {
+			return doOnConn(conn, false, cmd, args...)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, err
+}
+
+// Get a connection to the node with the supplied address.
+func (c *cluster) conn(ctx context.Context, addr string) (redisDriver.Conn, error) {
+	pool, err := c.pool(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return pool.GetContext(ctx)
+}
+
+// Run a command on a node connection and return the connection to its pool. If
+// asking is set, the command is preceded by ASKING.
+func doOnConn(conn redisDriver.Conn, asking bool, cmd string, args ...interface{}) (interface{}, error) {
+	defer conn.Close()
+
+	if asking {
+		if _, err := conn.Do("ASKING"); err != nil {
+			return nil, err
+		}
+	}
+
+	return conn.Do(cmd, args...)
+}
+
+// Get the address of the node serving the slot of the first argument or an empty
+// string if the command has no arguments or the owner of its slot is unknown.
+func (c *cluster) nodeFor(args []interface{}) string {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(args) == 0 {
+		return ""
+	}
+	return c.slots[keySlot(keyString(args[0]))]
+}
+
+// Get the pool for the node with the supplied address, creating it if needed.
+func (c *cluster) pool(addr string) (*redisDriver.Pool, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return nil, errors.New("redis: cluster client closed")
+	}
+
+	pool, exists := c.pools[addr]
+	if !exists {
+		pool = newPool(func() (redisDriver.Conn, error) {
+			return c.dialNode(addr)
+		})
+		c.pools[addr] = pool
+	}
+	return pool, nil
+}
+
+// Refresh the slot mapping in the background unless a refresh is already running.
+func (c *cluster) refreshAsync() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.refreshing || c.closed {
+		return
+	}
+	c.refreshing = true
+
+	go func() {
+		c.refresh()
+
+		c.Lock()
+		c.refreshing = false
+		c.Unlock()
+	}()
+}
+
+// Query the known nodes for the cluster topology and update the slot mapping
+// using the first successful reply.
+func (c *cluster) refresh() error {
+	c.Lock()
+	nodes := append([]string{}, c.seeds...)
+	for addr := range c.pools {
+		nodes = append(nodes, addr)
+	}
+	c.Unlock()
+
+	var err error
+	for _, addr := range nodes {
+		var reply []interface{}
+		reply, err = redisDriver.Values(c.doOnNode(context.Background(), addr, false, "CLUSTER", "SLOTS"))
+		if err != nil {
+			continue
+		}
+
+		var slots [clusterSlots]string
+		if err = parseClusterSlots(reply, addr, &slots); err != nil {
+			continue
+		}
+
+		c.Lock()
+		c.slots = slots
+		c.Unlock()
+		return nil
+	}
+
+	return err
+}
+
+// Get the number of connections that are currently borrowed from the node pools.
+func (c *cluster) inUse() int {
+	c.Lock()
+	defer c.Unlock()
+
+	count := 0
+	for _, pool := range c.pools {
+		count += pool.ActiveCount() - pool.IdleCount()
+	}
+	return count
+}
+
+// Close all node pools.
+func (c *cluster) close() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.closed = true
+	for _, pool := range c.pools {
+		pool.Close()
+	}
+}
+
+// Parse a CLUSTER SLOTS reply into slots. Each reply entry has the format
+// [start end [ip port ...] replicas...]. An empty ip refers to the node that
+// served the reply.
+func parseClusterSlots(reply []interface{}, replyAddr string, slots *[clusterSlots]string) error {
+	replyHost, _, _ := net.SplitHostPort(replyAddr)
+
+	for _, entry := range reply {
+		fields, err := redisDriver.Values(entry, nil)
+		if err != nil || len(fields) < 3 {
+			return fmt.Errorf("redis: malformed CLUSTER SLOTS entry: %v", entry)
+		}
+
+		start, err1 := redisDriver.Int(fields[0], nil)
+		end, err2 := redisDriver.Int(fields[1], nil)
+		master, err3 := redisDriver.Values(fields[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(master) < 2 || start < 0 || end >= clusterSlots || start > end {
+			return fmt.Errorf("redis: malformed CLUSTER SLOTS entry: %v", entry)
+		}
+
+		host, err1 := redisDriver.String(master[0], nil)
+		port, err2 := redisDriver.Int(master[1], nil)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("redis: malformed CLUSTER SLOTS entry: %v", entry)
+		}
+		if host == "" {
+			host = replyHost
+		}
+
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = addr
+		}
+	}
+
+	return nil
+}
+
+// Parse a MOVED or ASK redirection error with format "<kind> <slot> <addr>".
+func parseRedirect(err redisDriver.Error) (kind string, slot int, addr string, ok bool) {
+	fields := strings.Fields(string(err))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", 0, "", false
+	}
+
+	slot, convErr := strconv.Atoi(fields[1])
+	if convErr != nil || slot < 0 || slot >= clusterSlots {
+		return "", 0, "", false
+	}
+
+	return fields[0], slot, fields[2], true
+}
+
+// Convert a command argument into the key used for slot calculation.
+func keyString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(arg)
+}
+
+// Calculate the hash slot for key. If the key contains a non-empty hash tag
+// (a substring enclosed in braces), only the tag is hashed.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// Calculate the CRC16 (XMODEM) checksum used by redis cluster.
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
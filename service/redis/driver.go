@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"context"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// A connection to a redis server. The method set matches the redigo Conn interface
+// so redigo connections implement RedisConn and RedisConn values can be passed to
+// the redigo reply helpers (e.g. redis.String). Code that only depends on RedisConn
+// does not need to import redigo and can be tested using a fake connection.
+type RedisConn interface {
+
+	// Close the connection or return it to the pool it was fetched from.
+	Close() error
+
+	// Get a non-nil value if the connection is broken.
+	Err() error
+
+	// Send a command to the server and wait for its reply.
+	Do(cmd string, args ...interface{}) (reply interface{}, err error)
+
+	// Buffer a command for sending to the server.
+	Send(cmd string, args ...interface{}) error
+
+	// Flush the buffered commands to the server.
+	Flush() error
+
+	// Receive a single reply from the server.
+	Receive() (reply interface{}, err error)
+}
+
+// A source of redis connections. The service adapter implements RedisPool using a
+// redigo pool; code that borrows connections via RedisPool rather than a *Redis
+// can be tested using a fake pool.
+type RedisPool interface {
+
+	// Fetch a connection from the pool.
+	GetConnection() (RedisConn, error)
+
+	// Fetch a connection from the pool using the supplied context.
+	GetConnectionContext(ctx context.Context) (RedisConn, error)
+}
+
+var (
+	_ RedisConn = redisDriver.Conn(nil)
+	_ RedisPool = (*Redis)(nil)
+)
@@ -0,0 +1,135 @@
+package redis
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// An in-memory RedisConn that records the commands it runs and replies using
+// canned replies keyed by command name.
+type fakeConn struct {
+	replies  map[string]interface{}
+	commands []string
+	pending  []interface{}
+	closed   bool
+}
+
+func (c *fakeConn) reply(cmd string) (interface{}, error) {
+	c.commands = append(c.commands, strings.ToUpper(cmd))
+	reply := c.replies[strings.ToUpper(cmd)]
+	if err, isErr := reply.(error); isErr {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *fakeConn) Close() error { c.closed = true; return nil }
+func (c *fakeConn) Err() error   { return nil }
+func (c *fakeConn) Flush() error { return nil }
+
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.reply(cmd)
+}
+
+func (c *fakeConn) Send(cmd string, args ...interface{}) error {
+	reply, err := c.reply(cmd)
+	if err != nil {
+		reply = err
+	}
+	c.pending = append(c.pending, reply)
+	return nil
+}
+
+func (c *fakeConn) Receive() (interface{}, error) {
+	reply := c.pending[0]
+	c.pending = c.pending[1:]
+	if err, isErr := reply.(error); isErr {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// A RedisPool that always hands out the same fake connection.
+type fakePool struct {
+	conn *fakeConn
+}
+
+func (p *fakePool) GetConnection() (RedisConn, error) { return p.conn, nil }
+func (p *fakePool) GetConnectionContext(context.Context) (RedisConn, error) {
+	return p.conn, nil
+}
+
+// Sample downstream code that only depends on RedisPool.
+func incrCounter(pool RedisPool, key string) (int, error) {
+	conn, err := pool.GetConnection()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return redisDriver.Int(conn.Do("INCR", key))
+}
+
+func TestRedisPoolWithFakeConn(t *testing.T) {
+	conn := &fakeConn{replies: map[string]interface{}{"INCR": int64(42)}}
+
+	count, err := incrCounter(&fakePool{conn: conn}, "visits")
+	if err != nil {
+		t.Fatalf("Expected incrCounter to succeed; got %v", err)
+	}
+	if count != 42 {
+		t.Fatalf("Expected count to be 42; got %d", count)
+	}
+	if !conn.closed {
+		t.Fatalf("Expected the connection to be returned to the pool")
+	}
+}
+
+func TestRedisPoolWithAdapter(t *testing.T) {
+	srv := newTestAdapter(newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) == "INCR" {
+				return ":7\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	}))
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	count, err := incrCounter(srv, "visits")
+	if err != nil {
+		t.Fatalf("Expected incrCounter to succeed; got %v", err)
+	}
+	if count != 7 {
+		t.Fatalf("Expected count to be 7; got %d", count)
+	}
+}
+
+func TestScriptRunWithFakeConn(t *testing.T) {
+	conn := &fakeConn{replies: map[string]interface{}{
+		"SCRIPT":  []byte("sha"),
+		"EVALSHA": redisDriver.Error("NOSCRIPT No matching script. Please use EVAL."),
+		"EVAL":    []byte("ok"),
+	}}
+
+	script := newTestAdapter("").NewScript("return 'ok'")
+	reply, err := redisDriver.String(script.Run(conn, nil))
+	if err != nil {
+		t.Fatalf("Expected Run to succeed; got %v", err)
+	}
+	if reply != "ok" {
+		t.Fatalf("Expected reply to be ok; got %q", reply)
+	}
+
+	expCommands := []string{"SCRIPT", "EVALSHA", "EVAL"}
+	if !reflect.DeepEqual(conn.commands, expCommands) {
+		t.Fatalf("Expected commands %v; got %v", expCommands, conn.commands)
+	}
+}
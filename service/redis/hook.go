@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// A hook invoked after a pooled connection runs a command. For commands sent with
+// Do, reply and err are the command result and dur is the round-trip time. For
+// commands queued with Send, reply is always nil, err is the error returned by Send
+// and dur only covers buffering the command.
+type CommandHook func(cmd string, args []interface{}, reply interface{}, err error, dur time.Duration)
+
+// Register a hook that is invoked for every command run by a connection obtained
+// from the master, replica or cluster pools, including the PINGs issued by the
+// testOnBorrow and keepAlive settings. The hook is invoked synchronously so it
+// should not block. It may be changed at any time; passing nil removes the hook.
+func (s *Redis) SetCommandHook(hook func(cmd string, args []interface{}, reply interface{}, err error, dur time.Duration)) {
+	s.commandHook.Store(CommandHook(hook))
+}
+
+// Get the hook registered via SetCommandHook or nil if no hook is registered.
+func (s *Redis) loadCommandHook() CommandHook {
+	hook, _ := s.commandHook.Load().(CommandHook)
+	return hook
+}
+
+// Wrap the connection returned by dialFn so that the commands it runs invoke the
+// hook registered via SetCommandHook.
+func (s *Redis) hookedDial(dialFn func() (redisDriver.Conn, error)) (redisDriver.Conn, error) {
+	c, err := dialFn()
+	if err != nil {
+		return nil, err
+	}
+	return &hookedConn{Conn: c, hook: &s.commandHook}, nil
+}
+
+// A connection that reports the commands it runs to a CommandHook.
+type hookedConn struct {
+	redisDriver.Conn
+
+	// The current CommandHook; loaded for each command so it can be changed
+	// while the connection is in use.
+	hook *atomic.Value
+}
+
+func (c *hookedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	// The server closes the connection after replying to QUIT. Close our end as
+	// well so that Err reports the connection as broken and the pool discards it
+	// instead of handing it out again.
+	if strings.EqualFold(cmd, "QUIT") {
+		defer c.Conn.Close()
+	}
+
+	hook, _ := c.hook.Load().(CommandHook)
+
+	// An empty command only flushes and receives pending replies
+	if hook == nil || cmd == "" {
+		return c.Conn.Do(cmd, args...)
+	}
+
+	start := clock.Default.Now()
+	reply, err := c.Conn.Do(cmd, args...)
+	hook(cmd, args, reply, err, clock.Since(start))
+	return reply, err
+}
+
+func (c *hookedConn) Send(cmd string, args ...interface{}) error {
+	hook, _ := c.hook.Load().(CommandHook)
+	if hook == nil {
+		return c.Conn.Send(cmd, args...)
+	}
+
+	start := clock.Default.Now()
+	err := c.Conn.Send(cmd, args...)
+	hook(cmd, args, nil, err, clock.Since(start))
+	return err
+}
@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type hookCall struct {
+	cmd   string
+	args  []interface{}
+	reply interface{}
+	err   error
+	dur   time.Duration
+}
+
+// A CommandHook that records its invocations.
+type hookRecorder struct {
+	mu    sync.Mutex
+	calls []hookCall
+}
+
+func (r *hookRecorder) hook(cmd string, args []interface{}, reply interface{}, err error, dur time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, hookCall{cmd, args, reply, err, dur})
+}
+
+func (r *hookRecorder) recorded() []hookCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]hookCall(nil), r.calls...)
+}
+
+func TestCommandHook(t *testing.T) {
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) == "GET" {
+				time.Sleep(20 * time.Millisecond)
+				return "$3\r\nbar\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	})
+	srv := newTestAdapter(endpoint)
+	if err := srv.Config(map[string]string{"testOnBorrow": "never"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	rec := &hookRecorder{}
+	srv.SetCommandHook(rec.hook)
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	if _, err = conn.Do("GET", "foo"); err != nil {
+		t.Fatalf("Expected GET to succeed; got %v", err)
+	}
+	if err = conn.Send("PING"); err != nil {
+		t.Fatalf("Expected Send to succeed; got %v", err)
+	}
+	if _, err = conn.Do(""); err != nil {
+		t.Fatalf("Expected flushing the pipeline to succeed; got %v", err)
+	}
+	conn.Close()
+
+	calls := rec.recorded()
+	if len(calls) != 2 {
+		t.Fatalf("Expected the hook to be invoked twice; got %d call(s): %v", len(calls), calls)
+	}
+	get := calls[0]
+	if get.cmd != "GET" || len(get.args) != 1 || get.args[0] != "foo" {
+		t.Fatalf("Expected the first call to report GET foo; got %s %v", get.cmd, get.args)
+	}
+	if reply, ok := get.reply.([]byte); !ok || string(reply) != "bar" || get.err != nil {
+		t.Fatalf("Expected the GET call to report the reply; got %v, %v", get.reply, get.err)
+	}
+	if get.dur < 20*time.Millisecond {
+		t.Fatalf("Expected the GET call duration to be at least 20ms; got %v", get.dur)
+	}
+	if send := calls[1]; send.cmd != "PING" || send.reply != nil || send.err != nil {
+		t.Fatalf("Expected the second call to report the queued PING; got %v", send)
+	}
+
+	// Removing the hook stops reporting commands on already pooled connections
+	srv.SetCommandHook(nil)
+	if _, err = srv.DoContext(context.Background(), "GET", "foo"); err != nil {
+		t.Fatalf("Expected DoContext to succeed; got %v", err)
+	}
+	if calls = rec.recorded(); len(calls) != 2 {
+		t.Fatalf("Expected the hook not to be invoked after removal; got %d call(s)", len(calls))
+	}
+}
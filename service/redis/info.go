@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"context"
+	"strings"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// Run INFO for section and parse its output into a map of section names to the
+// key/value pairs listed under each "# Section" header. Section names are converted
+// to lower case (e.g. info["server"]["redis_version"]); values are returned verbatim,
+// so composite values such as the keyspace "db0:keys=1,expires=0" entries are not
+// split. An empty section runs INFO without arguments, which reports the default
+// sections. In cluster mode the output of a single node is returned.
+func (s *Redis) Info(ctx context.Context, section string) (map[string]map[string]string, error) {
+	var args []interface{}
+	if section != "" {
+		args = append(args, section)
+	}
+
+	info, err := redisDriver.String(s.DoContext(ctx, "INFO", args...))
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(info), nil
+}
+
+// Parse the output of INFO. Entries listed before the first section header are
+// stored under the empty section name.
+func parseInfo(info string) map[string]map[string]string {
+	sections := make(map[string]map[string]string)
+	name := ""
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#"):
+			name = strings.ToLower(strings.TrimSpace(line[1:]))
+			if sections[name] == nil {
+				sections[name] = make(map[string]string)
+			}
+		default:
+			sep := strings.IndexByte(line, ':')
+			if sep == -1 {
+				continue
+			}
+			if sections[name] == nil {
+				sections[name] = make(map[string]string)
+			}
+			sections[name][line[:sep]] = line[sep+1:]
+		}
+	}
+	return sections
+}
@@ -0,0 +1,86 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const cannedInfo = "# Server\r\n" +
+	"redis_version:7.2.4\r\n" +
+	"redis_mode:standalone\r\n" +
+	"config_file:\r\n" +
+	"\r\n" +
+	"# Keyspace\r\n" +
+	"db0:keys=3,expires=1,avg_ttl=5000\r\n"
+
+func TestInfo(t *testing.T) {
+	var mu sync.Mutex
+	var received [][]string
+
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) != "INFO" {
+				return "+PONG\r\n"
+			}
+
+			mu.Lock()
+			received = append(received, args[1:])
+			mu.Unlock()
+			return fmt.Sprintf("$%d\r\n%s\r\n", len(cannedInfo), cannedInfo)
+		}
+	})
+
+	srv := newTestAdapter(endpoint)
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	for _, section := range []string{"", "keyspace"} {
+		info, err := srv.Info(context.Background(), section)
+		if err != nil {
+			t.Fatalf("[section %q] Expected Info to succeed; got %v", section, err)
+		}
+
+		exp := map[string]map[string]string{
+			"server": {
+				"redis_version": "7.2.4",
+				"redis_mode":    "standalone",
+				"config_file":   "",
+			},
+			"keyspace": {
+				"db0": "keys=3,expires=1,avg_ttl=5000",
+			},
+		}
+		if !reflect.DeepEqual(info, exp) {
+			t.Fatalf("[section %q] Expected %v; got %v", section, exp, info)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if exp := [][]string{{}, {"keyspace"}}; !reflect.DeepEqual(received, exp) {
+		t.Fatalf("Expected INFO to be invoked with args %v; got %v", exp, received)
+	}
+}
+
+func TestInfoNotConnected(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+	if _, err := srv.Info(context.Background(), "server"); err == nil {
+		t.Fatalf("Expected Info to fail for a service that is not connected")
+	}
+}
+
+func TestParseInfoWithoutHeader(t *testing.T) {
+	info := parseInfo("loading:0\nrdb_changes_since_last_save:12\n")
+	exp := map[string]map[string]string{
+		"": {"loading": "0", "rdb_changes_since_last_save": "12"},
+	}
+	if !reflect.DeepEqual(info, exp) {
+		t.Fatalf("Expected %v; got %v", exp, info)
+	}
+}
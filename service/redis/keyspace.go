@@ -0,0 +1,268 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// The interval between attempts to re-subscribe to key event notifications
+// after the subscription connection is lost.
+var watchRetryInterval = 100 * time.Millisecond
+
+// A key event notification emitted by WatchKeyspace.
+type KeyEvent struct {
+	// The affected key.
+	Key string
+
+	// The operation that triggered the event (e.g. del, expired or evicted).
+	Op string
+}
+
+// Stream key event notifications for keys matching the glob-style pattern until ctx
+// is cancelled. Unless the keyspaceEvents setting is empty, the server's
+// notify-keyspace-events setting is updated to enable the configured events.
+//
+// The subscription is re-established whenever its connection is lost or the service
+// is reset, so events emitted while re-subscribing may be missed. The returned channel
+// is closed when ctx is cancelled.
+func (s *Redis) WatchKeyspace(ctx context.Context, pattern string) (<-chan KeyEvent, error) {
+	reset := make(adapters.CloseListener, 1)
+	s.NotifyClose(reset)
+
+	psc, err := s.subscribeKeyEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan KeyEvent)
+	go func() {
+		defer close(events)
+
+		for {
+			if wasReset := streamKeyEvents(ctx, psc, reset, pattern, events); wasReset {
+				reset = make(adapters.CloseListener, 1)
+				s.NotifyClose(reset)
+			}
+
+			// Re-subscribe once the service is reachable again
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(watchRetryInterval):
+				}
+
+				if psc, err = s.subscribeKeyEvents(); err == nil {
+					break
+				}
+				s.logger.Printf("%s Could not re-subscribe to key events: %v\n", s.logPrefix(), err)
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// Enable key event notifications and subscribe to the key events of the configured db.
+func (s *Redis) subscribeKeyEvents() (redisDriver.PubSubConn, error) {
+	s.Lock()
+	db, flags := s.db, s.keyspaceEvents
+	s.Unlock()
+
+	conn, err := s.GetConnection()
+	if err != nil {
+		return redisDriver.PubSubConn{}, err
+	}
+
+	if err = enableKeyspaceEvents(conn, flags); err != nil {
+		conn.Close()
+		return redisDriver.PubSubConn{}, err
+	}
+
+	psc := redisDriver.PubSubConn{Conn: conn}
+	if err = psc.PSubscribe(fmt.Sprintf("__keyevent@%d__:*", db)); err != nil {
+		psc.Close()
+		return redisDriver.PubSubConn{}, err
+	}
+
+	// Wait for the subscription to be confirmed
+	switch reply := psc.Receive().(type) {
+	case redisDriver.Subscription:
+		return psc, nil
+	case error:
+		psc.Close()
+		return redisDriver.PubSubConn{}, reply
+	default:
+		psc.Close()
+		return redisDriver.PubSubConn{}, fmt.Errorf("redis: unexpected reply to PSUBSCRIBE: %v", reply)
+	}
+}
+
+// Forward the key events received by psc that match pattern to events until
+// ctx is cancelled, the connection fails or reset fires. The connection is closed
+// before returning. Returns true if the session was terminated by reset.
+func streamKeyEvents(ctx context.Context, psc redisDriver.PubSubConn, reset adapters.CloseListener, pattern string, events chan<- KeyEvent) bool {
+	defer psc.Close()
+
+	// Unsubscribing makes Receive return once the server acknowledges it
+	done := make(chan struct{})
+	stopped := make(chan bool, 1)
+	go func() {
+		wasReset := false
+		select {
+		case <-done:
+			stopped <- false
+			return
+		case <-ctx.Done():
+		case <-reset:
+			wasReset = true
+		}
+
+		psc.PUnsubscribe()
+		stopped <- wasReset
+	}()
+
+	for streaming := true; streaming; {
+		switch msg := psc.Receive().(type) {
+		case redisDriver.PMessage:
+			// Channels have the format __keyevent@<db>__:<op>
+			op := msg.Channel[strings.Index(msg.Channel, "__:")+3:]
+			key := string(msg.Data)
+			if !matchPattern(pattern, key) {
+				continue
+			}
+
+			select {
+			case events <- KeyEvent{Key: key, Op: op}:
+			case <-ctx.Done():
+			}
+		case redisDriver.Subscription:
+			streaming = msg.Count > 0
+		case error:
+			streaming = false
+		}
+	}
+
+	close(done)
+	return <-stopped
+}
+
+// Make sure that the server emits the notifications specified by flags (see the
+// notify-keyspace-events setting) while preserving any already enabled flags.
+func enableKeyspaceEvents(conn redisDriver.Conn, flags string) error {
+	if flags == "" {
+		return nil
+	}
+
+	reply, err := redisDriver.Strings(conn.Do("CONFIG", "GET", "notify-keyspace-events"))
+	if err != nil {
+		return err
+	}
+	cur := ""
+	if len(reply) == 2 {
+		cur = reply[1]
+	}
+
+	// The A flag is an alias for all event classes
+	enabled := strings.Replace(cur, "A", "g$lshzxet", -1)
+	missing := ""
+	for _, flag := range flags {
+		if !strings.ContainsRune(enabled, flag) && !strings.ContainsRune(missing, flag) {
+			missing += string(flag)
+		}
+	}
+	if missing == "" {
+		return nil
+	}
+
+	_, err = conn.Do("CONFIG", "SET", "notify-keyspace-events", cur+missing)
+	return err
+}
+
+// Report whether key matches the glob-style pattern using the rules of the redis
+// KEYS command: * matches any sequence, ? matches a single character, [...]
+// matches a character class (supporting ^ negation and a-z ranges) and \ escapes
+// the following character.
+func matchPattern(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if matchPattern(pattern, key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if key == "" {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if end == -1 {
+				// Treat unterminated classes as a literal '['
+				if key == "" || key[0] != '[' {
+					return false
+				}
+				pattern, key = pattern[1:], key[1:]
+				continue
+			}
+			if key == "" || !matchClass(pattern[1:1+end], key[0]) {
+				return false
+			}
+			pattern, key = pattern[end+2:], key[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if key == "" || pattern[0] != key[0] {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		}
+	}
+
+	return key == ""
+}
+
+// Report whether c matches a character class (the contents of a [...] pattern).
+func matchClass(class string, c byte) bool {
+	negate := strings.HasPrefix(class, "^")
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		switch {
+		case class[i] == '\\' && i+1 < len(class):
+			i++
+			matched = matched || class[i] == c
+		case i+2 < len(class) && class[i+1] == '-':
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			i += 2
+		default:
+			matched = matched || class[i] == c
+		}
+	}
+
+	return matched != negate
+}
@@ -0,0 +1,247 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake redis server that supports pattern subscriptions and CONFIG GET/SET
+// for the notify-keyspace-events setting.
+type fakePubSub struct {
+	sync.Mutex
+
+	// The current notify-keyspace-events setting.
+	config string
+
+	// The number of CONFIG SET and PSUBSCRIBE commands received.
+	configSets  int
+	psubscribes int
+
+	// All accepted connections and the patterns subscribed by each connection.
+	conns []net.Conn
+	subs  map[net.Conn]string
+}
+
+func newFakePubSub(t *testing.T) (*fakePubSub, string) {
+	srv := &fakePubSub{subs: make(map[net.Conn]string)}
+	endpoint := serveFake(t, "tcp", "127.0.0.1:0", srv.handler)
+	return srv, endpoint
+}
+
+func (srv *fakePubSub) handler(conn net.Conn) fakeHandler {
+	srv.Lock()
+	srv.conns = append(srv.conns, conn)
+	srv.Unlock()
+
+	return func(args []string) string {
+		srv.Lock()
+		defer srv.Unlock()
+
+		switch strings.ToUpper(args[0]) {
+		case "CONFIG":
+			if strings.ToUpper(args[1]) == "SET" {
+				srv.configSets++
+				srv.config = args[3]
+				return "+OK\r\n"
+			}
+			return fmt.Sprintf("*2\r\n%s%s", bulk(args[2]), bulk(srv.config))
+		case "PSUBSCRIBE":
+			srv.psubscribes++
+			srv.subs[conn] = args[1]
+			return fmt.Sprintf("*3\r\n%s%s:1\r\n", bulk("psubscribe"), bulk(args[1]))
+		case "ECHO":
+			return bulk(args[1])
+		case "UNSUBSCRIBE":
+			return fmt.Sprintf("*3\r\n%s$-1\r\n:0\r\n", bulk("unsubscribe"))
+		case "PUNSUBSCRIBE":
+			pattern := srv.subs[conn]
+			delete(srv.subs, conn)
+			return fmt.Sprintf("*3\r\n%s%s:0\r\n", bulk("punsubscribe"), bulk(pattern))
+		}
+		return "+PONG\r\n"
+	}
+}
+
+// Publish a key event to all subscribed connections.
+func (srv *fakePubSub) publish(op, key string) {
+	srv.Lock()
+	defer srv.Unlock()
+
+	channel := "__keyevent@0__:" + op
+	for conn, pattern := range srv.subs {
+		fmt.Fprintf(conn, "*4\r\n%s%s%s%s", bulk("pmessage"), bulk(pattern), bulk(channel), bulk(key))
+	}
+}
+
+// Drop all client connections.
+func (srv *fakePubSub) dropConnections() {
+	srv.Lock()
+	defer srv.Unlock()
+
+	for _, conn := range srv.conns {
+		conn.Close()
+	}
+	srv.conns = nil
+	srv.subs = make(map[net.Conn]string)
+}
+
+func (srv *fakePubSub) subscriptions() int {
+	srv.Lock()
+	defer srv.Unlock()
+
+	return len(srv.subs)
+}
+
+func bulk(val string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(val), val)
+}
+
+func expectKeyEvent(t *testing.T, events <-chan KeyEvent, exp KeyEvent) {
+	select {
+	case evt, ok := <-events:
+		if !ok {
+			t.Fatalf("Expected event %+v; channel was closed", exp)
+		}
+		if evt != exp {
+			t.Fatalf("Expected event %+v; got %+v", exp, evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for event %+v", exp)
+	}
+}
+
+// Wait until the fake server reports the expected number of subscriptions.
+func waitForSubscriptions(t *testing.T, srv *fakePubSub, count int) {
+	deadline := time.Now().Add(time.Second)
+	for srv.subscriptions() != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d subscription(s)", count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatchKeyspace(t *testing.T) {
+	defer func(interval time.Duration) { watchRetryInterval = interval }(watchRetryInterval)
+	watchRetryInterval = 10 * time.Millisecond
+
+	server, endpoint := newFakePubSub(t)
+	server.config = "Kl"
+
+	srv := newTestAdapter(endpoint)
+	srv.keyspaceEvents = "Egxe"
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := srv.WatchKeyspace(ctx, "user:*")
+	if err != nil {
+		t.Fatalf("Expected WatchKeyspace to succeed; got %v", err)
+	}
+
+	// Existing flags should be preserved
+	server.Lock()
+	if server.config != "KlEgxe" {
+		t.Fatalf("Expected notify-keyspace-events to be set to KlEgxe; got %s", server.config)
+	}
+	server.Unlock()
+
+	// Events for keys that do not match the pattern should be filtered out
+	server.publish("expired", "session:1")
+	server.publish("expired", "user:1")
+	server.publish("del", "user:2")
+	expectKeyEvent(t, events, KeyEvent{Key: "user:1", Op: "expired"})
+	expectKeyEvent(t, events, KeyEvent{Key: "user:2", Op: "del"})
+
+	// The subscription should be re-established after the connection is lost
+	server.dropConnections()
+	waitForSubscriptions(t, server, 1)
+	server.publish("evicted", "user:3")
+	expectKeyEvent(t, events, KeyEvent{Key: "user:3", Op: "evicted"})
+
+	// The subscription should be re-established after a service reset
+	if err = srv.Config(map[string]string{"connTimeout": "2"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		server.Lock()
+		psubscribes := server.psubscribes
+		server.Unlock()
+		if psubscribes == 3 && server.subscriptions() == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for a re-subscription after a service reset")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	server.publish("del", "user:4")
+	expectKeyEvent(t, events, KeyEvent{Key: "user:4", Op: "del"})
+
+	// The flags are already enabled so they should not be set again
+	server.Lock()
+	if server.configSets != 1 {
+		t.Fatalf("Expected notify-keyspace-events to be set once; got %d", server.configSets)
+	}
+	server.Unlock()
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatalf("Expected no further events after cancelling the context")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the event channel to be closed")
+	}
+	waitForSubscriptions(t, server, 0)
+}
+
+func TestWatchKeyspaceNotConnected(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	if _, err := srv.WatchKeyspace(context.Background(), "*"); err == nil {
+		t.Fatalf("Expected WatchKeyspace to fail for a disconnected service")
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	specs := []struct {
+		pattern string
+		key     string
+		exp     bool
+	}{
+		{"*", "", true},
+		{"*", "user:1", true},
+		{"user:*", "user:1", true},
+		{"user:*", "session:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"h[llo", "h[llo", true},
+		{"user:1", "user:10", false},
+	}
+
+	for index, spec := range specs {
+		if got := matchPattern(spec.pattern, spec.key); got != spec.exp {
+			t.Fatalf("[spec %d] Expected matchPattern(%q, %q) to return %t; got %t", index, spec.pattern, spec.key, spec.exp, got)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// ErrTooManyConnections is returned when a new connection would exceed the
+// maxTotalConnections setting and poolWait is disabled.
+var ErrTooManyConnections = errors.New("redis: maxTotalConnections limit reached")
+
+// The interval for retrying a pooled connection while waiting for the
+// maxTotalConnections limit to free up.
+var connLimitPollInterval = 10 * time.Millisecond
+
+// A counting semaphore shared by all connections opened by a Redis instance:
+// pooled master connections (including the ones used for pub/sub), replica
+// connections and cluster node connections. The zero value imposes no limit.
+type connLimiter struct {
+	sync.Mutex
+
+	// The max number of open connections; 0 means no limit.
+	max int
+
+	// The number of open connections.
+	count int
+}
+
+// Set the max number of open connections. Connections that are already open
+// are not affected if they exceed the new limit.
+func (l *connLimiter) setMax(max int) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.max = max
+}
+
+// Get the number of open connections.
+func (l *connLimiter) open() int {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.count
+}
+
+// Acquire a slot for a new connection if the limit allows it.
+func (l *connLimiter) tryAcquire() bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.max > 0 && l.count >= l.max {
+		return false
+	}
+	l.count++
+	return true
+}
+
+func (l *connLimiter) release() {
+	l.Lock()
+	defer l.Unlock()
+
+	l.count--
+}
+
+// Acquire a slot and dial a connection using dialFn. The slot is released when
+// the returned connection is closed or if dialFn fails. ErrTooManyConnections is
+// returned without dialing if no slot is available.
+func (l *connLimiter) dial(dialFn func() (redisDriver.Conn, error)) (redisDriver.Conn, error) {
+	if !l.tryAcquire() {
+		return nil, ErrTooManyConnections
+	}
+
+	c, err := dialFn()
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	return &limitedConn{Conn: c, release: l.release}, nil
+}
+
+// A connection that releases its limiter slot when closed.
+type limitedConn struct {
+	redisDriver.Conn
+
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// Get a connection from pool. If wait is set and the pool cannot dial a connection
+// because of the maxTotalConnections limit, the call is retried until a connection
+// is available or ctx is done, in which case ctx.Err() is returned. On failure, the
+// returned connection must still be closed by the caller.
+func (s *Redis) getPooled(ctx context.Context, pool *redisDriver.Pool, wait bool) (redisDriver.Conn, error) {
+	for {
+		conn, err := pool.GetContext(ctx)
+		if err != ErrTooManyConnections || !wait {
+			return conn, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return conn, ctx.Err()
+		case <-time.After(connLimitPollInterval):
+			conn.Close()
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMaxTotalConnections(t *testing.T) {
+	endpoint := newFakeServer(t)
+	srv := newTestAdapter(endpoint)
+	if err := srv.Config(map[string]string{"replicas": endpoint + "," + endpoint, "maxTotalConnections": "3"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	// Saturate the limit using connections from the master and both replica pools
+	master, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	defer master.Close()
+	for i := 0; i < 2; i++ {
+		replica, err := srv.GetReadConnection()
+		if err != nil {
+			t.Fatalf("[replica %d] Expected GetReadConnection to succeed; got %v", i, err)
+		}
+		defer replica.Close()
+	}
+	if open := srv.conns.open(); open != 3 {
+		t.Fatalf("Expected 3 open connections; got %d", open)
+	}
+
+	if _, err = srv.GetConnection(); err != ErrTooManyConnections {
+		t.Fatalf("Expected GetConnection to fail with ErrTooManyConnections; got %v", err)
+	}
+	if _, err = srv.GetReadConnection(); err != ErrTooManyConnections {
+		t.Fatalf("Expected GetReadConnection to fail with ErrTooManyConnections; got %v", err)
+	}
+	if _, err = srv.DoContext(context.Background(), "PING"); err != ErrTooManyConnections {
+		t.Fatalf("Expected DoContext to fail with ErrTooManyConnections; got %v", err)
+	}
+	if open := srv.conns.open(); open != 3 {
+		t.Fatalf("Expected the limit to hold at 3 open connections; got %d", open)
+	}
+
+	// Replicas must not be evicted because of the limit
+	srv.replicaSet.Lock()
+	for index, until := range srv.replicaSet.evictedUntil {
+		if !until.IsZero() {
+			t.Fatalf("Expected replica %d not to be evicted", index)
+		}
+	}
+	srv.replicaSet.Unlock()
+}
+
+func TestMaxTotalConnectionsWait(t *testing.T) {
+	endpoint := newFakeServer(t)
+	srv := newTestAdapter(endpoint)
+	if err := srv.Config(map[string]string{"replicas": endpoint, "maxTotalConnections": "2", "poolWait": "true"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	master, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	replica, err := srv.GetReadConnection()
+	if err != nil {
+		t.Fatalf("Expected GetReadConnection to succeed; got %v", err)
+	}
+	defer replica.Close()
+
+	if _, err = srv.GetConnectionTimeout(30 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("Expected GetConnectionTimeout to fail with context.DeadlineExceeded; got %v", err)
+	}
+
+	// Returning the master connection unblocks a waiting caller
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		master.Close()
+	}()
+	conn, err := srv.GetConnectionTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("Expected GetConnectionTimeout to succeed once a connection is returned; got %v", err)
+	}
+	defer conn.Close()
+
+	if open := srv.conns.open(); open != 2 {
+		t.Fatalf("Expected the limit to hold at 2 open connections; got %d", open)
+	}
+}
+
+func TestMaxTotalConnectionsReleasedOnClose(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+	if err := srv.Config(map[string]string{"maxTotalConnections": "1"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	conn.Close()
+	srv.Close()
+
+	if open := srv.conns.open(); open != 0 {
+		t.Fatalf("Expected closing the service to release all connections; got %d open", open)
+	}
+}
+
+func TestMaxTotalConnectionsReleasedOnReset(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+	if err := srv.Config(map[string]string{"maxTotalConnections": "2"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	// Leave an idle connection in the pool before each config-triggered reset
+	for index, connTimeout := range []string{"2", "3"} {
+		conn, err := srv.GetConnection()
+		if err != nil {
+			t.Fatalf("[reset %d] Expected GetConnection to succeed; got %v", index, err)
+		}
+		conn.Close()
+
+		if err = srv.Config(map[string]string{"connTimeout": connTimeout}); err != nil {
+			t.Fatalf("[reset %d] Expected Config to succeed; got %v", index, err)
+		}
+	}
+	if open := srv.conns.open(); open != 0 {
+		t.Fatalf("Expected the reset to close the idle connections of the previous pool; got %d open", open)
+	}
+
+	// The limit should still allow borrowing up to maxTotalConnections
+	for i := 0; i < 2; i++ {
+		conn, err := srv.GetConnectionTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("[conn %d] Expected GetConnectionTimeout to succeed after the reset; got %v", i, err)
+		}
+		defer conn.Close()
+	}
+}
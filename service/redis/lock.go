@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+var (
+	// ErrLockNotAcquired is returned by AcquireLock if the lock is held by another owner.
+	ErrLockNotAcquired = errors.New("redis: lock is held by another owner")
+
+	// ErrLockNotHeld is returned by Release and Refresh if the lock has expired or
+	// has since been acquired by another owner.
+	ErrLockNotHeld = errors.New("redis: lock is no longer held")
+)
+
+// Delete the lock key only if it still holds the owner token.
+var releaseLockScript = &Script{src: `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`}
+
+// Extend the TTL of the lock key only if it still holds the owner token.
+var refreshLockScript = &Script{src: `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`}
+
+// A lock acquired via AcquireLock. The lock key stores a random token identifying
+// its owner so that a lock that expired and was acquired by someone else is never
+// released or extended by its previous owner.
+type Lock struct {
+	service *Redis
+	key     string
+	token   string
+}
+
+// Acquire a lock by setting key to a random token with SET NX PX. The lock expires
+// after ttl unless it is extended via Refresh. If the key is already held by another
+// owner, ErrLockNotAcquired is returned; callers that want to wait for the lock should
+// retry. Locks are not supported in cluster mode.
+func (s *Redis) AcquireLock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	if ttl < time.Millisecond {
+		return nil, fmt.Errorf("invalid value for 'ttl': %v; lock TTLs must be at least 1ms", ttl)
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	lock := &Lock{service: s, key: key, token: hex.EncodeToString(token)}
+
+	conn, err := s.GetConnectionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = redisDriver.String(conn.Do("SET", key, lock.token, "NX", "PX", int64(ttl/time.Millisecond)))
+	if err == redisDriver.ErrNil {
+		return nil, ErrLockNotAcquired
+	} else if err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Get the lock key.
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Release the lock. The key is only deleted if it is still held by this lock;
+// otherwise ErrLockNotHeld is returned.
+func (l *Lock) Release() error {
+	return l.run(releaseLockScript, l.token)
+}
+
+// Extend the lock so that it expires after ttl. Returns ErrLockNotHeld if the lock
+// has expired or has been acquired by another owner.
+func (l *Lock) Refresh(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return fmt.Errorf("invalid value for 'ttl': %v; lock TTLs must be at least 1ms", ttl)
+	}
+	return l.run(refreshLockScript, l.token, int64(ttl/time.Millisecond))
+}
+
+// Run a compare-and-act script against the lock key using a pooled connection.
+func (l *Lock) run(sc *Script, args ...interface{}) error {
+	conn, err := l.service.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	updated, err := redisDriver.Int(sc.Run(conn, []string{l.key}, args...))
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters/service/redis/redistest"
+)
+
+func TestLockContention(t *testing.T) {
+	server := redistest.Run(t)
+	srv := newTestAdapter("")
+	server.Connect(t, srv, nil)
+
+	lock, err := srv.AcquireLock(context.Background(), "jobs", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected AcquireLock to succeed; got %v", err)
+	}
+	if ttl := server.TTL("jobs"); ttl != time.Minute {
+		t.Fatalf("Expected the lock key to expire in 1m; got %v", ttl)
+	}
+
+	if _, err = srv.AcquireLock(context.Background(), "jobs", time.Minute); err != ErrLockNotAcquired {
+		t.Fatalf("Expected a second AcquireLock to fail with ErrLockNotAcquired; got %v", err)
+	}
+
+	if err = lock.Release(); err != nil {
+		t.Fatalf("Expected Release to succeed; got %v", err)
+	}
+	if server.Exists("jobs") {
+		t.Fatalf("Expected Release to delete the lock key")
+	}
+	if lock, err = srv.AcquireLock(context.Background(), "jobs", time.Minute); err != nil {
+		t.Fatalf("Expected AcquireLock to succeed after the lock was released; got %v", err)
+	}
+	lock.Release()
+}
+
+func TestLockSafeRelease(t *testing.T) {
+	server := redistest.Run(t)
+	srv := newTestAdapter("")
+	server.Connect(t, srv, nil)
+
+	stale, err := srv.AcquireLock(context.Background(), "jobs", time.Second)
+	if err != nil {
+		t.Fatalf("Expected AcquireLock to succeed; got %v", err)
+	}
+
+	// Let the lock expire and have another owner acquire it
+	server.FastForward(2 * time.Second)
+	owner, err := srv.AcquireLock(context.Background(), "jobs", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected AcquireLock to succeed once the lock expired; got %v", err)
+	}
+
+	if err = stale.Refresh(time.Minute); err != ErrLockNotHeld {
+		t.Fatalf("Expected Refresh of an expired lock to fail with ErrLockNotHeld; got %v", err)
+	}
+	if err = stale.Release(); err != ErrLockNotHeld {
+		t.Fatalf("Expected Release of an expired lock to fail with ErrLockNotHeld; got %v", err)
+	}
+	if !server.Exists("jobs") {
+		t.Fatalf("Expected the lock of the new owner to be kept")
+	}
+
+	if err = owner.Refresh(5 * time.Minute); err != nil {
+		t.Fatalf("Expected Refresh to succeed; got %v", err)
+	}
+	if ttl := server.TTL("jobs"); ttl != 5*time.Minute {
+		t.Fatalf("Expected Refresh to extend the lock TTL to 5m; got %v", ttl)
+	}
+	if err = owner.Release(); err != nil {
+		t.Fatalf("Expected Release to succeed; got %v", err)
+	}
+}
+
+func TestAcquireLockInvalidTTL(t *testing.T) {
+	srv := newTestAdapter("localhost:6379")
+	if _, err := srv.AcquireLock(context.Background(), "jobs", 0); err == nil {
+		t.Fatalf("Expected AcquireLock to reject a zero TTL")
+	}
+}
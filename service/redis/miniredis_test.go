@@ -0,0 +1,135 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/service/redis/redistest"
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+func TestMiniredisGetConnection(t *testing.T) {
+	server := redistest.Run(t)
+	srv := newTestAdapter("")
+	server.Connect(t, srv, nil)
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Do("SET", "foo", "bar"); err != nil {
+		t.Fatalf("Expected SET to succeed; got %v", err)
+	}
+	if val, err := server.Get("foo"); err != nil || val != "bar" {
+		t.Fatalf("Expected foo to be set to bar; got %q (%v)", val, err)
+	}
+}
+
+func TestMiniredisConfigReconnect(t *testing.T) {
+	server := redistest.Run(t)
+	srv := newTestAdapter("")
+	server.Connect(t, srv, nil)
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	// Pointing the adapter at a different server should reset the connection
+	other := redistest.Run(t)
+	other.Set("foo", "other")
+	if err := srv.Config(other.Settings()); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err, ok := <-listener; ok {
+		t.Fatalf("Expected the listener to be closed without an error; got %v", err)
+	}
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	defer conn.Close()
+	if val, err := redisDriver.String(conn.Do("GET", "foo")); err != nil || val != "other" {
+		t.Fatalf("Expected connections to be established to the new server; got %q (%v)", val, err)
+	}
+}
+
+func TestMiniredisAuthAndSelect(t *testing.T) {
+	server := redistest.Run(t)
+	server.RequireAuth("secret")
+
+	srv := newTestAdapter("")
+	server.Connect(t, srv, map[string]string{"password": "secret", "db": "2"})
+	if _, err := srv.DoContext(context.Background(), "SET", "foo", "bar"); err != nil {
+		t.Fatalf("Expected SET to succeed; got %v", err)
+	}
+	if val, err := server.DB(2).Get("foo"); err != nil || val != "bar" {
+		t.Fatalf("Expected foo to be set to bar on db 2; got %q (%v)", val, err)
+	}
+
+	// Authentication failures are not retried
+	if err := srv.Config(map[string]string{"password": "wrong"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if _, err := srv.GetConnection(); err == nil {
+		t.Fatalf("Expected GetConnection to fail with an invalid password")
+	}
+}
+
+func TestMiniredisCloseNotification(t *testing.T) {
+	server := redistest.Run(t)
+	srv := newTestAdapter("")
+	server.Connect(t, srv, nil)
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	srv.Close()
+
+	if err := <-listener; err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected to receive ErrConnectionClosed; got %v", err)
+	}
+	if _, err := srv.GetConnection(); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected GetConnection to fail with ErrConnectionClosed; got %v", err)
+	}
+}
+
+func TestMiniredisReconfigure(t *testing.T) {
+	server := redistest.Run(t)
+	srv := newTestAdapter("")
+	server.Connect(t, srv, nil)
+
+	other := redistest.Run(t)
+	other.Set("foo", "other")
+	if err := adapters.Reconfigure(context.Background(), srv, other.Settings()); err != nil {
+		t.Fatalf("Expected Reconfigure to succeed; got %v", err)
+	}
+	if val, err := redisDriver.String(srv.DoContext(context.Background(), "GET", "foo")); err != nil || val != "other" {
+		t.Fatalf("Expected commands to run against the new server; got %q (%v)", val, err)
+	}
+}
+
+func TestMiniredisReconfigureUnreachableEndpoint(t *testing.T) {
+	server := redistest.Run(t)
+	srv := newTestAdapter("")
+	server.Connect(t, srv, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := adapters.Reconfigure(ctx, srv, map[string]string{"endpoint": "127.0.0.1:1"})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected Reconfigure to fail with context.DeadlineExceeded; got %v", err)
+	}
+
+	// The service is closed once the pending probe observes the expired context
+	deadline := time.Now().Add(time.Second)
+	for srv.IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the service to be left disconnected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
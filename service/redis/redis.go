@@ -1,37 +1,74 @@
 package redis
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"time"
 
 	"io/ioutil"
 
-	"strconv"
-
 	"strings"
 
 	"github.com/achilleasa/usrv-service-adapters"
 	"github.com/achilleasa/usrv-service-adapters/dial"
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
 	redisDriver "github.com/garyburd/redigo/redis"
 )
 
+// The service name reported to the metrics sink.
+const serviceName = "redis"
+
+// The endpoint prefix for connecting over a unix socket.
+const unixPrefix = "unix://"
+
 // Adapter is a singleton instance of a redis service
 var Adapter *Redis
 
+// The default settings of the service. Adapter is initialized from these settings and
+// they are reported by ConfigDefaults.
+var defaultConfig = map[string]string{
+	"endpoint":            "localhost:3679",
+	"network":             "tcp",
+	"password":            "",
+	"db":                  "0",
+	"connTimeout":         "1",
+	"cluster":             "false",
+	"replicas":            "",
+	"keepAlive":           "0",
+	"tcpKeepAlive":        "0",
+	"maxActive":           "0",
+	"maxTotalConnections": "0",
+	"poolWait":            "false",
+	"warmup":              "0",
+	"keyspaceEvents":      "Egxe",
+	"retryableErrors":     defaultRetryableErrors,
+	"testOnBorrow":        "always",
+	"testOnBorrowIdle":    "60",
+	"protocol":            strconv.Itoa(protocolRESP2),
+}
+
 // Initialize the service using default values
 func init() {
 	Adapter = &Redis{
-		endpoint:          "localhost:3679",
-		password:          "",
-		db:                0,
-		connectionTimeout: time.Second * 1,
-		logger:            log.New(ioutil.Discard, "", log.LstdFlags),
-		dialPolicy:        dial.ExpBackoff(10, time.Millisecond),
-		closeNotifier:     adapters.NewNotifier(),
+		logger:        log.New(ioutil.Discard, "", log.LstdFlags),
+		dialPolicy:    dial.ExpBackoff(10, time.Millisecond),
+		metrics:       adapters.NopMetrics,
+		tracer:        adapters.NopTracer,
+		closeNotifier: adapters.NewNotifier(),
 	}
+
+	cfg, _, err := Adapter.parseConfig(defaultConfig)
+	if err != nil {
+		panic(err)
+	}
+	Adapter.applyConfig(cfg)
 }
 
 type Redis struct {
@@ -40,6 +77,10 @@ type Redis struct {
 	// by a configuration service (e.g. etcd)
 	endpoint string
 
+	// The network used for connecting to the endpoint (tcp or unix). Endpoints
+	// with a unix:// prefix always use the unix network.
+	network string
+
 	// Redis password (used if non-empty)
 	password string
 
@@ -49,115 +90,778 @@ type Redis struct {
 	// Connection timeout
 	connectionTimeout time.Duration
 
+	// Enable cluster mode. The endpoint is then treated as a comma-delimited
+	// list of seed nodes and db must be 0.
+	clusterMode bool
+
+	// Detect whether the endpoint is a cluster node when dialing instead of
+	// using clusterMode.
+	clusterAuto bool
+
+	// Set when clusterAuto is enabled and the endpoint was detected to be a cluster node.
+	clusterDetected bool
+
+	// A comma-delimited list of read replica endpoints used by GetReadConnection.
+	readReplicas string
+
+	// The interval between keepalive probes of idle pool connections; 0 disables probing.
+	keepAlive time.Duration
+
+	// The period of OS-level TCP keepalive probes for new connections; 0 uses
+	// the driver default.
+	tcpKeepAlive time.Duration
+
+	// The dialer used for establishing network connections; nil uses net.DialTimeout.
+	dialer adapters.Dialer
+
+	// The maximum number of connections allocated by the pool; 0 means no limit.
+	maxActive int
+
+	// The maximum number of connections opened across the master, replica and
+	// cluster node pools; 0 means no limit.
+	maxTotalConnections int
+
+	// If set and the pool is at its maxActive limit, GetConnection waits for a
+	// connection to be returned to the pool instead of failing.
+	poolWait bool
+
+	// The number of connections eagerly opened by Dial and added to the pool; 0
+	// keeps the pool lazy.
+	warmup int
+
+	// The notify-keyspace-events flags enabled by WatchKeyspace; empty leaves the
+	// server setting unmodified.
+	keyspaceEvents string
+
+	// A comma-delimited list of error substrings that DoRetry treats as transient.
+	retryableErrors string
+
+	// Controls when pooled connections are PINGed before being handed out: always,
+	// onIdle or never. An empty value is treated as always.
+	testOnBorrow string
+
+	// The idle time after which connections are PINGed in onIdle mode.
+	testOnBorrowIdle time.Duration
+
+	// The RESP protocol version requested when dialing (2 or 3).
+	protocol int
+
+	// An optional name for telling instances apart in log lines and metrics.
+	name string
+
 	// A logger for service events.
 	logger *log.Logger
 
+	// An optional logger for tagging log lines with the fields of a context.
+	contextLogger adapters.ContextLogger
+
 	// A mutex protecting dial attempts.
 	sync.Mutex
 
 	// The dial policy to use.
 	dialPolicy dial.Policy
 
+	// A sink for dial and connection metrics.
+	metrics adapters.Metrics
+
+	// A tracer for creating spans around Dial and Config.
+	tracer adapters.Tracer
+
+	// An optional callback invoked when a dial gives up; see OnDialFailure.
+	onDialFailure func(attempts uint32, lastErr error)
+
 	// Connection status.
 	connected bool
 
+	// The error that caused the most recent dial to fail; nil if it succeeded.
+	lastErr error
+
+	// The number of attempts made by the most recent connection dial.
+	dialAttempts uint32
+
+	// The time of the most recent successful connection dial.
+	lastConnectedAt time.Time
+
+	// The RESP protocol version negotiated by the most recent connection dial.
+	negotiatedProtocol int
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
+	// Set while CloseContext waits for borrowed connections to be returned.
+	draining bool
+
 	// Redis pool
 	pool *redisDriver.Pool
 
+	// Cluster client; used instead of pool in cluster mode.
+	cluster *cluster
+
+	// Read replica pools; nil if no replicas are configured.
+	replicaSet *replicaSet
+
+	// Enforces the maxTotalConnections limit across all pools.
+	conns connLimiter
+
+	// The CommandHook registered via SetCommandHook.
+	commandHook atomic.Value
+
+	// The PushHandler registered via SetPushHandler.
+	pushHandler atomic.Value
+
+	// Closed to stop the keepalive goroutine of the current pool.
+	keepAliveStop chan struct{}
+
 	// A notifier for close events.
 	closeNotifier *adapters.Notifier
+
+	// A stream of connection events.
+	events adapters.EventStream
 }
 
 // Connect to the service. If a dial policy has been specified,
 // the service will keep trying to reconnect until a connection
 // is established or the dial policy aborts the reconnection attempt.
 func (s *Redis) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context. The connection pool dials
+// lazily unless the warmup setting is specified; ctx is checked for cancellation
+// before the pool is set up and aborts any pending warmup dials. As ctx only applies
+// to the initial connect, cancelling it afterwards does not affect the connections
+// that the pool dials later on.
+func (s *Redis) DialContext(ctx context.Context) (err error) {
 	s.Lock()
 	defer s.Unlock()
 
 	// We are already connected
 	if s.connected {
+		return adapters.ErrAlreadyConnected
+	}
+	s.events.DialStarted()
+
+	_, span := s.tracer.StartSpan(ctx, serviceName+".Dial")
+	span.SetAttribute("endpoint", s.endpoint)
+	defer func() {
+		span.End(err)
+		s.lastErr = err
+		if err != nil {
+			s.events.Emit(adapters.Event{Type: adapters.EventDialFailed, Err: err})
+		}
+	}()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if s.clusterAuto {
+		if err = s.detectCluster(ctx); err != nil {
+			return err
+		}
+	}
+
+	s.setupPool(ctx)
+	s.events.Emit(adapters.Event{Type: adapters.EventConnect})
+
+	return nil
+}
+
+// Setup the connection pool and pre-fill it with warmup connections. This method
+// is not thread-safe so it should be invoked while holding the service lock.
+func (s *Redis) setupPool(ctx context.Context) {
+
+	// Stop probing and close the previous pool, if any. Idle connections are
+	// closed right away and borrowed ones once they are returned so that their
+	// maxTotalConnections slots are released
+	s.stopKeepAlive()
+	if s.pool != nil {
+		s.pool.Close()
+	}
+	if s.replicaSet != nil {
+		s.replicaSet.close()
+		s.replicaSet = nil
+	}
+
+	// Create a new pool or cluster client
+	if s.clusterMode || s.clusterDetected {
+		dialer, timeout, tcpKeepAlive, password, conns := s.dialer, s.connectionTimeout, s.tcpKeepAlive, s.password, &s.conns
+		s.pool = nil
+		s.cluster = newCluster(strings.Split(s.endpoint, ","), func(addr string) (redisDriver.Conn, error) {
+			return s.hookedDial(func() (redisDriver.Conn, error) {
+				return conns.dial(func() (redisDriver.Conn, error) {
+					return dialClusterNode(addr, dialer, timeout, tcpKeepAlive, password)
+				})
+			})
+		})
+
+		// Populate the slot mapping so that commands are routed to their owners
+		// rather than redirected by a seed node
+		s.cluster.refreshAsync()
+	} else {
+		s.cluster = nil
+		s.pool = newPool(s.dialPoolConnection)
+		s.pool.MaxActive = s.maxActive
+		s.pool.Wait = s.poolWait
+		s.pool.TestOnBorrow = testOnBorrowFunc(s.testOnBorrow, s.testOnBorrowIdle)
+		s.warmupPool(ctx)
+		if s.readReplicas != "" {
+			s.replicaSet = newReplicaSet(strings.Split(s.readReplicas, ","), s.dialReplica, s.pool.TestOnBorrow, s.logger, s.logPrefix())
+		}
+		if s.keepAlive > 0 {
+			s.keepAliveStop = make(chan struct{})
+			ping := s.testOnBorrow == "onIdle" || s.testOnBorrow == "never"
+			go keepAlive(s.pool, s.keepAlive, ping, s.keepAliveStop)
+		}
+	}
+
+	s.connected = true
+	s.metrics.SetConnected(s.Name(), true)
+	s.dialPolicy.ResetAttempts()
+}
+
+// Query the endpoint with INFO cluster and set clusterDetected if it reports
+// cluster_enabled:1. Servers that reject the command are treated as standalone
+// servers. This method is not thread-safe so it should be invoked while holding
+// the service lock.
+func (s *Redis) detectCluster(ctx context.Context) error {
+	s.clusterDetected = false
+
+	c, err := s.conns.dial(func() (redisDriver.Conn, error) { return s.dialWithPolicy(ctx) })
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	reply, err := redisDriver.String(c.Do("INFO", "cluster"))
+	if err != nil {
+		if _, isReplyErr := err.(redisDriver.Error); isReplyErr {
+			return nil
+		}
+		return err
+	}
+	if parseInfo(reply)["cluster"]["cluster_enabled"] != "1" {
 		return nil
 	}
 
-	s.setupPool()
+	switch network, _ := s.dialAddress(); {
+	case network == "unix":
+		return fmt.Errorf("endpoint %s is a cluster node; cluster mode only supports tcp", s.endpoint)
+	case s.readReplicas != "":
+		return fmt.Errorf("endpoint %s is a cluster node; replicas are not supported in cluster mode", s.endpoint)
+	case s.db != 0:
+		return fmt.Errorf("endpoint %s is a cluster node; cluster mode only supports db 0", s.endpoint)
+	}
 
+	s.clusterDetected = true
+	s.logger.Printf("%s Detected cluster node at endpoint %s; using cluster mode\n", s.logPrefix(), s.endpoint)
 	return nil
 }
 
-// Setup the connection pool. This method is not thread-safe
+// Open warmup connections using the dial policy and add them to the idle connections
+// of the pool. The warmup is capped at maxActive and stops at the first connection that
+// cannot be established within the dial policy limits; the remaining connections are
+// then dialed lazily by the pool. This method is not thread-safe so it should be
+// invoked while holding the service lock and before the pool is used.
+func (s *Redis) warmupPool(ctx context.Context) {
+	count := s.warmup
+	if s.maxActive > 0 && count > s.maxActive {
+		count = s.maxActive
+	}
+	if count <= 0 {
+		return
+	}
+	if count > s.pool.MaxIdle {
+		s.pool.MaxIdle = count
+	}
+
+	conns := make([]redisDriver.Conn, 0, count)
+	for len(conns) < count {
+		c, err := s.hookedDial(func() (redisDriver.Conn, error) {
+			return s.conns.dial(func() (redisDriver.Conn, error) { return s.dialWithPolicy(ctx) })
+		})
+		if err != nil {
+			adapters.LoggerForContext(ctx, s.contextLogger, s.logger).Printf("%s Pool warmup aborted after %d of %d connection(s): %v\n", s.logPrefix(), len(conns), count, err)
+			break
+		}
+		conns = append(conns, c)
+	}
+
+	seedPool(s.pool, conns)
+}
+
+// Add conns to the idle connections of pool. As the pool does not provide a way for
+// adding connections, its dialer is temporarily replaced with one that hands out conns
+// and the borrowed connections are then returned to the pool. The pool must not be
+// in use by any other goroutine.
+func seedPool(pool *redisDriver.Pool, conns []redisDriver.Conn) {
+	dialFn := pool.Dial
+	defer func() { pool.Dial = dialFn }()
+
+	next := 0
+	pool.Dial = func() (redisDriver.Conn, error) {
+		c := conns[next]
+		next++
+		return c, nil
+	}
+
+	borrowed := make([]redisDriver.Conn, 0, len(conns))
+	for range conns {
+		borrowed = append(borrowed, pool.Get())
+	}
+	for _, c := range borrowed {
+		c.Close()
+	}
+}
+
+// Stop the keepalive goroutine if one is running. This method is not thread-safe
 // so it should be invoked while holding the service lock.
-func (s *Redis) setupPool() {
-
-	// Create a new pool
-	s.pool = &redisDriver.Pool{
-		MaxIdle:     3,
-		IdleTimeout: 240 * time.Second,
-		Dial:        s.dialPoolConnection,
-		TestOnBorrow: func(c redisDriver.Conn, t time.Time) error {
+func (s *Redis) stopKeepAlive() {
+	if s.keepAliveStop != nil {
+		close(s.keepAliveStop)
+		s.keepAliveStop = nil
+	}
+}
+
+// Probe the idle connections of pool every interval until stop is closed. If ping
+// is set, probed connections are PINGed explicitly as the pool does not PING every
+// borrowed connection.
+func keepAlive(pool *redisDriver.Pool, interval time.Duration, ping bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			probeIdle(pool, ping)
+		}
+	}
+}
+
+// Borrow all idle connections of pool and return them. Borrowing an idle connection
+// triggers the pool's TestOnBorrow PING which keeps NAT/LB state fresh and evicts
+// connections that fail to respond. The connections are held until all of them
+// have been probed as the pool always hands out the most recently returned one.
+// If all remaining idle connections turn out to be dead, the pool dials a replacement.
+// If ping is set, each borrowed connection is also PINGed explicitly; connections that
+// fail with a network error are discarded when returned to the pool.
+func probeIdle(pool *redisDriver.Pool, ping bool) {
+	idle := pool.IdleCount()
+	conns := make([]redisDriver.Conn, 0, idle)
+	for ; idle > 0 && pool.IdleCount() > 0; idle-- {
+		conns = append(conns, pool.Get())
+	}
+
+	for _, conn := range conns {
+		if ping {
+			conn.Do("PING")
+		}
+		conn.Close()
+	}
+}
+
+// Get the TestOnBorrow hook for the supplied testOnBorrow mode. The always mode
+// PINGs every borrowed connection, onIdle only PINGs connections that have been idle
+// for at least idle and never skips the PING so that dead connections surface as
+// command errors.
+func testOnBorrowFunc(mode string, idle time.Duration) func(redisDriver.Conn, time.Time) error {
+	switch mode {
+	case "never":
+		return nil
+	case "onIdle":
+		return func(c redisDriver.Conn, t time.Time) error {
+			if time.Since(t) < idle {
+				return nil
+			}
 			_, err := c.Do("PING")
 			return err
-		},
+		}
 	}
 
-	s.connected = true
-	s.dialPolicy.ResetAttempts()
+	return pingOnBorrow
 }
 
-// Redis pool dialer. This method is invoked whenever the redis pool allocates a new connection
+// A TestOnBorrow hook that PINGs every borrowed connection.
+func pingOnBorrow(c redisDriver.Conn, t time.Time) error {
+	_, err := c.Do("PING")
+	return err
+}
+
+// Create a connection pool that uses dialFn to establish new connections.
+func newPool(dialFn func() (redisDriver.Conn, error)) *redisDriver.Pool {
+	return &redisDriver.Pool{
+		MaxIdle:      3,
+		IdleTimeout:  240 * time.Second,
+		Dial:         dialFn,
+		TestOnBorrow: pingOnBorrow,
+	}
+}
+
+// Dial a connection to a cluster node and authenticate if a password is specified.
+func dialClusterNode(addr string, dialer adapters.Dialer, timeout, tcpKeepAlive time.Duration, password string) (redisDriver.Conn, error) {
+	c, err := dialRedis("tcp", addr, dialer, timeout, tcpKeepAlive, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if password != "" {
+		if _, err = c.Do("AUTH", password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Dial a redis connection using dialer or net.DialTimeout if dialer is nil. If
+// tcpKeepAlive is positive, TCP keepalive probes with that period are enabled on
+// the underlying connection. If resp3 is set, RESP3 replies received over the
+// connection are translated to RESP2 and push messages that are not part of the
+// reply stream are passed to onPush.
+func dialRedis(network, address string, dialer adapters.Dialer, timeout, tcpKeepAlive time.Duration, resp3 bool, onPush func(push []interface{})) (redisDriver.Conn, error) {
+	if dialer == nil && tcpKeepAlive <= 0 && !resp3 {
+		return redisDriver.DialTimeout(network, address, timeout, 0, 0)
+	}
+	if dialer == nil {
+		dialer = net.DialTimeout
+	}
+
+	netDial := func(network, address string) (net.Conn, error) {
+		return dialer(network, address, timeout)
+	}
+	if tcpKeepAlive > 0 {
+		netDial = adapters.KeepAliveDialer(dialer, timeout, tcpKeepAlive)
+	}
+	if resp3 {
+		baseDial := netDial
+		netDial = func(network, address string) (net.Conn, error) {
+			conn, err := baseDial(network, address)
+			if err != nil {
+				return nil, err
+			}
+			return newRESP3Conn(conn, onPush), nil
+		}
+	}
+	return redisDriver.Dial(network, address, redisDriver.DialNetDial(netDial))
+}
+
+// Redis pool dialer. This method is invoked whenever the redis pool allocates a new
+// connection. The pool does not pass a context to its dialer so pool connections are
+// dialed with a background context and are only bounded by the dial policy and the
+// connection timeout; the context passed to DialContext applies to the initial connect.
 func (s *Redis) dialPoolConnection() (redisDriver.Conn, error) {
+	return s.hookedDial(func() (redisDriver.Conn, error) {
+		return s.conns.dial(func() (redisDriver.Conn, error) {
+			return s.dialConnection(context.Background())
+		})
+	})
+}
+
+// Read replica pool dialer. Unlike master connections, replica connections are
+// not retried according to the dial policy so that reads can quickly fall back
+// to the master if a replica is down.
+func (s *Redis) dialReplica(addr string) (redisDriver.Conn, error) {
+	return s.hookedDial(func() (redisDriver.Conn, error) {
+		return s.conns.dial(func() (redisDriver.Conn, error) {
+			s.Lock()
+			defer s.Unlock()
+
+			c, err := dialRedis("tcp", addr, s.dialer, s.connectionTimeout, s.tcpKeepAlive, s.protocol == protocolRESP3, s.dispatchPush)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = s.initConnection(c); err != nil {
+				c.Close()
+				return nil, err
+			}
+			return c, nil
+		})
+	})
+}
+
+// Dial a new redis connection using the configured dial policy. If ctx is cancelled
+// while waiting for the next dial attempt, the dial is aborted and ctx.Err() is returned.
+func (s *Redis) dialConnection(ctx context.Context) (redisDriver.Conn, error) {
 	s.Lock()
 	defer s.Unlock()
 
-	var err error
-	var wait time.Duration
-	var c redisDriver.Conn
+	return s.dialWithPolicy(ctx)
+}
+
+// Verify that a connection can be established using the current settings. As Config
+// re-creates the pool of a connected service in place and the pool dials lazily,
+// this is used by adapters.Reconfigure to detect unreachable endpoints. A connection
+// is dialed using the configured dial policy and closed right away; in cluster mode,
+// a PING is sent to the first reachable seed node instead. Implements adapters.Prober.
+func (s *Redis) Probe(ctx context.Context) error {
+	s.Lock()
+	cluster := s.cluster
+	s.Unlock()
+
+	if cluster != nil {
+		_, err := cluster.doOnSeed(ctx, "PING")
+		return err
+	}
+
+	c, err := s.conns.dial(func() (redisDriver.Conn, error) { return s.dialConnection(ctx) })
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// Dial a new redis connection using the configured dial policy. This method is not
+// thread-safe so it should be invoked while holding the service lock.
+func (s *Redis) dialWithPolicy(ctx context.Context) (c redisDriver.Conn, err error) {
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".DialConnection")
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+	span.SetAttribute("endpoint", s.endpoint)
+	attempts := 0
+	defer func() {
+		span.SetAttribute("attempts", attempts)
+		span.End(err)
+		s.lastErr = err
+		s.dialAttempts = uint32(attempts)
+		if err != nil {
+			s.events.Emit(adapters.Event{Type: adapters.EventDialFailed, Attempt: attempts, Err: err})
+		}
+	}()
+
+	defer dial.Release(s.dialPolicy)
 	s.dialPolicy.ResetAttempts()
-	wait, err = s.dialPolicy.NextRetry()
+	if _, err = dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		logger.Printf("%s Dial cancelled: %v\n", s.logPrefix(), err)
+		return nil, err
+	}
+	start := clock.Default.Now()
 	for {
-		c, err = redisDriver.DialTimeout("tcp", s.endpoint, s.connectionTimeout, 0, 0)
+		s.metrics.IncDialAttempt(s.Name())
+		attempts++
+		network, address := s.dialAddress()
+		c, err = dialRedis(network, address, s.dialer, s.connectionTimeout, s.tcpKeepAlive, s.protocol == protocolRESP3, s.dispatchPush)
 		if err == nil {
-			break
+			var protocol int
+			if protocol, err = s.initConnection(c); err == nil {
+				s.negotiatedProtocol = protocol
+				dial.RecordOutcome(s.dialPolicy, true)
+				break
+			}
+			c.Close()
+
+			// HELLO, AUTH and SELECT failures are only retried while the server is loading its dataset
+			if _, isLoading := err.(*loadingError); !isLoading {
+				return nil, err
+			}
 		}
 
-		wait, err = s.dialPolicy.NextRetry()
-		if err != nil {
-			s.logger.Printf("Could not connect to REDIS endpoint %s after %d attempt(s)\n", s.endpoint, s.dialPolicy.CurAttempt())
-			return nil, dial.ErrTimeout
+		dial.RecordOutcome(s.dialPolicy, false)
+		s.metrics.IncDialFailure(s.Name(), err)
+		dialErr := err
+		dial.SuggestFromError(s.dialPolicy, dialErr)
+		err = dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			logger.Printf("%s Could not connect to endpoint %s; retrying in %v\n", s.logPrefix(), s.endpoint, wait)
+			s.events.Emit(adapters.Event{Type: adapters.EventRetryScheduled, Attempt: attempts, Wait: wait, Err: dialErr})
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			logger.Printf("%s Could not connect to endpoint %s after %d attempt(s)\n", s.logPrefix(), s.endpoint, s.dialPolicy.CurAttempt())
+			s.metrics.ObserveDialDuration(s.Name(), clock.Since(start))
+			if s.onDialFailure != nil {
+				go s.onDialFailure(uint32(attempts), dialErr)
+			}
+			return nil, dial.Exhausted(attempts, dialErr)
+		case err != nil:
+			logger.Printf("%s Dial cancelled: %v\n", s.logPrefix(), err)
+			s.metrics.ObserveDialDuration(s.Name(), clock.Since(start))
+			return nil, err
 		}
-		s.logger.Printf("Could not connect to REDIS endpoint %s; retrying in %v\n", s.endpoint, wait)
-		<-time.After(wait)
 	}
 
-	if s.password != "" {
-		if _, err = c.Do("AUTH", s.password); err != nil {
-			c.Close()
-			return nil, err
+	s.lastConnectedAt = clock.Default.Now()
+	s.metrics.IncDialSuccess(s.Name())
+	s.metrics.ObserveDialDuration(s.Name(), clock.Since(start))
+	return c, err
+}
+
+// Negotiate the protocol version, authenticate and select the configured db.
+// Returns the negotiated protocol version. This method is not thread-safe so it
+// should be invoked while holding the service lock.
+func (s *Redis) initConnection(c redisDriver.Conn) (int, error) {
+	authenticated := false
+	protocol := protocolRESP2
+	if s.protocol == protocolRESP3 {
+		args := []interface{}{protocolRESP3}
+		if s.password != "" {
+			args = append(args, "AUTH", "default", s.password)
+		}
+		_, err := c.Do("HELLO", args...)
+		switch {
+		case err == nil:
+			authenticated = true
+			protocol = protocolRESP3
+		case isHelloUnsupported(err):
+			s.logger.Printf("%s Server does not support RESP3; falling back to RESP2\n", s.logPrefix())
+		default:
+			return 0, checkLoading(c, err)
+		}
+	}
+	if s.password != "" && !authenticated {
+		if _, err := c.Do("AUTH", s.password); err != nil {
+			return 0, checkLoading(c, err)
 		}
 	}
 	if s.db > 0 {
-		if _, err = c.Do("SELECT", s.db); err != nil {
-			c.Close()
-			return nil, err
+		if _, err := c.Do("SELECT", s.db); err != nil {
+			return 0, checkLoading(c, err)
 		}
 	}
+	return protocol, nil
+}
 
-	return c, err
+// Check whether err indicates that the server does not support HELLO or the
+// requested protocol version (e.g. servers older than redis 6).
+func isHelloUnsupported(err error) bool {
+	replyErr, ok := err.(redisDriver.Error)
+	if !ok {
+		return false
+	}
+	msg := string(replyErr)
+	return strings.HasPrefix(msg, "NOPROTO") || strings.HasPrefix(msg, "ERR unknown command")
+}
+
+// An error returned while the server is loading its dataset in memory. It carries
+// the server's estimate of the remaining loading time as a dial retry hint.
+type loadingError struct {
+	err error
+	eta time.Duration
+}
+
+func (e *loadingError) Error() string {
+	return e.err.Error()
+}
+
+func (e *loadingError) Unwrap() error {
+	return e.err
+}
+
+// Implements the dial.RetryHint interface.
+func (e *loadingError) RetryAfter() time.Duration {
+	return e.eta
+}
+
+// If err is a LOADING reply, query the server for the remaining loading time and
+// wrap err in a *loadingError; any other error is returned as-is.
+func checkLoading(c redisDriver.Conn, err error) error {
+	replyErr, ok := err.(redisDriver.Error)
+	if !ok || !strings.HasPrefix(string(replyErr), "LOADING ") {
+		return err
+	}
+
+	loadErr := &loadingError{err: err}
+	info, infoErr := redisDriver.String(c.Do("INFO", "persistence"))
+	if infoErr != nil {
+		return loadErr
+	}
+	for _, line := range strings.Split(info, "\r\n") {
+		if val := strings.TrimPrefix(line, "loading_eta_seconds:"); val != line {
+			if eta, convErr := strconv.Atoi(val); convErr == nil {
+				loadErr.eta = time.Duration(eta) * time.Second
+			}
+		}
+	}
+	return loadErr
+}
+
+// Get the network and address for dialing the endpoint. This method is not
+// thread-safe so it should be invoked while holding the service lock.
+func (s *Redis) dialAddress() (network, address string) {
+	if strings.HasPrefix(s.endpoint, unixPrefix) {
+		return "unix", strings.TrimPrefix(s.endpoint, unixPrefix)
+	}
+	if s.network == "" {
+		return "tcp", s.endpoint
+	}
+	return s.network, s.endpoint
 }
 
 // Disconnect.
-func (s *Redis) Close() {
+func (s *Redis) Close() error {
 	s.Lock()
 	defer s.Unlock()
 
 	if !s.connected {
-		return
+		return nil
 	}
 
-	// Close connection and notify any registered listeners
+	return s.disconnect()
+}
+
+// Disconnect after waiting for any borrowed connections to be returned to the pool.
+// New connection requests are rejected while waiting. If ctx expires before all
+// connections are returned, the service is closed anyway and ctx.Err() is returned.
+func (s *Redis) CloseContext(ctx context.Context) error {
+	s.Lock()
+	if !s.connected {
+		s.Unlock()
+		return nil
+	}
+	s.draining = true
+	pool, cluster, replicas := s.pool, s.cluster, s.replicaSet
+	s.Unlock()
+
+	err := adapters.WaitForRelease(ctx, func() int {
+		if cluster != nil {
+			return cluster.inUse()
+		}
+		inUse := pool.ActiveCount() - pool.IdleCount()
+		if replicas != nil {
+			inUse += replicas.inUse()
+		}
+		return inUse
+	})
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Unless the pool was reset in the meantime
+	s.draining = false
+	if s.connected && s.pool == pool && s.cluster == cluster {
+		if closeErr := s.disconnect(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// Drain the connection pool and close the service. New connection requests are
+// rejected while waiting for borrowed connections to be returned; in-flight commands
+// are allowed to complete. This is equivalent to CloseContext.
+func (s *Redis) Drain(ctx context.Context) error {
+	return s.CloseContext(ctx)
+}
+
+// Close the connection pool and notify any registered listeners. This method is
+// not thread-safe so it should be invoked while holding the service lock.
+func (s *Redis) disconnect() error {
 	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
-	s.pool.Close()
+	s.stopKeepAlive()
+	var err error
+	if s.cluster != nil {
+		s.cluster.close()
+	} else {
+		err = s.pool.Close()
+	}
+	if s.replicaSet != nil {
+		s.replicaSet.close()
+		s.replicaSet = nil
+	}
 	s.connected = false
+	s.metrics.SetConnected(s.Name(), false)
+	s.events.Emit(adapters.Event{Type: adapters.EventDisconnect, Err: adapters.ErrConnectionClosed})
+	return err
 }
 
 // Register a listener for receiving close notifications. The service adapter will emit an error and
@@ -166,6 +870,89 @@ func (s *Redis) NotifyClose(c adapters.CloseListener) {
 	s.closeNotifier.Add(c)
 }
 
+// Register a callback that is invoked when a dial gives up because its dial policy
+// is exhausted. The callback receives the number of attempts and the error of the
+// last attempt. It fires once per failed dial rather than once per attempt and runs
+// on its own goroutine so it may call back into the service. Passing nil removes it.
+func (s *Redis) OnDialFailure(fn func(attempts uint32, lastErr error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onDialFailure = fn
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *Redis) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Log a warning when more than max close listeners are registered, which usually
+// indicates a listener leak; 0 disables the check. See adapters.MaxCloseListeners.
+func (s *Redis) SetMaxCloseListeners(max int) {
+	s.closeNotifier.SetMaxListeners(max, func(count int) {
+		s.logger.Printf("%s Possible close listener leak: %d listeners registered (max %d)\n", s.logPrefix(), count, max)
+	})
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *Redis) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Report whether the service is connected.
+func (s *Redis) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Get the error that caused the most recent dial to fail; nil if the most recent
+// dial succeeded or no dial has been attempted yet.
+func (s *Redis) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Get a snapshot of the connection state of the service. As the pool dials lazily,
+// the dial fields refer to the most recent connection dial rather than to Dial.
+func (s *Redis) Status() adapters.Status {
+	s.Lock()
+	defer s.Unlock()
+
+	status := adapters.Status{
+		Connected:       s.connected,
+		Endpoint:        s.endpoint,
+		DialAttempts:    s.dialAttempts,
+		LastConnectedAt: s.lastConnectedAt,
+		Mode:            "standalone",
+	}
+	if s.clusterMode || s.clusterDetected {
+		status.Mode = "cluster"
+	}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	return status
+}
+
+// Get a stream of connection events. All calls return the same channel; if the
+// channel is not drained, new events are dropped.
+func (s *Redis) Events() <-chan adapters.Event {
+	return s.events.C()
+}
+
+// Block until the service is connected. If the most recent dial fails, its error is
+// returned instead; if ctx expires first, ctx.Err() is returned. Unlike Events, this
+// method does not consume any events.
+func (s *Redis) WaitForConnection(ctx context.Context) error {
+	return s.events.WaitForConnection(ctx)
+}
+
 // Apply a list of options to the service.
 func (s *Redis) SetOptions(opts ...adapters.ServiceOption) error {
 	for _, opt := range opts {
@@ -181,68 +968,150 @@ func (s *Redis) SetLogger(logger *log.Logger) {
 	s.logger = logger
 }
 
+// Get the logger registered via SetLogger. Implements the adapters.LoggerGetter interface.
+func (s *Redis) Logger() *log.Logger {
+	return s.logger
+}
+
+// Set a name for telling this instance apart from other Redis instances managed by the
+// same process. The name is included in the prefix of log lines (e.g. "[REDIS orders]")
+// and replaces "redis" as the service label of reported metrics. It should be set
+// before the service is dialed.
+func (s *Redis) SetName(name string) {
+	s.name = name
+}
+
+// Get the name set via SetName or "redis" if no name was set.
+func (s *Redis) Name() string {
+	if s.name == "" {
+		return serviceName
+	}
+	return s.name
+}
+
+// Get the prefix of the log lines emitted by the service.
+func (s *Redis) logPrefix() string {
+	if s.name == "" {
+		return "[REDIS]"
+	}
+	return "[REDIS " + s.name + "]"
+}
+
+// Attach a logger for tagging DialContext and ConfigContext log lines with the fields
+// of the supplied context. Passing nil restores plain logging.
+func (s *Redis) SetContextLogger(logger adapters.ContextLogger) {
+	s.contextLogger = logger
+}
+
 // Set a dial policy for this service.
 func (s *Redis) SetDialPolicy(policy dial.Policy) {
-	s.dialPolicy = policy
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Get the dial policy used by this service, e.g. for logging its settings via
+// dial.Describe or inspecting CurAttempt while a dial is in progress. The policy
+// is the copy stored by SetDialPolicy so it must not be passed to other services.
+func (s *Redis) DialPolicy() dial.Policy {
+	return s.dialPolicy
+}
+
+// Set the dialer used for establishing network connections to the server, replicas
+// and cluster nodes; nil restores net.DialTimeout. It should be set before the
+// service is dialed.
+func (s *Redis) SetDialer(dialer adapters.Dialer) {
+	s.dialer = dialer
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *Redis) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+	s.metrics = m
+}
+
+// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+func (s *Redis) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+	s.tracer = t
+}
+
+// Get a copy of the default value of each setting recognized by Config. Implements
+// adapters.ConfigDefaulter.
+func (s *Redis) ConfigDefaults() map[string]string {
+	defaults := make(map[string]string, len(defaultConfig))
+	for k, v := range defaultConfig {
+		defaults[k] = v
+	}
+	return defaults
 }
 
 // Set the service configuration. Changing the configuration settings for an already connected
 // service will trigger a service shutdown. The service consumer is responsible for handing
 // service close events and triggering a re-dial.
 func (s *Redis) Config(params map[string]string) error {
+	return s.ConfigContext(context.Background(), params)
+}
+
+// Apply configuration settings using the supplied context. If a context logger is
+// attached, log lines are written to the logger obtained for ctx; see Config.
+func (s *Redis) ConfigContext(ctx context.Context, params map[string]string) (err error) {
 	s.Lock()
 	defer s.Unlock()
 
-	needsReset := false
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Config")
+	defer func() { span.End(err) }()
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
 
-	endpoint, exists := params["endpoint"]
-	if exists {
-		s.endpoint = endpoint
-		needsReset = true
-	}
+	s.configChanged = false
 
-	password, exists := params["password"]
-	if exists {
-		s.password = password
-		needsReset = true
+	// Validate all settings before applying any of them so that a bad value
+	// does not leave the service with a partially applied configuration.
+	cfg, needsReset, err := s.parseConfig(params)
+	if err != nil {
+		logger.Printf("%s Configuration error: %s", s.logPrefix(), err.Error())
+		return err
 	}
 
-	dbVal, exists := params["db"]
-	if exists {
-		db, err := strconv.Atoi(dbVal)
-		if err != nil {
-			err := fmt.Errorf("invalid value for setting 'db': %s\n", dbVal)
-			s.logger.Println("[REDIS] Configuration error: %s", err.Error())
-			return err
-		}
-		s.db = db
-		needsReset = true
-	}
-
-	timeoutVal, exists := params["connTimeout"]
-	if exists {
-		timeout, err := strconv.Atoi(timeoutVal)
-		if err != nil {
-			err := fmt.Errorf("invalid value for setting 'connTimeout': %s\n", timeoutVal)
-			s.logger.Println("[REDIS] Configuration error: %s", err.Error())
-			return err
-		}
-		s.connectionTimeout = time.Duration(timeout) * time.Second
-		needsReset = true
-	}
+	s.applyConfig(cfg)
 
+	s.configChanged = needsReset
+	span.SetAttribute("reset", needsReset)
 	if needsReset {
-		s.logger.Printf("[REDIS] Configuration changed; new settings:  endpoint=%s, password=%s, db=%d, connTimeout=%v\n",
+		s.events.Emit(adapters.Event{Type: adapters.EventConfigChanged})
+		logger.Printf("%s Configuration changed; new settings:  endpoint=%s, network=%s, password=%s, db=%d, connTimeout=%v, cluster=%s, replicas=%s, keepAlive=%v, tcpKeepAlive=%v, maxActive=%d, maxTotalConnections=%d, poolWait=%t, warmup=%d, keyspaceEvents=%s, retryableErrors=%s, testOnBorrow=%s, testOnBorrowIdle=%v, protocol=%d\n", s.logPrefix(),
 			s.endpoint,
+			s.network,
 			strings.Repeat("*", len(s.password)),
 			s.db,
 			s.connectionTimeout,
+			s.clusterSetting(),
+			s.readReplicas,
+			s.keepAlive,
+			s.tcpKeepAlive,
+			s.maxActive,
+			s.maxTotalConnections,
+			s.poolWait,
+			s.warmup,
+			s.keyspaceEvents,
+			s.retryableErrors,
+			s.testOnBorrow,
+			s.testOnBorrowIdle,
+			s.protocol,
 		)
 
-		// Re-init connection pool
-		s.setupPool()
-
+		// Re-init the connection pool if already connected; otherwise the
+		// new settings will be picked up by the next call to Dial.
 		if s.connected {
+			s.clusterDetected = false
+			if s.clusterAuto {
+				if detectErr := s.detectCluster(ctx); detectErr != nil {
+					logger.Printf("%s Could not detect cluster mode: %v; assuming a standalone server\n", s.logPrefix(), detectErr)
+				}
+			}
+			s.setupPool(ctx)
 			s.closeNotifier.NotifyAll(nil)
 		}
 	}
@@ -250,18 +1119,553 @@ func (s *Redis) Config(params map[string]string) error {
 	return nil
 }
 
+// Apply settings parsed via parseConfig. This method is not thread-safe so it should
+// be invoked while holding the service lock.
+func (s *Redis) applyConfig(cfg config) {
+	s.endpoint = cfg.endpoint
+	s.network = cfg.network
+	s.password = cfg.password
+	s.db = cfg.db
+	s.connectionTimeout = cfg.connectionTimeout
+	s.clusterMode = cfg.clusterMode
+	s.clusterAuto = cfg.clusterAuto
+	s.readReplicas = cfg.readReplicas
+	s.keepAlive = cfg.keepAlive
+	s.tcpKeepAlive = cfg.tcpKeepAlive
+	s.maxActive = cfg.maxActive
+	s.maxTotalConnections = cfg.maxTotalConnections
+	s.conns.setMax(cfg.maxTotalConnections)
+	s.poolWait = cfg.poolWait
+	s.warmup = cfg.warmup
+	s.keyspaceEvents = cfg.keyspaceEvents
+	s.retryableErrors = cfg.retryableErrors
+	s.testOnBorrow = cfg.testOnBorrow
+	s.testOnBorrowIdle = cfg.testOnBorrowIdle
+	s.protocol = cfg.protocol
+}
+
+// Get the value of the cluster setting: true, false or auto. This method is not
+// thread-safe so it should be invoked while holding the service lock.
+func (s *Redis) clusterSetting() string {
+	if s.clusterAuto {
+		return "auto"
+	}
+	return strconv.FormatBool(s.clusterMode)
+}
+
+// Report whether the most recent Config call modified any setting and triggered a service reset.
+func (s *Redis) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
+
+// Get the RESP protocol version negotiated by the most recent connection dial: 3
+// if the protocol setting is 3 and the server accepted HELLO 3; 2 otherwise. It
+// returns 0 if no connection has been dialed yet.
+func (s *Redis) Protocol() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.negotiatedProtocol
+}
+
+// Get the configured redis endpoint.
+func (s *Redis) Endpoint() string {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.endpoint
+}
+
+// Set the redis endpoint. This is equivalent to calling Config with the "endpoint" setting.
+func (s *Redis) SetEndpoint(endpoint string) error {
+	return s.Config(map[string]string{"endpoint": endpoint})
+}
+
+// The set of redis settings that can be modified via Config.
+type config struct {
+	endpoint            string
+	network             string
+	password            string
+	db                  int
+	connectionTimeout   time.Duration
+	clusterMode         bool
+	clusterAuto         bool
+	readReplicas        string
+	keepAlive           time.Duration
+	tcpKeepAlive        time.Duration
+	maxActive           int
+	maxTotalConnections int
+	poolWait            bool
+	warmup              int
+	keyspaceEvents      string
+	retryableErrors     string
+	testOnBorrow        string
+	testOnBorrowIdle    time.Duration
+	protocol            int
+}
+
+// The settings recognized by Config.
+var configKeys = map[string]struct{}{
+	"endpoint":            {},
+	"network":             {},
+	"password":            {},
+	"db":                  {},
+	"connTimeout":         {},
+	"cluster":             {},
+	"replicas":            {},
+	"keepAlive":           {},
+	"tcpKeepAlive":        {},
+	"maxActive":           {},
+	"maxTotalConnections": {},
+	"poolWait":            {},
+	"warmup":              {},
+	"keyspaceEvents":      {},
+	"retryableErrors":     {},
+	"testOnBorrow":        {},
+	"testOnBorrowIdle":    {},
+	"protocol":            {},
+}
+
+// Parse params on top of the current service settings without modifying them.
+// Returns the new settings and whether any of them differs from the current ones. This
+// method is not thread-safe so it should be invoked while holding the service lock.
+func (s *Redis) parseConfig(params map[string]string) (config, bool, error) {
+	cur := config{
+		endpoint:            s.endpoint,
+		network:             s.network,
+		password:            s.password,
+		db:                  s.db,
+		connectionTimeout:   s.connectionTimeout,
+		clusterMode:         s.clusterMode,
+		clusterAuto:         s.clusterAuto,
+		readReplicas:        s.readReplicas,
+		keepAlive:           s.keepAlive,
+		tcpKeepAlive:        s.tcpKeepAlive,
+		maxActive:           s.maxActive,
+		maxTotalConnections: s.maxTotalConnections,
+		poolWait:            s.poolWait,
+		warmup:              s.warmup,
+		keyspaceEvents:      s.keyspaceEvents,
+		retryableErrors:     s.retryableErrors,
+		testOnBorrow:        s.testOnBorrow,
+		testOnBorrowIdle:    s.testOnBorrowIdle,
+		protocol:            s.protocol,
+	}
+
+	for key := range params {
+		if _, known := configKeys[key]; !known {
+			s.logger.Printf("%s Ignoring unknown setting '%s'\n", s.logPrefix(), key)
+		}
+	}
+
+	schema := adapters.ConfigSchema(params)
+	cfg := config{
+		endpoint:        schema.String("endpoint", cur.endpoint),
+		network:         schema.String("network", cur.network),
+		readReplicas:    schema.String("replicas", cur.readReplicas),
+		keyspaceEvents:  schema.String("keyspaceEvents", cur.keyspaceEvents),
+		retryableErrors: schema.String("retryableErrors", cur.retryableErrors),
+		testOnBorrow:    schema.String("testOnBorrow", cur.testOnBorrow),
+		password:        schema.String("password", cur.password),
+	}
+
+	var err error
+	if cfg.db, err = schema.Int("db", cur.db); err != nil {
+		return cur, false, err
+	}
+	if cfg.connectionTimeout, err = schema.Duration("connTimeout", time.Second, cur.connectionTimeout); err != nil {
+		return cur, false, err
+	}
+	cfg.clusterAuto = cur.clusterAuto
+	if val, set := params["cluster"]; set && val == "auto" {
+		cfg.clusterMode, cfg.clusterAuto = false, true
+	} else {
+		if cfg.clusterMode, err = schema.Bool("cluster", cur.clusterMode); err != nil {
+			return cur, false, err
+		}
+		if set {
+			cfg.clusterAuto = false
+		}
+	}
+	if cfg.keepAlive, err = schema.Duration("keepAlive", time.Second, cur.keepAlive); err != nil {
+		return cur, false, err
+	}
+	if cfg.keepAlive < 0 {
+		return cur, false, fmt.Errorf("invalid value for 'keepAlive': %s", params["keepAlive"])
+	}
+	if cfg.tcpKeepAlive, err = schema.Duration("tcpKeepAlive", time.Second, cur.tcpKeepAlive); err != nil {
+		return cur, false, err
+	}
+	if cfg.tcpKeepAlive < 0 {
+		return cur, false, fmt.Errorf("invalid value for 'tcpKeepAlive': %s", params["tcpKeepAlive"])
+	}
+	if cfg.maxActive, err = schema.Int("maxActive", cur.maxActive); err != nil {
+		return cur, false, err
+	}
+	if cfg.maxActive < 0 {
+		return cur, false, fmt.Errorf("invalid value for 'maxActive': %s", params["maxActive"])
+	}
+	if cfg.maxTotalConnections, err = schema.Int("maxTotalConnections", cur.maxTotalConnections); err != nil {
+		return cur, false, err
+	}
+	if cfg.maxTotalConnections < 0 {
+		return cur, false, fmt.Errorf("invalid value for 'maxTotalConnections': %s", params["maxTotalConnections"])
+	}
+	if cfg.poolWait, err = schema.Bool("poolWait", cur.poolWait); err != nil {
+		return cur, false, err
+	}
+	if cfg.warmup, err = schema.Int("warmup", cur.warmup); err != nil {
+		return cur, false, err
+	}
+	if cfg.warmup < 0 {
+		return cur, false, fmt.Errorf("invalid value for 'warmup': %s", params["warmup"])
+	}
+	if cfg.testOnBorrowIdle, err = schema.Duration("testOnBorrowIdle", time.Second, cur.testOnBorrowIdle); err != nil {
+		return cur, false, err
+	}
+	if cfg.testOnBorrowIdle < 0 {
+		return cur, false, fmt.Errorf("invalid value for 'testOnBorrowIdle': %s", params["testOnBorrowIdle"])
+	}
+	if cfg.protocol, err = schema.Int("protocol", cur.protocol); err != nil {
+		return cur, false, err
+	}
+	if _, set := params["protocol"]; set && cfg.protocol != protocolRESP2 && cfg.protocol != protocolRESP3 {
+		return cur, false, fmt.Errorf("invalid value for 'protocol': %s", params["protocol"])
+	}
+	if cfg.testOnBorrow != "" && cfg.testOnBorrow != "always" && cfg.testOnBorrow != "onIdle" && cfg.testOnBorrow != "never" {
+		return cur, false, fmt.Errorf("invalid value for 'testOnBorrow': %s", cfg.testOnBorrow)
+	}
+	if cfg.network != "" && cfg.network != "tcp" && cfg.network != "unix" {
+		return cur, false, fmt.Errorf("invalid value for 'network': %s", cfg.network)
+	}
+	if cfg.clusterMode && (cfg.network == "unix" || strings.HasPrefix(cfg.endpoint, unixPrefix)) {
+		return cur, false, fmt.Errorf("invalid value for 'network': unix; cluster mode only supports tcp")
+	}
+	if cfg.clusterMode && cfg.readReplicas != "" {
+		return cur, false, fmt.Errorf("invalid value for 'replicas': %s; replicas are not supported in cluster mode", cfg.readReplicas)
+	}
+	if cfg.clusterMode && cfg.db != 0 {
+		return cur, false, fmt.Errorf("invalid value for 'db': %d; cluster mode only supports db 0", cfg.db)
+	}
+
+	return cfg, cfg != cur, nil
+}
+
 // Fetch a connection from the pool.
-func (s *Redis) GetConnection() (redisDriver.Conn, error) {
+func (s *Redis) GetConnection() (RedisConn, error) {
+	return s.GetConnectionContext(context.Background())
+}
+
+// Fetch a connection from the pool, giving up after d if the pool is exhausted.
+// This is only meaningful when poolWait is enabled; otherwise an exhausted pool
+// fails immediately with redis.ErrPoolExhausted. Returns context.DeadlineExceeded
+// if no connection becomes available in time.
+func (s *Redis) GetConnectionTimeout(d time.Duration) (RedisConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	return s.GetConnectionContext(ctx)
+}
+
+// Fetch a connection from the pool using the supplied context. If poolWait is
+// enabled and the pool is exhausted, the call blocks until a connection is returned
+// to the pool or ctx is done, in which case ctx.Err() is returned. Dialing a new
+// connection is governed by the dial timeout rather than ctx.
+func (s *Redis) GetConnectionContext(ctx context.Context) (RedisConn, error) {
+	conn, _, err := s.getConnection(ctx)
+	return conn, err
+}
+
+// Fetch a connection from the pool using the supplied context and return it along
+// with the pool it was fetched from; see GetConnectionContext.
+func (s *Redis) getConnection(ctx context.Context) (RedisConn, *redisDriver.Pool, error) {
 	s.Lock()
-	if !s.connected {
+	if !s.connected || s.draining {
+		s.Unlock()
+		return nil, nil, adapters.ErrConnectionClosed
+	}
+	if s.cluster != nil {
+		s.Unlock()
+		return nil, nil, ErrClusterMode
+	}
+	pool, wait := s.pool, s.poolWait
+	s.Unlock()
+
+	// The pool may need to dial a new connection which acquires the service
+	// lock so we cannot hold it while calling GetContext.
+	conn, err := s.getPooled(ctx, pool, wait)
+	if s.poolReset(pool) {
+		// Do not hand out connections (or pool errors such as "get on closed
+		// pool") from a pool that was closed while the connection was fetched
+		conn.Close()
+		return nil, nil, adapters.ErrConnectionClosed
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, pool, nil
+}
+
+// Check whether pool was closed or replaced by a concurrent Close or Config call.
+func (s *Redis) poolReset(pool *redisDriver.Pool) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return !s.connected || s.pool != pool
+}
+
+// Fetch a connection for running read-only commands. If read replicas are configured,
+// connections are fetched from the replica pools in round-robin order. Replicas that
+// cannot be reached or whose connections break are evicted for a while; if no replica
+// is available, the connection is fetched from the master pool. Without replicas, this
+// is equivalent to GetConnection.
+func (s *Redis) GetReadConnection() (RedisConn, error) {
+	s.Lock()
+	if !s.connected || s.draining {
+		s.Unlock()
+		return nil, adapters.ErrConnectionClosed
+	}
+	replicas := s.replicaSet
+	s.Unlock()
+
+	if replicas != nil {
+		if conn := replicas.get(); conn != nil {
+			s.Lock()
+			closed := !s.connected || s.replicaSet != replicas
+			s.Unlock()
+			if closed {
+				conn.Close()
+				return nil, adapters.ErrConnectionClosed
+			}
+			return conn, nil
+		}
+		s.logger.Printf("%s No read replica available; falling back to master\n", s.logPrefix())
+	}
+
+	return s.GetConnection()
+}
+
+// Run a command using a pooled connection. In cluster mode, the command is routed
+// to the node serving the hash slot of its key (the first argument) and any MOVED
+// or ASK redirections are followed. Topology changes update the slot mapping
+// without resetting the service.
+func (s *Redis) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	s.Lock()
+	if !s.connected || s.draining {
 		s.Unlock()
 		return nil, adapters.ErrConnectionClosed
 	}
+	pool, cluster, wait := s.pool, s.cluster, s.poolWait
 	s.Unlock()
 
-	conn := s.pool.Get()
-	if conn.Err() != nil {
-		return nil, conn.Err()
+	if cluster != nil {
+		return cluster.do(ctx, cmd, args...)
+	}
+
+	conn, err := s.getPooled(ctx, pool, wait)
+	if s.poolReset(pool) {
+		conn.Close()
+		return nil, adapters.ErrConnectionClosed
 	}
-	return conn, nil
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.Do(cmd, args...)
+}
+
+// Run a command against db using a pooled connection. The connection is switched
+// back to the configured db before it is returned to the pool, even if the command
+// fails. If the configured db cannot be restored, the connection is dropped and
+// the restore error is returned. DoOnDB is not supported in cluster mode.
+func (s *Redis) DoOnDB(db int, cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := s.GetConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	s.Lock()
+	defaultDB := s.db
+	s.Unlock()
+
+	if db == defaultDB {
+		return conn.Do(cmd, args...)
+	}
+
+	if _, err = conn.Do("SELECT", db); err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.Do(cmd, args...)
+
+	if _, restoreErr := conn.Do("SELECT", defaultDB); restoreErr != nil {
+		// Make sure that the connection is not reused while on the wrong db; QUIT
+		// closes it so the pool discards it when it is returned
+		conn.Do("QUIT")
+		return nil, fmt.Errorf("redis: could not restore db %d: %w", defaultDB, restoreErr)
+	}
+
+	return reply, err
+}
+
+// A command executed as part of a pipeline.
+type RedisCmd struct {
+	// The command name.
+	Name string
+
+	// The command arguments.
+	Args []interface{}
+}
+
+// Run a batch of commands over a single pooled connection using a pipeline. The
+// returned slice contains one reply per command in the order the commands were
+// specified. Error replies (redis.Error) are stored in the slot of the command that
+// caused them and do not abort the batch; an error is only returned if the commands
+// could not be sent or their replies could not be read.
+func (s *Redis) Pipeline(cmds []RedisCmd) ([]interface{}, error) {
+	conn, err := s.GetConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	for _, cmd := range cmds {
+		if err = conn.Send(cmd.Name, cmd.Args...); err != nil {
+			return nil, err
+		}
+	}
+	if err = conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for index := range cmds {
+		reply, err := conn.Receive()
+		if replyErr, ok := err.(redisDriver.Error); ok {
+			replies[index] = replyErr
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		replies[index] = reply
+	}
+
+	return replies, nil
+}
+
+// Returned in a ScanResult if the service was reset while iterating keys.
+var ErrScanReset = errors.New("redis: SCAN aborted: service was reset")
+
+// A key streamed by Scan. If the iteration is aborted, the last value sent before
+// the channel is closed carries the error that caused the abort and an empty Key.
+type ScanResult struct {
+	Key string
+	Err error
+}
+
+// Iterate the keys matching the glob-style pattern match using SCAN and stream
+// them on the returned channel. If count is positive, it is passed to SCAN as a
+// hint for the number of keys to fetch per call. An empty match iterates all keys.
+//
+// The iteration uses a single pooled connection which is returned to the pool once
+// all keys have been streamed or ctx is cancelled; in both cases the channel is
+// closed. Since SCAN cursors cannot be resumed safely on a different connection,
+// the iteration is aborted if a SCAN call fails or the service is reset while
+// iterating. In that case a final ScanResult with the SCAN error or ErrScanReset
+// is sent before the channel is closed so callers can tell a truncated iteration
+// from a complete one.
+func (s *Redis) Scan(ctx context.Context, match string, count int) (<-chan ScanResult, error) {
+	// Compare against the pool the connection came from as a concurrent Config call
+	// may replace the pool while the connection is fetched
+	conn, pool, err := s.getConnection(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch the first page synchronously so that errors are reported to the caller
+	cursor, keys, err := scanPage(conn, 0, match, count)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	out := make(chan ScanResult)
+	go func() {
+		defer close(out)
+		defer conn.Close()
+
+		send := func(res ScanResult) bool {
+			select {
+			case out <- res:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			for _, key := range keys {
+				if !send(ScanResult{Key: key}) {
+					return
+				}
+			}
+			if cursor == 0 {
+				return
+			}
+
+			s.Lock()
+			reset := s.pool != pool
+			s.Unlock()
+			if reset {
+				s.logger.Printf("%s Aborting SCAN: service was reset\n", s.logPrefix())
+				send(ScanResult{Err: ErrScanReset})
+				return
+			}
+
+			if cursor, keys, err = scanPage(conn, cursor, match, count); err != nil {
+				s.logger.Printf("%s Aborting SCAN: %v\n", s.logPrefix(), err)
+				send(ScanResult{Err: err})
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Run a single SCAN call and return the next cursor and the returned keys.
+func scanPage(conn redisDriver.Conn, cursor uint64, match string, count int) (uint64, []string, error) {
+	args := []interface{}{cursor}
+	if match != "" {
+		args = append(args, "MATCH", match)
+	}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+
+	reply, err := redisDriver.Values(conn.Do("SCAN", args...))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(reply) != 2 {
+		return 0, nil, fmt.Errorf("redis: unexpected SCAN reply: %v", reply)
+	}
+
+	next, err := redisDriver.Uint64(reply[0], nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	keys, err := redisDriver.Strings(reply[1], nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return next, keys, nil
 }
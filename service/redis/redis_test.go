@@ -0,0 +1,2133 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	"github.com/achilleasa/usrv-service-adapters/internal/clock"
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+func newTestAdapter(endpoint string) *Redis {
+	return &Redis{
+		endpoint:          endpoint,
+		connectionTimeout: 100 * time.Millisecond,
+		logger:            Adapter.logger,
+		dialPolicy:        dial.Periodic(1000, 50*time.Millisecond),
+		metrics:           adapters.NopMetrics,
+		tracer:            adapters.NopTracer,
+		closeNotifier:     adapters.NewNotifier(),
+	}
+}
+
+func TestDialContextCancelled(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := srv.DialContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("Expected DialContext to fail with context.Canceled; got %v", err)
+	}
+
+	_, err = srv.GetConnection()
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected GetConnection() to return ErrConnectionClosed; got %v", err)
+	}
+}
+
+func TestDialContextOnlyAppliesToInitialConnect(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := srv.DialContext(ctx); err != nil {
+		t.Fatalf("Expected DialContext to succeed; got %v", err)
+	}
+	cancel()
+
+	// The pool dials its connections lazily; they must not inherit the cancelled context
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection() to succeed after the dial context was cancelled; got %v", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Do("PING"); err != nil {
+		t.Fatalf("Expected PING to succeed; got %v", err)
+	}
+}
+
+func TestDialConnectionCancel(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := srv.dialConnection(ctx)
+	if err != context.Canceled {
+		t.Fatalf("Expected dialConnection to fail with context.Canceled; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected dialConnection to return promptly after cancellation; took %v", elapsed)
+	}
+}
+
+func TestDialConnectionBudgetExhausted(t *testing.T) {
+	budget := dial.SharedBudget(1)
+	holder := budget.Wrap(dial.Periodic(1, time.Millisecond))
+	holder.NextRetry()
+	defer dial.Release(holder)
+
+	srv := newTestAdapter(newFakeServer(t))
+	srv.SetDialPolicy(budget.Wrap(dial.Periodic(1000, 50*time.Millisecond)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := srv.dialConnection(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected dialConnection to fail with context.DeadlineExceeded; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected dialConnection to return once the context expired; took %v", elapsed)
+	}
+}
+
+// Handles a command received by a fake redis server and returns a raw RESP reply.
+type fakeHandler func(args []string) string
+
+// Start a fake redis server that replies with +PONG to every command. This is
+// sufficient for allocating pool connections and passing the TestOnBorrow check.
+func newFakeServer(t *testing.T) string {
+	return newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string { return "+PONG\r\n" }
+	})
+}
+
+// Start a fake redis server that serves each connection using a handler
+// obtained via newHandler. This allows handlers to keep per-connection state.
+func newFakeServerFunc(t *testing.T, newHandler func() fakeHandler) string {
+	return serveFake(t, "tcp", "127.0.0.1:0", func(net.Conn) fakeHandler { return newHandler() })
+}
+
+// Start a fake redis server listening on the supplied network and address and
+// return the address it listens on. Handlers may use conn to push replies.
+func serveFake(t *testing.T, network, address string, newHandler func(conn net.Conn) fakeHandler) string {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("Error starting fake server: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go serveFakeConn(conn, newHandler(conn))
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func serveFakeConn(conn net.Conn, handler fakeHandler) {
+	r := bufio.NewReader(conn)
+	for {
+		// Commands are sent as an array of bulk strings: *<n> followed by n ($<len>, <data>) pairs
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		count, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+		args := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			lenLine, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			// Args may contain arbitrary bytes so read them using their length
+			argLen, _ := strconv.Atoi(strings.TrimSpace(lenLine[1:]))
+			arg := make([]byte, argLen+2)
+			if _, err = io.ReadFull(r, arg); err != nil {
+				return
+			}
+			args = append(args, string(arg[:argLen]))
+		}
+
+		// An empty reply drops the connection
+		reply := handler(args)
+		if reply == "" {
+			conn.Close()
+			return
+		}
+		if _, err = conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func TestCloseContextWaitsForConnections(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- srv.CloseContext(context.Background())
+	}()
+
+	select {
+	case err := <-closed:
+		t.Fatalf("Expected CloseContext to block while a connection is borrowed; got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = srv.GetConnection()
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected GetConnection to return ErrConnectionClosed while closing; got %v", err)
+	}
+
+	conn.Close()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Expected CloseContext to succeed; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for CloseContext to return")
+	}
+
+	if err := <-listener; err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected to receive ErrConnectionClosed; got %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- srv.Drain(context.Background())
+	}()
+
+	select {
+	case err := <-drained:
+		t.Fatalf("Expected Drain to block while a connection is borrowed; got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// New borrows are rejected while draining but in-flight commands complete
+	if _, err = srv.GetConnection(); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected GetConnection to fail with ErrConnectionClosed while draining; got %v", err)
+	}
+	if _, err = conn.Do("PING"); err != nil {
+		t.Fatalf("Expected the borrowed connection to remain usable while draining; got %v", err)
+	}
+
+	conn.Close()
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("Expected Drain to succeed; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Drain to return once the connection was released")
+	}
+	if srv.IsConnected() {
+		t.Fatalf("Expected the service to be closed after draining")
+	}
+}
+
+func TestCloseContextDeadline(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err = srv.CloseContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected CloseContext to fail with context.DeadlineExceeded; got %v", err)
+	}
+
+	_, err = srv.GetConnection()
+	if err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected GetConnection to return ErrConnectionClosed after CloseContext; got %v", err)
+	}
+}
+
+func TestConfigIsAtomic(t *testing.T) {
+	srv := newTestAdapter("localhost:6379")
+	srv.password = "secret"
+
+	invalidSettings := []map[string]string{
+		{"endpoint": "10.0.0.1:6379", "password": "other", "db": "one"},
+		{"endpoint": "10.0.0.1:6379", "password": "other", "connTimeout": "soon"},
+	}
+	for _, params := range invalidSettings {
+		if err := srv.Config(params); err == nil {
+			t.Fatalf("Expected Config(%v) to fail", params)
+		}
+
+		if srv.endpoint != "localhost:6379" {
+			t.Fatalf("Expected endpoint to remain localhost:6379 after a failed Config; got %s", srv.endpoint)
+		}
+		if srv.password != "secret" {
+			t.Fatalf("Expected password to remain unchanged after a failed Config; got %s", srv.password)
+		}
+		if srv.pool != nil {
+			t.Fatalf("Expected a failed Config not to set up a connection pool")
+		}
+	}
+
+	err := srv.Config(map[string]string{"endpoint": "10.0.0.1:6379", "db": "2", "connTimeout": "3", "bogus": "1"})
+	if err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if srv.endpoint != "10.0.0.1:6379" || srv.db != 2 || srv.connectionTimeout != 3*time.Second {
+		t.Fatalf("Expected settings to be applied; got endpoint=%s, db=%d, connTimeout=%v", srv.endpoint, srv.db, srv.connectionTimeout)
+	}
+}
+
+func TestConfigChanged(t *testing.T) {
+	srv := newTestAdapter("localhost:6379")
+
+	params := map[string]string{"endpoint": "10.0.0.1:6379", "db": "1", "connTimeout": "2"}
+	if err := srv.Config(params); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if !srv.ConfigChanged() {
+		t.Fatalf("Expected ConfigChanged() to report a change")
+	}
+
+	pool := srv.pool
+	if err := srv.Config(params); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if srv.ConfigChanged() {
+		t.Fatalf("Expected ConfigChanged() to report no change for identical settings")
+	}
+	if srv.pool != pool {
+		t.Fatalf("Expected identical settings not to re-create the connection pool")
+	}
+
+	if err := srv.Config(map[string]string{"db": "invalid"}); err == nil {
+		t.Fatalf("Expected Config to fail")
+	}
+	if srv.ConfigChanged() {
+		t.Fatalf("Expected ConfigChanged() to report no change after a failed Config")
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	srv := newTestAdapter("localhost:6379")
+	if endpoint := srv.Endpoint(); endpoint != "localhost:6379" {
+		t.Fatalf("Expected Endpoint() to return localhost:6379; got %s", endpoint)
+	}
+
+	if err := srv.Config(map[string]string{"endpoint": "10.0.0.1:6379"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if endpoint := srv.Endpoint(); endpoint != "10.0.0.1:6379" {
+		t.Fatalf("Expected Endpoint() to return 10.0.0.1:6379; got %s", endpoint)
+	}
+
+	if err := srv.SetEndpoint("10.0.0.2:6379"); err != nil {
+		t.Fatalf("Expected SetEndpoint to succeed; got %v", err)
+	}
+	if endpoint := srv.Endpoint(); endpoint != "10.0.0.2:6379" {
+		t.Fatalf("Expected Endpoint() to return 10.0.0.2:6379; got %s", endpoint)
+	}
+}
+
+func TestDialConnectionWrapsLastError(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+	srv.dialPolicy = dial.Periodic(2, time.Millisecond)
+
+	_, err := srv.dialConnection(context.Background())
+	if !errors.Is(err, dial.ErrTimeout) {
+		t.Fatalf("Expected dialConnection to fail with ErrTimeout; got %v", err)
+	}
+
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("Expected error to wrap the underlying dial error; got %v", err)
+	}
+}
+
+func TestConfigBeforeDial(t *testing.T) {
+	srv := newTestAdapter("localhost:6379")
+
+	if err := srv.Config(map[string]string{"endpoint": "10.0.0.1:6379"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if srv.connected {
+		t.Fatalf("Expected Config not to mark a service that was never dialed as connected")
+	}
+	if srv.pool != nil {
+		t.Fatalf("Expected Config not to create a pool for a service that was never dialed")
+	}
+
+	// Close should be a no-op for a service that was never dialed
+	srv.Close()
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+	if srv.pool == nil || srv.endpoint != "10.0.0.1:6379" {
+		t.Fatalf("Expected Dial to create a pool using the configured endpoint")
+	}
+}
+
+func TestConfigWhileConnectedResetsPool(t *testing.T) {
+	srv := newTestAdapter("localhost:6379")
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	pool := srv.pool
+	if err := srv.Config(map[string]string{"db": "2"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if srv.pool == pool {
+		t.Fatalf("Expected Config to re-create the pool for a connected service")
+	}
+
+	select {
+	case err, ok := <-listener:
+		if ok {
+			t.Fatalf("Expected listener to be closed without an error; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for a connection reset")
+	}
+}
+
+func TestGetConnectionRacesClose(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+
+	for i := 0; i < 10; i++ {
+		if err := srv.Dial(); err != nil {
+			t.Fatalf("[iteration %d] Expected Dial to succeed; got %v", i, err)
+		}
+
+		failures := make(chan error, 8)
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					conn, err := srv.GetConnection()
+					if err == adapters.ErrConnectionClosed {
+						return
+					} else if err != nil {
+						failures <- err
+						return
+					}
+					conn.Close()
+				}
+			}()
+		}
+
+		// Replace the pool and then close the service while workers are fetching connections
+		time.Sleep(5 * time.Millisecond)
+		if err := srv.Config(map[string]string{"connTimeout": strconv.Itoa(i + 1)}); err != nil {
+			t.Fatalf("[iteration %d] Expected Config to succeed; got %v", i, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		srv.Close()
+		wg.Wait()
+
+		select {
+		case err := <-failures:
+			t.Fatalf("[iteration %d] Expected GetConnection to succeed or return ErrConnectionClosed; got %v", i, err)
+		default:
+		}
+	}
+}
+
+func TestGetConnectionAfterClose(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	// Leave an idle connection in the pool
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	conn.Close()
+
+	if err = srv.Close(); err != nil {
+		t.Fatalf("Expected Close to succeed; got %v", err)
+	}
+
+	if _, err = srv.GetConnection(); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected GetConnection to return ErrConnectionClosed; got %v", err)
+	}
+	if _, err = srv.GetConnectionContext(context.Background()); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected GetConnectionContext to return ErrConnectionClosed; got %v", err)
+	}
+	if _, err = srv.GetReadConnection(); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected GetReadConnection to return ErrConnectionClosed; got %v", err)
+	}
+	if _, err = srv.DoContext(context.Background(), "GET", "foo"); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected DoContext to return ErrConnectionClosed; got %v", err)
+	}
+}
+
+func TestGetConnectionClosedWhileBorrowing(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	// Leave an idle connection in the pool
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	conn.Close()
+
+	// Close the service while the pool tests the idle connection on borrow
+	var closeOnce sync.Once
+	srv.SetCommandHook(func(cmd string, _ []interface{}, _ interface{}, _ error, _ time.Duration) {
+		if cmd == "PING" {
+			closeOnce.Do(func() { srv.Close() })
+		}
+	})
+
+	if _, err = srv.GetConnection(); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected GetConnection to return ErrConnectionClosed; got %v", err)
+	}
+}
+
+func TestDialWhenConnected(t *testing.T) {
+	srv := newTestAdapter("localhost:6379")
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	if err := srv.Dial(); err != adapters.ErrAlreadyConnected {
+		t.Fatalf("Expected a second Dial to return ErrAlreadyConnected; got %v", err)
+	}
+}
+
+// Read len(expected) events from events and compare their types with expected.
+func expectEvents(t *testing.T, events <-chan adapters.Event, expected ...adapters.EventType) []adapters.Event {
+	received := make([]adapters.Event, 0, len(expected))
+	for index, evtType := range expected {
+		select {
+		case evt := <-events:
+			if evt.Type != evtType {
+				t.Fatalf("[event %d] Expected event type %s; got %s", index, evtType, evt.Type)
+			}
+			received = append(received, evt)
+		case <-time.After(time.Second):
+			t.Fatalf("[event %d] Timed out waiting for %s event", index, evtType)
+		}
+	}
+	return received
+}
+
+func TestRetryScheduledEvents(t *testing.T) {
+	fake := clock.UseFake(t)
+	srv := newTestAdapter("127.0.0.1:1")
+	srv.dialPolicy = dial.ExpBackoff(4, 10*time.Millisecond)
+	srv.dialConnection(context.Background())
+
+	events := expectEvents(t, srv.Events(),
+		adapters.EventRetryScheduled,
+		adapters.EventRetryScheduled,
+		adapters.EventRetryScheduled,
+		adapters.EventDialFailed,
+	)
+	sleeps := fake.Sleeps()
+	if len(sleeps) != 3 {
+		t.Fatalf("Expected the dial loop to sleep 3 times; got %v", sleeps)
+	}
+	for index, evt := range events[:3] {
+		if evt.Attempt != index+1 || evt.Wait != sleeps[index] {
+			t.Fatalf("[event %d] Expected retry event for attempt %d with wait %v; got %+v", index, index+1, sleeps[index], evt)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv := newTestAdapter("localhost:6379")
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	if err := srv.Config(map[string]string{"db": "1"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	srv.Close()
+
+	events := expectEvents(t, srv.Events(),
+		adapters.EventConnect,
+		adapters.EventConfigChanged,
+		adapters.EventDisconnect,
+	)
+	if events[2].Err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected disconnect event to carry ErrConnectionClosed; got %v", events[2].Err)
+	}
+
+	// Failed pool dials emit a retry event for each scheduled attempt
+	srv = newTestAdapter("127.0.0.1:1")
+	srv.dialPolicy = dial.Periodic(3, time.Millisecond)
+	srv.dialConnection(context.Background())
+
+	events = expectEvents(t, srv.Events(), adapters.EventRetryScheduled, adapters.EventRetryScheduled, adapters.EventDialFailed)
+	for index, evt := range events[:2] {
+		if evt.Attempt != index+1 || evt.Err == nil {
+			t.Fatalf("[event %d] Expected retry event for attempt %d with a dial error; got %+v", index, index+1, evt)
+		}
+	}
+}
+
+// Build a CLUSTER SLOTS reply that assigns all slots to addr.
+func clusterSlotsReply(addr string) string {
+	host, portVal, _ := net.SplitHostPort(addr)
+	return fmt.Sprintf("*1\r\n*3\r\n:0\r\n:%d\r\n*2\r\n$%d\r\n%s\r\n:%s\r\n", clusterSlots-1, len(host), host, portVal)
+}
+
+func TestKeySlot(t *testing.T) {
+	specs := []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 12739},
+		{"foo", 12182},
+		{"{foo}.bar", 12182},
+		{"user{foo}", 12182},
+	}
+
+	for index, spec := range specs {
+		if slot := keySlot(spec.key); slot != spec.slot {
+			t.Fatalf("[spec %d] Expected slot for key %q to be %d; got %d", index, spec.key, spec.slot, slot)
+		}
+	}
+
+	// An empty hash tag should hash the entire key
+	if keySlot("{}foo") == keySlot("") {
+		t.Fatalf("Expected an empty hash tag to be ignored")
+	}
+}
+
+// Wait for the slot mapping of the cluster client of srv to assign slot 0 to addr.
+func waitForSlotOwner(t *testing.T, srv *Redis, addr string) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		srv.cluster.Lock()
+		owner := srv.cluster.slots[0]
+		srv.cluster.Unlock()
+		if owner == addr {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for a topology refresh assigning the slots to %s; slot 0 is served by %q", addr, owner)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClusterFollowsMovedRedirection(t *testing.T) {
+	var mu sync.Mutex
+	var seed string
+	getsOnSeed := 0
+
+	target := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			switch strings.ToUpper(args[0]) {
+			case "GET":
+				return "$3\r\nbar\r\n"
+			case "CLUSTER":
+				return "-ERR not expected\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	})
+	addr := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch strings.ToUpper(args[0]) {
+			case "GET":
+				getsOnSeed++
+				return fmt.Sprintf("-MOVED %d %s\r\n", keySlot(args[1]), target)
+			case "CLUSTER":
+				// Once a key has been moved, all slots are served by target
+				if getsOnSeed > 0 {
+					return clusterSlotsReply(target)
+				}
+				return clusterSlotsReply(seed)
+			}
+			return "+PONG\r\n"
+		}
+	})
+	mu.Lock()
+	seed = addr
+	mu.Unlock()
+
+	srv := newTestAdapter(seed)
+	if err := srv.Config(map[string]string{"cluster": "true"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	// Dialing should populate the slot mapping
+	waitForSlotOwner(t, srv, seed)
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		reply, err := redisDriver.String(srv.DoContext(context.Background(), "GET", "foo"))
+		if err != nil {
+			t.Fatalf("[attempt %d] Expected DoContext to succeed; got %v", attempt, err)
+		}
+		if reply != "bar" {
+			t.Fatalf("[attempt %d] Expected reply to be bar; got %s", attempt, reply)
+		}
+	}
+
+	// The MOVED redirection should update the slot mapping
+	mu.Lock()
+	if getsOnSeed != 1 {
+		t.Fatalf("Expected the seed to receive a single GET; got %d", getsOnSeed)
+	}
+	mu.Unlock()
+
+	// Wait for the background topology refresh and check that the service was not reset
+	waitForSlotOwner(t, srv, target)
+	select {
+	case <-listener:
+		t.Fatalf("Expected a topology change not to reset the service")
+	default:
+	}
+
+	if _, err := srv.GetConnection(); err != ErrClusterMode {
+		t.Fatalf("Expected GetConnection to return ErrClusterMode; got %v", err)
+	}
+}
+
+func TestClusterAutoDetect(t *testing.T) {
+	specs := []struct {
+		infoReply string
+		expMode   string
+	}{
+		{"$30\r\n# Cluster\r\ncluster_enabled:1\r\n\r\n", "cluster"},
+		{"$30\r\n# Cluster\r\ncluster_enabled:0\r\n\r\n", "standalone"},
+		{"-ERR unknown command 'INFO'\r\n", "standalone"},
+	}
+
+	for index, spec := range specs {
+		var mu sync.Mutex
+		var self string
+		endpoint := newFakeServerFunc(t, func() fakeHandler {
+			return func(args []string) string {
+				switch strings.ToUpper(args[0]) {
+				case "INFO":
+					return spec.infoReply
+				case "CLUSTER":
+					mu.Lock()
+					defer mu.Unlock()
+					return clusterSlotsReply(self)
+				case "GET":
+					return "$3\r\nbar\r\n"
+				}
+				return "+PONG\r\n"
+			}
+		})
+		mu.Lock()
+		self = endpoint
+		mu.Unlock()
+
+		srv := newTestAdapter(endpoint)
+		if err := srv.Config(map[string]string{"cluster": "auto"}); err != nil {
+			t.Fatalf("[spec %d] Expected Config to succeed; got %v", index, err)
+		}
+		if err := srv.Dial(); err != nil {
+			t.Fatalf("[spec %d] Expected Dial to succeed; got %v", index, err)
+		}
+
+		if mode := srv.Status().Mode; mode != spec.expMode {
+			t.Fatalf("[spec %d] Expected mode to be %s; got %s", index, spec.expMode, mode)
+		}
+		reply, err := redisDriver.String(srv.DoContext(context.Background(), "GET", "foo"))
+		if err != nil || reply != "bar" {
+			t.Fatalf("[spec %d] Expected DoContext to return bar; got %q, %v", index, reply, err)
+		}
+		_, err = srv.GetConnection()
+		if spec.expMode == "cluster" && err != ErrClusterMode {
+			t.Fatalf("[spec %d] Expected GetConnection to return ErrClusterMode; got %v", index, err)
+		} else if spec.expMode == "standalone" && err != nil {
+			t.Fatalf("[spec %d] Expected GetConnection to succeed; got %v", index, err)
+		}
+		srv.Close()
+	}
+}
+
+func TestClusterAutoDetectRequiresDefaultDB(t *testing.T) {
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) == "INFO" {
+				return "$30\r\n# Cluster\r\ncluster_enabled:1\r\n\r\n"
+			}
+			return "+OK\r\n"
+		}
+	})
+
+	srv := newTestAdapter(endpoint)
+	if err := srv.Config(map[string]string{"cluster": "auto", "db": "1"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err == nil || !strings.Contains(err.Error(), "db 0") {
+		t.Fatalf("Expected Dial to reject a non-zero db for a cluster node; got %v", err)
+	}
+}
+
+func TestClusterFollowsAskRedirection(t *testing.T) {
+	var mu sync.Mutex
+	getsOnSeed := 0
+
+	target := newFakeServerFunc(t, func() fakeHandler {
+		asking := false
+		return func(args []string) string {
+			switch strings.ToUpper(args[0]) {
+			case "ASKING":
+				asking = true
+				return "+OK\r\n"
+			case "GET":
+				if !asking {
+					return "-ERR expected ASKING\r\n"
+				}
+				asking = false
+				return "$3\r\nbar\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	})
+	seed := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) == "GET" {
+				mu.Lock()
+				getsOnSeed++
+				mu.Unlock()
+				return fmt.Sprintf("-ASK %d %s\r\n", keySlot(args[1]), target)
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	srv := newTestAdapter(seed)
+	srv.clusterMode = true
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		reply, err := redisDriver.String(srv.DoContext(context.Background(), "GET", "foo"))
+		if err != nil {
+			t.Fatalf("[attempt %d] Expected DoContext to succeed; got %v", attempt, err)
+		}
+		if reply != "bar" {
+			t.Fatalf("[attempt %d] Expected reply to be bar; got %s", attempt, reply)
+		}
+	}
+
+	// ASK redirections should not update the slot mapping
+	mu.Lock()
+	defer mu.Unlock()
+	if getsOnSeed != 2 {
+		t.Fatalf("Expected the seed to receive 2 GETs; got %d", getsOnSeed)
+	}
+}
+
+func TestClusterRedirectionLoop(t *testing.T) {
+	var seed string
+	seed = newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) == "GET" {
+				return fmt.Sprintf("-ASK %d %s\r\n", keySlot(args[1]), seed)
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	srv := newTestAdapter(seed)
+	srv.clusterMode = true
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	_, err := srv.DoContext(context.Background(), "GET", "foo")
+	if err == nil || !strings.Contains(err.Error(), "too many cluster redirections") {
+		t.Fatalf("Expected DoContext to fail after too many redirections; got %v", err)
+	}
+}
+
+func TestClusterFallsBackToReachableSeed(t *testing.T) {
+	// The reachable seed cannot report the topology so commands are sent to a seed
+	seed := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			switch strings.ToUpper(args[0]) {
+			case "GET":
+				return "$3\r\nbar\r\n"
+			case "CLUSTER":
+				return "-ERR not available\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	srv := newTestAdapter("127.0.0.1:1," + seed)
+	srv.clusterMode = true
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	reply, err := redisDriver.String(srv.DoContext(context.Background(), "GET", "foo"))
+	if err != nil || reply != "bar" {
+		t.Fatalf("Expected DoContext to fall back to the reachable seed; got %q, %v", reply, err)
+	}
+}
+
+func TestClusterModeRequiresDefaultDB(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	err := srv.Config(map[string]string{"cluster": "true", "db": "1"})
+	if err == nil || !strings.Contains(err.Error(), "cluster mode only supports db 0") {
+		t.Fatalf("Expected Config to reject a non-zero db in cluster mode; got %v", err)
+	}
+	if srv.clusterMode {
+		t.Fatalf("Expected a rejected config not to enable cluster mode")
+	}
+}
+
+func TestKeepAliveProbesIdleConnections(t *testing.T) {
+	var mu sync.Mutex
+	pings, conns, deadConns := 0, 0, 0
+
+	// Connections with an id <= deadConns are dropped when they receive a command
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		mu.Lock()
+		conns++
+		id := conns
+		mu.Unlock()
+
+		return func(args []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			pings++
+			if id <= deadConns {
+				return ""
+			}
+			return "+PONG\r\n"
+		}
+	})
+	countPings := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return pings
+	}
+	waitFor := func(what string, cond func() bool) {
+		deadline := time.Now().Add(time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	srv := newTestAdapter(endpoint)
+	srv.keepAlive = 5 * time.Millisecond
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	// Populate the pool with two idle connections
+	conn1, _ := srv.GetConnection()
+	conn2, _ := srv.GetConnection()
+	conn1.Close()
+	conn2.Close()
+	pool := srv.pool
+
+	before := countPings()
+	waitFor("keepalive probes", func() bool { return countPings() >= before+4 })
+	if idle := pool.IdleCount(); idle != 2 {
+		t.Fatalf("Expected healthy connections to remain idle; got %d idle connection(s)", idle)
+	}
+
+	// Drop the existing connections; failed probes should evict them and the
+	// pool should be left with a single replacement connection
+	mu.Lock()
+	deadConns = conns
+	mu.Unlock()
+	waitFor("dead connections to be evicted", func() bool { return pool.ActiveCount() == 1 && pool.IdleCount() == 1 })
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed after evicting dead connections; got %v", err)
+	}
+	if _, err = conn.Do("PING"); err != nil {
+		t.Fatalf("Expected a healthy connection; got %v", err)
+	}
+	conn.Close()
+
+	srv.Close()
+	if srv.keepAliveStop != nil {
+		t.Fatalf("Expected Close to stop the keepalive goroutine")
+	}
+
+	// Allow any in-flight probe to complete
+	time.Sleep(2 * srv.keepAlive)
+	after := countPings()
+	time.Sleep(5 * srv.keepAlive)
+	if got := countPings(); got != after {
+		t.Fatalf("Expected no probes after Close; got %d", got-after)
+	}
+}
+
+func TestKeepAliveConfig(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	if err := srv.Config(map[string]string{"keepAlive": "30"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if srv.keepAlive != 30*time.Second {
+		t.Fatalf("Expected keepAlive to be 30s; got %v", srv.keepAlive)
+	}
+
+	if err := srv.Config(map[string]string{"keepAlive": "-1"}); err == nil {
+		t.Fatalf("Expected Config to reject a negative keepAlive interval")
+	}
+}
+
+func TestTCPKeepAliveConfig(t *testing.T) {
+	endpoint := newFakeServer(t)
+	srv := newTestAdapter(endpoint)
+
+	if err := srv.Config(map[string]string{"tcpKeepAlive": "-1"}); err == nil {
+		t.Fatalf("Expected Config to reject a negative tcpKeepAlive period")
+	}
+
+	if err := srv.Config(map[string]string{"tcpKeepAlive": "30"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if srv.tcpKeepAlive != 30*time.Second {
+		t.Fatalf("Expected tcpKeepAlive to be 30s; got %v", srv.tcpKeepAlive)
+	}
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	reply, err := redisDriver.String(srv.DoContext(context.Background(), "PING"))
+	if err != nil || reply != "PONG" {
+		t.Fatalf("Expected PING to return PONG; got %q, %v", reply, err)
+	}
+}
+
+func TestCustomDialer(t *testing.T) {
+	var mu sync.Mutex
+	var dialed []string
+	dialer := func(network, address string, timeout time.Duration) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, network+" "+address)
+		mu.Unlock()
+
+		// Serve the connection in-memory instead of over the network
+		client, server := net.Pipe()
+		go serveFakeConn(server, func(args []string) string {
+			if strings.ToUpper(args[0]) == "GET" {
+				return "$6\r\nin-mem\r\n"
+			}
+			return "+PONG\r\n"
+		})
+		return client, nil
+	}
+
+	srv := newTestAdapter("redis.invalid:6379")
+	if err := srv.SetOptions(adapters.WithDialer(dialer)); err != nil {
+		t.Fatalf("Expected SetOptions to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	reply, err := redisDriver.String(srv.DoContext(context.Background(), "GET", "foo"))
+	if err != nil || reply != "in-mem" {
+		t.Fatalf("Expected GET to be served by the in-memory connection; got %q, %v", reply, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if exp := []string{"tcp redis.invalid:6379"}; !reflect.DeepEqual(dialed, exp) {
+		t.Fatalf("Expected the dialer to be invoked with %v; got %v", exp, dialed)
+	}
+}
+
+func TestDoOnDB(t *testing.T) {
+	var mu sync.Mutex
+	conns, failRestore := 0, false
+
+	// DB replies with the db selected by the connection and FAIL always fails
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		mu.Lock()
+		conns++
+		mu.Unlock()
+
+		db := "0"
+		return func(args []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch strings.ToUpper(args[0]) {
+			case "SELECT":
+				if failRestore && args[1] == "0" {
+					return "-ERR cannot select db\r\n"
+				}
+				db = args[1]
+				return "+OK\r\n"
+			case "DB":
+				return ":" + db + "\r\n"
+			case "FAIL":
+				return "-ERR command failed\r\n"
+			case "QUIT":
+				// Like a real server, reply before closing the connection
+				return "+OK\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	srv := newTestAdapter(endpoint)
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	pooledDB := func() int64 {
+		conn, err := srv.GetConnection()
+		if err != nil {
+			t.Fatalf("Expected GetConnection to succeed; got %v", err)
+		}
+		defer conn.Close()
+
+		db, err := redisDriver.Int64(conn.Do("DB"))
+		if err != nil {
+			t.Fatalf("Expected DB to succeed; got %v", err)
+		}
+		return db
+	}
+
+	db, err := redisDriver.Int64(srv.DoOnDB(2, "DB"))
+	if err != nil || db != 2 {
+		t.Fatalf("Expected command to run against db 2; got %d, %v", db, err)
+	}
+	if db = pooledDB(); db != 0 {
+		t.Fatalf("Expected the pooled connection to be restored to db 0; got %d", db)
+	}
+
+	// The db should be restored even if the command fails
+	_, err = srv.DoOnDB(3, "FAIL")
+	if err == nil || err.Error() != "ERR command failed" {
+		t.Fatalf("Expected the command error to be returned; got %v", err)
+	}
+	if db = pooledDB(); db != 0 {
+		t.Fatalf("Expected the pooled connection to be restored to db 0 after a command error; got %d", db)
+	}
+
+	// If the db cannot be restored, the connection should be dropped
+	mu.Lock()
+	failRestore = true
+	dialed := conns
+	mu.Unlock()
+	_, err = srv.DoOnDB(4, "DB")
+	if err == nil || !strings.Contains(err.Error(), "could not restore db 0") {
+		t.Fatalf("Expected a restore error; got %v", err)
+	}
+	if db = pooledDB(); db != 0 {
+		t.Fatalf("Expected a fresh connection on db 0; got %d", db)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != dialed+1 {
+		t.Fatalf("Expected the connection on the wrong db to be replaced; dialed %d new connection(s)", conns-dialed)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	// ECHO replies with its argument and FAIL always fails
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			switch strings.ToUpper(args[0]) {
+			case "ECHO":
+				return fmt.Sprintf("$%d\r\n%s\r\n", len(args[1]), args[1])
+			case "FAIL":
+				return "-ERR command failed\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	srv := newTestAdapter(endpoint)
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	var cmds []RedisCmd
+	for index := 0; index < 10; index++ {
+		if index == 5 {
+			cmds = append(cmds, RedisCmd{Name: "FAIL"})
+			continue
+		}
+		cmds = append(cmds, RedisCmd{Name: "ECHO", Args: []interface{}{index}})
+	}
+
+	replies, err := srv.Pipeline(cmds)
+	if err != nil {
+		t.Fatalf("Expected Pipeline to succeed; got %v", err)
+	}
+	if len(replies) != len(cmds) {
+		t.Fatalf("Expected %d replies; got %d", len(cmds), len(replies))
+	}
+
+	for index, reply := range replies {
+		if index == 5 {
+			if replyErr, ok := reply.(redisDriver.Error); !ok || replyErr.Error() != "ERR command failed" {
+				t.Fatalf("[reply %d] Expected a command error; got %v", index, reply)
+			}
+			continue
+		}
+
+		val, err := redisDriver.Int(reply, nil)
+		if err != nil || val != index {
+			t.Fatalf("[reply %d] Expected reply to be %d; got %v (%v)", index, index, reply, err)
+		}
+	}
+
+	// The connection should still be usable after an error reply
+	if _, err = srv.Pipeline([]RedisCmd{{Name: "PING"}}); err != nil {
+		t.Fatalf("Expected Pipeline to succeed; got %v", err)
+	}
+}
+
+func TestDialAddress(t *testing.T) {
+	specs := []struct {
+		endpoint   string
+		network    string
+		expNetwork string
+		expAddress string
+	}{
+		{"localhost:6379", "", "tcp", "localhost:6379"},
+		{"localhost:6379", "tcp", "tcp", "localhost:6379"},
+		{"/var/run/redis.sock", "unix", "unix", "/var/run/redis.sock"},
+		{"unix:///var/run/redis.sock", "", "unix", "/var/run/redis.sock"},
+		{"unix:///var/run/redis.sock", "tcp", "unix", "/var/run/redis.sock"},
+	}
+
+	for index, spec := range specs {
+		srv := newTestAdapter(spec.endpoint)
+		srv.network = spec.network
+
+		network, address := srv.dialAddress()
+		if network != spec.expNetwork || address != spec.expAddress {
+			t.Fatalf("[spec %d] Expected to dial %s %s; got %s %s", index, spec.expNetwork, spec.expAddress, network, address)
+		}
+	}
+}
+
+func TestDialUnixSocket(t *testing.T) {
+	var mu sync.Mutex
+	var auth []string
+
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	serveFake(t, "unix", path, func(net.Conn) fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) == "AUTH" {
+				mu.Lock()
+				auth = append(auth, args[1])
+				mu.Unlock()
+				return "+OK\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	specs := []map[string]string{
+		{"endpoint": "unix://" + path, "password": "secret"},
+		{"endpoint": path, "network": "unix", "password": "secret"},
+	}
+
+	for index, spec := range specs {
+		srv := newTestAdapter("127.0.0.1:1")
+		if err := srv.Config(spec); err != nil {
+			t.Fatalf("[spec %d] Expected Config to succeed; got %v", index, err)
+		}
+		if err := srv.Dial(); err != nil {
+			t.Fatalf("[spec %d] Expected Dial to succeed; got %v", index, err)
+		}
+
+		conn, err := srv.GetConnection()
+		if err != nil {
+			t.Fatalf("[spec %d] Expected GetConnection to succeed over the unix socket; got %v", index, err)
+		}
+		conn.Close()
+		srv.Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(auth) != len(specs) || auth[0] != "secret" || auth[1] != "secret" {
+		t.Fatalf("Expected each unix socket connection to authenticate; got %v", auth)
+	}
+}
+
+func TestNetworkConfig(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	if err := srv.Config(map[string]string{"network": "udp"}); err == nil {
+		t.Fatalf("Expected Config to reject an unsupported network")
+	}
+	if err := srv.Config(map[string]string{"endpoint": "unix:///tmp/redis.sock", "cluster": "true"}); err == nil {
+		t.Fatalf("Expected Config to reject a unix socket endpoint in cluster mode")
+	}
+}
+
+// A dial policy that records suggested retry intervals.
+type recordingPolicy struct {
+	dial.Policy
+
+	mu        sync.Mutex
+	suggested []time.Duration
+}
+
+func (p *recordingPolicy) SuggestNext(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.suggested = append(p.suggested, d)
+}
+
+func TestDialRetriesWhileLoading(t *testing.T) {
+	var mu sync.Mutex
+	loadingReplies := 2
+
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch strings.ToUpper(args[0]) {
+			case "AUTH":
+				if loadingReplies > 0 {
+					loadingReplies--
+					return "-LOADING Redis is loading the dataset in memory\r\n"
+				}
+				return "+OK\r\n"
+			case "INFO":
+				info := "# Persistence\r\nloading:1\r\nloading_eta_seconds:7\r\n"
+				return fmt.Sprintf("$%d\r\n%s\r\n", len(info), info)
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	policy := &recordingPolicy{Policy: dial.Periodic(5, time.Millisecond)}
+	srv := newTestAdapter(endpoint)
+	srv.password = "secret"
+	srv.dialPolicy = policy
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed once loading completes; got %v", err)
+	}
+	conn.Close()
+
+	policy.mu.Lock()
+	defer policy.mu.Unlock()
+	if len(policy.suggested) != 2 || policy.suggested[0] != 7*time.Second || policy.suggested[1] != 7*time.Second {
+		t.Fatalf("Expected the loading ETA to be suggested for each retry; got %v", policy.suggested)
+	}
+}
+
+func TestDialRetriesWithFakeClock(t *testing.T) {
+	var mu sync.Mutex
+	loadingReplies := 3
+
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch strings.ToUpper(args[0]) {
+			case "AUTH":
+				if loadingReplies > 0 {
+					loadingReplies--
+					return "-LOADING Redis is loading the dataset in memory\r\n"
+				}
+				return "+OK\r\n"
+			case "INFO":
+				info := "# Persistence\r\nloading:1\r\nloading_eta_seconds:30\r\n"
+				return fmt.Sprintf("$%d\r\n%s\r\n", len(info), info)
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	fake := clock.UseFake(t)
+	srv := newTestAdapter(endpoint)
+	srv.password = "secret"
+	srv.dialPolicy = dial.ServerDirected(dial.Periodic(5, time.Hour))
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	// The pool dials connections lazily
+	start := time.Now()
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed once loading completes; got %v", err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected retries to complete without real delays; took %v", elapsed)
+	}
+
+	// The loading ETA should be used for each retry
+	expected := []time.Duration{30 * time.Second, 30 * time.Second, 30 * time.Second}
+	if sleeps := fake.Sleeps(); !reflect.DeepEqual(sleeps, expected) {
+		t.Fatalf("Expected sleeps %v; got %v", expected, sleeps)
+	}
+}
+
+func TestDialDoesNotRetryAuthFailures(t *testing.T) {
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) == "AUTH" {
+				return "-ERR invalid password\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	srv := newTestAdapter(endpoint)
+	srv.password = "secret"
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	_, err := srv.GetConnection()
+	if err == nil || err.Error() != "ERR invalid password" {
+		t.Fatalf("Expected GetConnection to fail with the AUTH error; got %v", err)
+	}
+	if attempt := srv.dialPolicy.CurAttempt(); attempt != 1 {
+		t.Fatalf("Expected a single dial attempt; got %d", attempt)
+	}
+}
+
+// Start a fake redis server that supports SCAN over the supplied keys. SCAN
+// cursors are indexes into keys.
+func newFakeScanServer(t *testing.T, keys []string) string {
+	return newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) != "SCAN" {
+				return "+PONG\r\n"
+			}
+
+			cursor, _ := strconv.Atoi(args[1])
+			match, count := "*", 10
+			for i := 2; i+1 < len(args); i += 2 {
+				switch strings.ToUpper(args[i]) {
+				case "MATCH":
+					match = args[i+1]
+				case "COUNT":
+					count, _ = strconv.Atoi(args[i+1])
+				}
+			}
+
+			// Like redis, MATCH is applied after fetching a page of keys
+			end := cursor + count
+			if end >= len(keys) {
+				end = 0
+			}
+			var page []string
+			for _, key := range keys[cursor:] {
+				if end != 0 && len(page)+cursor >= end {
+					break
+				}
+				page = append(page, key)
+			}
+
+			var matches []string
+			for _, key := range page {
+				if matchPattern(match, key) {
+					matches = append(matches, fmt.Sprintf("$%d\r\n%s\r\n", len(key), key))
+				}
+			}
+			next := strconv.Itoa(end)
+			return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n%s", len(next), next, len(matches), strings.Join(matches, ""))
+		}
+	})
+}
+
+func TestScan(t *testing.T) {
+	var keys []string
+	expKeys := make(map[string]bool)
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		if i%5 == 0 {
+			key = fmt.Sprintf("session:%d", i)
+		} else {
+			expKeys[key] = true
+		}
+		keys = append(keys, key)
+	}
+
+	srv := newTestAdapter(newFakeScanServer(t, keys))
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	out, err := srv.Scan(context.Background(), "user:*", 25)
+	if err != nil {
+		t.Fatalf("Expected Scan to succeed; got %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for res := range out {
+		if res.Err != nil {
+			t.Fatalf("Expected the scan to complete; got %v", res.Err)
+		}
+		key := res.Key
+		if !expKeys[key] {
+			t.Fatalf("Unexpected key %s", key)
+		}
+		if seen[key] {
+			t.Fatalf("Key %s was returned twice", key)
+		}
+		seen[key] = true
+	}
+	if len(seen) != len(expKeys) {
+		t.Fatalf("Expected %d keys; got %d", len(expKeys), len(seen))
+	}
+
+	// The connection should be returned to the pool
+	if inUse := srv.pool.ActiveCount() - srv.pool.IdleCount(); inUse != 0 {
+		t.Fatalf("Expected the connection to be released; %d connection(s) still in use", inUse)
+	}
+}
+
+func TestScanCancelled(t *testing.T) {
+	var keys []string
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("key:%d", i))
+	}
+
+	srv := newTestAdapter(newFakeScanServer(t, keys))
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out, err := srv.Scan(ctx, "", 0)
+	if err != nil {
+		t.Fatalf("Expected Scan to succeed; got %v", err)
+	}
+	<-out
+	cancel()
+
+	// The channel should be closed and the connection released
+	deadline := time.Now().Add(time.Second)
+	for srv.pool.ActiveCount()-srv.pool.IdleCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the connection to be released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for range out {
+	}
+}
+
+func TestScanAbortsOnReset(t *testing.T) {
+	var keys []string
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("key:%d", i))
+	}
+
+	srv := newTestAdapter(newFakeScanServer(t, keys))
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	out, err := srv.Scan(context.Background(), "", 10)
+	if err != nil {
+		t.Fatalf("Expected Scan to succeed; got %v", err)
+	}
+	<-out
+
+	if err = srv.Config(map[string]string{"connTimeout": "2"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+
+	// Only the keys of the page fetched before the reset should be returned,
+	// followed by the error that aborted the scan
+	received := 1
+	var scanErr error
+	for res := range out {
+		if res.Err != nil {
+			scanErr = res.Err
+			continue
+		}
+		received++
+	}
+	if received != 10 {
+		t.Fatalf("Expected the scan to abort after the first page of 10 keys; got %d keys", received)
+	}
+	if scanErr != ErrScanReset {
+		t.Fatalf("Expected the aborted scan to report ErrScanReset; got %v", scanErr)
+	}
+}
+
+func TestScanReportsPageError(t *testing.T) {
+	srv := newTestAdapter(newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) != "SCAN" {
+				return "+PONG\r\n"
+			}
+			if args[1] != "0" {
+				return "-ERR injected failure\r\n"
+			}
+			return "*2\r\n$1\r\n5\r\n*1\r\n$3\r\nfoo\r\n"
+		}
+	}))
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	out, err := srv.Scan(context.Background(), "", 10)
+	if err != nil {
+		t.Fatalf("Expected Scan to succeed; got %v", err)
+	}
+
+	var results []ScanResult
+	for res := range out {
+		results = append(results, res)
+	}
+	if len(results) != 2 || results[0].Key != "foo" || results[0].Err != nil {
+		t.Fatalf("Expected the first page followed by an error; got %+v", results)
+	}
+	if err := results[1].Err; err == nil || !strings.Contains(err.Error(), "injected failure") {
+		t.Fatalf("Expected the SCAN error to be reported; got %v", err)
+	}
+}
+
+func TestScanNotConnected(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	if _, err := srv.Scan(context.Background(), "*", 10); err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected Scan to fail with ErrConnectionClosed; got %v", err)
+	}
+}
+
+func TestWaitForConnection(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		srv.Dial()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.WaitForConnection(ctx); err != nil {
+		t.Fatalf("Expected WaitForConnection to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	if _, err := srv.GetConnection(); err != nil {
+		t.Fatalf("Expected GetConnection to succeed after WaitForConnection; got %v", err)
+	}
+}
+
+func TestGetConnectionTimeout(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+	if err := srv.Config(map[string]string{"maxActive": "1", "poolWait": "true"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	// Saturate the pool
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+
+	start := time.Now()
+	if _, err = srv.GetConnectionTimeout(50 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("Expected GetConnectionTimeout to fail with %v; got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("Expected GetConnectionTimeout to give up after 50ms; took %v", elapsed)
+	}
+
+	// Waiters should be handed the connection once it is returned to the pool
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		conn.Close()
+	}()
+	conn, err = srv.GetConnectionTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("Expected GetConnectionTimeout to succeed once a connection is released; got %v", err)
+	}
+	conn.Close()
+}
+
+func TestPoolLimitConfig(t *testing.T) {
+	srv := newTestAdapter(newFakeServer(t))
+
+	if err := srv.Config(map[string]string{"maxActive": "-1"}); err == nil {
+		t.Fatalf("Expected Config to reject a negative maxActive")
+	}
+	if err := srv.Config(map[string]string{"poolWait": "maybe"}); err == nil {
+		t.Fatalf("Expected Config to reject a non-boolean poolWait")
+	}
+
+	// Without poolWait, an exhausted pool fails immediately
+	if err := srv.Config(map[string]string{"maxActive": "1"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	defer conn.Close()
+
+	if _, err = srv.GetConnectionTimeout(time.Second); err != redisDriver.ErrPoolExhausted {
+		t.Fatalf("Expected GetConnectionTimeout to fail with %v; got %v", redisDriver.ErrPoolExhausted, err)
+	}
+}
+
+func TestPoolWarmup(t *testing.T) {
+	specs := []struct {
+		warmup    string
+		maxActive string
+		expIdle   int
+	}{
+		{"0", "0", 0},
+		{"2", "0", 2},
+		// Warmups larger than the default MaxIdle should not be discarded
+		{"5", "0", 5},
+		// Warmups are capped at maxActive
+		{"4", "2", 2},
+	}
+
+	endpoint := newFakeServer(t)
+	for _, spec := range specs {
+		srv := newTestAdapter(endpoint)
+		if err := srv.Config(map[string]string{"warmup": spec.warmup, "maxActive": spec.maxActive}); err != nil {
+			t.Fatalf("[warmup %s] Expected Config to succeed; got %v", spec.warmup, err)
+		}
+		if err := srv.Dial(); err != nil {
+			t.Fatalf("[warmup %s] Expected Dial to succeed; got %v", spec.warmup, err)
+		}
+		if idle := srv.pool.IdleCount(); idle != spec.expIdle {
+			t.Fatalf("[warmup %s, maxActive %s] Expected %d idle connection(s); got %d", spec.warmup, spec.maxActive, spec.expIdle, idle)
+		}
+
+		// Warm connections should be handed out before dialing new ones
+		if spec.expIdle > 0 {
+			conn, err := srv.GetConnection()
+			if err != nil {
+				t.Fatalf("[warmup %s] Expected GetConnection to succeed; got %v", spec.warmup, err)
+			}
+			if idle := srv.pool.IdleCount(); idle != spec.expIdle-1 {
+				t.Fatalf("[warmup %s] Expected GetConnection to use a warm connection; got %d idle connection(s)", spec.warmup, idle)
+			}
+			conn.Close()
+		}
+		srv.Close()
+	}
+}
+
+func TestPoolWarmupFailure(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+	srv.dialPolicy = dial.Periodic(2, 10*time.Millisecond)
+	srv.warmup = 3
+
+	// Warmup failures are bounded by the dial policy and do not fail Dial
+	start := time.Now()
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the warmup to give up once the dial policy is exhausted; took %v", elapsed)
+	}
+	if idle := srv.pool.IdleCount(); idle != 0 {
+		t.Fatalf("Expected no idle connections; got %d", idle)
+	}
+
+	// A cancelled context aborts the warmup
+	srv.Close()
+	srv.dialPolicy = dial.Periodic(1000, 50*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start = time.Now()
+	if err := srv.DialContext(ctx); err != nil {
+		t.Fatalf("Expected DialContext to succeed; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected cancelling the context to abort the warmup; took %v", elapsed)
+	}
+
+	if err := srv.Config(map[string]string{"warmup": "-1"}); err == nil {
+		t.Fatalf("Expected Config to reject a negative warmup")
+	}
+}
+
+func TestTestOnBorrowModes(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			commands = append(commands, strings.Join(args, " "))
+			return "+PONG\r\n"
+		}
+	})
+	countPings := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		pings := 0
+		for _, cmd := range commands {
+			if cmd == "PING" {
+				pings++
+			}
+		}
+		commands = nil
+		return pings
+	}
+
+	specs := []struct {
+		mode string
+		// The expected PINGs when borrowing a connection right after it was returned
+		// to the pool and after it stayed idle for longer than testOnBorrowIdle.
+		expPings     int
+		expIdlePings int
+	}{
+		{"always", 1, 1},
+		{"", 1, 1},
+		{"onIdle", 0, 1},
+		{"never", 0, 0},
+	}
+
+	for _, spec := range specs {
+		srv := newTestAdapter(endpoint)
+		srv.testOnBorrow = spec.mode
+		srv.testOnBorrowIdle = 50 * time.Millisecond
+		if err := srv.Dial(); err != nil {
+			t.Fatalf("[mode %q] Expected Dial to succeed; got %v", spec.mode, err)
+		}
+
+		// Newly dialed connections are never tested
+		conn, err := srv.GetConnection()
+		if err != nil {
+			t.Fatalf("[mode %q] Expected GetConnection to succeed; got %v", spec.mode, err)
+		}
+		conn.Close()
+		countPings()
+
+		conn, _ = srv.GetConnection()
+		conn.Close()
+		if pings := countPings(); pings != spec.expPings {
+			t.Fatalf("[mode %q] Expected %d PING(s) when borrowing a recently used connection; got %d", spec.mode, spec.expPings, pings)
+		}
+
+		time.Sleep(2 * srv.testOnBorrowIdle)
+		conn, _ = srv.GetConnection()
+		conn.Close()
+		if pings := countPings(); pings != spec.expIdlePings {
+			t.Fatalf("[mode %q] Expected %d PING(s) when borrowing an idle connection; got %d", spec.mode, spec.expIdlePings, pings)
+		}
+		srv.Close()
+	}
+}
+
+func TestTestOnBorrowConfig(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	if err := srv.Config(map[string]string{"testOnBorrow": "onIdle", "testOnBorrowIdle": "30"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if srv.testOnBorrow != "onIdle" || srv.testOnBorrowIdle != 30*time.Second {
+		t.Fatalf("Expected testOnBorrow=onIdle and testOnBorrowIdle=30s; got %s and %v", srv.testOnBorrow, srv.testOnBorrowIdle)
+	}
+
+	for _, params := range []map[string]string{
+		{"testOnBorrow": "sometimes"},
+		{"testOnBorrowIdle": "-1"},
+	} {
+		if err := srv.Config(params); err == nil {
+			t.Fatalf("Expected Config to reject %v", params)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	var mu sync.Mutex
+	loadingReplies := 1
+
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if strings.ToUpper(args[0]) == "AUTH" && loadingReplies > 0 {
+				loadingReplies--
+				return "-LOADING Redis is loading the dataset in memory\r\n"
+			}
+			if strings.ToUpper(args[0]) == "INFO" {
+				info := "# Persistence\r\nloading:1\r\n"
+				return fmt.Sprintf("$%d\r\n%s\r\n", len(info), info)
+			}
+			return "+OK\r\n"
+		}
+	})
+
+	fake := clock.UseFake(t)
+	srv := newTestAdapter("127.0.0.1:1")
+	srv.password = "secret"
+	srv.dialPolicy = dial.Periodic(2, time.Hour)
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	if _, err := srv.GetConnection(); err == nil {
+		t.Fatalf("Expected GetConnection to fail")
+	}
+	status := srv.Status()
+	if !status.Connected || status.LastError == "" || status.DialAttempts != 2 || !status.LastConnectedAt.IsZero() {
+		t.Fatalf("Expected the status to reflect the failed dial; got %+v", status)
+	}
+
+	// Changing the endpoint re-creates the pool; the first attempt fails while the server is loading
+	if err := srv.SetEndpoint(endpoint); err != nil {
+		t.Fatalf("Expected SetEndpoint to succeed; got %v", err)
+	}
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	conn.Close()
+
+	exp := adapters.Status{
+		Connected:       true,
+		Endpoint:        endpoint,
+		DialAttempts:    2,
+		LastConnectedAt: fake.Now(),
+		Mode:            "standalone",
+	}
+	if status = srv.Status(); !reflect.DeepEqual(status, exp) {
+		t.Fatalf("Expected status %+v; got %+v", exp, status)
+	}
+}
+
+// A metrics sink that records the service label of dial attempts.
+type labelMetrics struct {
+	adapters.Metrics
+
+	mu     sync.Mutex
+	labels []string
+}
+
+func (m *labelMetrics) IncDialAttempt(service string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.labels = append(m.labels, service)
+}
+
+func TestSetName(t *testing.T) {
+	var buf bytes.Buffer
+	metrics := &labelMetrics{Metrics: adapters.NopMetrics}
+	srv := newTestAdapter("127.0.0.1:1")
+	srv.dialPolicy = dial.Periodic(1, time.Millisecond)
+	srv.logger = log.New(&buf, "", 0)
+	srv.metrics = metrics
+
+	if name := srv.Name(); name != "redis" {
+		t.Fatalf("Expected the default name to be redis; got %s", name)
+	}
+	if err := srv.SetOptions(adapters.WithName("sessions")); err != nil {
+		t.Fatalf("Expected SetOptions to succeed; got %v", err)
+	}
+	if name := adapters.ServiceName(srv); name != "sessions" {
+		t.Fatalf("Expected the service name to be sessions; got %s", name)
+	}
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+	if _, err := srv.GetConnection(); err == nil {
+		t.Fatalf("Expected GetConnection to fail")
+	}
+
+	if out := buf.String(); !strings.Contains(out, "[REDIS sessions] Could not connect to endpoint 127.0.0.1:1") {
+		t.Fatalf("Expected log lines to be prefixed with the service name; got %q", out)
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if !reflect.DeepEqual(metrics.labels, []string{"sessions"}) {
+		t.Fatalf("Expected metrics to be labelled with the service name; got %v", metrics.labels)
+	}
+}
+
+func TestMaxCloseListeners(t *testing.T) {
+	var buf bytes.Buffer
+	srv := newTestAdapter("localhost:6379")
+	srv.logger = log.New(&buf, "", 0)
+	if err := srv.SetOptions(adapters.MaxCloseListeners(2)); err != nil {
+		t.Fatalf("Expected SetOptions to succeed; got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		srv.NotifyClose(make(adapters.CloseListener, 1))
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Expected no warning below the threshold; got %q", buf.String())
+	}
+
+	srv.NotifyClose(make(adapters.CloseListener, 1))
+	if exp := "[REDIS] Possible close listener leak: 3 listeners registered (max 2)\n"; buf.String() != exp {
+		t.Fatalf("Expected warning %q; got %q", exp, buf.String())
+	}
+}
+
+func TestDialPolicyAccessor(t *testing.T) {
+	policy := dial.ExpBackoff(10, time.Second)
+	srv := newTestAdapter("127.0.0.1:1")
+	srv.SetDialPolicy(policy)
+
+	if srv.DialPolicy() == policy {
+		t.Fatalf("Expected DialPolicy to return the copy stored by SetDialPolicy")
+	}
+	if descr := dial.Describe(srv.DialPolicy()); descr != "expBackoff(max=10, unit=1s)" {
+		t.Fatalf("Expected the policy to be described as expBackoff(max=10, unit=1s); got %q", descr)
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	srv := newTestAdapter("10.0.0.1:6379")
+	srv.Config(map[string]string{"db": "3", "keyspaceEvents": "", "testOnBorrow": "never", "protocol": "3"})
+
+	if err := srv.Config(srv.ConfigDefaults()); err != nil {
+		t.Fatalf("Expected Config to accept the default settings; got %v", err)
+	}
+
+	if srv.endpoint != Adapter.endpoint || srv.db != Adapter.db || srv.keyspaceEvents != Adapter.keyspaceEvents ||
+		srv.testOnBorrow != Adapter.testOnBorrow || srv.protocol != Adapter.protocol {
+		t.Fatalf("Expected the default settings to be restored; got endpoint=%s, db=%d, keyspaceEvents=%s, testOnBorrow=%s, protocol=%d", srv.endpoint, srv.db, srv.keyspaceEvents, srv.testOnBorrow, srv.protocol)
+	}
+
+	// The defaults are copied so that callers cannot modify them
+	srv.ConfigDefaults()["db"] = "3"
+	if db := srv.ConfigDefaults()["db"]; db != "0" {
+		t.Fatalf("Expected ConfigDefaults to return a copy of the defaults; got db=%s", db)
+	}
+}
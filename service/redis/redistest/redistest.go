@@ -0,0 +1,55 @@
+// Package redistest provides an in-memory redis server for testing code that
+// uses the redis service adapter. The server is backed by miniredis and supports
+// the commands used by the adapter for dialing (PING, AUTH and SELECT).
+package redistest
+
+import (
+	"testing"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/alicebob/miniredis/v2"
+)
+
+// An in-memory redis server. The embedded miniredis instance can be used for
+// seeding and inspecting keys (e.g. via Set, Get or DB) and for simulating
+// failures (e.g. via RequireAuth or SetError).
+type Server struct {
+	*miniredis.Miniredis
+}
+
+// Start an in-memory redis server that is shut down when the test completes.
+func Run(t testing.TB) *Server {
+	t.Helper()
+
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("redistest: could not start server: %v", err)
+	}
+	t.Cleanup(m.Close)
+
+	return &Server{m}
+}
+
+// Get the adapter settings for connecting to the server.
+func (s *Server) Settings() map[string]string {
+	return map[string]string{"endpoint": s.Addr()}
+}
+
+// Configure svc using the server Settings and then dial it. Any params are applied
+// on top of the server settings. The service is closed when the test completes.
+func (s *Server) Connect(t testing.TB, svc adapters.Service, params map[string]string) {
+	t.Helper()
+
+	settings := s.Settings()
+	for key, val := range params {
+		settings[key] = val
+	}
+
+	if err := svc.Config(settings); err != nil {
+		t.Fatalf("redistest: could not configure service: %v", err)
+	}
+	if err := svc.Dial(); err != nil {
+		t.Fatalf("redistest: could not dial service: %v", err)
+	}
+	t.Cleanup(func() { svc.Close() })
+}
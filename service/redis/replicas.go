@@ -0,0 +1,163 @@
+package redis
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// The time an unreachable replica is excluded from read routing before it is retried.
+var replicaEvictionPeriod = 30 * time.Second
+
+// A set of read replicas that maintains a connection pool per replica. Connections
+// are handed out in round-robin order. Replicas whose connections fail are evicted
+// for replicaEvictionPeriod.
+type replicaSet struct {
+
+	// A mutex protecting the replica state.
+	sync.Mutex
+
+	// The replica addresses.
+	addrs []string
+
+	// Connection pools indexed like addrs.
+	pools []*redisDriver.Pool
+
+	// The time until which each replica is evicted; zero if not evicted.
+	evictedUntil []time.Time
+
+	// The index of the replica that serves the next connection.
+	next int
+
+	// A logger for replica evictions and the prefix of its log lines.
+	logger    *log.Logger
+	logPrefix string
+}
+
+// Create a replica set whose pools dial connections via dialReplica and test borrowed
+// connections via testOnBorrow (see testOnBorrowFunc).
+func newReplicaSet(addrs []string, dialReplica func(addr string) (redisDriver.Conn, error), testOnBorrow func(redisDriver.Conn, time.Time) error, logger *log.Logger, logPrefix string) *replicaSet {
+	rs := &replicaSet{
+		addrs:        addrs,
+		pools:        make([]*redisDriver.Pool, len(addrs)),
+		evictedUntil: make([]time.Time, len(addrs)),
+		logger:       logger,
+		logPrefix:    logPrefix,
+	}
+
+	for index, addr := range addrs {
+		addr := addr
+		rs.pools[index] = newPool(func() (redisDriver.Conn, error) {
+			return dialReplica(addr)
+		})
+		rs.pools[index].TestOnBorrow = testOnBorrow
+	}
+
+	return rs
+}
+
+// Get a connection from the next available replica. Replicas that fail to provide
+// a connection are evicted and the next one is tried. Returns nil if no replica is
+// available.
+func (rs *replicaSet) get() redisDriver.Conn {
+	for tries := 0; tries < len(rs.addrs); tries++ {
+		index, pool := rs.nextReplica()
+		if pool == nil {
+			return nil
+		}
+
+		// The pool may need to dial a new connection so we cannot hold the lock while calling Get
+		conn := pool.Get()
+		if err := conn.Err(); err != nil {
+			conn.Close()
+
+			// Hitting the maxTotalConnections limit says nothing about the replica health
+			if err != ErrTooManyConnections {
+				rs.evict(index, err)
+			}
+			continue
+		}
+
+		return &replicaConn{Conn: conn, evict: func(err error) { rs.evict(index, err) }}
+	}
+
+	return nil
+}
+
+// Select the next replica that is not evicted and advance the round-robin index.
+// Returns a nil pool if all replicas are evicted.
+func (rs *replicaSet) nextReplica() (int, *redisDriver.Pool) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	now := time.Now()
+	for tries := 0; tries < len(rs.addrs); tries++ {
+		index := rs.next
+		rs.next = (rs.next + 1) % len(rs.addrs)
+		if now.Before(rs.evictedUntil[index]) {
+			continue
+		}
+		return index, rs.pools[index]
+	}
+
+	return -1, nil
+}
+
+// Exclude the replica at index from read routing for replicaEvictionPeriod.
+func (rs *replicaSet) evict(index int, err error) {
+	rs.Lock()
+	defer rs.Unlock()
+
+	if time.Now().Before(rs.evictedUntil[index]) {
+		return
+	}
+	rs.evictedUntil[index] = time.Now().Add(replicaEvictionPeriod)
+	rs.logger.Printf("%s Evicting read replica %s for %v: %v\n", rs.logPrefix, rs.addrs[index], replicaEvictionPeriod, err)
+}
+
+// Get the number of connections that are currently borrowed from the replica pools.
+func (rs *replicaSet) inUse() int {
+	count := 0
+	for _, pool := range rs.pools {
+		count += pool.ActiveCount() - pool.IdleCount()
+	}
+	return count
+}
+
+// Close all replica pools.
+func (rs *replicaSet) close() {
+	for _, pool := range rs.pools {
+		pool.Close()
+	}
+}
+
+// A connection to a read replica that evicts the replica if the connection breaks
+// while executing a command.
+type replicaConn struct {
+	redisDriver.Conn
+
+	// Evict the replica that served this connection.
+	evict func(err error)
+}
+
+func (c *replicaConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(cmd, args...)
+	c.checkErr()
+	return reply, err
+}
+
+func (c *replicaConn) Receive() (interface{}, error) {
+	reply, err := c.Conn.Receive()
+	c.checkErr()
+	return reply, err
+}
+
+// Evict the replica if the underlying connection is no longer usable. Error
+// replies (redis.Error) do not break the connection.
+func (c *replicaConn) checkErr() {
+	if err := c.Conn.Err(); err != nil {
+		c.evict(err)
+	}
+}
@@ -0,0 +1,190 @@
+package redis
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// Start a fake redis server that replies to WHO with name. If dropOnWho is set,
+// WHO commands drop the connection instead.
+func newFakeNamedServer(t *testing.T, name string, dropOnWho bool) string {
+	return newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			if strings.ToUpper(args[0]) != "WHO" {
+				return "+PONG\r\n"
+			}
+			if dropOnWho {
+				return ""
+			}
+			return "+" + name + "\r\n"
+		}
+	})
+}
+
+// Fetch a read connection, run WHO and return the reply.
+func readWho(t *testing.T, srv *Redis) (string, error) {
+	conn, err := srv.GetReadConnection()
+	if err != nil {
+		t.Fatalf("Expected GetReadConnection to succeed; got %v", err)
+	}
+	defer conn.Close()
+
+	return redisDriver.String(conn.Do("WHO"))
+}
+
+func TestGetReadConnectionRoundRobin(t *testing.T) {
+	master := newFakeNamedServer(t, "master", false)
+	replica1 := newFakeNamedServer(t, "replica1", false)
+	replica2 := newFakeNamedServer(t, "replica2", false)
+
+	srv := newTestAdapter(master)
+	if err := srv.Config(map[string]string{"replicas": replica1 + "," + replica2}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	expReplies := []string{"replica1", "replica2", "replica1", "replica2"}
+	for index, exp := range expReplies {
+		reply, err := readWho(t, srv)
+		if err != nil || reply != exp {
+			t.Fatalf("[read %d] Expected read to be served by %s; got %q, %v", index, exp, reply, err)
+		}
+	}
+
+	// Writes should always go to the master
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	defer conn.Close()
+	if reply, err := redisDriver.String(conn.Do("WHO")); err != nil || reply != "master" {
+		t.Fatalf("Expected GetConnection to return a master connection; got %q, %v", reply, err)
+	}
+}
+
+func TestGetReadConnectionFallback(t *testing.T) {
+	master := newFakeNamedServer(t, "master", false)
+	broken := newFakeNamedServer(t, "broken", true)
+
+	// The first replica is down and the second one breaks its connections
+	srv := newTestAdapter(master)
+	if err := srv.Config(map[string]string{"replicas": "127.0.0.1:1," + broken}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	// The unreachable replica should be evicted and the read routed to the second one
+	if _, err := readWho(t, srv); err == nil {
+		t.Fatalf("Expected read via the broken replica to fail")
+	}
+
+	// With both replicas evicted, reads should fall back to the master
+	for i := 0; i < 3; i++ {
+		reply, err := readWho(t, srv)
+		if err != nil || reply != "master" {
+			t.Fatalf("[read %d] Expected read to fall back to the master; got %q, %v", i, reply, err)
+		}
+	}
+
+	// Evicted replicas are retried once the eviction period elapses
+	srv.replicaSet.Lock()
+	for index := range srv.replicaSet.evictedUntil {
+		if srv.replicaSet.evictedUntil[index].IsZero() {
+			t.Errorf("Expected replica %s to be evicted", srv.replicaSet.addrs[index])
+		}
+		srv.replicaSet.evictedUntil[index] = srv.replicaSet.evictedUntil[index].Add(-2 * replicaEvictionPeriod)
+	}
+	srv.replicaSet.Unlock()
+	if _, err := readWho(t, srv); err == nil {
+		t.Fatalf("Expected read to be routed to a replica again after the eviction period")
+	}
+}
+
+func TestReplicasConfig(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:7000")
+
+	err := srv.Config(map[string]string{"cluster": "true", "replicas": "127.0.0.1:7001"})
+	if err == nil {
+		t.Fatalf("Expected Config to reject read replicas in cluster mode")
+	}
+
+	// Without replicas, reads are served by the master
+	master := newFakeNamedServer(t, "master", false)
+	srv = newTestAdapter(master)
+	if err = srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	if reply, err := readWho(t, srv); err != nil || reply != "master" {
+		t.Fatalf("Expected read to be served by the master; got %q, %v", reply, err)
+	}
+}
+
+func TestReplicaTestOnBorrow(t *testing.T) {
+	master := newFakeNamedServer(t, "master", false)
+	var mu sync.Mutex
+	pings := 0
+	replica := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if strings.ToUpper(args[0]) == "PING" {
+				pings++
+			}
+			return "+PONG\r\n"
+		}
+	})
+	countPings := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		count := pings
+		pings = 0
+		return count
+	}
+
+	specs := []struct {
+		mode     string
+		expPings int
+	}{
+		{"always", 1},
+		{"never", 0},
+	}
+
+	for _, spec := range specs {
+		srv := newTestAdapter(master)
+		if err := srv.Config(map[string]string{"replicas": replica, "testOnBorrow": spec.mode}); err != nil {
+			t.Fatalf("[mode %q] Expected Config to succeed; got %v", spec.mode, err)
+		}
+		if err := srv.Dial(); err != nil {
+			t.Fatalf("[mode %q] Expected Dial to succeed; got %v", spec.mode, err)
+		}
+
+		// Newly dialed connections are never tested
+		conn, err := srv.GetReadConnection()
+		if err != nil {
+			t.Fatalf("[mode %q] Expected GetReadConnection to succeed; got %v", spec.mode, err)
+		}
+		conn.Close()
+		countPings()
+
+		conn, _ = srv.GetReadConnection()
+		conn.Close()
+		if pings := countPings(); pings != spec.expPings {
+			t.Fatalf("[mode %q] Expected %d PING(s) when borrowing a replica connection; got %d", spec.mode, spec.expPings, pings)
+		}
+
+		srv.Close()
+	}
+}
@@ -0,0 +1,372 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// The protocol versions accepted by the protocol setting.
+const (
+	protocolRESP2 = 2
+	protocolRESP3 = 3
+)
+
+// The kinds of push messages used by pub/sub. The driver reads these as the
+// replies of a subscribed connection so they are kept in the reply stream.
+var pubSubPushKinds = map[string]bool{
+	"message":      true,
+	"pmessage":     true,
+	"smessage":     true,
+	"subscribe":    true,
+	"psubscribe":   true,
+	"ssubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"sunsubscribe": true,
+}
+
+// A handler for RESP3 push messages that are not part of the reply stream, such
+// as client-side caching invalidations. The push argument contains the push kind
+// (e.g. "invalidate") followed by its payload, using the reply types of the driver.
+type PushHandler func(push []interface{})
+
+// Register a handler for the RESP3 push messages (other than pub/sub messages)
+// received by connections negotiated with protocol 3, e.g. the invalidation
+// messages sent when CLIENT TRACKING is enabled. Such messages are removed from the
+// reply stream so they never become the reply of a command; if no handler is
+// registered, they are dropped. Pushes are only read when the connection reads a
+// reply, so the handler is invoked synchronously by the goroutine running the next
+// command on that connection and should not block. Passing nil removes the handler.
+func (s *Redis) SetPushHandler(handler func(push []interface{})) {
+	s.pushHandler.Store(PushHandler(handler))
+}
+
+// Invoke the handler registered via SetPushHandler, if any.
+func (s *Redis) dispatchPush(push []interface{}) {
+	if handler, _ := s.pushHandler.Load().(PushHandler); handler != nil {
+		handler(push)
+	}
+}
+
+// A net.Conn that translates RESP3 replies into their closest RESP2 equivalent so
+// that connections negotiated with HELLO 3 can still be read by the redis driver.
+// Maps and sets are flattened into arrays, nulls become nil bulk strings, booleans
+// become integers and doubles, big numbers and verbatim strings become bulk
+// strings. Attributes are discarded. RESP2 replies are passed through unmodified.
+// Pub/sub push messages are translated to arrays; all other push messages are
+// removed from the reply stream and passed to onPush.
+type resp3Conn struct {
+	net.Conn
+
+	// A reader for the replies sent by the server.
+	br *bufio.Reader
+
+	// Invoked with the push messages removed from the reply stream; may be nil.
+	onPush func(push []interface{})
+
+	// The translated reply data that has not been consumed by Read yet.
+	pending bytes.Buffer
+}
+
+// Wrap conn so that RESP3 replies are translated to RESP2 and out-of-band push
+// messages are passed to onPush.
+func newRESP3Conn(conn net.Conn, onPush func(push []interface{})) *resp3Conn {
+	return &resp3Conn{
+		Conn:   conn,
+		br:     bufio.NewReader(conn),
+		onPush: onPush,
+	}
+}
+
+// Read translated reply data.
+func (c *resp3Conn) Read(p []byte) (int, error) {
+	for c.pending.Len() == 0 {
+		if err := c.translateNext(); err != nil {
+			return 0, err
+		}
+	}
+	return c.pending.Read(p)
+}
+
+// Translate the next protocol line (and for string types its payload) and append
+// it to the pending buffer. Aggregate headers are translated on their own; their
+// elements are translated by subsequent calls.
+func (c *resp3Conn) translateNext() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if len(line) == 0 {
+		return errors.New("redis: empty RESP3 line")
+	}
+
+	switch line[0] {
+	case '+', '-', ':', '*':
+		c.writeLine(line)
+	case '$':
+		c.writeLine(line)
+		n, err := parseLen(line)
+		if err != nil || n < 0 {
+			return err
+		}
+		payload, err := c.readPayload(n)
+		if err != nil {
+			return err
+		}
+		c.pending.Write(payload)
+		c.pending.WriteString("\r\n")
+	case '%':
+		n, err := parseLen(line)
+		if err != nil {
+			return err
+		}
+		c.writeLine("*" + strconv.Itoa(2*n))
+	case '~':
+		c.writeLine("*" + line[1:])
+	case '>':
+		return c.translatePush(line)
+	case '_':
+		c.writeLine("$-1")
+	case '#':
+		switch line[1:] {
+		case "t":
+			c.writeLine(":1")
+		case "f":
+			c.writeLine(":0")
+		default:
+			return fmt.Errorf("redis: invalid RESP3 boolean %q", line)
+		}
+	case ',', '(':
+		c.writeBulk(line[1:])
+	case '=':
+		n, err := parseLen(line)
+		if err != nil {
+			return err
+		}
+		payload, err := c.readPayload(n)
+		if err != nil {
+			return err
+		}
+		// Verbatim strings carry a 3-character format prefix such as "txt:".
+		if len(payload) >= 4 && payload[3] == ':' {
+			payload = payload[4:]
+		}
+		c.writeBulk(string(payload))
+	case '!':
+		n, err := parseLen(line)
+		if err != nil {
+			return err
+		}
+		payload, err := c.readPayload(n)
+		if err != nil {
+			return err
+		}
+		c.writeLine("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(string(payload)))
+	case '|':
+		n, err := parseLen(line)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2*n; i++ {
+			if err = c.skipElement(); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("redis: unsupported RESP3 reply %q", line)
+	}
+	return nil
+}
+
+// Translate a push message whose header line has already been read. Pub/sub
+// pushes are translated to arrays; their remaining elements are translated by
+// subsequent calls. Any other push is read completely and passed to onPush
+// without adding anything to the pending buffer.
+func (c *resp3Conn) translatePush(header string) error {
+	n, err := parseLen(header)
+	if err != nil || n <= 0 {
+		return err
+	}
+	kindVal, err := c.readValue()
+	if err != nil {
+		return err
+	}
+	kind, _ := redisDriver.String(kindVal, nil)
+
+	if pubSubPushKinds[kind] {
+		c.writeLine("*" + strconv.Itoa(n))
+		c.writeBulk(kind)
+		return nil
+	}
+
+	push := make([]interface{}, 1, n)
+	push[0] = kind
+	for i := 1; i < n; i++ {
+		val, err := c.readValue()
+		if err != nil {
+			return err
+		}
+		push = append(push, val)
+	}
+	if c.onPush != nil {
+		c.onPush(push)
+	}
+	return nil
+}
+
+// Read a complete protocol element and convert it to the reply types used by the
+// driver: strings for simple strings, []byte for bulk strings, int64 for integers,
+// redis.Error for errors and []interface{} for aggregates.
+func (c *resp3Conn) readValue() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty RESP3 line")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisDriver.Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '_':
+		return nil, nil
+	case '#':
+		if line[1:] == "t" {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case ',', '(':
+		return []byte(line[1:]), nil
+	case '$', '=', '!':
+		n, err := parseLen(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		payload, err := c.readPayload(n)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case line[0] == '!':
+			return redisDriver.Error(payload), nil
+		case line[0] == '=' && len(payload) >= 4 && payload[3] == ':':
+			return payload[4:], nil
+		}
+		return payload, nil
+	case '*', '~', '>', '%':
+		n, err := parseLen(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if line[0] == '%' {
+			n *= 2
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = c.readValue(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	case '|':
+		n, err := parseLen(line)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < 2*n; i++ {
+			if err = c.skipElement(); err != nil {
+				return nil, err
+			}
+		}
+		return c.readValue()
+	}
+	return nil, fmt.Errorf("redis: unsupported RESP3 reply %q", line)
+}
+
+// Read and discard a complete protocol element including any nested elements.
+func (c *resp3Conn) skipElement() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if len(line) == 0 {
+		return errors.New("redis: empty RESP3 line")
+	}
+
+	var children int
+	switch line[0] {
+	case '$', '=', '!':
+		n, err := parseLen(line)
+		if err != nil || n < 0 {
+			return err
+		}
+		_, err = c.readPayload(n)
+		return err
+	case '*', '~', '>':
+		if children, err = parseLen(line); err != nil {
+			return err
+		}
+	case '%', '|':
+		if children, err = parseLen(line); err != nil {
+			return err
+		}
+		children *= 2
+	}
+
+	for i := 0; i < children; i++ {
+		if err = c.skipElement(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read a protocol line without its CRLF terminator.
+func (c *resp3Conn) readLine() (string, error) {
+	line, err := c.br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// Read a length-prefixed payload and its CRLF terminator.
+func (c *resp3Conn) readPayload(n int) ([]byte, error) {
+	payload := make([]byte, n+2)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return nil, err
+	}
+	return payload[:n], nil
+}
+
+func (c *resp3Conn) writeLine(line string) {
+	c.pending.WriteString(line)
+	c.pending.WriteString("\r\n")
+}
+
+func (c *resp3Conn) writeBulk(val string) {
+	c.writeLine("$" + strconv.Itoa(len(val)))
+	c.writeLine(val)
+}
+
+// Parse the length of a protocol line such as "*3" or "$-1".
+func parseLen(line string) (int, error) {
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return 0, fmt.Errorf("redis: invalid RESP3 length %q", line)
+	}
+	return n, nil
+}
@@ -0,0 +1,230 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// Start a fake redis server that replies to HELLO using helloReply and records
+// the commands it receives. RESP3 replies are sent for GET and HGETALL.
+func newFakeRESP3Server(t *testing.T, helloReply string) (string, func() [][]string) {
+	var mu sync.Mutex
+	var received [][]string
+
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			mu.Lock()
+			received = append(received, args)
+			mu.Unlock()
+
+			switch strings.ToUpper(args[0]) {
+			case "HELLO":
+				return helloReply
+			case "GET":
+				return "_\r\n"
+			case "HGETALL":
+				return "%2\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n:2\r\n"
+			case "AUTH":
+				return "+OK\r\n"
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	return endpoint, func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]string(nil), received...)
+	}
+}
+
+func TestRESP3Negotiation(t *testing.T) {
+	endpoint, received := newFakeRESP3Server(t, "%2\r\n$6\r\nserver\r\n$5\r\nredis\r\n$5\r\nproto\r\n:3\r\n")
+
+	srv := newTestAdapter(endpoint)
+	if err := srv.Config(map[string]string{"protocol": "3", "password": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	_, err := redisDriver.String(srv.DoContext(context.Background(), "GET", "missing"))
+	if err != redisDriver.ErrNil {
+		t.Fatalf("Expected GET to fail with ErrNil; got %v", err)
+	}
+
+	hash, err := redisDriver.IntMap(srv.DoContext(context.Background(), "HGETALL", "h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]int{"a": 1, "b": 2}; !reflect.DeepEqual(hash, exp) {
+		t.Fatalf("Expected HGETALL to return %v; got %v", exp, hash)
+	}
+
+	if proto := srv.Protocol(); proto != 3 {
+		t.Fatalf("Expected negotiated protocol to be 3; got %d", proto)
+	}
+
+	for _, args := range received() {
+		switch args[0] {
+		case "HELLO":
+			if exp := []string{"HELLO", "3", "AUTH", "default", "secret"}; !reflect.DeepEqual(args, exp) {
+				t.Fatalf("Expected HELLO args to be %v; got %v", exp, args)
+			}
+		case "AUTH":
+			t.Fatalf("Expected credentials to be sent with HELLO instead of AUTH")
+		}
+	}
+}
+
+func TestRESP3Fallback(t *testing.T) {
+	endpoint, received := newFakeRESP3Server(t, "-ERR unknown command 'HELLO'\r\n")
+
+	srv := newTestAdapter(endpoint)
+	if err := srv.Config(map[string]string{"protocol": "3", "password": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	if _, err := srv.DoContext(context.Background(), "PING"); err != nil {
+		t.Fatal(err)
+	}
+	if proto := srv.Protocol(); proto != 2 {
+		t.Fatalf("Expected negotiated protocol to be 2; got %d", proto)
+	}
+
+	var authenticated bool
+	for _, args := range received() {
+		if args[0] == "AUTH" && reflect.DeepEqual(args, []string{"AUTH", "secret"}) {
+			authenticated = true
+		}
+	}
+	if !authenticated {
+		t.Fatalf("Expected the connection to authenticate with AUTH; got %v", received())
+	}
+}
+
+func TestRESP3Rejected(t *testing.T) {
+	endpoint, _ := newFakeRESP3Server(t, "-WRONGPASS invalid username-password pair\r\n")
+
+	srv := newTestAdapter(endpoint)
+	if err := srv.Config(map[string]string{"protocol": "3", "password": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	if _, err := srv.DoContext(context.Background(), "PING"); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+		t.Fatalf("Expected WRONGPASS error; got %v", err)
+	}
+}
+
+func TestProtocolConfig(t *testing.T) {
+	srv := newTestAdapter("127.0.0.1:1")
+
+	for _, val := range []string{"1", "4", "resp3"} {
+		if err := srv.Config(map[string]string{"protocol": val}); err == nil {
+			t.Fatalf("Expected Config to reject protocol=%s", val)
+		}
+	}
+}
+
+func TestRESP3Translation(t *testing.T) {
+	specs := []struct {
+		in  string
+		exp string
+	}{
+		{"+OK\r\n", "+OK\r\n"},
+		{"$5\r\na\r\nbc\r\n", "$5\r\na\r\nbc\r\n"},
+		{"*2\r\n:1\r\n$-1\r\n", "*2\r\n:1\r\n$-1\r\n"},
+		{"%1\r\n+k\r\n_\r\n", "*2\r\n+k\r\n$-1\r\n"},
+		{"~2\r\n#t\r\n#f\r\n", "*2\r\n:1\r\n:0\r\n"},
+		{">2\r\n+message\r\n+hi\r\n", "*2\r\n$7\r\nmessage\r\n+hi\r\n"},
+		{">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n+OK\r\n", "+OK\r\n"},
+		{",3.14\r\n", "$4\r\n3.14\r\n"},
+		{"(12345678901234567890\r\n", "$20\r\n12345678901234567890\r\n"},
+		{"=9\r\ntxt:hello\r\n", "$5\r\nhello\r\n"},
+		{"!22\r\nSYNTAX invalid\r\nsyntax\r\n", "-SYNTAX invalid  syntax\r\n"},
+		{"|1\r\n+ttl\r\n*2\r\n:1\r\n:2\r\n+OK\r\n", "+OK\r\n"},
+	}
+
+	for index, spec := range specs {
+		c := &resp3Conn{br: bufio.NewReader(strings.NewReader(spec.in))}
+		out, _ := ioutil.ReadAll(c)
+		if string(out) != spec.exp {
+			t.Errorf("[spec %d] Expected %q to be translated to %q; got %q", index, spec.in, spec.exp, out)
+		}
+	}
+}
+
+func TestRESP3InterleavedPush(t *testing.T) {
+	// Invalidation pushes arrive ahead of the GET replies
+	endpoint := newFakeServerFunc(t, func() fakeHandler {
+		return func(args []string) string {
+			switch strings.ToUpper(args[0]) {
+			case "HELLO":
+				return "%1\r\n$5\r\nproto\r\n:3\r\n"
+			case "GET":
+				return ">2\r\n$10\r\ninvalidate\r\n*1\r\n" + bulk(args[1]) + bulk("value:"+args[1])
+			}
+			return "+PONG\r\n"
+		}
+	})
+
+	srv := newTestAdapter(endpoint)
+	if err := srv.Config(map[string]string{"protocol": "3"}); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var pushes [][]interface{}
+	srv.SetPushHandler(func(push []interface{}) {
+		mu.Lock()
+		pushes = append(pushes, push)
+		mu.Unlock()
+	})
+	if err := srv.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	defer conn.Close()
+
+	// Each command should receive its own reply rather than the preceding push
+	for _, key := range []string{"foo", "bar"} {
+		reply, err := redisDriver.String(conn.Do("GET", key))
+		if err != nil || reply != "value:"+key {
+			t.Fatalf("Expected GET %s to return value:%s; got %q, %v", key, key, reply, err)
+		}
+	}
+	if reply, err := redisDriver.String(conn.Do("PING")); err != nil || reply != "PONG" {
+		t.Fatalf("Expected PING to return PONG; got %q, %v", reply, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	exp := [][]interface{}{
+		{"invalidate", []interface{}{[]byte("foo")}},
+		{"invalidate", []interface{}{[]byte("bar")}},
+	}
+	if !reflect.DeepEqual(pushes, exp) {
+		t.Fatalf("Expected the pushes to be passed to the handler as %v; got %v", exp, pushes)
+	}
+}
@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters/dial"
+)
+
+// The error substrings treated as transient by DoRetry unless the retryableErrors
+// setting is specified. These only cover server-side replies that guarantee the
+// command was not executed: servers loading their dataset, replicas that lost their
+// master during a failover and cluster slots that are being migrated.
+const defaultRetryableErrors = "LOADING,MASTERDOWN,TRYAGAIN"
+
+// Run a command and retry it according to policy if it fails with an error that
+// contains one of the substrings listed in the retryableErrors setting. Each attempt
+// borrows a fresh connection via DoContext. Any other error (e.g. WRONGTYPE or a
+// syntax error) is returned immediately; once policy gives up, the error of the last
+// attempt is returned. If ctx is cancelled while waiting for the next attempt, the
+// command is aborted and ctx.Err() is returned. The policy is cloned so that it can be
+// shared by concurrent callers.
+//
+// Transport errors such as "connection reset", "broken pipe" or "EOF" are not
+// retried by default as the server may have executed the command before the
+// connection dropped. They can be opted into via the retryableErrors setting;
+// doing so gives at-least-once semantics, so it should only be enabled for
+// idempotent commands.
+func (s *Redis) DoRetry(ctx context.Context, policy dial.Policy, cmd string, args ...interface{}) (interface{}, error) {
+	s.Lock()
+	retryable := splitRetryableErrors(s.retryableErrors)
+	s.Unlock()
+
+	policy = dial.Clone(policy)
+	defer dial.Release(policy)
+	policy.ResetAttempts()
+	if _, err := dial.NextRetryContext(ctx, policy); err != nil && err == ctx.Err() {
+		return nil, err
+	}
+	for {
+		reply, err := s.DoContext(ctx, cmd, args...)
+		if err == nil || !isRetryable(err, retryable) {
+			return reply, err
+		}
+
+		sleepErr := dial.SleepNotify(ctx, policy, func(wait time.Duration) {
+			s.logger.Printf("%s Command %s failed: %v; retrying in %v\n", s.logPrefix(), cmd, err, wait)
+		})
+		switch {
+		case sleepErr == dial.ErrTimeout:
+			return nil, err
+		case sleepErr != nil:
+			return nil, sleepErr
+		}
+	}
+}
+
+// Split a comma-delimited list of retryable error substrings, skipping empty entries.
+func splitRetryableErrors(list string) []string {
+	var retryable []string
+	for _, substr := range strings.Split(list, ",") {
+		if substr = strings.TrimSpace(substr); substr != "" {
+			retryable = append(retryable, substr)
+		}
+	}
+	return retryable
+}
+
+// Check whether err contains any of the retryable substrings.
+func isRetryable(err error, retryable []string) bool {
+	msg := err.Error()
+	for _, substr := range retryable {
+		if strings.Contains(msg, substr) {
+			return true
+		}
+	}
+	return false
+}
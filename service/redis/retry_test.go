@@ -0,0 +1,137 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters/dial"
+)
+
+// A fake server that fails GET commands with a scripted list of error replies
+// before serving them. Other commands are answered with +PONG.
+type flakyServer struct {
+	sync.Mutex
+	errReplies []string
+	gets       int
+}
+
+func (srv *flakyServer) handler() fakeHandler {
+	return func(args []string) string {
+		srv.Lock()
+		defer srv.Unlock()
+
+		if strings.ToUpper(args[0]) != "GET" {
+			return "+PONG\r\n"
+		}
+
+		srv.gets++
+		if len(srv.errReplies) > 0 {
+			reply := srv.errReplies[0]
+			srv.errReplies = srv.errReplies[1:]
+			return reply
+		}
+		return "$3\r\nbar\r\n"
+	}
+}
+
+func (srv *flakyServer) getCount() int {
+	srv.Lock()
+	defer srv.Unlock()
+	return srv.gets
+}
+
+func newFlakyAdapter(t *testing.T, errReplies ...string) (*Redis, *flakyServer) {
+	flaky := &flakyServer{errReplies: errReplies}
+	srv := newTestAdapter(newFakeServerFunc(t, flaky.handler))
+	srv.retryableErrors = defaultRetryableErrors
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv, flaky
+}
+
+func TestDoRetryTransientError(t *testing.T) {
+	srv, flaky := newFlakyAdapter(t, "-LOADING Redis is loading the dataset in memory\r\n")
+
+	reply, err := srv.DoRetry(context.Background(), dial.Periodic(3, time.Millisecond), "GET", "foo")
+	if err != nil {
+		t.Fatalf("Expected DoRetry to succeed; got %v", err)
+	}
+	if val, _ := reply.([]byte); string(val) != "bar" {
+		t.Fatalf("Expected reply to be bar; got %v", reply)
+	}
+	if count := flaky.getCount(); count != 2 {
+		t.Fatalf("Expected GET to be sent 2 times; got %d", count)
+	}
+}
+
+func TestDoRetryNonRetryableError(t *testing.T) {
+	srv, flaky := newFlakyAdapter(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	if _, err := srv.DoRetry(context.Background(), dial.Periodic(3, time.Millisecond), "GET", "foo"); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Fatalf("Expected DoRetry to fail with WRONGTYPE; got %v", err)
+	}
+	if count := flaky.getCount(); count != 1 {
+		t.Fatalf("Expected GET to be sent once; got %d", count)
+	}
+}
+
+func TestDoRetryGivesUp(t *testing.T) {
+	masterDown := "-MASTERDOWN Link with MASTER is down and replica-serve-stale-data is set to 'no'.\r\n"
+	srv, flaky := newFlakyAdapter(t, masterDown, masterDown, masterDown)
+
+	_, err := srv.DoRetry(context.Background(), dial.Periodic(2, time.Millisecond), "GET", "foo")
+	if err == nil || !strings.HasPrefix(err.Error(), "MASTERDOWN") {
+		t.Fatalf("Expected DoRetry to fail with the last MASTERDOWN error; got %v", err)
+	}
+	if count := flaky.getCount(); count != 2 {
+		t.Fatalf("Expected GET to be sent 2 times; got %d", count)
+	}
+
+	// Errors no longer listed in retryableErrors are not retried
+	if err = srv.Config(map[string]string{"retryableErrors": "LOADING"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if _, err = srv.DoRetry(context.Background(), dial.Periodic(2, time.Millisecond), "GET", "foo"); err == nil {
+		t.Fatalf("Expected DoRetry to fail")
+	}
+	if count := flaky.getCount(); count != 3 {
+		t.Fatalf("Expected MASTERDOWN not to be retried; got %d GET commands", count)
+	}
+}
+
+func TestDoRetryTransportErrorsAreOptIn(t *testing.T) {
+	eof := "-ERR unexpected EOF\r\n"
+	srv, flaky := newFlakyAdapter(t, eof, eof)
+
+	if _, err := srv.DoRetry(context.Background(), dial.Periodic(3, time.Millisecond), "GET", "foo"); err == nil || !strings.Contains(err.Error(), "EOF") {
+		t.Fatalf("Expected DoRetry to fail with EOF; got %v", err)
+	}
+	if count := flaky.getCount(); count != 1 {
+		t.Fatalf("Expected EOF not to be retried by default; got %d GET commands", count)
+	}
+
+	if err := srv.Config(map[string]string{"retryableErrors": defaultRetryableErrors + ",EOF"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if _, err := srv.DoRetry(context.Background(), dial.Periodic(3, time.Millisecond), "GET", "foo"); err != nil {
+		t.Fatalf("Expected DoRetry to succeed; got %v", err)
+	}
+	if count := flaky.getCount(); count != 3 {
+		t.Fatalf("Expected GET to be sent 3 times; got %d", count)
+	}
+}
+
+func TestDoRetryCancel(t *testing.T) {
+	srv, _ := newFlakyAdapter(t, "-LOADING Redis is loading the dataset in memory\r\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := srv.DoRetry(ctx, dial.Periodic(10, time.Second), "GET", "foo"); err != context.Canceled {
+		t.Fatalf("Expected DoRetry to fail with context.Canceled; got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package redis
+
+import (
+	"strings"
+	"sync"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// A Lua script that is executed via EVALSHA. The script SHA is obtained via
+// SCRIPT LOAD on first use and cached for subsequent invocations.
+type Script struct {
+
+	// A mutex protecting the cached SHA.
+	sync.Mutex
+
+	// The service used by Do for borrowing connections.
+	service *Redis
+
+	// The script source.
+	src string
+
+	// The SHA returned by SCRIPT LOAD; empty until the script is first loaded.
+	sha string
+}
+
+// Create a new Lua script. Scripts are safe for concurrent use.
+func (s *Redis) NewScript(src string) *Script {
+	return &Script{
+		service: s,
+		src:     src,
+	}
+}
+
+// Run the script using conn. If the server has not cached the script (e.g. after a
+// restart or a SCRIPT FLUSH), Run falls back to EVAL which also caches it.
+func (sc *Script) Run(conn RedisConn, keys []string, args ...interface{}) (interface{}, error) {
+	sha, err := sc.load(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.Do("EVALSHA", sc.evalArgs(sha, keys, args)...)
+	if replyErr, ok := err.(redisDriver.Error); ok && strings.HasPrefix(string(replyErr), "NOSCRIPT ") {
+		reply, err = conn.Do("EVAL", sc.evalArgs(sc.src, keys, args)...)
+	}
+
+	return reply, err
+}
+
+// Run the script using a connection borrowed from the service pool.
+func (sc *Script) Do(keys []string, args ...interface{}) (interface{}, error) {
+	conn, err := sc.service.GetConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return sc.Run(conn, keys, args...)
+}
+
+// Get the cached script SHA, loading the script via conn if required.
+func (sc *Script) load(conn RedisConn) (string, error) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if sc.sha == "" {
+		sha, err := redisDriver.String(conn.Do("SCRIPT", "LOAD", sc.src))
+		if err != nil {
+			return "", err
+		}
+		sc.sha = sha
+	}
+
+	return sc.sha, nil
+}
+
+// Build the argument list for EVAL or EVALSHA.
+func (sc *Script) evalArgs(script string, keys []string, args []interface{}) []interface{} {
+	evalArgs := make([]interface{}, 0, 2+len(keys)+len(args))
+	evalArgs = append(evalArgs, script, len(keys))
+	for _, key := range keys {
+		evalArgs = append(evalArgs, key)
+	}
+	return append(evalArgs, args...)
+}
@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// A fake server-side script cache.
+type fakeScriptCache struct {
+	sync.Mutex
+	scripts  map[string]string
+	commands map[string]int
+}
+
+func (c *fakeScriptCache) handler() fakeHandler {
+	return func(args []string) string {
+		c.Lock()
+		defer c.Unlock()
+
+		cmd := strings.ToUpper(args[0])
+		if cmd == "SCRIPT" {
+			cmd += " " + strings.ToUpper(args[1])
+		}
+		c.commands[cmd]++
+
+		// Scripts reply with their source followed by their keys and args
+		switch cmd {
+		case "SCRIPT LOAD":
+			sum := sha1.Sum([]byte(args[2]))
+			sha := hex.EncodeToString(sum[:])
+			c.scripts[sha] = args[2]
+			return fmt.Sprintf("$%d\r\n%s\r\n", len(sha), sha)
+		case "EVALSHA":
+			src, exists := c.scripts[args[1]]
+			if !exists {
+				return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+			}
+			return fakeScriptReply(src, args[2:])
+		case "EVAL":
+			sum := sha1.Sum([]byte(args[1]))
+			c.scripts[hex.EncodeToString(sum[:])] = args[1]
+			return fakeScriptReply(args[1], args[2:])
+		}
+		return "+PONG\r\n"
+	}
+}
+
+func (c *fakeScriptCache) flush() {
+	c.Lock()
+	defer c.Unlock()
+	c.scripts = make(map[string]string)
+}
+
+func (c *fakeScriptCache) count(cmd string) int {
+	c.Lock()
+	defer c.Unlock()
+	return c.commands[cmd]
+}
+
+func fakeScriptReply(src string, args []string) string {
+	reply := src + " " + strings.Join(args, " ")
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(reply), reply)
+}
+
+func TestScript(t *testing.T) {
+	cache := &fakeScriptCache{
+		scripts:  make(map[string]string),
+		commands: make(map[string]int),
+	}
+	endpoint := newFakeServerFunc(t, cache.handler)
+
+	srv := newTestAdapter(endpoint)
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	script := srv.NewScript("return redis.call('GET', KEYS[1])")
+	expReply := "return redis.call('GET', KEYS[1]) 1 foo bar"
+	run := func(attempt string) {
+		reply, err := redisDriver.String(script.Do([]string{"foo"}, "bar"))
+		if err != nil {
+			t.Fatalf("[%s] Expected script to run; got %v", attempt, err)
+		}
+		if reply != expReply {
+			t.Fatalf("[%s] Expected reply %q; got %q", attempt, expReply, reply)
+		}
+	}
+
+	// The first run should load the script and subsequent runs should use the cached SHA
+	run("first run")
+	run("cached run")
+	if got := cache.count("SCRIPT LOAD"); got != 1 {
+		t.Fatalf("Expected the script to be loaded once; got %d", got)
+	}
+	if got := cache.count("EVALSHA"); got != 2 {
+		t.Fatalf("Expected 2 EVALSHA calls; got %d", got)
+	}
+
+	// If the server no longer has the script, fall back to EVAL
+	cache.flush()
+	run("NOSCRIPT fallback")
+	if got := cache.count("EVAL"); got != 1 {
+		t.Fatalf("Expected an EVAL fallback; got %d EVAL call(s)", got)
+	}
+
+	// EVAL caches the script so the next run should hit the cache
+	run("cache hit after fallback")
+	if got := cache.count("EVAL"); got != 1 {
+		t.Fatalf("Expected no further EVAL calls; got %d", got)
+	}
+	if got := cache.count("EVALSHA"); got != 4 {
+		t.Fatalf("Expected 4 EVALSHA calls; got %d", got)
+	}
+	if got := cache.count("SCRIPT LOAD"); got != 1 {
+		t.Fatalf("Expected the cached SHA to be reused; got %d SCRIPT LOAD call(s)", got)
+	}
+}
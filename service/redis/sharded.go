@@ -0,0 +1,286 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// A message received on a channel subscribed via SSubscribe.
+type Message struct {
+	// The channel the message was published to.
+	Channel string
+
+	// The message payload.
+	Data []byte
+}
+
+// Subscribe to channels using sharded pub/sub (SSUBSCRIBE) and stream the received
+// messages until ctx is cancelled. In cluster mode, the channels are grouped by the
+// node serving their hash slot and each node is subscribed to over a dedicated
+// connection. Messages published via SPUBLISH are only delivered by the node that
+// owns the slot of their channel.
+//
+// The subscriptions are re-established whenever a connection is lost, the cluster
+// topology changes (e.g. a slot is migrated) or the service is reset, so messages
+// published while re-subscribing may be missed. The returned channel is closed when
+// ctx is cancelled.
+func (s *Redis) SSubscribe(ctx context.Context, channels ...string) (<-chan Message, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("redis: SSubscribe requires at least one channel")
+	}
+
+	reset := make(adapters.CloseListener, 1)
+	s.NotifyClose(reset)
+
+	conns, err := s.subscribeShards(channels, false)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(chan Message)
+	go func() {
+		defer close(messages)
+
+		for {
+			if wasReset := streamShards(ctx, conns, reset, messages); wasReset {
+				reset = make(adapters.CloseListener, 1)
+				s.NotifyClose(reset)
+			}
+
+			// Re-subscribe using the current topology once the service is reachable again
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(watchRetryInterval):
+				}
+
+				if conns, err = s.subscribeShards(channels, true); err == nil {
+					break
+				}
+				s.logger.Printf("%s Could not re-subscribe to shard channels: %v\n", s.logPrefix(), err)
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
+// Dial a connection to each node serving any of the channels and subscribe to the
+// channels it serves. If refresh is set, the cluster topology is refreshed before
+// the channels are assigned to nodes; otherwise, it is only refreshed if the owner
+// of any channel is unknown.
+func (s *Redis) subscribeShards(channels []string, refresh bool) ([]redisDriver.Conn, error) {
+	s.Lock()
+	connected, cluster := s.connected && !s.draining, s.cluster
+	s.Unlock()
+	if !connected {
+		return nil, adapters.ErrConnectionClosed
+	}
+
+	// Group the channels by node and slot
+	nodes := map[string]map[int][]string{"": groupBySlot(channels)}
+	dialFn := func(string) (redisDriver.Conn, error) { return s.dialPoolConnection() }
+	if cluster != nil {
+		var err error
+		if nodes, err = cluster.shardNodes(channels, refresh); err != nil {
+			return nil, err
+		}
+		dialFn = cluster.dialNode
+	}
+
+	conns := make([]redisDriver.Conn, 0, len(nodes))
+	for addr, slots := range nodes {
+		conn, err := dialFn(addr)
+		if err == nil {
+			err = shardSubscribe(conn, slots)
+			if err != nil {
+				conn.Close()
+			}
+		}
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+
+	return conns, nil
+}
+
+// Subscribe to the supplied channels grouped by slot and wait for the server to
+// confirm each subscription. A separate SSUBSCRIBE is sent per slot as redis
+// rejects commands whose channels map to different slots.
+func shardSubscribe(conn redisDriver.Conn, slots map[int][]string) error {
+	pending := 0
+	for _, channels := range slots {
+		args := make([]interface{}, len(channels))
+		for index, channel := range channels {
+			args[index] = channel
+		}
+		if err := conn.Send("SSUBSCRIBE", args...); err != nil {
+			return err
+		}
+		pending += len(channels)
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	for pending > 0 {
+		kind, _, _, err := receiveShardReply(conn)
+		switch {
+		case err != nil:
+			return err
+		case kind == "smessage":
+			// Messages for already confirmed channels may arrive before the remaining
+			// confirmations; they are dropped like messages published while subscribing
+		case kind != "ssubscribe":
+			return fmt.Errorf("redis: unexpected reply to SSUBSCRIBE: %s", kind)
+		default:
+			pending--
+		}
+	}
+	return nil
+}
+
+// Forward the messages received by conns to messages until ctx is cancelled, any
+// of the connections fails or is unsubscribed by the server or reset fires. All
+// connections are closed before returning. Returns true if the subscriptions were
+// terminated by reset.
+func streamShards(ctx context.Context, conns []redisDriver.Conn, reset adapters.CloseListener, messages chan<- Message) bool {
+	// Closing the connections makes the blocked Receive calls return
+	var closeOnce sync.Once
+	closeAll := func() {
+		closeOnce.Do(func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		})
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn redisDriver.Conn) {
+			defer wg.Done()
+			defer closeAll()
+
+			for {
+				kind, channel, data, err := receiveShardReply(conn)
+				switch {
+				case err != nil:
+					return
+				case kind == "sunsubscribe":
+					// The slot of the channel was migrated to another node
+					return
+				case kind == "smessage":
+					select {
+					case messages <- Message{Channel: channel, Data: data}:
+					case <-done:
+						return
+					}
+				}
+			}
+		}(conn)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	wasReset := false
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	case <-reset:
+		wasReset = true
+	}
+
+	close(done)
+	closeAll()
+	<-stopped
+	return wasReset
+}
+
+// Receive a sharded pub/sub reply and split it into its kind (ssubscribe, smessage
+// or sunsubscribe), channel and payload. The driver's PubSubConn does not recognize
+// sharded pub/sub replies so they are parsed here. Error replies (e.g. MOVED) are
+// returned as errors.
+func receiveShardReply(conn redisDriver.Conn) (kind, channel string, data []byte, err error) {
+	values, err := redisDriver.Values(conn.Receive())
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(values) < 3 {
+		return "", "", nil, fmt.Errorf("redis: unexpected sharded pub/sub reply: %v", values)
+	}
+
+	kindBytes, _ := values[0].([]byte)
+	channelBytes, _ := values[1].([]byte)
+	kind, channel = string(kindBytes), string(channelBytes)
+	if kind == "smessage" {
+		data, _ = values[2].([]byte)
+	}
+	return kind, channel, data, nil
+}
+
+// Group channels by their hash slot.
+func groupBySlot(channels []string) map[int][]string {
+	slots := make(map[int][]string)
+	for _, channel := range channels {
+		slot := keySlot(channel)
+		slots[slot] = append(slots[slot], channel)
+	}
+	return slots
+}
+
+// Group channels by the address of the node serving their slot and then by slot.
+// If refresh is set or the owner of any slot is unknown, the topology is refreshed
+// first. Channels whose owner is still unknown are assigned to a seed node.
+func (c *cluster) shardNodes(channels []string, refresh bool) (map[string]map[int][]string, error) {
+	slots := groupBySlot(channels)
+
+	if !refresh {
+		c.Lock()
+		for slot := range slots {
+			if c.slots[slot] == "" {
+				refresh = true
+				break
+			}
+		}
+		c.Unlock()
+	}
+	if refresh {
+		if err := c.refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	nodes := make(map[string]map[int][]string)
+	for slot, slotChannels := range slots {
+		addr := c.slots[slot]
+		if addr == "" {
+			addr = c.seeds[0]
+		}
+		if nodes[addr] == nil {
+			nodes[addr] = make(map[int][]string)
+		}
+		nodes[addr][slot] = slotChannels
+	}
+	return nodes, nil
+}
@@ -0,0 +1,231 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake redis node that supports sharded pub/sub and replies to CLUSTER SLOTS
+// using a topology shared by all nodes of the fake cluster.
+type fakeShardNode struct {
+	sync.Mutex
+
+	// The connection subscribed to each channel.
+	subs map[string]net.Conn
+
+	topology func() string
+}
+
+func newFakeShardNode(t *testing.T, topology func() string) (*fakeShardNode, string) {
+	node := &fakeShardNode{subs: make(map[string]net.Conn), topology: topology}
+	endpoint := serveFake(t, "tcp", "127.0.0.1:0", node.handler)
+	return node, endpoint
+}
+
+func (node *fakeShardNode) handler(conn net.Conn) fakeHandler {
+	return func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "CLUSTER":
+			return node.topology()
+		case "SSUBSCRIBE":
+			node.Lock()
+			defer node.Unlock()
+
+			var reply string
+			for index, channel := range args[1:] {
+				node.subs[channel] = conn
+				reply += fmt.Sprintf("*3\r\n%s%s:%d\r\n", bulk("ssubscribe"), bulk(channel), index+1)
+			}
+			return reply
+		}
+		return "+PONG\r\n"
+	}
+}
+
+// Publish a message to the connection subscribed to channel.
+func (node *fakeShardNode) publish(channel, data string) {
+	node.Lock()
+	defer node.Unlock()
+
+	if conn := node.subs[channel]; conn != nil {
+		fmt.Fprintf(conn, "*3\r\n%s%s%s", bulk("smessage"), bulk(channel), bulk(data))
+	}
+}
+
+// Unsubscribe the connection subscribed to channel as redis does when the slot
+// of the channel is migrated to another node.
+func (node *fakeShardNode) unsubscribe(channel string) {
+	node.Lock()
+	defer node.Unlock()
+
+	if conn := node.subs[channel]; conn != nil {
+		fmt.Fprintf(conn, "*3\r\n%s%s:0\r\n", bulk("sunsubscribe"), bulk(channel))
+		delete(node.subs, channel)
+	}
+}
+
+func (node *fakeShardNode) subscriber(channel string) net.Conn {
+	node.Lock()
+	defer node.Unlock()
+
+	return node.subs[channel]
+}
+
+// Build a CLUSTER SLOTS reply that assigns consecutive slot ranges to each address.
+func clusterRangesReply(addrs ...string) string {
+	reply := fmt.Sprintf("*%d\r\n", len(addrs))
+	rangeSize := clusterSlots / len(addrs)
+	for index, addr := range addrs {
+		host, portVal, _ := net.SplitHostPort(addr)
+		reply += fmt.Sprintf("*3\r\n:%d\r\n:%d\r\n*2\r\n%s:%s\r\n", index*rangeSize, (index+1)*rangeSize-1, bulk(host), portVal)
+	}
+	return reply
+}
+
+// Find a channel name whose slot lies in [minSlot, maxSlot].
+func channelInSlots(minSlot, maxSlot int) string {
+	for index := 0; ; index++ {
+		channel := fmt.Sprintf("channel:%d", index)
+		if slot := keySlot(channel); slot >= minSlot && slot <= maxSlot {
+			return channel
+		}
+	}
+}
+
+// Wait for one message per channel with the expected payload.
+func expectMessages(t *testing.T, messages <-chan Message, exp map[string]string) {
+	for len(exp) != 0 {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				t.Fatalf("Expected messages %v; channel was closed", exp)
+			}
+			if data, pending := exp[msg.Channel]; !pending || data != string(msg.Data) {
+				t.Fatalf("Received unexpected message %s: %s", msg.Channel, msg.Data)
+			}
+			delete(exp, msg.Channel)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for messages %v", exp)
+		}
+	}
+}
+
+// Wait for the message channel to be closed.
+func expectClosed(t *testing.T, messages <-chan Message) {
+	select {
+	case msg, ok := <-messages:
+		if ok {
+			t.Fatalf("Expected no further messages after cancelling the context; got %s: %s", msg.Channel, msg.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the message channel to be closed after cancelling the context")
+	}
+}
+
+func TestSSubscribeCluster(t *testing.T) {
+	defer func(interval time.Duration) { watchRetryInterval = interval }(watchRetryInterval)
+	watchRetryInterval = time.Millisecond
+
+	var mu sync.Mutex
+	var slotsReply string
+	topology := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slotsReply
+	}
+	nodeA, addrA := newFakeShardNode(t, topology)
+	nodeB, addrB := newFakeShardNode(t, topology)
+	mu.Lock()
+	slotsReply = clusterRangesReply(addrA, addrB)
+	mu.Unlock()
+
+	srv := newTestAdapter(addrA)
+	if err := srv.Config(map[string]string{"cluster": "true"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	chanA := channelInSlots(0, clusterSlots/2-1)
+	chanB := channelInSlots(clusterSlots/2, clusterSlots-1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages, err := srv.SSubscribe(ctx, chanA, chanB)
+	if err != nil {
+		t.Fatalf("Expected SSubscribe to succeed; got %v", err)
+	}
+
+	// Each channel should be subscribed on the node serving its slot
+	if nodeA.subscriber(chanA) == nil || nodeB.subscriber(chanB) == nil {
+		t.Fatalf("Expected each channel to be subscribed on the node serving its slot")
+	}
+	if nodeA.subscriber(chanB) != nil || nodeB.subscriber(chanA) != nil {
+		t.Fatalf("Expected channels not to be subscribed on nodes that do not serve their slot")
+	}
+
+	nodeA.publish(chanA, "a1")
+	nodeB.publish(chanB, "b1")
+	expectMessages(t, messages, map[string]string{chanA: "a1", chanB: "b1"})
+
+	// Migrate all slots to node A; node B drops its subscription
+	oldConn := nodeA.subscriber(chanA)
+	mu.Lock()
+	slotsReply = clusterRangesReply(addrA)
+	mu.Unlock()
+	nodeB.unsubscribe(chanB)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn := nodeA.subscriber(chanB)
+		if conn != nil && conn == nodeA.subscriber(chanA) && conn != oldConn {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the channels to be re-subscribed on the new owner")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	nodeA.publish(chanA, "a2")
+	nodeA.publish(chanB, "b2")
+	expectMessages(t, messages, map[string]string{chanA: "a2", chanB: "b2"})
+
+	cancel()
+	expectClosed(t, messages)
+}
+
+func TestSSubscribeStandalone(t *testing.T) {
+	node, endpoint := newFakeShardNode(t, func() string { return "-ERR cluster support disabled\r\n" })
+
+	srv := newTestAdapter(endpoint)
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	if _, err := srv.SSubscribe(context.Background()); err == nil {
+		t.Fatalf("Expected SSubscribe to fail when no channels are specified")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages, err := srv.SSubscribe(ctx, "news", "sports")
+	if err != nil {
+		t.Fatalf("Expected SSubscribe to succeed; got %v", err)
+	}
+
+	node.publish("news", "hello")
+	node.publish("sports", "goal")
+	expectMessages(t, messages, map[string]string{"news": "hello", "sports": "goal"})
+
+	cancel()
+	expectClosed(t, messages)
+}
@@ -0,0 +1,35 @@
+package redis
+
+import (
+	"context"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// Run a MULTI/EXEC transaction over a pooled connection. After MULTI is sent, fn
+// is invoked to queue the transaction commands using conn (via Send or Do) and the
+// transaction is then executed with EXEC. The returned slice contains one reply per
+// queued command; error replies (redis.Error) are stored in the slot of the command
+// that caused them. If fn returns an error, the transaction is discarded with
+// DISCARD and the error of fn is returned. fn must not close conn or run EXEC or
+// DISCARD itself.
+func (s *Redis) Transaction(ctx context.Context, fn func(conn RedisConn) error) ([]interface{}, error) {
+	conn, err := s.GetConnectionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Do("MULTI"); err != nil {
+		return nil, err
+	}
+
+	if err = fn(conn); err != nil {
+		// Leave the connection outside the transaction before returning it to the pool.
+		// If DISCARD fails, the connection is broken and the pool will not reuse it.
+		conn.Do("DISCARD")
+		return nil, err
+	}
+
+	return redisDriver.Values(conn.Do("EXEC"))
+}
@@ -0,0 +1,147 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	redisDriver "github.com/garyburd/redigo/redis"
+)
+
+// A fake server that tracks MULTI state per connection and records the
+// commands it receives prefixed with the id of the connection that sent them.
+type fakeTxServer struct {
+	sync.Mutex
+	conns    int
+	commands []string
+}
+
+func (f *fakeTxServer) handler() fakeHandler {
+	f.Lock()
+	f.conns++
+	id := f.conns
+	f.Unlock()
+
+	var queued []string
+	inMulti := false
+	return func(args []string) string {
+		cmd := strings.ToUpper(args[0])
+		f.Lock()
+		f.commands = append(f.commands, fmt.Sprintf("%d:%s", id, cmd))
+		f.Unlock()
+
+		switch {
+		case cmd == "MULTI" && inMulti:
+			return "-ERR MULTI calls can not be nested\r\n"
+		case cmd == "MULTI":
+			inMulti = true
+			return "+OK\r\n"
+		case cmd == "DISCARD":
+			inMulti, queued = false, nil
+			return "+OK\r\n"
+		case cmd == "EXEC":
+			reply := fmt.Sprintf("*%d\r\n", len(queued))
+			for _, q := range queued {
+				reply += q
+			}
+			inMulti, queued = false, nil
+			return reply
+		case inMulti && cmd == "INCR":
+			queued = append(queued, ":1\r\n")
+			return "+QUEUED\r\n"
+		case inMulti && cmd == "LPUSH":
+			queued = append(queued, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+			return "+QUEUED\r\n"
+		case inMulti:
+			queued = append(queued, "+OK\r\n")
+			return "+QUEUED\r\n"
+		}
+		return "+PONG\r\n"
+	}
+}
+
+func (f *fakeTxServer) recorded() []string {
+	f.Lock()
+	defer f.Unlock()
+
+	return append([]string(nil), f.commands...)
+}
+
+func TestTransaction(t *testing.T) {
+	server := &fakeTxServer{}
+	srv := newTestAdapter(newFakeServerFunc(t, server.handler))
+	if err := srv.Config(map[string]string{"testOnBorrow": "never"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	replies, err := srv.Transaction(context.Background(), func(conn RedisConn) error {
+		conn.Send("SET", "foo", "bar")
+		conn.Send("INCR", "counter")
+		_, err := conn.Do("LPUSH", "foo", "baz")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Expected Transaction to succeed; got %v", err)
+	}
+
+	expReplies := []interface{}{
+		"OK",
+		int64(1),
+		redisDriver.Error("WRONGTYPE Operation against a key holding the wrong kind of value"),
+	}
+	if !reflect.DeepEqual(replies, expReplies) {
+		t.Fatalf("Expected replies %v; got %v", expReplies, replies)
+	}
+
+	expCommands := []string{"1:MULTI", "1:SET", "1:INCR", "1:LPUSH", "1:EXEC"}
+	if commands := server.recorded(); !reflect.DeepEqual(commands, expCommands) {
+		t.Fatalf("Expected commands %v; got %v", expCommands, commands)
+	}
+}
+
+func TestTransactionDiscardsOnError(t *testing.T) {
+	server := &fakeTxServer{}
+	srv := newTestAdapter(newFakeServerFunc(t, server.handler))
+	if err := srv.Config(map[string]string{"testOnBorrow": "never"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	fnErr := errors.New("validation failed")
+	replies, err := srv.Transaction(context.Background(), func(conn RedisConn) error {
+		conn.Send("SET", "foo", "bar")
+		return fnErr
+	})
+	if err != fnErr {
+		t.Fatalf("Expected Transaction to return the error of fn; got %v", err)
+	}
+	if replies != nil {
+		t.Fatalf("Expected no replies; got %v", replies)
+	}
+
+	// The connection is returned to the pool outside the transaction and can be reused
+	conn, err := srv.GetConnection()
+	if err != nil {
+		t.Fatalf("Expected GetConnection to succeed; got %v", err)
+	}
+	if reply, err := redisDriver.String(conn.Do("PING")); err != nil || reply != "PONG" {
+		t.Fatalf("Expected the reused connection not to be in a transaction; got %v, %v", reply, err)
+	}
+	conn.Close()
+
+	expCommands := []string{"1:MULTI", "1:SET", "1:DISCARD", "1:PING"}
+	if commands := server.recorded(); !reflect.DeepEqual(commands, expCommands) {
+		t.Fatalf("Expected commands %v; got %v", expCommands, commands)
+	}
+}
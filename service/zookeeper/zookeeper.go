@@ -0,0 +1,588 @@
+package zookeeper
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	zkDriver "github.com/go-zookeeper/zk"
+)
+
+// The service name reported to the metrics sink.
+const serviceName = "zookeeper"
+
+// The time to wait before retrying a failed znode watch.
+var watchRetryInterval = time.Second
+
+var (
+	// The error reported when a session cannot be established within the connection timeout.
+	errNoSession = errors.New("Could not establish a session with any host in the ensemble")
+
+	// The error reported when the ensemble rejects the session credentials.
+	errAuthFailed = errors.New("Authentication with the ensemble failed")
+)
+
+// Adapter is a singleton instance of a zookeeper service
+var Adapter *Zookeeper = &Zookeeper{
+	hosts:             []string{"127.0.0.1:2181"},
+	sessionTimeout:    10 * time.Second,
+	connectionTimeout: time.Second * 1,
+	logger:            log.New(ioutil.Discard, "", log.LstdFlags),
+	dialPolicy:        dial.ExpBackoff(10, time.Millisecond),
+	metrics:           adapters.NopMetrics,
+	tracer:            adapters.NopTracer,
+	closeNotifier:     adapters.NewNotifier(),
+}
+
+// The subset of the zookeeper client API used by the adapter.
+type zkClient interface {
+	GetW(path string) ([]byte, *zkDriver.Stat, <-chan zkDriver.Event, error)
+	ExistsW(path string) (bool, *zkDriver.Stat, <-chan zkDriver.Event, error)
+	Close()
+}
+
+// Create a client for the ensemble hosts. The client establishes its session in
+// the background and reports its progress via the returned session event channel.
+var connect = func(hosts []string, sessionTimeout time.Duration, logger *log.Logger) (zkClient, <-chan zkDriver.Event, error) {
+	conn, events, err := zkDriver.Connect(hosts, sessionTimeout, zkDriver.WithLogger(logger))
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, events, nil
+}
+
+type Zookeeper struct {
+	// The zookeeper ensemble hosts to connect to
+	hosts []string
+
+	// The session timeout requested from the ensemble.
+	sessionTimeout time.Duration
+
+	// The max time to wait for a session to be established.
+	connectionTimeout time.Duration
+
+	// The zookeeper client instance; nil while disconnected.
+	client zkClient
+
+	// A logger for service events.
+	logger *log.Logger
+
+	// An optional logger for tagging log lines with the fields of a context.
+	contextLogger adapters.ContextLogger
+
+	// A notifier for close events.
+	closeNotifier *adapters.Notifier
+
+	// Connection status.
+	connected bool
+
+	// The error that caused the most recent dial to fail; nil if it succeeded.
+	lastErr error
+
+	// Set by Config when its most recent invocation modified any setting.
+	configChanged bool
+
+	// The dial policy to use.
+	dialPolicy dial.Policy
+
+	// A sink for dial and connection metrics.
+	metrics adapters.Metrics
+
+	// A tracer for creating spans around Dial and Config.
+	tracer adapters.Tracer
+
+	// An optional callback invoked when a dial gives up; see OnDialFailure.
+	onDialFailure func(attempts uint32, lastErr error)
+
+	// A mutex protecting the client
+	sync.Mutex
+}
+
+// Connect to the service. If a dial policy has been specified,
+// the service will keep trying to reconnect until a connection
+// is established or the dial policy aborts the reconnection attempt.
+func (s *Zookeeper) Dial() error {
+	return s.DialContext(context.Background())
+}
+
+// Connect to the service using the supplied context. If ctx is cancelled while
+// waiting for a session or the next dial attempt, the dial is aborted and ctx.Err()
+// is returned.
+func (s *Zookeeper) DialContext(ctx context.Context) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// We are already connected
+	if s.connected {
+		return adapters.ErrAlreadyConnected
+	}
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Dial")
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+	span.SetAttribute("endpoint", strings.Join(s.hosts, ","))
+	attempts := 0
+	defer func() {
+		span.SetAttribute("attempts", attempts)
+		span.End(err)
+		s.lastErr = err
+	}()
+
+	if len(s.hosts) == 0 {
+		return errors.New("No zookeeper hosts defined")
+	}
+
+	var (
+		client zkClient
+		events <-chan zkDriver.Event
+	)
+	defer dial.Release(s.dialPolicy)
+	s.dialPolicy.ResetAttempts()
+	if _, err = dial.NextRetryContext(ctx, s.dialPolicy); err != nil && err == ctx.Err() {
+		logger.Printf("[ZOOKEEPER] Dial cancelled: %v\n", err)
+		return err
+	}
+	logger.Printf("[ZOOKEEPER] Connecting to ensemble hosts: %s\n", s.hosts)
+	start := time.Now()
+	for {
+		s.metrics.IncDialAttempt(serviceName)
+		attempts++
+		client, events, err = s.newSession(ctx)
+		dial.RecordOutcome(s.dialPolicy, err == nil)
+		if err == nil {
+			break
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			logger.Printf("[ZOOKEEPER] Dial cancelled: %v\n", ctxErr)
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			return ctxErr
+		}
+
+		s.metrics.IncDialFailure(serviceName, err)
+		dialErr := err
+		err = dial.SleepNotify(ctx, s.dialPolicy, func(wait time.Duration) {
+			logger.Printf("[ZOOKEEPER] Could not establish a session (%v); retrying in %v\n", dialErr, wait)
+		})
+		switch {
+		case err == dial.ErrTimeout:
+			logger.Printf("[ZOOKEEPER] Could not establish a session after %d attempt(s)\n", s.dialPolicy.CurAttempt())
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			if s.onDialFailure != nil {
+				go s.onDialFailure(uint32(attempts), dialErr)
+			}
+			return dial.Exhausted(attempts, dialErr)
+		case err != nil:
+			logger.Printf("[ZOOKEEPER] Dial cancelled: %v\n", err)
+			s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+			return err
+		}
+	}
+
+	s.client = client
+	s.connected = true
+	s.metrics.IncDialSuccess(serviceName)
+	s.metrics.ObserveDialDuration(serviceName, time.Since(start))
+	s.metrics.SetConnected(serviceName, true)
+	s.dialPolicy.ResetAttempts()
+	logger.Printf("[ZOOKEEPER] Connected to ensemble\n")
+
+	// Start watchdog
+	go s.watchdog(client, events)
+
+	return nil
+}
+
+// Create a client and wait for it to establish a session. This method is not
+// thread-safe so it should be invoked while holding the service lock.
+func (s *Zookeeper) newSession(ctx context.Context) (zkClient, <-chan zkDriver.Event, error) {
+	client, events, err := connect(s.hosts, s.sessionTimeout, s.logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timeout := time.NewTimer(s.connectionTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case evt, ok := <-events:
+			if !ok {
+				return nil, nil, adapters.ErrConnectionClosed
+			}
+			switch evt.State {
+			case zkDriver.StateHasSession:
+				return client, events, nil
+			case zkDriver.StateAuthFailed:
+				client.Close()
+				return nil, nil, errAuthFailed
+			}
+		case <-timeout.C:
+			client.Close()
+			return nil, nil, errNoSession
+		case <-ctx.Done():
+			client.Close()
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// A worker that monitors the session events of client. The client transparently
+// reconnects to another ensemble host when it loses its connection; if the session
+// expires, the service is reset so that consumers can re-dial and re-create any
+// ephemeral state. The worker exits when client is closed.
+func (s *Zookeeper) watchdog(client zkClient, events <-chan zkDriver.Event) {
+	for evt := range events {
+		if evt.Type != zkDriver.EventSession {
+			continue
+		}
+
+		switch evt.State {
+		case zkDriver.StateDisconnected:
+			s.logger.Printf("[ZOOKEEPER] Lost connection to %s; reconnecting\n", evt.Server)
+		case zkDriver.StateHasSession:
+			s.logger.Printf("[ZOOKEEPER] Reconnected to %s\n", evt.Server)
+		case zkDriver.StateExpired:
+			s.Lock()
+
+			// The client was closed or replaced by the service in the meantime
+			if s.connected && s.client == client {
+				s.disconnect()
+				s.closeNotifier.NotifyAll(nil)
+				s.logger.Printf("[ZOOKEEPER] Session expired\n")
+			}
+			s.Unlock()
+			return
+		}
+	}
+}
+
+// Disconnect.
+func (s *Zookeeper) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.connected {
+		return nil
+	}
+
+	s.disconnect()
+	s.closeNotifier.NotifyAll(adapters.ErrConnectionClosed)
+	return nil
+}
+
+// Disconnect. The adapter does not hand out any connections so there is nothing to wait
+// for; this is equivalent to Close.
+func (s *Zookeeper) CloseContext(ctx context.Context) error {
+	return s.Close()
+}
+
+// Close the client. This method is not thread-safe so it should be invoked
+// while holding the service lock.
+func (s *Zookeeper) disconnect() {
+	s.client.Close()
+	s.client = nil
+	s.connected = false
+	s.metrics.SetConnected(serviceName, false)
+}
+
+// Register a listener for receiving close notifications. The service adapter will emit an error and
+// close the channel if the service is cleanly shut down or close the channel if the connection is reset.
+func (s *Zookeeper) NotifyClose(c adapters.CloseListener) {
+	s.closeNotifier.Add(c)
+}
+
+// Register a callback that is invoked when a dial gives up because its dial policy
+// is exhausted. The callback receives the number of attempts and the error of the
+// last attempt. It fires once per failed dial rather than once per attempt and runs
+// on its own goroutine so it may call back into the service. Passing nil removes it.
+func (s *Zookeeper) OnDialFailure(fn func(attempts uint32, lastErr error)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onDialFailure = fn
+}
+
+// Enable or disable close notifications. This is intended for tests only; see
+// adapters.SuppressCloseNotifications.
+func (s *Zookeeper) SuppressCloseNotifications(suppress bool) {
+	s.closeNotifier.Suppress(suppress)
+}
+
+// Log a warning when more than max close listeners are registered, which usually
+// indicates a listener leak; 0 disables the check. See adapters.MaxCloseListeners.
+func (s *Zookeeper) SetMaxCloseListeners(max int) {
+	s.closeNotifier.SetMaxListeners(max, func(count int) {
+		s.logger.Printf("[ZOOKEEPER] Possible close listener leak: %d listeners registered (max %d)\n", count, max)
+	})
+}
+
+// Remove all registered close listeners without closing the connection. The
+// listener channels are closed so that any consumers blocked on them are released.
+func (s *Zookeeper) ResetCloseListeners() {
+	s.closeNotifier.Reset()
+}
+
+// Report whether the service is connected.
+func (s *Zookeeper) IsConnected() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.connected
+}
+
+// Get the error that caused the most recent dial to fail; nil if the most recent
+// dial succeeded or no dial has been attempted yet.
+func (s *Zookeeper) LastError() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lastErr
+}
+
+// Apply a list of options to the service.
+func (s *Zookeeper) SetOptions(opts ...adapters.ServiceOption) error {
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register a logger instance for service events. The logger is also used by
+// clients created by subsequent dials.
+func (s *Zookeeper) SetLogger(logger *log.Logger) {
+	s.logger = logger
+}
+
+// Get the logger registered via SetLogger. Implements the adapters.LoggerGetter interface.
+func (s *Zookeeper) Logger() *log.Logger {
+	return s.logger
+}
+
+// Attach a logger for tagging DialContext and ConfigContext log lines with the fields
+// of the supplied context. Passing nil restores plain logging.
+func (s *Zookeeper) SetContextLogger(logger adapters.ContextLogger) {
+	s.contextLogger = logger
+}
+
+// Set a dial policy for this service.
+func (s *Zookeeper) SetDialPolicy(policy dial.Policy) {
+	s.dialPolicy = dial.Clone(policy)
+}
+
+// Get the dial policy used by this service, e.g. for logging its settings via
+// dial.Describe or inspecting CurAttempt while a dial is in progress. The policy
+// is the copy stored by SetDialPolicy so it must not be passed to other services.
+func (s *Zookeeper) DialPolicy() dial.Policy {
+	return s.dialPolicy
+}
+
+// Register a metrics sink for dial and connection events. Passing nil discards all events.
+func (s *Zookeeper) SetMetrics(m adapters.Metrics) {
+	if m == nil {
+		m = adapters.NopMetrics
+	}
+	s.metrics = m
+}
+
+// Register a tracer for creating spans around Dial and Config. Passing nil disables tracing.
+func (s *Zookeeper) SetTracer(t adapters.Tracer) {
+	if t == nil {
+		t = adapters.NopTracer
+	}
+	s.tracer = t
+}
+
+// Set the service configuration. Changing the configuration settings for an already connected
+// service will trigger a service shutdown. The service consumer is responsible for handing
+// service close events and triggering a re-dial.
+func (s *Zookeeper) Config(params map[string]string) error {
+	return s.ConfigContext(context.Background(), params)
+}
+
+// Apply configuration settings using the supplied context. If a context logger is
+// attached, log lines are written to the logger obtained for ctx; see Config.
+func (s *Zookeeper) ConfigContext(ctx context.Context, params map[string]string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, span := s.tracer.StartSpan(ctx, serviceName+".Config")
+	defer func() { span.End(err) }()
+	logger := adapters.LoggerForContext(ctx, s.contextLogger, s.logger)
+
+	s.configChanged = false
+
+	schema := adapters.ConfigSchema(params)
+
+	hosts := schema.String("hosts", strings.Join(s.hosts, ","))
+	sessionTimeout, err := schema.Duration("sessionTimeout", time.Second, s.sessionTimeout)
+	if err != nil {
+		logger.Printf("[ZOOKEEPER] Configuration error: %s\n", err.Error())
+		return err
+	}
+	connectionTimeout, err := schema.Duration("connTimeout", time.Second, s.connectionTimeout)
+	if err != nil {
+		logger.Printf("[ZOOKEEPER] Configuration error: %s\n", err.Error())
+		return err
+	}
+
+	needsReset := false
+	if hosts != strings.Join(s.hosts, ",") {
+		s.hosts = strings.Split(hosts, ",")
+		needsReset = true
+	}
+	if sessionTimeout != s.sessionTimeout {
+		s.sessionTimeout = sessionTimeout
+		needsReset = true
+	}
+	if connectionTimeout != s.connectionTimeout {
+		s.connectionTimeout = connectionTimeout
+		needsReset = true
+	}
+
+	s.configChanged = needsReset
+	span.SetAttribute("reset", needsReset)
+	if needsReset {
+		logger.Printf("[ZOOKEEPER] Configuration changed; new settings: hosts=%s, sessionTimeout=%v, connTimeout=%v\n",
+			strings.Join(s.hosts, ","),
+			s.sessionTimeout,
+			s.connectionTimeout,
+		)
+		if s.connected {
+			s.disconnect()
+			s.closeNotifier.NotifyAll(nil)
+		}
+	}
+
+	return nil
+}
+
+// Report whether the most recent Config call modified any setting and triggered a service reset.
+func (s *Zookeeper) ConfigChanged() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.configChanged
+}
+
+// Get the configured ensemble hosts as a comma-delimited list.
+func (s *Zookeeper) Endpoint() string {
+	s.Lock()
+	defer s.Unlock()
+
+	return strings.Join(s.hosts, ",")
+}
+
+// Set the ensemble hosts from a comma-delimited list. This is equivalent to calling
+// Config with the "hosts" setting.
+func (s *Zookeeper) SetEndpoint(hosts string) error {
+	return s.Config(map[string]string{"hosts": hosts})
+}
+
+// Fetch the value of a znode and set a watch for changes to it. If the znode does not
+// exist, a nil value is returned along with a watch for its creation.
+func (s *Zookeeper) getW(path string) ([]byte, <-chan zkDriver.Event, error) {
+	s.Lock()
+	client := s.client
+	s.Unlock()
+
+	if client == nil {
+		return nil, nil, adapters.ErrConnectionClosed
+	}
+
+	for {
+		value, _, watch, err := client.GetW(path)
+		if err != zkDriver.ErrNoNode {
+			return value, watch, err
+		}
+
+		exists, _, watch, err := client.ExistsW(path)
+		if err != nil || !exists {
+			return nil, watch, err
+		}
+
+		// The znode was created in the meantime; a watch for its creation would never fire
+	}
+}
+
+// Configuration middleware for service adaptors. It returns a ServiceOption that reads
+// the settings stored in a znode and watches it for changes, triggering a service
+// reconfiguration whenever its value changes. If the zookeeper adapter is reset (e.g.
+// due to a session expiry), the watch is re-established and the current value re-applied
+// once the adapter is re-dialed. The monitor is stopped when the service is shut down.
+func ZkConf(path string) adapters.ServiceOption {
+	return func(s adapters.Service) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		shutdownChan := adapters.NotifyShutdown(s)
+
+		// Fetch initial settings
+		value, watch, err := Adapter.getW(path)
+		if err != nil {
+			Adapter.logger.Printf("[ZOOKEEPER] Error retrieving current settings for znode '%s': %v\n", path, err)
+		} else if value != nil {
+			applyVal(s, value)
+		}
+
+		// Stop watching when the service shuts down
+		go func() {
+			select {
+			case <-shutdownChan:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// Wait for a znode change
+		go func() {
+			defer cancel()
+
+			for {
+				if watch == nil {
+					select {
+					case <-time.After(watchRetryInterval):
+					case <-ctx.Done():
+						return
+					}
+				} else {
+					select {
+					case <-watch:
+					case <-ctx.Done():
+						return
+					}
+				}
+
+				// Watches fire once so they need to be re-armed after each
+				// event; this also fetches the latest value.
+				value, watch, err = Adapter.getW(path)
+				if err != nil {
+					Adapter.logger.Printf("[ZOOKEEPER] Error watching znode '%s': %v\n", path, err)
+					watch = nil
+					continue
+				}
+				if value != nil {
+					applyVal(s, value)
+				}
+			}
+		}()
+
+		return nil
+	}
+}
+
+// Parse a znode value and apply it to service s. Values that cannot be parsed are
+// logged and ignored.
+func applyVal(s adapters.Service, value []byte) {
+	params, err := adapters.ParseConfigValue(string(value))
+	if err != nil {
+		Adapter.logger.Printf("[ZOOKEEPER] Error parsing settings: %v\n", err)
+		return
+	}
+
+	s.Config(params)
+}
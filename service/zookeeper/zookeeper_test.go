@@ -0,0 +1,475 @@
+package zookeeper
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/usrv-service-adapters"
+	"github.com/achilleasa/usrv-service-adapters/adaptertest"
+	"github.com/achilleasa/usrv-service-adapters/dial"
+	zkDriver "github.com/go-zookeeper/zk"
+)
+
+// A fake zookeeper client that serves znodes from memory. Each GetW and ExistsW call
+// returns a one-off watch that fires on the next change to any znode. The session
+// event channel is closed when the client is closed.
+type fakeClient struct {
+	mu      sync.Mutex
+	nodes   map[string][]byte
+	watches []chan zkDriver.Event
+	events  chan zkDriver.Event
+	closed  bool
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		nodes:  make(map[string][]byte),
+		events: make(chan zkDriver.Event, 10),
+	}
+}
+
+func (c *fakeClient) GetW(path string) ([]byte, *zkDriver.Stat, <-chan zkDriver.Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, nil, nil, zkDriver.ErrClosing
+	}
+	value, exists := c.nodes[path]
+	if !exists {
+		return nil, nil, nil, zkDriver.ErrNoNode
+	}
+	return value, &zkDriver.Stat{}, c.watch(), nil
+}
+
+func (c *fakeClient) ExistsW(path string) (bool, *zkDriver.Stat, <-chan zkDriver.Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false, nil, nil, zkDriver.ErrClosing
+	}
+	_, exists := c.nodes[path]
+	return exists, &zkDriver.Stat{}, c.watch(), nil
+}
+
+func (c *fakeClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.fire(zkDriver.Event{Type: zkDriver.EventNotWatching, State: zkDriver.StateDisconnected, Err: zkDriver.ErrClosing})
+	close(c.events)
+}
+
+func (c *fakeClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// Set the value of a znode and fire any pending watches.
+func (c *fakeClient) set(path, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	evtType := zkDriver.EventNodeDataChanged
+	if _, exists := c.nodes[path]; !exists {
+		evtType = zkDriver.EventNodeCreated
+	}
+	c.nodes[path] = []byte(value)
+	c.fire(zkDriver.Event{Type: evtType, Path: path})
+}
+
+// Allocate a new watch. This method must be invoked while holding the client lock.
+func (c *fakeClient) watch() <-chan zkDriver.Event {
+	w := make(chan zkDriver.Event, 1)
+	c.watches = append(c.watches, w)
+	return w
+}
+
+// Fire all pending watches. This method must be invoked while holding the client lock.
+func (c *fakeClient) fire(evt zkDriver.Event) {
+	for _, w := range c.watches {
+		w <- evt
+		close(w)
+	}
+	c.watches = nil
+}
+
+// Emit a session event.
+func (c *fakeClient) emit(state zkDriver.State) {
+	c.events <- zkDriver.Event{Type: zkDriver.EventSession, State: state, Server: "127.0.0.1:2181"}
+}
+
+// Replace connect for the duration of a test. Each call returns the next client from
+// clients; once they run out, clients that never establish a session are returned.
+func useFakeConnect(t *testing.T, clients ...*fakeClient) *int {
+	var mu sync.Mutex
+	calls := 0
+
+	origConnect := connect
+	connect = func(hosts []string, sessionTimeout time.Duration, logger *log.Logger) (zkClient, <-chan zkDriver.Event, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		if calls > len(clients) {
+			client := newFakeClient()
+			return client, client.events, nil
+		}
+		return clients[calls-1], clients[calls-1].events, nil
+	}
+	t.Cleanup(func() { connect = origConnect })
+
+	return &calls
+}
+
+// Create a client with an established session.
+func newSessionClient() *fakeClient {
+	client := newFakeClient()
+	client.emit(zkDriver.StateConnecting)
+	client.emit(zkDriver.StateConnected)
+	client.emit(zkDriver.StateHasSession)
+	return client
+}
+
+func newTestAdapter() *Zookeeper {
+	return &Zookeeper{
+		hosts:             []string{"127.0.0.1:2181"},
+		sessionTimeout:    time.Second,
+		connectionTimeout: 20 * time.Millisecond,
+		logger:            Adapter.logger,
+		dialPolicy:        dial.Periodic(1, time.Millisecond),
+		metrics:           adapters.NopMetrics,
+		tracer:            adapters.NopTracer,
+		closeNotifier:     adapters.NewNotifier(),
+	}
+}
+
+// Swap the adapter client with client for the duration of a test.
+func useFakeAdapterClient(t *testing.T, client *fakeClient) {
+	setAdapterClient(client)
+	t.Cleanup(func() { setAdapterClient(nil) })
+}
+
+func setAdapterClient(client *fakeClient) {
+	Adapter.Lock()
+	defer Adapter.Unlock()
+
+	if client == nil {
+		Adapter.client, Adapter.connected = nil, false
+		return
+	}
+	Adapter.client, Adapter.connected = client, true
+}
+
+// Wait for the next Config call of srv and get its params.
+func nextConfig(t *testing.T, srv *adaptertest.FakeService) map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	params, err := srv.NextConfig(ctx)
+	if err != nil {
+		t.Fatalf("Timed out waiting for a Config call")
+	}
+	return params
+}
+
+// Ensure that no further Config calls are made to srv.
+func expectNoConfig(t *testing.T, srv *adaptertest.FakeService) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if params, err := srv.NextConfig(ctx); err == nil {
+		t.Fatalf("Expected no further Config calls; got %v", params)
+	}
+}
+
+// Shut down srv. The fake service only notifies its close listeners while connected
+// and Config calls that modify its settings reset the connection, so it is dialed first.
+func shutdown(srv *adaptertest.FakeService) {
+	srv.Dial()
+	srv.Close()
+}
+
+func TestDialWaitsForSession(t *testing.T) {
+	client := newSessionClient()
+	useFakeConnect(t, client)
+
+	srv := newTestAdapter()
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	if err := srv.Dial(); err != adapters.ErrAlreadyConnected {
+		t.Fatalf("Expected Dial to fail with ErrAlreadyConnected; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+	srv.Close()
+	if err := <-listener; err != adapters.ErrConnectionClosed {
+		t.Fatalf("Expected to receive ErrConnectionClosed; got %v", err)
+	}
+	if !client.isClosed() {
+		t.Fatalf("Expected client to be closed")
+	}
+}
+
+func TestDialRetries(t *testing.T) {
+	// The first client never establishes a session
+	noSession, client := newFakeClient(), newSessionClient()
+	calls := useFakeConnect(t, noSession, client)
+
+	srv := newTestAdapter()
+	srv.dialPolicy = dial.Periodic(3, time.Millisecond)
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer srv.Close()
+
+	if *calls != 2 {
+		t.Fatalf("Expected 2 connection attempts; got %d", *calls)
+	}
+	if !noSession.isClosed() {
+		t.Fatalf("Expected the client that failed to establish a session to be closed")
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	useFakeConnect(t)
+
+	srv := newTestAdapter()
+	srv.dialPolicy = dial.Periodic(2, time.Millisecond)
+
+	err := srv.Dial()
+	if !errors.Is(err, dial.ErrTimeout) || !errors.Is(err, errNoSession) {
+		t.Fatalf("Expected Dial to fail with ErrTimeout wrapping errNoSession; got %v", err)
+	}
+}
+
+func TestDialAuthFailure(t *testing.T) {
+	client := newFakeClient()
+	client.emit(zkDriver.StateConnecting)
+	client.emit(zkDriver.StateAuthFailed)
+	useFakeConnect(t, client)
+
+	srv := newTestAdapter()
+	if err := srv.Dial(); !errors.Is(err, errAuthFailed) {
+		t.Fatalf("Expected Dial to fail with errAuthFailed; got %v", err)
+	}
+}
+
+func TestDialContextCancel(t *testing.T) {
+	useFakeConnect(t)
+
+	srv := newTestAdapter()
+	srv.connectionTimeout = time.Minute
+	srv.dialPolicy = dial.Periodic(1000, 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := srv.DialContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("Expected DialContext to fail with context.Canceled; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected DialContext to return promptly after cancellation; took %v", elapsed)
+	}
+}
+
+func TestSessionExpiryResetsService(t *testing.T) {
+	client := newSessionClient()
+	useFakeConnect(t, client, newSessionClient())
+
+	srv := newTestAdapter()
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	// Transient connection losses are handled by the client
+	client.emit(zkDriver.StateDisconnected)
+	client.emit(zkDriver.StateHasSession)
+	select {
+	case <-listener:
+		t.Fatalf("Expected transient connection losses not to reset the service")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	client.emit(zkDriver.StateExpired)
+	select {
+	case err, ok := <-listener:
+		if ok {
+			t.Fatalf("Expected listener to be closed without an error; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for a connection reset")
+	}
+	if !client.isClosed() {
+		t.Fatalf("Expected the expired client to be closed")
+	}
+
+	// The service can be re-dialed after a reset
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected re-dial to succeed; got %v", err)
+	}
+	srv.Close()
+}
+
+func TestConfig(t *testing.T) {
+	useFakeConnect(t, newSessionClient())
+
+	srv := newTestAdapter()
+	if err := srv.Config(map[string]string{"sessionTimeout": "soon"}); err == nil {
+		t.Fatalf("Expected Config to reject an invalid sessionTimeout")
+	}
+
+	if err := srv.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+
+	listener := make(adapters.CloseListener, 1)
+	srv.NotifyClose(listener)
+
+	err := srv.Config(map[string]string{"hosts": "10.0.0.1:2181,10.0.0.2:2181", "sessionTimeout": "30"})
+	if err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if !srv.ConfigChanged() {
+		t.Fatalf("Expected ConfigChanged() to report a change")
+	}
+	if endpoint := srv.Endpoint(); endpoint != "10.0.0.1:2181,10.0.0.2:2181" {
+		t.Fatalf("Expected Endpoint() to reflect the configured hosts; got %s", endpoint)
+	}
+	if srv.sessionTimeout != 30*time.Second {
+		t.Fatalf("Expected sessionTimeout to be 30s; got %v", srv.sessionTimeout)
+	}
+	if _, ok := <-listener; ok {
+		t.Fatalf("Expected the config change to reset the service")
+	}
+
+	if err = srv.Config(map[string]string{"hosts": "10.0.0.1:2181,10.0.0.2:2181"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if srv.ConfigChanged() {
+		t.Fatalf("Expected ConfigChanged() to report no change for identical settings")
+	}
+}
+
+func TestZkConf(t *testing.T) {
+	defer func(interval time.Duration) { watchRetryInterval = interval }(watchRetryInterval)
+	watchRetryInterval = time.Millisecond
+
+	client := newFakeClient()
+	client.set("/config/redis", "db=1")
+	useFakeAdapterClient(t, client)
+
+	srv := adaptertest.New()
+	if err := ZkConf("/config/redis")(srv); err != nil {
+		t.Fatalf("Error applying ZkConf option: %v", err)
+	}
+
+	if params := nextConfig(t, srv); params["db"] != "1" {
+		t.Fatalf("Expected initial config to contain db=1; got %v", params)
+	}
+
+	client.set("/config/redis", `{"db":"2"}`)
+	if params := nextConfig(t, srv); params["db"] != "2" {
+		t.Fatalf("Expected updated config to contain db=2; got %v", params)
+	}
+
+	// Simulate an adapter reset followed by a re-dial; the watch should be
+	// re-established and the current value re-applied
+	setAdapterClient(nil)
+	client.Close()
+	client = newFakeClient()
+	client.set("/config/redis", "db=3")
+	setAdapterClient(client)
+	if params := nextConfig(t, srv); params["db"] != "3" {
+		t.Fatalf("Expected config to contain db=3 after re-watch; got %v", params)
+	}
+
+	shutdown(srv)
+	time.Sleep(10 * time.Millisecond)
+	client.set("/config/redis", "db=4")
+	expectNoConfig(t, srv)
+}
+
+func TestZkConfMissingNode(t *testing.T) {
+	client := newFakeClient()
+	useFakeAdapterClient(t, client)
+
+	srv := adaptertest.New()
+	if err := ZkConf("/config/redis")(srv); err != nil {
+		t.Fatalf("Error applying ZkConf option: %v", err)
+	}
+	expectNoConfig(t, srv)
+
+	client.set("/config/redis", "db=1")
+	if params := nextConfig(t, srv); params["db"] != "1" {
+		t.Fatalf("Expected config to contain db=1 once the znode is created; got %v", params)
+	}
+	shutdown(srv)
+}
+
+// Requires a running zookeeper ensemble; set USRV_ZK_HOSTS to enable.
+func TestZkConfAgainstServer(t *testing.T) {
+	hosts := os.Getenv("USRV_ZK_HOSTS")
+	if hosts == "" {
+		t.Skip("USRV_ZK_HOSTS not set")
+	}
+
+	conn, _, err := zkDriver.Connect(strings.Split(hosts, ","), 5*time.Second)
+	if err != nil {
+		t.Fatalf("Error connecting to zookeeper: %v", err)
+	}
+	defer conn.Close()
+
+	path := "/usrv-zkconf-test"
+	if _, err = conn.Create(path, []byte("db=1"), 0, zkDriver.WorldACL(zkDriver.PermAll)); err != nil && err != zkDriver.ErrNodeExists {
+		t.Fatalf("Error creating znode: %v", err)
+	}
+	defer conn.Delete(path, -1)
+	if _, err = conn.Set(path, []byte("db=1"), -1); err != nil {
+		t.Fatalf("Error setting znode: %v", err)
+	}
+
+	if err = Adapter.Config(map[string]string{"hosts": hosts, "connTimeout": "5"}); err != nil {
+		t.Fatalf("Expected Config to succeed; got %v", err)
+	}
+	if err = Adapter.Dial(); err != nil {
+		t.Fatalf("Expected Dial to succeed; got %v", err)
+	}
+	defer Adapter.Close()
+
+	srv := adaptertest.New()
+	if err = ZkConf(path)(srv); err != nil {
+		t.Fatalf("Error applying ZkConf option: %v", err)
+	}
+	if params := nextConfig(t, srv); params["db"] != "1" {
+		t.Fatalf("Expected initial config to contain db=1; got %v", params)
+	}
+
+	if _, err = conn.Set(path, []byte("db=2"), -1); err != nil {
+		t.Fatalf("Error setting znode: %v", err)
+	}
+	if params := nextConfig(t, srv); params["db"] != "2" {
+		t.Fatalf("Expected updated config to contain db=2; got %v", params)
+	}
+	shutdown(srv)
+}
@@ -1,6 +1,7 @@
 package adapters
 
 import (
+	"errors"
 	"log"
 
 	"github.com/achilleasa/usrv-service-adapters/dial"
@@ -31,3 +32,132 @@ func DialPolicy(policy dial.Policy) ServiceOption {
 		return nil
 	}
 }
+
+// Attach a metrics sink to a service.
+func WithMetrics(m Metrics) ServiceOption {
+	return func(s Service) error {
+		s.SetMetrics(m)
+		return nil
+	}
+}
+
+// Attach a tracer to a service.
+func WithTracer(t Tracer) ServiceOption {
+	return func(s Service) error {
+		s.SetTracer(t)
+		return nil
+	}
+}
+
+// Services that support naming individual instances. The redis, amqp and etcd
+// adapters implement this interface.
+type NameSetter interface {
+
+	// Set the name of the service instance.
+	SetName(name string)
+}
+
+// Name a service instance so that its log lines and metrics can be told apart from
+// those of other instances of the same adapter.
+func WithName(name string) ServiceOption {
+	return func(s Service) error {
+		setter, ok := s.(NameSetter)
+		if !ok {
+			return errors.New("service does not support names")
+		}
+		setter.SetName(name)
+		return nil
+	}
+}
+
+// Services that support establishing their network connections via a custom
+// dialer. The redis and amqp adapters implement this interface.
+type DialerSetter interface {
+
+	// Set the dialer used for establishing network connections; nil restores
+	// the default dialer.
+	SetDialer(dialer Dialer)
+}
+
+// Establish the network connections of a service via dialer, e.g. for routing them
+// through a proxy or connecting to an in-memory server in tests.
+func WithDialer(dialer Dialer) ServiceOption {
+	return func(s Service) error {
+		setter, ok := s.(DialerSetter)
+		if !ok {
+			return errors.New("service does not support custom dialers")
+		}
+		setter.SetDialer(dialer)
+		return nil
+	}
+}
+
+// Services that can warn about leaking close listeners. All service adapters in this
+// package implement this interface.
+type CloseListenerLimiter interface {
+
+	// Log a warning when more than max close listeners are registered; 0 disables the check.
+	SetMaxCloseListeners(max int)
+}
+
+// Log a warning via the service logger when more than max close listeners are
+// registered with a service. Listeners are only released when the service is closed
+// or reset, so a caller registering listeners in a loop slowly leaks memory; this
+// option surfaces such leaks during development. Passing 0 disables the check.
+func MaxCloseListeners(max int) ServiceOption {
+	return func(s Service) error {
+		limiter, ok := s.(CloseListenerLimiter)
+		if !ok {
+			return errors.New("service does not support close listener limits")
+		}
+		limiter.SetMaxCloseListeners(max)
+		return nil
+	}
+}
+
+// Services that can invoke a callback when a dial gives up. All service adapters in
+// this package implement this interface.
+type DialFailureNotifier interface {
+
+	// Register a callback that is invoked once per dial that exhausts its dial policy.
+	OnDialFailure(fn func(attempts uint32, lastErr error))
+}
+
+// Register a callback that is invoked when a dial of the service gives up because
+// its dial policy is exhausted (e.g. for alerting or failing over to another
+// endpoint). The callback receives the number of dial attempts and the error of the
+// last attempt; it runs on its own goroutine.
+func OnDialFailure(fn func(attempts uint32, lastErr error)) ServiceOption {
+	return func(s Service) error {
+		notifier, ok := s.(DialFailureNotifier)
+		if !ok {
+			return errors.New("service does not support dial failure callbacks")
+		}
+		notifier.OnDialFailure(fn)
+		return nil
+	}
+}
+
+// Services that support suppressing close notifications. All service adapters in
+// this package implement this interface.
+type CloseNotificationSuppressor interface {
+
+	// Enable or disable close notifications for the service.
+	SuppressCloseNotifications(suppress bool)
+}
+
+// Suppress close notifications for a service. While suppressed, closing or resetting
+// the service does not notify any registered listeners (including NotifyShutdown
+// channels) so that a controlled teardown does not cascade to supervisors.
+//
+// This option is intended for tests only.
+func SuppressCloseNotifications(suppress bool) ServiceOption {
+	return func(s Service) error {
+		suppressor, ok := s.(CloseNotificationSuppressor)
+		if !ok {
+			return errors.New("service does not support suppressing close notifications")
+		}
+		suppressor.SuppressCloseNotifications(suppress)
+		return nil
+	}
+}
@@ -0,0 +1,40 @@
+package adapters
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Install a handler for sigs and return a function that blocks until one of them
+// is received, closes the supplied services and returns once all of them have been
+// closed. If no signals are specified, os.Interrupt and syscall.SIGTERM are used.
+//
+// The handler is installed when CloseOnSignal is invoked so signals received before
+// the returned function is called are not lost. Like any other signal.Notify
+// registration, the handler does not prevent channels registered elsewhere from
+// receiving the signal.
+func CloseOnSignal(sigs ...os.Signal) func(services ...Service) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sigs...)
+
+	return func(services ...Service) {
+		<-sigCh
+		signal.Stop(sigCh)
+
+		var wg sync.WaitGroup
+		wg.Add(len(services))
+		for _, s := range services {
+			go func(s Service) {
+				defer wg.Done()
+				s.Close()
+			}(s)
+		}
+		wg.Wait()
+	}
+}
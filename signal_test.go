@@ -0,0 +1,53 @@
+package adapters
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCloseOnSignal(t *testing.T) {
+	srv1 := &hangingService{}
+	srv2 := &hangingService{}
+
+	// Other handlers for the same signal should still be notified
+	otherCh := make(chan os.Signal, 1)
+	signal.Notify(otherCh, syscall.SIGUSR1)
+	defer signal.Stop(otherCh)
+
+	waitAndClose := CloseOnSignal(syscall.SIGUSR1)
+	done := make(chan struct{})
+	go func() {
+		waitAndClose(srv1, srv2)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("Expected services to remain open until a signal is received")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("Error sending signal: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for services to be closed")
+	}
+
+	if atomic.LoadInt32(&srv1.closed) != 1 || atomic.LoadInt32(&srv2.closed) != 1 {
+		t.Fatalf("Expected all services to be closed")
+	}
+
+	select {
+	case <-otherCh:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the signal to be delivered to other handlers")
+	}
+}
@@ -0,0 +1,59 @@
+package adapters
+
+import (
+	"net"
+	"time"
+)
+
+// The function used by TCPKeepAliveDialer for establishing connections.
+var netDialTimeout = net.DialTimeout
+
+// A connection that supports OS-level TCP keepalive probes (e.g. *net.TCPConn).
+type keepAliveConn interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+// A function for establishing network connections within timeout. Adapters that
+// accept a custom dialer (see WithDialer) use net.DialTimeout by default.
+type Dialer func(network, address string, timeout time.Duration) (net.Conn, error)
+
+// Get a dial function that connects to an address within timeout and enables
+// TCP keepalive probes with the supplied period on the produced connection.
+// Connections that do not support keepalive (e.g. unix sockets) are returned
+// unmodified. The returned function can be used with drivers that accept a
+// custom net.Conn dialer.
+func TCPKeepAliveDialer(timeout, period time.Duration) func(network, address string) (net.Conn, error) {
+	return KeepAliveDialer(nil, timeout, period)
+}
+
+// Get a dial function that works like TCPKeepAliveDialer but establishes
+// connections using dialer. A nil dialer uses net.DialTimeout.
+func KeepAliveDialer(dialer Dialer, timeout, period time.Duration) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		dial := dialer
+		if dial == nil {
+			dial = netDialTimeout
+		}
+
+		conn, err := dial(network, address, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		tcpConn, ok := conn.(keepAliveConn)
+		if !ok {
+			return conn, nil
+		}
+
+		if err = tcpConn.SetKeepAlive(true); err == nil {
+			err = tcpConn.SetKeepAlivePeriod(period)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
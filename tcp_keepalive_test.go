@@ -0,0 +1,90 @@
+package adapters
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// A fake connection that records the keepalive options applied to it.
+type keepAliveRecorder struct {
+	net.Conn
+	keepAlive bool
+	period    time.Duration
+	periodErr error
+	closed    bool
+}
+
+func (c *keepAliveRecorder) SetKeepAlive(keepalive bool) error {
+	c.keepAlive = keepalive
+	return nil
+}
+
+func (c *keepAliveRecorder) SetKeepAlivePeriod(d time.Duration) error {
+	c.period = d
+	return c.periodErr
+}
+
+func (c *keepAliveRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestTCPKeepAliveDialer(t *testing.T) {
+	defer func(fn func(string, string, time.Duration) (net.Conn, error)) {
+		netDialTimeout = fn
+	}(netDialTimeout)
+
+	conn := &keepAliveRecorder{}
+	var dialTimeout time.Duration
+	netDialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		dialTimeout = timeout
+		return conn, nil
+	}
+
+	produced, err := TCPKeepAliveDialer(time.Second, 30*time.Second)("tcp", "127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("Expected dial to succeed; got %v", err)
+	}
+	if produced != conn {
+		t.Fatalf("Expected the dialed connection to be returned")
+	}
+	if dialTimeout != time.Second {
+		t.Fatalf("Expected dial timeout to be 1s; got %v", dialTimeout)
+	}
+	if !conn.keepAlive {
+		t.Fatalf("Expected keepalive to be enabled on the produced connection")
+	}
+	if conn.period != 30*time.Second {
+		t.Fatalf("Expected keepalive period to be 30s; got %v", conn.period)
+	}
+
+	// Connections are closed if the keepalive options cannot be applied
+	conn = &keepAliveRecorder{periodErr: errors.New("unsupported")}
+	_, err = TCPKeepAliveDialer(time.Second, 30*time.Second)("tcp", "127.0.0.1:1")
+	if err != conn.periodErr {
+		t.Fatalf("Expected dial to fail with %v; got %v", conn.periodErr, err)
+	}
+	if !conn.closed {
+		t.Fatalf("Expected connection to be closed")
+	}
+}
+
+func TestTCPKeepAliveDialerRealConnection(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := TCPKeepAliveDialer(time.Second, 30*time.Second)("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected dial to succeed; got %v", err)
+	}
+	defer conn.Close()
+
+	if _, ok := conn.(*net.TCPConn); !ok {
+		t.Fatalf("Expected a *net.TCPConn; got %T", conn)
+	}
+}
@@ -0,0 +1,34 @@
+package adapters
+
+import "context"
+
+// Tracer creates spans for service operations such as Dial and Config.
+type Tracer interface {
+
+	// Start a span for the named operation (e.g. "redis.Dial"). The returned
+	// context carries the span and should be used for any nested operations.
+	StartSpan(ctx context.Context, operation string) (context.Context, Span)
+}
+
+// Span records the attributes and outcome of a traced service operation.
+type Span interface {
+
+	// Attach an attribute (e.g. the number of dial attempts) to the span.
+	SetAttribute(key string, value interface{})
+
+	// Finish the span. If err is not nil, it is recorded as the span error.
+	End(err error)
+}
+
+// NopTracer is a Tracer implementation that discards all spans. Services use it by default.
+var NopTracer Tracer = nopTracer{}
+
+type nopTracer struct{}
+type nopSpan struct{}
+
+func (nopTracer) StartSpan(ctx context.Context, operation string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+func (nopSpan) End(err error)                              {}
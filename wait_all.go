@@ -0,0 +1,65 @@
+package adapters
+
+import "context"
+
+// The outcome of dialing a service via WaitAll.
+type dialResult struct {
+	service Service
+	err     error
+}
+
+// Dial all services concurrently and block until every service is connected, any
+// dial fails or ctx is done. Services that are already connected (i.e. their dial
+// returns ErrAlreadyConnected) count as connected. On failure, the dials still in
+// progress are aborted by cancelling the context passed to DialContext and all
+// services connected by this call are closed; services that connect after WaitAll
+// has returned are closed as well. Returns the error of the first failed dial or
+// ctx.Err() if ctx is done before all services are connected.
+func WaitAll(ctx context.Context, services ...Service) error {
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(services))
+	for _, s := range services {
+		go func(s Service) {
+			results <- dialResult{service: s, err: s.DialContext(dialCtx)}
+		}(s)
+	}
+
+	var connected []Service
+	var err error
+	pending := len(services)
+	for pending > 0 && err == nil {
+		select {
+		case res := <-results:
+			pending--
+			switch res.err {
+			case nil:
+				connected = append(connected, res.service)
+			case ErrAlreadyConnected:
+			default:
+				err = res.err
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	// Abort the remaining dials and clean up
+	cancel()
+	for _, s := range connected {
+		s.Close()
+	}
+	go func(pending int) {
+		for ; pending > 0; pending-- {
+			if res := <-results; res.err == nil {
+				res.service.Close()
+			}
+		}
+	}(pending)
+
+	return err
+}
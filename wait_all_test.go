@@ -0,0 +1,89 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Create a hanging service whose dial returns dialErr without blocking.
+func dialedService(dialErr error) *hangingService {
+	srv := &hangingService{release: make(chan struct{}), dialErr: dialErr}
+	close(srv.release)
+	return srv
+}
+
+func waitForClose(t *testing.T, srv *hangingService, name string) {
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&srv.closed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected service %s to be closed", name)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWaitAll(t *testing.T) {
+	services := []Service{dialedService(nil), dialedService(ErrAlreadyConnected), dialedService(nil)}
+	if err := WaitAll(context.Background(), services...); err != nil {
+		t.Fatalf("Expected WaitAll to succeed; got %v", err)
+	}
+
+	for index, s := range services {
+		if atomic.LoadInt32(&s.(*hangingService).closed) != 0 {
+			t.Fatalf("Expected service %d not to be closed", index)
+		}
+	}
+
+	if err := WaitAll(context.Background()); err != nil {
+		t.Fatalf("Expected WaitAll to succeed without services; got %v", err)
+	}
+}
+
+func TestWaitAllFailure(t *testing.T) {
+	dialErr := errors.New("connection refused")
+
+	connected := dialedService(nil)
+	alreadyConnected := dialedService(ErrAlreadyConnected)
+	failing := dialedService(dialErr)
+	hanging := &hangingService{release: make(chan struct{})}
+	late := &hangingService{release: make(chan struct{}), ignoreContext: true}
+
+	start := time.Now()
+	err := WaitAll(context.Background(), connected, alreadyConnected, failing, hanging, late)
+	if err != dialErr {
+		t.Fatalf("Expected WaitAll to return the dial error; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected WaitAll to return promptly; took %v", elapsed)
+	}
+
+	// Services connected by WaitAll should be closed, including the ones that
+	// connect after WaitAll has returned
+	waitForClose(t, connected, "connected")
+	close(late.release)
+	waitForClose(t, late, "late")
+
+	// Services that were not connected by WaitAll should be left alone
+	if atomic.LoadInt32(&alreadyConnected.closed) != 0 {
+		t.Fatalf("Expected an already connected service not to be closed")
+	}
+	if atomic.LoadInt32(&hanging.closed) != 0 {
+		t.Fatalf("Expected an aborted dial not to close the service")
+	}
+}
+
+func TestWaitAllContextExpires(t *testing.T) {
+	connected := dialedService(nil)
+	hanging := &hangingService{release: make(chan struct{})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := WaitAll(ctx, connected, hanging); err != context.DeadlineExceeded {
+		t.Fatalf("Expected WaitAll to fail with context.DeadlineExceeded; got %v", err)
+	}
+	waitForClose(t, connected, "connected")
+}